@@ -1,6 +1,9 @@
 package main
 
 import (
+	// embed the timezone database so schedules work in minimal containers
+	_ "time/tzdata"
+
 	"github.com/SevvyP/plants/internal/server"
 	"github.com/joho/godotenv"
 )
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/blob"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/devauth"
+	"github.com/SevvyP/plants/internal/ratelimit"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: plantsctl <command>
+
+commands:
+  audit verify [-file path]   verify the hash chain of the audit log
+  token mint -sub subject [-scope "a b"] [-client id] [-tenant id] [-audience aud] [-ttl 1h]
+                              mint a token from the development issuer (DEV_AUTH)
+  tenant create -id id -name name [-max-plants n] [-rate-limit 1000/1m] [-disable a,b]
+                              provision a tenant
+  tenant update -id id [-name name] [-max-plants n] [-rate-limit 1000/1m] [-disable a,b]
+                              change a tenant's config
+  tenant list                 list the tenants
+  tenant delete -id id -confirm id
+                              delete a tenant and all of its data
+`
+
+func main() {
+	// load env file if one exists
+	godotenv.Load()
+
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] + " " + os.Args[2] {
+	case "audit verify":
+		auditVerify(os.Args[3:])
+	case "token mint":
+		tokenMint(os.Args[3:])
+	case "tenant create":
+		tenantCreate(os.Args[3:])
+	case "tenant update":
+		tenantUpdate(os.Args[3:])
+	case "tenant list":
+		tenantList()
+	case "tenant delete":
+		tenantDelete(os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// auditVerify reads the whole audit log and checks every entry is chained to the one before it
+func auditVerify(args []string) {
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	file := flags.String("file", os.Getenv("AUDIT_LOG_FILE"), "audit log file to verify instead of the DynamoDB table")
+	flags.Parse(args)
+
+	sink := auditSink(*file)
+	entries := []audit.Entry{}
+	after := int64(0)
+	for {
+		page, err := sink.List(after, 1000, context.TODO())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		entries = append(entries, page...)
+		after = page[len(page)-1].Sequence
+	}
+	err := audit.Verify(entries)
+	if err != nil {
+		fmt.Printf("audit log verification failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("verified %d audit entries\n", len(entries))
+}
+
+// auditSink returns the audit log file if one is given, otherwise the DynamoDB table
+func auditSink(file string) audit.Sink {
+	if file != "" {
+		return audit.NewFileSink(file)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return audit.NewDynamoSink(dynamodb.NewFromConfig(cfg))
+}
+
+// recordAudit appends a change made with plantsctl to the same audit log the api writes to
+func recordAudit(action string, resource string, before interface{}, after interface{}) {
+	entry := audit.Entry{
+		Action:     action,
+		Resource:   resource,
+		Subject:    "plantsctl",
+		BeforeHash: audit.HashState(before),
+		AfterHash:  audit.HashState(after),
+	}
+	_, err := audit.NewLog(auditSink(os.Getenv("AUDIT_LOG_FILE"))).Record(entry, context.Background())
+	if err != nil {
+		log.Printf("failed to write audit entry for %s of %s: %v", action, resource, err)
+	}
+}
+
+// tokenMint prints a token signed by the development issuer, with the key the api loads
+// when DEV_AUTH is true
+func tokenMint(args []string) {
+	flags := flag.NewFlagSet("token mint", flag.ExitOnError)
+	subject := flags.String("sub", "", "subject of the token, e.g. auth0|dev")
+	scope := flags.String("scope", "", "space separated scopes of the token")
+	client := flags.String("client", "", "client id for the azp claim")
+	tenantID := flags.String("tenant", "", "tenant for the org_id claim")
+	audience := flags.String("audience", "", "audience of the token, AUTH0_AUDIENCE by default")
+	ttl := flags.Duration("ttl", devauth.DefaultTTL, "how long the token lasts")
+	flags.Parse(args)
+	if *subject == "" {
+		fmt.Fprintln(os.Stderr, "token mint needs a -sub")
+		os.Exit(2)
+	}
+
+	issuer, err := devauth.NewIssuerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, err := issuer.Mint(devauth.TokenOptions{
+		Subject:  *subject,
+		Scopes:   strings.Fields(*scope),
+		Audience: *audience,
+		ClientID: *client,
+		Tenant:   *tenantID,
+		TTL:      *ttl,
+	}, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(token)
+}
+
+// tenantFlags are the config of a tenant set by tenant create and tenant update
+type tenantFlags struct {
+	flags     *flag.FlagSet
+	id        *string
+	name      *string
+	maxPlants *int
+	rateLimit *string
+	disable   *string
+}
+
+func newTenantFlags(command string) tenantFlags {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	return tenantFlags{
+		flags:     flags,
+		id:        flags.String("id", "", "id of the tenant, as in the org_id claim of its tokens"),
+		name:      flags.String("name", "", "name of the tenant"),
+		maxPlants: flags.Int("max-plants", 0, "most plants the catalog may hold, 0 for no limit"),
+		rateLimit: flags.String("rate-limit", "", "requests the tenant may make, e.g. 1000/1m"),
+		disable:   flags.String("disable", "", "comma separated features to turn off: "+strings.Join(pkg.Features, ", ")),
+	}
+}
+
+// apply sets the flags which were given on the tenant
+func (f tenantFlags) apply(t *pkg.Tenant) {
+	f.flags.Visit(func(set *flag.Flag) {
+		switch set.Name {
+		case "name":
+			t.Name = *f.name
+		case "max-plants":
+			t.MaxPlants = *f.maxPlants
+		case "rate-limit":
+			t.RateLimit = *f.rateLimit
+		case "disable":
+			t.DisabledFeatures = nil
+			for _, feature := range strings.Split(*f.disable, ",") {
+				if feature = strings.TrimSpace(feature); feature != "" {
+					t.DisabledFeatures = append(t.DisabledFeatures, feature)
+				}
+			}
+		}
+	})
+}
+
+func validateTenant(t pkg.Tenant) {
+	if t.RateLimit != "" {
+		_, err := ratelimit.ParseLimit(t.RateLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, feature := range t.DisabledFeatures {
+		if !slices.Contains(pkg.Features, feature) {
+			log.Fatalf("unknown feature %s", feature)
+		}
+	}
+}
+
+func tenantCreate(args []string) {
+	f := newTenantFlags("tenant create")
+	f.flags.Parse(args)
+	if !tenant.Valid(*f.id) || *f.name == "" {
+		fmt.Fprintln(os.Stderr, "tenant create needs a -name and an -id of letters, digits, dashes and underscores")
+		os.Exit(2)
+	}
+	t := pkg.Tenant{ID: *f.id, CreatedBy: "plantsctl", CreatedAt: time.Now().UTC()}
+	f.apply(&t)
+	validateTenant(t)
+	err := db.NewDB().CreateTenant(t, context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	recordAudit(audit.ActionCreate, "tenant/"+t.ID, nil, t)
+	fmt.Printf("created tenant %s\n", t.ID)
+}
+
+func tenantUpdate(args []string) {
+	f := newTenantFlags("tenant update")
+	f.flags.Parse(args)
+	database := db.NewDB()
+	t, err := database.GetTenant(*f.id, context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	before := *t
+	f.apply(t)
+	validateTenant(*t)
+	err = database.UpdateTenant(*t, context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	recordAudit(audit.ActionUpdate, "tenant/"+t.ID, before, t)
+	fmt.Printf("updated tenant %s\n", t.ID)
+}
+
+func tenantList() {
+	tenants, err := db.NewDB().ListTenants(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, t := range tenants {
+		line := fmt.Sprintf("%s\t%s", t.ID, t.Name)
+		if t.MaxPlants > 0 {
+			line += fmt.Sprintf("\tmax plants %d", t.MaxPlants)
+		}
+		if t.RateLimit != "" {
+			line += "\trate limit " + t.RateLimit
+		}
+		if len(t.DisabledFeatures) > 0 {
+			line += "\tdisabled " + strings.Join(t.DisabledFeatures, ",")
+		}
+		fmt.Println(line)
+	}
+}
+
+// tenantDelete deletes a tenant and everything it stored, once the id has been given twice
+func tenantDelete(args []string) {
+	flags := flag.NewFlagSet("tenant delete", flag.ExitOnError)
+	id := flags.String("id", "", "id of the tenant")
+	confirm := flags.String("confirm", "", "the id again, to confirm every item of the tenant is to be deleted")
+	flags.Parse(args)
+	if *id == "" || *confirm != *id {
+		fmt.Fprintln(os.Stderr, "tenant delete needs an -id and the same id again as -confirm")
+		os.Exit(2)
+	}
+	database := db.NewDB()
+	before, err := database.GetTenant(*id, context.Background())
+	if err != nil && err.Error() != db.ErrNotFound {
+		log.Fatal(err)
+	}
+	deleted, err := database.DeleteTenant(*id, blob.NewStoreFromEnv(), context.Background())
+	if err != nil {
+		log.Fatalf("deleted %d items before failing, run again to finish: %v", deleted, err)
+	}
+	recordAudit(audit.ActionDelete, "tenant/"+*id, before, nil)
+	fmt.Printf("deleted tenant %s and %d items\n", *id, deleted)
+}
@@ -0,0 +1,173 @@
+// Package apikeys mints and checks api keys, which stand in for an Auth0 token for
+// callers which cannot sign in
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// keyMarker starts every key, so a leaked key is easy to recognise
+	keyMarker = "plk_"
+	// SubjectPrefix starts the subject of requests made with an api key
+	SubjectPrefix = "apikey|"
+	// checkTTL is how long a checked key is trusted before it is looked up again, so a
+	// revoked key stops working within this time
+	checkTTL = time.Minute
+	// maxChecked bounds how many checked keys are remembered
+	maxChecked = 10000
+)
+
+// argon2id parameters, as recommended by OWASP
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024
+	argonThreads = 1
+	argonKeyLen  = 32
+)
+
+var ErrInvalidKey = errors.New("invalid api key")
+
+// Mint returns a new key along with the prefix it is looked up by and the hash to store
+func Mint() (string, string, string) {
+	prefix := make([]byte, 6)
+	rand.Read(prefix)
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	p := hex.EncodeToString(prefix)
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	return keyMarker + p + "_" + s, p, Hash(s)
+}
+
+// Parse splits a key into its prefix and secret
+func Parse(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, keyMarker)
+	if !ok {
+		return "", "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// Hash hashes a secret with argon2id into the PHC string format
+func Hash(secret string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	sum := argon2.IDKey([]byte(secret), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum))
+}
+
+// Compare reports whether the secret matches a hash made by Hash
+func Compare(hash string, secret string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads)
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(secret), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// Claims are the claims a request made with the key has, in the same form as those of
+// an Auth0 token
+func Claims(key pkg.APIKey) *validator.ValidatedClaims {
+	return &validator.ValidatedClaims{
+		RegisteredClaims: validator.RegisteredClaims{Subject: SubjectPrefix + key.Prefix},
+		CustomClaims:     &middleware.CustomClaims{Scope: strings.Join(key.Scopes, " "), Tenant: key.Tenant},
+	}
+}
+
+// Verifier checks keys against those stored in the database. Hashing is deliberately slow,
+// so keys which have been checked are remembered for a short time.
+type Verifier struct {
+	db  db.DBInterface
+	now func() time.Time
+
+	mu sync.Mutex
+	// checked holds keys by a fast hash of the whole key
+	checked map[[sha256.Size]byte]checkedKey
+}
+
+type checkedKey struct {
+	key       pkg.APIKey
+	checkedAt time.Time
+}
+
+func NewVerifier(database db.DBInterface) *Verifier {
+	return &Verifier{db: database, now: time.Now, checked: map[[sha256.Size]byte]checkedKey{}}
+}
+
+// Verify returns the claims of a key which is stored, unrevoked and unexpired
+func (v *Verifier) Verify(key string, ctx context.Context) (*validator.ValidatedClaims, error) {
+	now := v.now()
+	sum := sha256.Sum256([]byte(key))
+	v.mu.Lock()
+	checked, ok := v.checked[sum]
+	v.mu.Unlock()
+	if ok && now.Sub(checked.checkedAt) < checkTTL {
+		if !checked.key.Active(now) {
+			return nil, ErrInvalidKey
+		}
+		return Claims(checked.key), nil
+	}
+
+	prefix, secret, ok := Parse(key)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	stored, err := v.db.GetAPIKey(prefix, ctx)
+	if err != nil && err.Error() == db.ErrNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !stored.Active(now) || !Compare(stored.Hash, secret) {
+		return nil, ErrInvalidKey
+	}
+	v.mu.Lock()
+	if len(v.checked) >= maxChecked {
+		clear(v.checked)
+	}
+	v.checked[sum] = checkedKey{key: *stored, checkedAt: now}
+	v.mu.Unlock()
+	return Claims(*stored), nil
+}
@@ -0,0 +1,98 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMint(t *testing.T) {
+	key, prefix, hash := Mint()
+	gotPrefix, secret, ok := Parse(key)
+	if !ok || gotPrefix != prefix {
+		t.Fatalf("Parse(%s) = %s, %v, want prefix %s", key, gotPrefix, ok, prefix)
+	}
+	if !Compare(hash, secret) {
+		t.Errorf("Compare() = false for the minted secret")
+	}
+	if Compare(hash, secret+"x") {
+		t.Errorf("Compare() = true for another secret")
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key, prefix, hash := Mint()
+	now := time.Unix(1000, 0).UTC()
+	past := now.Add(-time.Hour)
+	tests := []struct {
+		name    string
+		key     string
+		stored  *pkg.APIKey
+		err     error
+		wantErr bool
+	}{
+		{
+			name:   "verify returns the key's claims",
+			key:    key,
+			stored: &pkg.APIKey{Prefix: prefix, Hash: hash, Scopes: []string{"read:audit", "purge:plants"}},
+		},
+		{
+			name:    "verify rejects a key with the wrong secret",
+			key:     "plk_" + prefix + "_wrong",
+			stored:  &pkg.APIKey{Prefix: prefix, Hash: hash},
+			wantErr: true,
+		},
+		{
+			name:    "verify rejects a revoked key",
+			key:     key,
+			stored:  &pkg.APIKey{Prefix: prefix, Hash: hash, RevokedAt: &past},
+			wantErr: true,
+		},
+		{
+			name:    "verify rejects an expired key",
+			key:     key,
+			stored:  &pkg.APIKey{Prefix: prefix, Hash: hash, ExpiresAt: &past},
+			wantErr: true,
+		},
+		{
+			name:    "verify rejects an unknown key",
+			key:     key,
+			stored:  nil,
+			err:     errors.New(db.ErrNotFound),
+			wantErr: true,
+		},
+		{
+			name:    "verify rejects a malformed key",
+			key:     "not a key",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(db.MockDB)
+			mockDB.On("GetAPIKey", prefix, mock.Anything).Return(tt.stored, tt.err)
+			verifier := NewVerifier(mockDB)
+			verifier.now = func() time.Time { return now }
+			claims, err := verifier.Verify(tt.key, context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			custom := claims.CustomClaims.(*middleware.CustomClaims)
+			if claims.RegisteredClaims.Subject != SubjectPrefix+prefix || !custom.HasScope("purge:plants") {
+				t.Errorf("Verifier.Verify() = %+v, %+v", claims.RegisteredClaims, custom)
+			}
+			// checked keys are remembered
+			verifier.Verify(tt.key, context.TODO())
+			mockDB.AssertNumberOfCalls(t, "GetAPIKey", 1)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ActionCreate               = "create"
+	ActionUpdate               = "update"
+	ActionDelete               = "delete"
+	ActionRestore              = "restore"
+	ActionPurge                = "purge"
+	ActionRevert               = "revert"
+	ActionArchive              = "archive"
+	ActionUnarchive            = "unarchive"
+	ActionPropose              = "propose"
+	ActionApprove              = "approve"
+	ActionReject               = "reject"
+	ActionMerge                = "merge"
+	ActionRename               = "rename"
+	ActionRevoke               = "revoke"
+	ActionRotate               = "rotate"
+	ActionAuthorizationFailure = "authorization_failure"
+)
+
+var ErrSequenceTaken = errors.New("audit sequence already written")
+
+// Entry is a single record in the audit log. Each entry holds the hash of the entry
+// before it, so editing or removing any entry breaks the chain from that point on.
+type Entry struct {
+	Sequence     int64     `json:"sequence" dynamodbav:"sequence"`
+	Timestamp    time.Time `json:"timestamp" dynamodbav:"timestamp"`
+	Action       string    `json:"action" dynamodbav:"action"`
+	Resource     string    `json:"resource" dynamodbav:"resource"`
+	Status       int       `json:"status" dynamodbav:"status"`
+	Subject      string    `json:"subject" dynamodbav:"subject"`
+	ClientID     string    `json:"client_id" dynamodbav:"client_id"`
+	Scopes       string    `json:"scopes" dynamodbav:"scopes"`
+	SourceIP     string    `json:"source_ip" dynamodbav:"source_ip"`
+	RequestID    string    `json:"request_id" dynamodbav:"request_id"`
+	Tenant       string    `json:"tenant,omitempty" dynamodbav:"tenant,omitempty"`
+	BeforeHash   string    `json:"before_hash" dynamodbav:"before_hash"`
+	AfterHash    string    `json:"after_hash" dynamodbav:"after_hash"`
+	PreviousHash string    `json:"previous_hash" dynamodbav:"previous_hash"`
+	Hash         string    `json:"hash" dynamodbav:"hash"`
+}
+
+// Sink stores audit entries. Sinks must be append only: Append fails with
+// ErrSequenceTaken rather than overwrite an existing entry.
+type Sink interface {
+	Append(Entry, context.Context) error
+	// Last returns the entry with the highest sequence, or nil if the log is empty
+	Last(context.Context) (*Entry, error)
+	// List returns up to limit entries with a sequence greater than after, in order
+	List(after int64, limit int, context context.Context) ([]Entry, error)
+}
+
+// Log chains entries together and appends them to a sink
+type Log struct {
+	sink Sink
+	mu   sync.Mutex
+	last *Entry
+}
+
+func NewLog(sink Sink) *Log {
+	return &Log{sink: sink}
+}
+
+// Record fills in the sequence, timestamp and hashes of the entry and appends it.
+// If another writer appended to the sink first, the chain is reloaded and the append retried.
+func (l *Log) Record(entry Entry, context context.Context) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for attempt := 0; attempt < 5; attempt++ {
+		if l.last == nil {
+			last, err := l.sink.Last(context)
+			if err != nil {
+				return nil, err
+			}
+			l.last = last
+		}
+		chained := entry
+		chained.Timestamp = time.Now().UTC()
+		chained.Sequence = 1
+		chained.PreviousHash = ""
+		if l.last != nil {
+			chained.Sequence = l.last.Sequence + 1
+			chained.PreviousHash = l.last.Hash
+		}
+		hash, err := HashEntry(chained)
+		if err != nil {
+			return nil, err
+		}
+		chained.Hash = hash
+		err = l.sink.Append(chained, context)
+		if errors.Is(err, ErrSequenceTaken) {
+			l.last = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.last = &chained
+		return &chained, nil
+	}
+	return nil, ErrSequenceTaken
+}
+
+// List returns entries from the underlying sink
+func (l *Log) List(after int64, limit int, context context.Context) ([]Entry, error) {
+	return l.sink.List(after, limit, context)
+}
+
+// HashEntry returns the hex encoded sha256 of the entry with its own hash left empty
+func HashEntry(entry Entry) (string, error) {
+	entry.Hash = ""
+	entry.Timestamp = entry.Timestamp.UTC()
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HashState returns the hex encoded sha256 of the json encoding of a resource, or an
+// empty string if there is no resource
+func HashState(state interface{}) string {
+	if state == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(state)
+	if err != nil || string(encoded) == "null" {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that entries form an unbroken chain starting from the first entry in
+// the log. It returns an error describing the first entry which does not match.
+func Verify(entries []Entry) error {
+	var previous *Entry
+	for i := range entries {
+		entry := entries[i]
+		expectedSequence := int64(1)
+		expectedPrevious := ""
+		if previous != nil {
+			expectedSequence = previous.Sequence + 1
+			expectedPrevious = previous.Hash
+		}
+		if entry.Sequence != expectedSequence {
+			return fmt.Errorf("entry %d: expected sequence %d", entry.Sequence, expectedSequence)
+		}
+		if entry.PreviousHash != expectedPrevious {
+			return fmt.Errorf("entry %d: previous hash does not match entry %d", entry.Sequence, expectedSequence-1)
+		}
+		hash, err := HashEntry(entry)
+		if err != nil {
+			return err
+		}
+		if entry.Hash != hash {
+			return fmt.Errorf("entry %d: hash does not match contents", entry.Sequence)
+		}
+		previous = &entries[i]
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLog_Record(t *testing.T) {
+	sink := NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	log := NewLog(sink)
+	for _, action := range []string{ActionCreate, ActionUpdate, ActionDelete} {
+		_, err := log.Record(Entry{Action: action, Resource: "test", BeforeHash: HashState(nil)}, context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := sink.List(0, 0, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Log.Record() wrote %d entries, want 3", len(entries))
+	}
+	if entries[1].PreviousHash != entries[0].Hash || entries[2].Sequence != 3 {
+		t.Errorf("Log.Record() entries are not chained: %v", entries)
+	}
+	err = Verify(entries)
+	if err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+func TestLog_Record_ResumesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	_, err := NewLog(NewFileSink(path)).Record(Entry{Action: ActionCreate}, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewLog(NewFileSink(path)).Record(Entry{Action: ActionUpdate}, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Sequence != 2 || second.PreviousHash == "" {
+		t.Errorf("Log.Record() = %v, want entry chained to the existing log", second)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink := NewFileSink(path)
+	log := NewLog(sink)
+	for _, resource := range []string{"a", "b", "c"} {
+		_, err := log.Record(Entry{Action: ActionCreate, Resource: resource}, context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := sink.List(0, 0, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func([]Entry) []Entry
+		errText string
+	}{
+		{
+			name:   "verify accepts an untouched log",
+			tamper: func(entries []Entry) []Entry { return entries },
+		},
+		{
+			name: "verify rejects an edited entry",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Resource = "edited"
+				return entries
+			},
+			errText: "entry 2: hash does not match contents",
+		},
+		{
+			name: "verify rejects a removed entry",
+			tamper: func(entries []Entry) []Entry {
+				return append(entries[:1], entries[2:]...)
+			},
+			errText: "entry 3: expected sequence 2",
+		},
+		{
+			name: "verify rejects a rehashed entry",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Resource = "edited"
+				entries[1].Hash, _ = HashEntry(entries[1])
+				return entries
+			},
+			errText: "entry 3: previous hash does not match entry 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := make([]Entry, len(entries))
+			copy(copied, entries)
+			err := Verify(tt.tamper(copied))
+			if (err != nil) != (tt.errText != "") {
+				t.Errorf("Verify() error = %v, want %q", err, tt.errText)
+			}
+			if err != nil && err.Error() != tt.errText {
+				t.Errorf("Verify() error = %v, want %q", err, tt.errText)
+			}
+		})
+	}
+}
+
+func TestFileSink_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink := NewFileSink(path)
+	err := sink.Append(Entry{Sequence: 1}, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Append(Entry{Sequence: 1}, context.TODO())
+	if err != ErrSequenceTaken {
+		t.Errorf("FileSink.Append() error = %v, want %v", err, ErrSequenceTaken)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	var entry Entry
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &entry) != nil {
+		t.Errorf("FileSink.Append() wrote %q, want a single json line", contents)
+	}
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	auditTable = "plants_v1_audit"
+	// auditPartition is the partition key shared by every entry so the log can be
+	// read in sequence order
+	auditPartition = "audit"
+)
+
+// DynamoSink stores entries in the plants_v1_audit table. Puts are conditional on
+// the sequence being unused, which keeps the table append only across replicas.
+type DynamoSink struct {
+	client *dynamodb.Client
+}
+
+func NewDynamoSink(client *dynamodb.Client) *DynamoSink {
+	return &DynamoSink{client: client}
+}
+
+func (d *DynamoSink) Append(entry Entry, context context.Context) error {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+	item["log"] = &types.AttributeValueMemberS{Value: auditPartition}
+	_, err = d.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(auditTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#sequence)"), ExpressionAttributeNames: map[string]string{"#sequence": "sequence"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrSequenceTaken
+	}
+	return err
+}
+
+func (d *DynamoSink) Last(context context.Context) (*Entry, error) {
+	output, err := d.client.Query(context, &dynamodb.QueryInput{
+		TableName: aws.String(auditTable), KeyConditionExpression: aws.String("#log = :log"), ExpressionAttributeNames: map[string]string{"#log": "log"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":log": &types.AttributeValueMemberS{Value: auditPartition},
+		}, ScanIndexForward: aws.Bool(false), Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Items) == 0 {
+		return nil, nil
+	}
+	entry := &Entry{}
+	err = attributevalue.UnmarshalMap(output.Items[0], entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (d *DynamoSink) List(after int64, limit int, context context.Context) ([]Entry, error) {
+	entries := []Entry{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(auditTable), KeyConditionExpression: aws.String("#log = :log and #sequence > :after"), ExpressionAttributeNames: map[string]string{"#log": "log", "#sequence": "sequence"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":log":   &types.AttributeValueMemberS{Value: auditPartition},
+			":after": &types.AttributeValueMemberN{Value: strconv.FormatInt(after, 10)},
+		},
+	}
+	for {
+		if limit > 0 {
+			input.Limit = aws.Int32(int32(limit - len(entries)))
+		}
+		output, err := d.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []Entry
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(output.LastEvaluatedKey) == 0 || (limit > 0 && len(entries) >= limit) {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return entries, nil
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// FileSink appends entries to a file as json lines. It only supports a single writing
+// process; replicas should use the DynamoDB sink.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (f *FileSink) Append(entry Entry, context context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last, err := f.last()
+	if err != nil {
+		return err
+	}
+	if last != nil && entry.Sequence <= last.Sequence {
+		return ErrSequenceTaken
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(encoded, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FileSink) Last(context context.Context) (*Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last()
+}
+
+func (f *FileSink) List(after int64, limit int, context context.Context) ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := []Entry{}
+	err := f.each(func(entry Entry) bool {
+		if entry.Sequence > after {
+			entries = append(entries, entry)
+		}
+		return limit <= 0 || len(entries) < limit
+	})
+	return entries, err
+}
+
+func (f *FileSink) last() (*Entry, error) {
+	var last *Entry
+	err := f.each(func(entry Entry) bool {
+		last = &entry
+		return true
+	})
+	return last, err
+}
+
+// each calls fn with every entry in the file until fn returns false
+func (f *FileSink) each(fn func(Entry) bool) error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return err
+		}
+		if !fn(entry) {
+			break
+		}
+	}
+	return scanner.Err()
+}
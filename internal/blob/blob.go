@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves binary objects such as plant images under slash separated keys
+type Store interface {
+	Put(key string, data []byte, contentType string, context context.Context) error
+	Get(key string, context context.Context) ([]byte, string, error)
+	Delete(key string, context context.Context) error
+}
+
+// NewStoreFromEnv returns an S3 compatible store if BLOB_STORE is "s3", otherwise a
+// store on the local filesystem rooted at BLOB_DIR (default public/images)
+func NewStoreFromEnv() Store {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		store, err := NewS3StoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return store
+	}
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "public/images"
+	}
+	return NewLocalStore(dir)
+}
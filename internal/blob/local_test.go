@@ -0,0 +1,30 @@
+package blob
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	err := store.Put("plants/test/1/original.png", []byte("\x89PNG\r\n\x1a\n"), "image/png", context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, contentType, err := store.Get("plants/test/1/original.png", context.TODO())
+	if err != nil || contentType != "image/png" || len(data) != 8 {
+		t.Errorf("LocalStore.Get() = %q, %s, %v", data, contentType, err)
+	}
+	err = store.Delete("plants/test/1/original.png", context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = store.Get("plants/test/1/original.png", context.TODO())
+	if err != ErrNotFound {
+		t.Errorf("LocalStore.Get() after delete error = %v, want %v", err, ErrNotFound)
+	}
+	err = store.Put("../escape", []byte("test"), "text/plain", context.TODO())
+	if err == nil {
+		t.Errorf("LocalStore.Put() allowed a key outside the store")
+	}
+}
@@ -0,0 +1,144 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// S3Store keeps objects in a bucket of any S3 compatible service, addressed with path
+// style urls so it also works with MinIO, R2 and similar services
+type S3Store struct {
+	endpoint    string
+	bucket      string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+// NewS3StoreFromEnv configures a store from S3_BUCKET, S3_ENDPOINT (default AWS) and the
+// usual aws credential chain
+func NewS3StoreFromEnv() (*S3Store, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET must be set to use the s3 blob store")
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return NewS3Store(endpoint, bucket, cfg.Region, cfg.Credentials), nil
+}
+
+func NewS3Store(endpoint string, bucket string, region string, credentials aws.CredentialsProvider) *S3Store {
+	return &S3Store{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		bucket:      bucket,
+		region:      region,
+		credentials: credentials,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *S3Store) Put(key string, data []byte, contentType string, context context.Context) error {
+	request, err := s.newRequest(http.MethodPut, key, data, context)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	_, err = s.do(request, data)
+	return err
+}
+
+func (s *S3Store) Get(key string, context context.Context) ([]byte, string, error) {
+	request, err := s.newRequest(http.MethodGet, key, nil, context)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := s.do(request, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return response.body, response.contentType, nil
+}
+
+func (s *S3Store) Delete(key string, context context.Context) error {
+	request, err := s.newRequest(http.MethodDelete, key, nil, context)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(request, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *S3Store) newRequest(method string, key string, data []byte, context context.Context) (*http.Request, error) {
+	objectURL := s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + escapeKey(key)
+	return http.NewRequestWithContext(context, method, objectURL, bytes.NewReader(data))
+}
+
+type s3Response struct {
+	body        []byte
+	contentType string
+}
+
+// do signs and sends the request, mapping error statuses to errors
+func (s *S3Store) do(request *http.Request, payload []byte) (*s3Response, error) {
+	credentials, err := s.credentials.Retrieve(request.Context())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	err = s.signer.SignHTTP(request.Context(), credentials, request, payloadHash, "s3", s.region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("s3 %s %s returned %d: %s", request.Method, request.URL.Path, response.StatusCode, body)
+	}
+	return &s3Response{body: body, contentType: response.Header.Get("Content-Type")}, nil
+}
+
+// escapeKey escapes each segment of a key while keeping the slashes between them
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,226 @@
+// Package calendar renders care tasks as an RFC 5545 iCalendar feed
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	productID = "-//SevvyP//plants//EN"
+	// maxLineOctets is the longest a content line may be before it is folded
+	maxLineOctets = 75
+	localFormat   = "20060102T150405"
+	utcFormat     = "20060102T150405Z"
+)
+
+// Event is a recurring event in the feed. Start is in the feed's location.
+type Event struct {
+	// UID stays the same across renders so calendar apps update the event rather than add another
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	Duration    time.Duration
+	// IntervalDays repeats the event every so many days, or never if it is zero
+	IntervalDays int
+	// Alarm is how long before the start to remind, or no reminder if it is negative
+	Alarm time.Duration
+}
+
+// Render writes a calendar named name holding the events, with a VTIMEZONE describing
+// location's offsets over the years around now
+func Render(w io.Writer, name string, location *time.Location, events []Event, now time.Time) error {
+	out := &writer{w: bufio.NewWriter(w)}
+	out.line("BEGIN:VCALENDAR")
+	out.line("VERSION:2.0")
+	out.line("PRODID:" + productID)
+	out.line("CALSCALE:GREGORIAN")
+	out.line("METHOD:PUBLISH")
+	out.line("X-WR-CALNAME:" + escape(name))
+	out.line("X-WR-TIMEZONE:" + location.String())
+	writeTimezone(out, location, now)
+	stamp := now.UTC().Format(utcFormat)
+	for _, event := range events {
+		start := event.Start.In(location)
+		out.line("BEGIN:VEVENT")
+		out.line("UID:" + escape(event.UID))
+		out.line("DTSTAMP:" + stamp)
+		out.line("DTSTART;TZID=" + location.String() + ":" + start.Format(localFormat))
+		if event.Duration > 0 {
+			out.line("DURATION:" + duration(event.Duration))
+		}
+		if event.IntervalDays > 0 {
+			out.line(fmt.Sprintf("RRULE:FREQ=DAILY;INTERVAL=%d", event.IntervalDays))
+		}
+		out.line("SUMMARY:" + escape(event.Summary))
+		if event.Description != "" {
+			out.line("DESCRIPTION:" + escape(event.Description))
+		}
+		out.line("TRANSP:TRANSPARENT")
+		if event.Alarm >= 0 {
+			out.line("BEGIN:VALARM")
+			out.line("ACTION:DISPLAY")
+			out.line("DESCRIPTION:" + escape(event.Summary))
+			out.line("TRIGGER:-" + duration(event.Alarm))
+			out.line("END:VALARM")
+		}
+		out.line("END:VEVENT")
+	}
+	out.line("END:VCALENDAR")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// writeTimezone describes location with one STANDARD or DAYLIGHT component for each
+// offset change from the year before now to a few years after it. Listing the changes
+// rather than deriving recurrence rules stays correct for zones whose rules have changed.
+func writeTimezone(out *writer, location *time.Location, now time.Time) {
+	from := time.Date(now.Year()-1, time.January, 1, 0, 0, 0, 0, location)
+	to := time.Date(now.Year()+3, time.January, 1, 0, 0, 0, 0, location)
+	out.line("BEGIN:VTIMEZONE")
+	out.line("TZID:" + location.String())
+	transitions := Transitions(location, from, to)
+	if len(transitions) == 0 {
+		name, offset := from.Zone()
+		writeObservance(out, "STANDARD", from, offset, offset, name)
+	}
+	for _, transition := range transitions {
+		kind := "STANDARD"
+		if transition.At.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		_, offsetFrom := transition.Before.Zone()
+		name, offsetTo := transition.At.Zone()
+		// the onset is given in the local time in force before the change
+		onset := transition.At.In(time.FixedZone("", offsetFrom))
+		writeObservance(out, kind, onset, offsetFrom, offsetTo, name)
+	}
+	out.line("END:VTIMEZONE")
+}
+
+func writeObservance(out *writer, kind string, onset time.Time, offsetFrom int, offsetTo int, name string) {
+	out.line("BEGIN:" + kind)
+	out.line("DTSTART:" + onset.Format(localFormat))
+	out.line("TZOFFSETFROM:" + offset(offsetFrom))
+	out.line("TZOFFSETTO:" + offset(offsetTo))
+	if name != "" {
+		out.line("TZNAME:" + escape(name))
+	}
+	out.line("END:" + kind)
+}
+
+// Transition is a change of offset in a location. Before is the last second of the old offset.
+type Transition struct {
+	Before time.Time
+	At     time.Time
+}
+
+// Transitions returns the offset changes of location between from and to
+func Transitions(location *time.Location, from time.Time, to time.Time) []Transition {
+	transitions := []Transition{}
+	// no zone changes offset more than once in a week
+	step := 7 * 24 * time.Hour
+	for start := from.In(location); start.Before(to); start = start.Add(step) {
+		end := start.Add(step)
+		if sameZone(start, end) {
+			continue
+		}
+		// binary search for the first second of the new offset
+		low, high := start.Unix(), end.Unix()
+		for high-low > 1 {
+			middle := low + (high-low)/2
+			if sameZone(start, time.Unix(middle, 0).In(location)) {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		transitions = append(transitions, Transition{Before: time.Unix(low, 0).In(location), At: time.Unix(high, 0).In(location)})
+	}
+	return transitions
+}
+
+func sameZone(a time.Time, b time.Time) bool {
+	nameA, offsetA := a.Zone()
+	nameB, offsetB := b.Zone()
+	return nameA == nameB && offsetA == offsetB
+}
+
+// offset formats seconds east of UTC as +hhmm, or +hhmmss if there are seconds
+func offset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	formatted := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		formatted += fmt.Sprintf("%02d", seconds%60)
+	}
+	return formatted
+}
+
+// duration formats a non negative duration as an RFC 5545 duration
+func duration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	if seconds == 0 {
+		return "PT0S"
+	}
+	formatted := "P"
+	if days := seconds / 86400; days > 0 {
+		formatted += fmt.Sprintf("%dD", days)
+		seconds %= 86400
+	}
+	if seconds > 0 {
+		formatted += "T"
+		if hours := seconds / 3600; hours > 0 {
+			formatted += fmt.Sprintf("%dH", hours)
+		}
+		if minutes := seconds / 60 % 60; minutes > 0 {
+			formatted += fmt.Sprintf("%dM", minutes)
+		}
+		if seconds%60 > 0 {
+			formatted += fmt.Sprintf("%dS", seconds%60)
+		}
+	}
+	return formatted
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// escape escapes a TEXT value
+func escape(text string) string {
+	return escaper.Replace(text)
+}
+
+// writer writes content lines, folding them at 75 octets without splitting a utf-8 sequence
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) line(content string) {
+	if w.err != nil {
+		return
+	}
+	var folded strings.Builder
+	width := 0
+	for _, r := range content {
+		size := len(string(r))
+		if width+size > maxLineOctets {
+			folded.WriteString("\r\n ")
+			// the leading space counts towards the continuation line
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	folded.WriteString("\r\n")
+	_, w.err = w.w.WriteString(folded.String())
+}
@@ -0,0 +1,71 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{{
+		UID:          "1.water@plants",
+		Summary:      "Water Monty, the monstera; by the window",
+		Description:  strings.Repeat("long description ", 10),
+		Start:        time.Date(2024, 5, 3, 9, 0, 0, 0, location),
+		Duration:     15 * time.Minute,
+		IntervalDays: 7,
+		Alarm:        0,
+	}}
+	var buffer bytes.Buffer
+	err = Render(&buffer, "My plants", location, events, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := buffer.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"TZID:America/New_York\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240310T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20241103T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\n",
+		"UID:1.water@plants\r\n",
+		"DTSTART;TZID=America/New_York:20240503T090000\r\n",
+		"DURATION:PT15M\r\n",
+		"RRULE:FREQ=DAILY;INTERVAL=7\r\n",
+		`SUMMARY:Water Monty\, the monstera\; by the window` + "\r\n",
+		"BEGIN:VALARM\r\nACTION:DISPLAY\r\n",
+		"TRIGGER:-PT0S\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("Render() missing %q", want)
+		}
+	}
+	for _, line := range strings.Split(feed, "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("Render() line of %d octets: %s", len(line), line)
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	if transitions := Transitions(tokyo, from, to); len(transitions) != 0 {
+		t.Errorf("Transitions() = %v, want none", transitions)
+	}
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	transitions := Transitions(sydney, from, to)
+	if len(transitions) != 2 {
+		t.Fatalf("Transitions() = %v, want 2", transitions)
+	}
+	if transitions[0].At.IsDST() || !transitions[1].At.IsDST() {
+		t.Errorf("Transitions() = %v, want daylight saving to end then start", transitions)
+	}
+}
@@ -0,0 +1,210 @@
+package care
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+)
+
+const (
+	HemisphereNorth = "north"
+	HemisphereSouth = "south"
+)
+
+// Options control how a schedule is computed for a user
+type Options struct {
+	// Location is the user's timezone. Tasks fall due at midnight local time.
+	Location *time.Location
+	// Hemisphere decides which months are winter, "north" unless set to "south"
+	Hemisphere string
+	Now        time.Time
+}
+
+// TaskID identifies the recurring task of one kind for a collection entry
+func TaskID(entryID string, kind string) string {
+	return entryID + "." + kind
+}
+
+// ParseTaskID splits a task id into its collection entry id and kind
+func ParseTaskID(id string) (string, string, bool) {
+	i := strings.LastIndex(id, ".")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
+
+// Tasks derives the next occurrence of every recurring task for a collection entry from
+// the care profile of its catalog plant
+func Tasks(entry pkg.CollectionEntry, profile pkg.CareProfile, states map[string]pkg.TaskState, options Options) []pkg.CareTask {
+	tasks := []pkg.CareTask{}
+	for _, kind := range pkg.TaskKinds {
+		if profile.Interval(kind) <= 0 {
+			continue
+		}
+		id := TaskID(entry.ID, kind)
+		state := states[id]
+		due, interval := NextDue(entry, kind, profile.Interval(kind), state, options)
+		tasks = append(tasks, pkg.CareTask{
+			ID:           id,
+			EntryID:      entry.ID,
+			PlantName:    entry.PlantName,
+			Nickname:     entry.Nickname,
+			Kind:         kind,
+			IntervalDays: interval,
+			DueAt:        due,
+			DueDate:      due.Format(time.DateOnly),
+			Overdue:      !due.After(options.Now),
+			CompletedAt:  state.CompletedAt,
+			SnoozedUntil: state.SnoozedUntil,
+		})
+	}
+	return tasks
+}
+
+// ProfileFunc looks up the care profile of a catalog plant, returning nil for plants
+// without one or no longer in the catalog
+type ProfileFunc func(name string) (*pkg.CareProfile, error)
+
+// CollectionTasks computes the tasks for the given collection entries sorted by when they
+// are due, looking up the care profile of each catalog plant once
+func CollectionTasks(entries []pkg.CollectionEntry, states map[string]pkg.TaskState, options Options, lookup ProfileFunc) ([]pkg.CareTask, error) {
+	profiles := map[string]*pkg.CareProfile{}
+	tasks := []pkg.CareTask{}
+	for _, entry := range entries {
+		profile, ok := profiles[entry.PlantName]
+		if !ok {
+			var err error
+			profile, err = lookup(entry.PlantName)
+			if err != nil {
+				return nil, err
+			}
+			profiles[entry.PlantName] = profile
+		}
+		if profile == nil {
+			continue
+		}
+		tasks = append(tasks, Tasks(entry, *profile, states, options)...)
+	}
+	SortTasks(tasks)
+	return tasks, nil
+}
+
+// SortTasks orders tasks by when they are due
+func SortTasks(tasks []pkg.CareTask) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].DueAt.Before(tasks[j].DueAt)
+	})
+}
+
+// NextDue returns when a task next falls due and the adjusted interval in days used to
+// schedule it. Tasks recur from their anchor, or from when the plant was acquired or
+// added to the collection if they have never been done.
+func NextDue(entry pkg.CollectionEntry, kind string, baseInterval int, state pkg.TaskState, options Options) (time.Time, int) {
+	location := options.Location
+	if location == nil {
+		location = time.UTC
+	}
+	anchor := entry.CreatedAt
+	if acquired, err := time.ParseInLocation(time.DateOnly, entry.AcquiredOn, location); err == nil {
+		anchor = acquired
+	}
+	if state.Anchor != nil {
+		anchor = *state.Anchor
+	}
+	anchor = anchor.In(location)
+	interval := AdjustedInterval(kind, baseInterval, entry.PotDiameterCm, anchor.Month(), options.Hemisphere)
+	day := time.Date(anchor.Year(), anchor.Month(), anchor.Day(), 0, 0, 0, 0, location)
+	due := day.AddDate(0, 0, interval)
+	// plants are not fed while dormant, so fertilizing due in winter waits for spring
+	if kind == pkg.TaskFertilize && season(due.Month(), options.Hemisphere) == winter {
+		for season(due.Month(), options.Hemisphere) == winter {
+			due = time.Date(due.Year(), due.Month()+1, 1, 0, 0, 0, 0, location)
+		}
+	}
+	if state.SnoozedUntil != nil && state.SnoozedUntil.After(due) {
+		due = state.SnoozedUntil.In(location)
+	}
+	return due, interval
+}
+
+// AdjustedInterval scales the base interval for the season and the size of the pot.
+// Plants drink less in winter and more in summer, and small pots dry out and fill
+// with roots sooner than large ones.
+func AdjustedInterval(kind string, baseInterval int, potDiameterCm int, month time.Month, hemisphere string) int {
+	factor := 1.0
+	switch kind {
+	case pkg.TaskWater:
+		switch season(month, hemisphere) {
+		case winter:
+			factor *= 1.5
+		case summer:
+			factor *= 0.8
+		}
+		factor *= potFactor(potDiameterCm)
+	case pkg.TaskRepot:
+		factor *= potFactor(potDiameterCm)
+	}
+	return max(1, int(math.Round(float64(baseInterval)*factor)))
+}
+
+func potFactor(potDiameterCm int) float64 {
+	switch {
+	case potDiameterCm == 0:
+		return 1
+	case potDiameterCm < 10:
+		return 0.75
+	case potDiameterCm > 25:
+		return 1.25
+	}
+	return 1
+}
+
+type seasonName int
+
+const (
+	spring seasonName = iota
+	summer
+	autumn
+	winter
+)
+
+func season(month time.Month, hemisphere string) seasonName {
+	if hemisphere == HemisphereSouth {
+		month = (month+5)%12 + 1
+	}
+	switch month {
+	case time.December, time.January, time.February:
+		return winter
+	case time.March, time.April, time.May:
+		return spring
+	case time.June, time.July, time.August:
+		return summer
+	}
+	return autumn
+}
+
+// Complete marks the task done at the given time, so it next recurs from then
+func Complete(state pkg.TaskState, at time.Time) pkg.TaskState {
+	state.Anchor = &at
+	state.CompletedAt = &at
+	state.SnoozedUntil = nil
+	return state
+}
+
+// Skip passes over the current occurrence of a task, so it next recurs from when it
+// was due rather than from when it was last done
+func Skip(state pkg.TaskState, due time.Time) pkg.TaskState {
+	state.Anchor = &due
+	state.SnoozedUntil = nil
+	return state
+}
+
+// Snooze postpones the current occurrence of a task until the given time
+func Snooze(state pkg.TaskState, until time.Time) pkg.TaskState {
+	state.SnoozedUntil = &until
+	return state
+}
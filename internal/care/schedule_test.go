@@ -0,0 +1,136 @@
+package care
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+)
+
+func TestNextDue(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(s string) *time.Time {
+		parsed, err := time.ParseInLocation(time.DateOnly, s, paris)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &parsed
+	}
+	entry := pkg.CollectionEntry{ID: "1", AcquiredOn: "2024-04-01"}
+	tests := []struct {
+		name       string
+		entry      pkg.CollectionEntry
+		kind       string
+		interval   int
+		state      pkg.TaskState
+		hemisphere string
+		want       string
+		wantDays   int
+	}{
+		{
+			name:     "next due counts from the acquisition date",
+			entry:    entry,
+			kind:     pkg.TaskWater,
+			interval: 10,
+			want:     "2024-04-11",
+			wantDays: 10,
+		},
+		{
+			name:     "next due counts from the last completion",
+			entry:    entry,
+			kind:     pkg.TaskWater,
+			interval: 10,
+			state:    pkg.TaskState{Anchor: date("2024-04-20")},
+			want:     "2024-04-30",
+			wantDays: 10,
+		},
+		{
+			name:     "next due waters less often in winter",
+			entry:    entry,
+			kind:     pkg.TaskWater,
+			interval: 10,
+			state:    pkg.TaskState{Anchor: date("2024-01-10")},
+			want:     "2024-01-25",
+			wantDays: 15,
+		},
+		{
+			name:       "next due uses southern seasons",
+			entry:      entry,
+			kind:       pkg.TaskWater,
+			interval:   10,
+			state:      pkg.TaskState{Anchor: date("2024-01-10")},
+			hemisphere: HemisphereSouth,
+			want:       "2024-01-18",
+			wantDays:   8,
+		},
+		{
+			name:     "next due waters small pots more often",
+			entry:    pkg.CollectionEntry{ID: "1", AcquiredOn: "2024-04-01", PotDiameterCm: 8},
+			kind:     pkg.TaskWater,
+			interval: 8,
+			want:     "2024-04-07",
+			wantDays: 6,
+		},
+		{
+			name:     "next due holds fertilizing until spring",
+			entry:    entry,
+			kind:     pkg.TaskFertilize,
+			interval: 30,
+			state:    pkg.TaskState{Anchor: date("2024-11-15")},
+			want:     "2025-03-01",
+			wantDays: 30,
+		},
+		{
+			name:     "next due honors a snooze",
+			entry:    entry,
+			kind:     pkg.TaskRotate,
+			interval: 7,
+			state:    pkg.TaskState{SnoozedUntil: date("2024-04-20")},
+			want:     "2024-04-20",
+			wantDays: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, days := NextDue(tt.entry, tt.kind, tt.interval, tt.state, Options{Location: paris, Hemisphere: tt.hemisphere})
+			if got.Format(time.DateOnly) != tt.want || days != tt.wantDays {
+				t.Errorf("NextDue() = %s, %d, want %s, %d", got.Format(time.DateOnly), days, tt.want, tt.wantDays)
+			}
+			if got.Location() != paris || got.Hour() != 0 {
+				t.Errorf("NextDue() = %v, want midnight in the user's timezone", got)
+			}
+		})
+	}
+}
+
+func TestTasks(t *testing.T) {
+	now := time.Date(2024, 4, 15, 12, 0, 0, 0, time.UTC)
+	entry := pkg.CollectionEntry{ID: "1", PlantName: "fern", AcquiredOn: "2024-04-01"}
+	profile := pkg.CareProfile{WaterEveryDays: 7, RotateEveryDays: 30}
+	completed := now.Add(-time.Hour)
+	states := map[string]pkg.TaskState{TaskID("1", pkg.TaskWater): Complete(pkg.TaskState{}, completed)}
+	tasks := Tasks(entry, profile, states, Options{Now: now})
+	if len(tasks) != 2 {
+		t.Fatalf("Tasks() returned %d tasks, want 2", len(tasks))
+	}
+	if tasks[0].ID != "1.water" || tasks[0].DueDate != "2024-04-22" || tasks[0].Overdue {
+		t.Errorf("Tasks() water task = %+v", tasks[0])
+	}
+	if tasks[1].ID != "1.rotate" || tasks[1].DueDate != "2024-05-01" {
+		t.Errorf("Tasks() rotate task = %+v", tasks[1])
+	}
+}
+
+func TestParseTaskID(t *testing.T) {
+	entryID, kind, ok := ParseTaskID("abc.water")
+	if !ok || entryID != "abc" || kind != "water" {
+		t.Errorf("ParseTaskID() = %s, %s, %v", entryID, kind, ok)
+	}
+	_, _, ok = ParseTaskID("water")
+	if ok {
+		t.Errorf("ParseTaskID() accepted an id without a kind")
+	}
+}
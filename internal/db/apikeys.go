@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// apiKeysTable holds api keys keyed by prefix
+const apiKeysTable = "plants_v1_api_keys"
+
+func (db *DB) CreateAPIKey(key pkg.APIKey, context context.Context) error {
+	if key.Prefix == "" || key.Hash == "" {
+		return errors.New("missing prefix or hash")
+	}
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(apiKeysTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#prefix)"), ExpressionAttributeNames: map[string]string{"#prefix": "prefix"},
+	})
+	return err
+}
+
+func (db *DB) ListAPIKeys(context context.Context) ([]pkg.APIKey, error) {
+	keys := []pkg.APIKey{}
+	input := &dynamodb.ScanInput{TableName: aws.String(apiKeysTable)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.APIKey
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return keys, nil
+}
+
+func (db *DB) GetAPIKey(prefix string, context context.Context) (*pkg.APIKey, error) {
+	if prefix == "" {
+		return nil, errors.New("missing prefix")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(apiKeysTable), Key: map[string]types.AttributeValue{"prefix": &types.AttributeValueMemberS{Value: prefix}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	key := &pkg.APIKey{}
+	err = attributevalue.UnmarshalMap(output.Item, key)
+	if err != nil {
+		return nil, err
+	}
+	if key.Prefix == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return key, nil
+}
+
+// UpdateAPIKey writes an existing key, e.g. to revoke it or set when it expires
+func (db *DB) UpdateAPIKey(key pkg.APIKey, context context.Context) error {
+	if key.Prefix == "" || key.Hash == "" {
+		return errors.New("missing prefix or hash")
+	}
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(apiKeysTable), Item: item, ConditionExpression: aws.String("attribute_exists(#prefix)"), ExpressionAttributeNames: map[string]string{"#prefix": "prefix"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errors.New(ErrNotFound)
+	}
+	return err
+}
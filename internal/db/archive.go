@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ListArchive returns every plant which has been archived and is not in the trash
+func (db *DB) ListArchive(context context.Context) ([]pkg.Plant, error) {
+	return db.scanPlants("attribute_exists(archived_at) and attribute_not_exists(deleted_at)", context)
+}
+
+// ArchivePlant withdraws a published plant from the catalog, keeping it and its history
+// so it can be published again, and records an archive revision
+func (db *DB) ArchivePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	plant, err := db.GetPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	revision := newRevision(*plant, pkg.RevisionArchive, author, "archived plant")
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	archivedAt := revision.Timestamp
+	archivedattribute, err := attributevalue.Marshal(archivedAt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set archived_at = :archived_at, updated_at = :archived_at"), ConditionExpression: aws.String("attribute_exists(#name) and attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)"), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+					":archived_at": archivedattribute,
+				},
+			}},
+		}, change...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plant.UpdatedAt = &archivedAt
+	plant.ArchivedAt = &archivedAt
+	return plant, nil
+}
+
+// UnarchivePlant publishes an archived plant again and records an unarchive revision
+func (db *DB) UnarchivePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	plant, err := db.getPlantItem(name, context)
+	if err != nil {
+		return nil, err
+	}
+	if plant.ArchivedAt == nil || plant.DeletedAt != nil {
+		return nil, errors.New(ErrNotFound)
+	}
+	plant.ArchivedAt = nil
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	revision := newRevision(*plant, pkg.RevisionUnarchive, author, "published archived plant")
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	updatedattribute, err := attributevalue.Marshal(revision.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set updated_at = :updated_at remove archived_at"), ConditionExpression: aws.String("attribute_exists(archived_at) and attribute_not_exists(deleted_at)"), ExpressionAttributeValues: map[string]types.AttributeValue{
+					":updated_at": updatedattribute,
+				},
+			}},
+		}, change...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Snapshot, nil
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestDB_GetPlant_Archived(t *testing.T) {
+	archivedAt := time.Now().UTC()
+	db := newMockedDB(t, map[string]mockOutput{
+		"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test", ArchivedAt: &archivedAt})}},
+	})
+	_, err := db.GetPlant("test", context.TODO())
+	if err == nil || err.Error() != ErrNotFound {
+		t.Errorf("DB.GetPlant() error = %v, want %s", err, ErrNotFound)
+	}
+}
+
+func TestDB_ArchivePlant(t *testing.T) {
+	archivedAt := time.Unix(0, 0).UTC()
+	tests := []struct {
+		name    string
+		outputs map[string]mockOutput
+		wantErr bool
+		errText string
+	}{
+		{
+			name: "archive plant returns error if plant is already archived",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test", ArchivedAt: &archivedAt})}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name: "archive plant returns the archived plant if client is successful",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.ArchivePlant("test", "test", context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.ArchivePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.ArchivePlant() error = %v, errText = %s", err, tt.errText)
+			}
+			if err == nil && (got.ArchivedAt == nil || got.Status() != pkg.PlantStatusArchived) {
+				t.Errorf("DB.ArchivePlant() = %v, want archived_at set", got)
+			}
+		})
+	}
+}
+
+func TestDB_UnarchivePlant(t *testing.T) {
+	archivedAt := time.Unix(0, 0).UTC()
+	tests := []struct {
+		name    string
+		outputs map[string]mockOutput
+		wantErr bool
+		errText string
+	}{
+		{
+			name: "unarchive plant returns error if plant is published",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name: "unarchive plant returns error if plant is in the trash",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test", ArchivedAt: &archivedAt, DeletedAt: &archivedAt})}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name: "unarchive plant returns the published plant if client is successful",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test", ArchivedAt: &archivedAt})}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.UnarchivePlant("test", "test", context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.UnarchivePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.UnarchivePlant() error = %v, errText = %s", err, tt.errText)
+			}
+			if err == nil && got.ArchivedAt != nil {
+				t.Errorf("DB.UnarchivePlant() = %v, want archived_at cleared", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultCacheSize        = 1000
+	DefaultCacheTTL         = time.Minute
+	DefaultCacheNegativeTTL = 10 * time.Second
+)
+
+// CacheOptions size a CachedDB and decide how long it trusts what it has read
+type CacheOptions struct {
+	// Size is the most plants kept, after which the least recently used are evicted
+	Size int
+	// TTL is how long a plant is served from the cache before it is read again
+	TTL time.Duration
+	// NegativeTTL is how long a plant which was not found is remembered as missing
+	NegativeTTL time.Duration
+}
+
+// CacheStats count how GetPlant calls on a CachedDB were answered
+type CacheStats struct {
+	Hits int64 `json:"hits"`
+	// NegativeHits were answered not found from the cache
+	NegativeHits int64 `json:"negative_hits"`
+	Misses       int64 `json:"misses"`
+	// Coalesced misses waited for another call reading the same plant
+	Coalesced     int64 `json:"coalesced"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	Size          int   `json:"size"`
+}
+
+// CachedDB serves plants from a size bounded LRU cache in front of another DBInterface.
+// Every other method is passed straight through, and writes to a plant drop it from the
+// cache. Changes made elsewhere are seen once they expire, or sooner if Invalidate is
+// called for them.
+type CachedDB struct {
+	DBInterface
+	options CacheOptions
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the most recently used entry at the front
+	order *list.List
+	// generation counts invalidations, so a read which overlapped one is not cached
+	generation uint64
+	loads      singleflight.Group
+
+	hits, negativeHits, misses, coalesced, evictions, invalidations atomic.Int64
+}
+
+type cacheEntry struct {
+	// key is the plant's name scoped to its tenant
+	key string
+	// plant is nil if the plant was not found
+	plant     *pkg.Plant
+	expiresAt time.Time
+}
+
+// NewCachedDB wraps database in a cache, filling unset options with the defaults
+func NewCachedDB(database DBInterface, options CacheOptions) *CachedDB {
+	if options.Size <= 0 {
+		options.Size = DefaultCacheSize
+	}
+	if options.TTL <= 0 {
+		options.TTL = DefaultCacheTTL
+	}
+	if options.NegativeTTL <= 0 {
+		options.NegativeTTL = DefaultCacheNegativeTTL
+	}
+	return &CachedDB{
+		DBInterface: database,
+		options:     options,
+		now:         time.Now,
+		entries:     map[string]*list.Element{},
+		order:       list.New(),
+	}
+}
+
+// GetPlant returns the named plant from the cache, or reads it once however many callers
+// are waiting for it
+func (db *CachedDB) GetPlant(name string, ctx context.Context) (*pkg.Plant, error) {
+	key := tenant.Key(tenant.ID(ctx), name)
+	db.mu.Lock()
+	if element, ok := db.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if db.now().Before(entry.expiresAt) {
+			db.order.MoveToFront(element)
+			db.mu.Unlock()
+			if entry.plant == nil {
+				db.negativeHits.Add(1)
+				return nil, errors.New(ErrNotFound)
+			}
+			db.hits.Add(1)
+			return copyPlant(entry.plant), nil
+		}
+		db.remove(element)
+	}
+	generation := db.generation
+	db.mu.Unlock()
+
+	db.misses.Add(1)
+	// callers arriving after an invalidation do not join a read which started before it
+	read := false
+	result, err, shared := db.loads.Do(strconv.FormatUint(generation, 10)+"#"+key, func() (interface{}, error) {
+		read = true
+		// one caller giving up should not fail the others waiting on the same read
+		plant, err := db.DBInterface.GetPlant(name, context.WithoutCancel(ctx))
+		if err != nil && err.Error() != ErrNotFound {
+			return nil, err
+		}
+		db.store(key, plant, generation)
+		return plant, err
+	})
+	if shared && !read {
+		db.coalesced.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return copyPlant(result.(*pkg.Plant)), nil
+}
+
+// store caches the plant read at the given generation, unless it has been invalidated since
+func (db *CachedDB) store(key string, plant *pkg.Plant, generation uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.generation != generation {
+		return
+	}
+	ttl := db.options.TTL
+	if plant == nil {
+		ttl = db.options.NegativeTTL
+	}
+	entry := &cacheEntry{key: key, plant: copyPlant(plant), expiresAt: db.now().Add(ttl)}
+	if element, ok := db.entries[key]; ok {
+		element.Value = entry
+		db.order.MoveToFront(element)
+		return
+	}
+	db.entries[key] = db.order.PushFront(entry)
+	for db.order.Len() > db.options.Size {
+		db.remove(db.order.Back())
+		db.evictions.Add(1)
+	}
+}
+
+// remove drops an entry, with mu held
+func (db *CachedDB) remove(element *list.Element) {
+	db.order.Remove(element)
+	delete(db.entries, element.Value.(*cacheEntry).key)
+}
+
+// Invalidate drops the named plant of the context's tenant from the cache, e.g. when it
+// changed outside the api
+func (db *CachedDB) Invalidate(name string, ctx context.Context) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.generation++
+	db.invalidations.Add(1)
+	if element, ok := db.entries[tenant.Key(tenant.ID(ctx), name)]; ok {
+		db.remove(element)
+	}
+}
+
+// Stats returns the cache's counters since it was created
+func (db *CachedDB) Stats() CacheStats {
+	db.mu.Lock()
+	size := db.order.Len()
+	db.mu.Unlock()
+	return CacheStats{
+		Hits:          db.hits.Load(),
+		NegativeHits:  db.negativeHits.Load(),
+		Misses:        db.misses.Load(),
+		Coalesced:     db.coalesced.Load(),
+		Evictions:     db.evictions.Load(),
+		Invalidations: db.invalidations.Load(),
+		Size:          size,
+	}
+}
+
+func (db *CachedDB) CreatePlant(plant pkg.Plant, author string, ctx context.Context) error {
+	defer db.Invalidate(plant.Name, ctx)
+	return db.DBInterface.CreatePlant(plant, author, ctx)
+}
+
+func (db *CachedDB) UpdatePlant(plant pkg.Plant, author string, ctx context.Context) error {
+	defer db.Invalidate(plant.Name, ctx)
+	return db.DBInterface.UpdatePlant(plant, author, ctx)
+}
+
+func (db *CachedDB) DeletePlant(name string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.DeletePlant(name, author, ctx)
+}
+
+func (db *CachedDB) RevertPlant(name string, revisionID string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.RevertPlant(name, revisionID, author, ctx)
+}
+
+func (db *CachedDB) RestorePlant(name string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.RestorePlant(name, author, ctx)
+}
+
+func (db *CachedDB) PurgePlant(name string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.PurgePlant(name, author, ctx)
+}
+
+func (db *CachedDB) AddPlantImage(name string, image pkg.Image, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.AddPlantImage(name, image, author, ctx)
+}
+
+func (db *CachedDB) ArchivePlant(name string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.ArchivePlant(name, author, ctx)
+}
+
+func (db *CachedDB) UnarchivePlant(name string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	return db.DBInterface.UnarchivePlant(name, author, ctx)
+}
+
+func (db *CachedDB) MergePlants(into string, from string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(into, ctx)
+	defer db.Invalidate(from, ctx)
+	return db.DBInterface.MergePlants(into, from, author, ctx)
+}
+
+func (db *CachedDB) RenamePlant(name string, newName string, author string, ctx context.Context) (*pkg.Plant, error) {
+	defer db.Invalidate(name, ctx)
+	defer db.Invalidate(newName, ctx)
+	return db.DBInterface.RenamePlant(name, newName, author, ctx)
+}
+
+// copyPlant copies a cached plant so callers cannot change what other callers are served
+func copyPlant(plant *pkg.Plant) *pkg.Plant {
+	if plant == nil {
+		return nil
+	}
+	copied := *plant
+	copied.Images = append([]pkg.Image(nil), plant.Images...)
+	if plant.Care != nil {
+		care := *plant.Care
+		copied.Care = &care
+	}
+	if plant.Taxonomy != nil {
+		taxonomy := *plant.Taxonomy
+		copied.Taxonomy = &taxonomy
+	}
+	copied.Synonyms = append([]string(nil), plant.Synonyms...)
+	if plant.Translations != nil {
+		copied.Translations = make(map[string]pkg.Translation, len(plant.Translations))
+		for locale, translation := range plant.Translations {
+			copied.Translations[locale] = translation
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCachedDB_GetPlant(t *testing.T) {
+	fern := &pkg.Plant{Name: "fern", Description: "fern"}
+	tests := []struct {
+		name  string
+		setup func(*MockDB)
+		// calls runs against the cache, returning the error of the last GetPlant
+		calls     func(*CachedDB, *time.Time) error
+		wantErr   string
+		wantReads int
+		wantStats CacheStats
+	}{
+		{
+			name: "get plant reads a plant once while it is cached",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return(fern, nil)
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantReads: 1,
+			wantStats: CacheStats{Hits: 1, Misses: 1, Size: 1},
+		},
+		{
+			name: "get plant remembers a plant was not found",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return((*pkg.Plant)(nil), errors.New(ErrNotFound))
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantErr:   ErrNotFound,
+			wantReads: 1,
+			wantStats: CacheStats{NegativeHits: 1, Misses: 1, Size: 1},
+		},
+		{
+			name: "get plant does not cache other errors",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return((*pkg.Plant)(nil), errors.New("GetItemError"))
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantErr:   "GetItemError",
+			wantReads: 2,
+			wantStats: CacheStats{Misses: 2},
+		},
+		{
+			name: "get plant reads a plant again once it expires",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return(fern, nil)
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				*now = now.Add(time.Hour)
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantReads: 2,
+			wantStats: CacheStats{Misses: 2, Size: 1},
+		},
+		{
+			name: "get plant reads a plant again after it is written",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return(fern, nil)
+				m.On("UpdatePlant", *fern, "test", mock.Anything).Return(nil)
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				cache.UpdatePlant(*fern, "test", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantReads: 2,
+			wantStats: CacheStats{Misses: 2, Invalidations: 1, Size: 1},
+		},
+		{
+			name: "get plant forgets a plant was not found once it is created",
+			setup: func(m *MockDB) {
+				m.On("GetPlant", "fern", mock.Anything).Return((*pkg.Plant)(nil), errors.New(ErrNotFound)).Once()
+				m.On("CreatePlant", *fern, "test", mock.Anything).Return(nil)
+				m.On("GetPlant", "fern", mock.Anything).Return(fern, nil)
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				cache.CreatePlant(*fern, "test", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantReads: 2,
+			wantStats: CacheStats{Misses: 2, Invalidations: 1, Size: 1},
+		},
+		{
+			name: "get plant evicts the least recently used plant",
+			setup: func(m *MockDB) {
+				for _, name := range []string{"fern", "ivy", "moss"} {
+					m.On("GetPlant", name, mock.Anything).Return(&pkg.Plant{Name: name}, nil)
+				}
+			},
+			calls: func(cache *CachedDB, now *time.Time) error {
+				cache.GetPlant("fern", context.TODO())
+				cache.GetPlant("ivy", context.TODO())
+				cache.GetPlant("fern", context.TODO())
+				cache.GetPlant("moss", context.TODO())
+				_, err := cache.GetPlant("fern", context.TODO())
+				return err
+			},
+			wantReads: 3,
+			wantStats: CacheStats{Hits: 2, Misses: 3, Evictions: 1, Size: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(MockDB)
+			tt.setup(m)
+			now := time.Unix(0, 0)
+			cache := NewCachedDB(m, CacheOptions{Size: 2})
+			cache.now = func() time.Time { return now }
+			err := tt.calls(cache, &now)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("CachedDB.GetPlant() error = %v, want %s", err, tt.wantErr)
+			}
+			m.AssertNumberOfCalls(t, "GetPlant", tt.wantReads)
+			if got := cache.Stats(); got != tt.wantStats {
+				t.Errorf("CachedDB.Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestCachedDB_GetPlantCoalesces(t *testing.T) {
+	m := new(MockDB)
+	release := make(chan struct{})
+	m.On("GetPlant", "fern", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(&pkg.Plant{Name: "fern"}, nil)
+	cache := NewCachedDB(m, CacheOptions{})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plant, err := cache.GetPlant("fern", context.TODO())
+			if err != nil || plant.Name != "fern" {
+				t.Errorf("CachedDB.GetPlant() = %v, %v", plant, err)
+			}
+		}()
+	}
+	// let every caller reach the read before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	m.AssertNumberOfCalls(t, "GetPlant", 1)
+	if stats := cache.Stats(); stats.Misses+stats.Hits != 10 || stats.Coalesced != stats.Misses-1 {
+		t.Errorf("CachedDB.Stats() = %+v, want every miss but one coalesced", stats)
+	}
+}
+
+func TestCachedDB_GetPlantCopies(t *testing.T) {
+	m := new(MockDB)
+	m.On("GetPlant", "fern", mock.Anything).Return(&pkg.Plant{Name: "fern", Description: "fern"}, nil)
+	cache := NewCachedDB(m, CacheOptions{})
+	plant, _ := cache.GetPlant("fern", context.TODO())
+	plant.Description = "changed"
+	plant, _ = cache.GetPlant("fern", context.TODO())
+	if plant.Description != "fern" {
+		t.Errorf("CachedDB.GetPlant() = %+v, want the cached plant unchanged", plant)
+	}
+}
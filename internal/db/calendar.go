@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// calendarTokensTable maps the hash of each calendar feed token to the user it belongs to.
+// Each user also has an item keyed "user#" and their id holding the hash of their current
+// token, so a new token replaces the old one.
+const calendarTokensTable = "plants_v1_calendar_tokens"
+
+type calendarToken struct {
+	Key       string    `dynamodbav:"key"`
+	UserID    string    `dynamodbav:"user_id"`
+	TokenHash string    `dynamodbav:"token_hash"`
+	CreatedAt time.Time `dynamodbav:"created_at"`
+}
+
+func calendarUserKey(userID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: "user#" + userID}}
+}
+
+// GetCalendarUser returns the user owning the calendar token with the given hash
+func (db *DB) GetCalendarUser(tokenHash string, context context.Context) (string, error) {
+	if tokenHash == "" {
+		return "", errors.New("missing token")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(calendarTokensTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: "token#" + tokenHash}},
+	})
+	if err != nil {
+		return "", err
+	}
+	var token calendarToken
+	err = attributevalue.UnmarshalMap(output.Item, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.UserID == "" {
+		return "", errors.New(ErrNotFound)
+	}
+	return token.UserID, nil
+}
+
+// SetCalendarToken makes the token with the given hash the user's calendar token,
+// revoking any token they had before
+func (db *DB) SetCalendarToken(userID string, tokenHash string, context context.Context) error {
+	if userID == "" || tokenHash == "" {
+		return errors.New("missing user or token")
+	}
+	now := time.Now().UTC()
+	tokenItem, err := attributevalue.MarshalMap(calendarToken{Key: "token#" + tokenHash, UserID: userID, TokenHash: tokenHash, CreatedAt: now})
+	if err != nil {
+		return err
+	}
+	userItem, err := attributevalue.MarshalMap(calendarToken{Key: "user#" + userID, UserID: userID, TokenHash: tokenHash, CreatedAt: now})
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(calendarTokensTable), Item: tokenItem}},
+		{Put: &types.Put{TableName: aws.String(calendarTokensTable), Item: userItem}},
+	}
+	previous, err := db.calendarTokenHash(userID, context)
+	if err != nil {
+		return err
+	}
+	if previous != "" && previous != tokenHash {
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: aws.String(calendarTokensTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: "token#" + previous}},
+		}})
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return err
+}
+
+// DeleteCalendarToken revokes the user's calendar token
+func (db *DB) DeleteCalendarToken(userID string, context context.Context) error {
+	if userID == "" {
+		return errors.New("missing user")
+	}
+	previous, err := db.calendarTokenHash(userID, context)
+	if err != nil {
+		return err
+	}
+	if previous == "" {
+		return errors.New(ErrNotFound)
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: aws.String(calendarTokensTable), Key: calendarUserKey(userID)}},
+			{Delete: &types.Delete{TableName: aws.String(calendarTokensTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: "token#" + previous}}}},
+		},
+	})
+	return err
+}
+
+// calendarTokenHash returns the hash of the user's current token, or an empty string if they have none
+func (db *DB) calendarTokenHash(userID string, context context.Context) (string, error) {
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{TableName: aws.String(calendarTokensTable), Key: calendarUserKey(userID)})
+	if err != nil {
+		return "", err
+	}
+	var token calendarToken
+	err = attributevalue.UnmarshalMap(output.Item, &token)
+	if err != nil {
+		return "", err
+	}
+	return token.TokenHash, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	catalogTable = "plants_v1_catalog"
+	// catalogKey is the key of the catalog's only item
+	catalogKey = "plants"
+)
+
+// changeItems builds the transaction items which accompany every write to a plant: the
+// revision recording it and a bump of the catalog's version
+func changeItems(revision pkg.Revision) ([]types.TransactWriteItem, error) {
+	put, err := revisionPut(revision)
+	if err != nil {
+		return nil, err
+	}
+	changedattribute, err := attributevalue.Marshal(revision.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	bump := types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(catalogTable), Key: map[string]types.AttributeValue{"catalog": &types.AttributeValueMemberS{Value: catalogKey}}, UpdateExpression: aws.String("add version :one set changed_at = :changed_at"), ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+			":changed_at": changedattribute,
+		},
+	}}
+	return []types.TransactWriteItem{put, bump}, nil
+}
+
+// GetCatalogVersion returns the number of changes made to the catalog and when the last
+// was made. A catalog which has never changed is at version 0.
+func (db *DB) GetCatalogVersion(context context.Context) (*pkg.CatalogVersion, error) {
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(catalogTable), Key: map[string]types.AttributeValue{"catalog": &types.AttributeValueMemberS{Value: catalogKey}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	version := pkg.CatalogVersion{}
+	if output.Item == nil {
+		return &version, nil
+	}
+	err = attributevalue.UnmarshalMap(output.Item, &version)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestDB_GetCatalogVersion(t *testing.T) {
+	changedAt := time.Unix(100, 0).UTC()
+	tests := []struct {
+		name    string
+		outputs map[string]mockOutput
+		want    *pkg.CatalogVersion
+		wantErr bool
+	}{
+		{
+			name: "get catalog version returns error if client returns error",
+			outputs: map[string]mockOutput{
+				"GetItem": {err: fmt.Errorf("GetItemError")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "get catalog version returns version 0 if the catalog has never changed",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{}},
+			},
+			want: &pkg.CatalogVersion{},
+		},
+		{
+			name: "get catalog version returns the version",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.CatalogVersion{Version: 3, ChangedAt: changedAt})}},
+			},
+			want: &pkg.CatalogVersion{Version: 3, ChangedAt: changedAt},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.GetCatalogVersion(context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.GetCatalogVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.GetCatalogVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// collectionsTable is keyed by user_id and id, so every read and write is scoped to
+// the user who owns the entry
+const collectionsTable = "plants_v1_collections"
+
+func collectionKey(userID string, id string) (map[string]types.AttributeValue, error) {
+	if userID == "" || id == "" {
+		return nil, errors.New("missing user or entry id")
+	}
+	return attributevalue.MarshalMap(map[string]string{"user_id": userID, "id": id})
+}
+
+func (db *DB) CreateCollectionEntry(entry pkg.CollectionEntry, context context.Context) error {
+	if entry.UserID == "" || entry.ID == "" || entry.PlantName == "" {
+		return errors.New("missing user, entry id or plant name")
+	}
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(collectionsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	return err
+}
+
+// GetCollection returns every entry in the user's collection
+func (db *DB) GetCollection(userID string, context context.Context) ([]pkg.CollectionEntry, error) {
+	if userID == "" {
+		return nil, errors.New("missing user")
+	}
+	entries := []pkg.CollectionEntry{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(collectionsTable), KeyConditionExpression: aws.String("#user_id = :user_id"), ExpressionAttributeNames: map[string]string{"#user_id": "user_id"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.CollectionEntry
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+func (db *DB) GetCollectionEntry(userID string, id string, context context.Context) (*pkg.CollectionEntry, error) {
+	key, err := collectionKey(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{Key: key, TableName: aws.String(collectionsTable)})
+	if err != nil {
+		return nil, err
+	}
+	entry := &pkg.CollectionEntry{}
+	err = attributevalue.UnmarshalMap(output.Item, entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return entry, nil
+}
+
+// UpdateCollectionEntry replaces an existing entry. It never creates one, so an entry
+// id belonging to another user is reported as not found.
+func (db *DB) UpdateCollectionEntry(entry pkg.CollectionEntry, context context.Context) error {
+	if entry.UserID == "" || entry.ID == "" || entry.PlantName == "" {
+		return errors.New("missing user, entry id or plant name")
+	}
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(collectionsTable), Item: item, ConditionExpression: aws.String("attribute_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errors.New(ErrNotFound)
+	}
+	return err
+}
+
+func (db *DB) DeleteCollectionEntry(userID string, id string, context context.Context) (*pkg.CollectionEntry, error) {
+	key, err := collectionKey(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(collectionsTable), Key: key, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry := &pkg.CollectionEntry{}
+	err = attributevalue.UnmarshalMap(output.Attributes, entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return entry, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDB_UpdateCollectionEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   pkg.CollectionEntry
+		outputs map[string]mockOutput
+		wantErr bool
+		errText string
+	}{
+		{
+			name:    "update collection entry returns error if user is missing",
+			entry:   pkg.CollectionEntry{ID: "1", PlantName: "test"},
+			outputs: map[string]mockOutput{},
+			wantErr: true,
+			errText: "missing user, entry id or plant name",
+		},
+		{
+			name:  "update collection entry returns not found if the entry does not exist for the user",
+			entry: pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "test"},
+			outputs: map[string]mockOutput{
+				"PutItem": {err: &types.ConditionalCheckFailedException{}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name:  "update collection entry doesn't return error if client is successful",
+			entry: pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "test"},
+			outputs: map[string]mockOutput{
+				"PutItem": {result: &dynamodb.PutItemOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			err := db.UpdateCollectionEntry(tt.entry, context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.UpdateCollectionEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.UpdateCollectionEntry() error = %v, errText = %s", err, tt.errText)
+			}
+		})
+	}
+}
+
+func TestDB_DeleteCollectionEntry(t *testing.T) {
+	db := newMockedDB(t, map[string]mockOutput{
+		"DeleteItem": {result: &dynamodb.DeleteItemOutput{}},
+	})
+	_, err := db.DeleteCollectionEntry("user", "1", context.TODO())
+	if err == nil || err.Error() != ErrNotFound {
+		t.Errorf("DB.DeleteCollectionEntry() error = %v, want %s", err, ErrNotFound)
+	}
+}
@@ -3,9 +3,12 @@ package db
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/SevvyP/plants/internal/blob"
 	"github.com/SevvyP/plants/pkg"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,15 +19,90 @@ import (
 
 var ErrNotFound = "item not found"
 
+const plantsTable = "plants_v1"
+
 type DBInterface interface {
-	CreatePlant(pkg.Plant, context.Context) error
+	CreatePlant(pkg.Plant, string, context.Context) error
 	GetPlant(string, context.Context) (*pkg.Plant, error)
-	UpdatePlant(pkg.Plant, context.Context) error
-	DeletePlant(string, context.Context) (*pkg.Plant, error)
+	UpdatePlant(pkg.Plant, string, context.Context) error
+	DeletePlant(string, string, context.Context) (*pkg.Plant, error)
+	GetRevisions(string, context.Context) ([]pkg.Revision, error)
+	GetRevision(string, string, context.Context) (*pkg.Revision, error)
+	RevertPlant(string, string, string, context.Context) (*pkg.Plant, error)
+	ListPlants(context.Context) ([]pkg.Plant, error)
+	ListTrash(context.Context) ([]pkg.Plant, error)
+	RestorePlant(string, string, context.Context) (*pkg.Plant, error)
+	PurgePlant(string, string, context.Context) (*pkg.Plant, error)
+	AddPlantImage(string, pkg.Image, string, context.Context) (*pkg.Plant, error)
+	CreateCollectionEntry(pkg.CollectionEntry, context.Context) error
+	GetCollection(string, context.Context) ([]pkg.CollectionEntry, error)
+	GetCollectionEntry(string, string, context.Context) (*pkg.CollectionEntry, error)
+	UpdateCollectionEntry(pkg.CollectionEntry, context.Context) error
+	DeleteCollectionEntry(string, string, context.Context) (*pkg.CollectionEntry, error)
+	GetTaskStates(string, context.Context) (map[string]pkg.TaskState, error)
+	PutTaskState(pkg.TaskState, context.Context) error
+	CreateJournalEntry(pkg.JournalEntry, context.Context) error
+	GetJournal(string, string, time.Time, time.Time, context.Context) ([]pkg.JournalEntry, error)
+	GetJournalEntry(string, string, string, context.Context) (*pkg.JournalEntry, error)
+	UpdateJournalEntry(string, pkg.JournalEntry, context.Context) error
+	DeleteJournalEntry(string, string, string, context.Context) (*pkg.JournalEntry, error)
+	GetCalendarUser(string, context.Context) (string, error)
+	SetCalendarToken(string, string, context.Context) error
+	DeleteCalendarToken(string, context.Context) error
+	GetNotificationPreferences(string, context.Context) (*pkg.NotificationPreferences, error)
+	PutNotificationPreferences(pkg.NotificationPreferences, context.Context) error
+	ListNotificationPreferences(context.Context) ([]pkg.NotificationPreferences, error)
+	GetDeliveries(string, context.Context) ([]pkg.Delivery, error)
+	PutDelivery(pkg.Delivery, context.Context) error
+	CreateSubscription(pkg.Subscription, context.Context) error
+	ListSubscriptions(context.Context) ([]pkg.Subscription, error)
+	GetSubscription(string, context.Context) (*pkg.Subscription, error)
+	DeleteSubscription(string, context.Context) (*pkg.Subscription, error)
+	PutWebhookDelivery(pkg.WebhookDelivery, context.Context) error
+	GetWebhookDeliveries(string, context.Context) ([]pkg.WebhookDelivery, error)
+	GetWebhookDelivery(string, string, context.Context) (*pkg.WebhookDelivery, error)
+	ListRetryableWebhookDeliveries(time.Time, context.Context) ([]pkg.WebhookDelivery, error)
+	GetCatalogVersion(context.Context) (*pkg.CatalogVersion, error)
+	CreateAPIKey(pkg.APIKey, context.Context) error
+	ListAPIKeys(context.Context) ([]pkg.APIKey, error)
+	GetAPIKey(string, context.Context) (*pkg.APIKey, error)
+	UpdateAPIKey(pkg.APIKey, context.Context) error
+	PutRole(pkg.Role, context.Context) error
+	GetRole(string, context.Context) (*pkg.Role, error)
+	ListRoles(context.Context) ([]pkg.Role, error)
+	DeleteRole(string, context.Context) (*pkg.Role, error)
+	PutRoleAssignment(pkg.RoleAssignment, context.Context) error
+	GetRoleAssignments(string, context.Context) ([]pkg.RoleAssignment, error)
+	DeleteRoleAssignment(string, string, context.Context) (*pkg.RoleAssignment, error)
+	CreateTenant(pkg.Tenant, context.Context) error
+	GetTenant(string, context.Context) (*pkg.Tenant, error)
+	ListTenants(context.Context) ([]pkg.Tenant, error)
+	UpdateTenant(pkg.Tenant, context.Context) error
+	DeleteTenant(string, blob.Store, context.Context) (int, error)
+	ListArchive(context.Context) ([]pkg.Plant, error)
+	ArchivePlant(string, string, context.Context) (*pkg.Plant, error)
+	UnarchivePlant(string, string, context.Context) (*pkg.Plant, error)
+	CreateProposal(pkg.Proposal, context.Context) error
+	GetProposal(string, context.Context) (*pkg.Proposal, error)
+	ListProposals(string, string, context.Context) ([]pkg.Proposal, error)
+	UpdateProposal(pkg.Proposal, string, context.Context) error
+	DeleteProposal(string, context.Context) (*pkg.Proposal, error)
+	CreateSuggestion(pkg.Suggestion, context.Context) error
+	GetSuggestions(string, context.Context) ([]pkg.Suggestion, error)
+	GetSuggestion(string, string, context.Context) (*pkg.Suggestion, error)
+	UpdateSuggestion(pkg.Suggestion, string, context.Context) error
+	MergePlants(string, string, string, context.Context) (*pkg.Plant, error)
+	GetRedirect(string, context.Context) (*pkg.Redirect, error)
+	RepointCollections(string, string, context.Context) (int, error)
+	ResolvePlant(string, context.Context) (*pkg.PlantRef, error)
+	RenamePlant(string, string, string, context.Context) (*pkg.Plant, error)
+	CopyRevisions(string, pkg.Plant, context.Context) error
 }
 
 type DB struct {
 	client *dynamodb.Client
+	// trashRetention is how long deleted plants stay in the trash before they are purged
+	trashRetention time.Duration
 }
 
 func NewDB() *DB {
@@ -32,35 +110,60 @@ func NewDB() *DB {
 	if err != nil {
 		log.Fatal(err)
 	}
-	client := dynamodb.NewFromConfig(cfg)
-	return &DB{client: client}
+	client := dynamodb.NewFromConfig(cfg, PartitionByTenant)
+	return &DB{client: client, trashRetention: trashRetentionFromEnv()}
 }
 
-func (db *DB) CreatePlant(plant pkg.Plant, context context.Context) error {
+// CreatePlant writes the plant along with a create revision authored by author
+func (db *DB) CreatePlant(plant pkg.Plant, author string, context context.Context) error {
 	if plant.Name == "" || plant.Description == "" {
 		return errors.New("missing name or description")
 	}
-	item, err := attributevalue.MarshalMap(plant)
+	err := assignIdentity(&plant)
 	if err != nil {
 		return err
 	}
-	output, err := db.client.PutItem(context, &dynamodb.PutItemInput{
-		TableName: aws.String("plants_v1"), Item: item,
-	})
+	revision := newRevision(plant, pkg.RevisionCreate, author, "created plant")
+	item, err := attributevalue.MarshalMap(revision.Snapshot)
 	if err != nil {
 		return err
 	}
-	err = attributevalue.UnmarshalMap(output.Attributes, &plant)
+	change, err := changeItems(revision)
 	if err != nil {
 		return err
 	}
-	if plant.Name == "" {
-		return errors.New("unmarshal failed")
+	err = db.writeWithIdentity(plant, append([]types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(plantsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#name)"), ExpressionAttributeNames: map[string]string{"#name": "name"},
+		}},
+	}, change...), context)
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// a plant in the trash keeps its name until it is restored or purged
+		if conditionFailed(err, 0) {
+			if existing, lookupErr := db.getPlantItem(plant.Name, context); lookupErr == nil && existing.DeletedAt != nil {
+				return ErrPlantTrashed
+			}
+		}
+		return ErrNameTaken
 	}
 	return err
 }
 
-func (db *DB) GetPlant(name string, context context.Context) (*pkg.Plant, error){
+// GetPlant returns the named plant. Plants in the trash or the archive are not found.
+func (db *DB) GetPlant(name string, context context.Context) (*pkg.Plant, error) {
+	plant, err := db.getPlantItem(name, context)
+	if err != nil {
+		return nil, err
+	}
+	if plant.DeletedAt != nil || plant.ArchivedAt != nil {
+		return nil, errors.New(ErrNotFound)
+	}
+	return plant, nil
+}
+
+// getPlantItem returns the named plant whether or not it is in the trash
+func (db *DB) getPlantItem(name string, context context.Context) (*pkg.Plant, error) {
 	if name == "" {
 		return nil, errors.New("missing name or description")
 	}
@@ -68,62 +171,202 @@ func (db *DB) GetPlant(name string, context context.Context) (*pkg.Plant, error)
 	if err != nil {
 		return nil, err
 	}
-	input := &dynamodb.GetItemInput{Key: map[string]types.AttributeValue{"name": nameattribute}, TableName: aws.String("plants_v1")}
+	input := &dynamodb.GetItemInput{Key: map[string]types.AttributeValue{"name": nameattribute}, TableName: aws.String(plantsTable)}
 	output, err := db.client.GetItem(context, input)
-	if err !=nil {
+	if err != nil {
 		return nil, err
 	}
 	var plant *pkg.Plant
 	err = attributevalue.UnmarshalMap(output.Item, &plant)
-	fmt.Println(output.Item)
+	if err != nil {
+		return nil, err
+	}
 	if plant.Name == "" {
 		return nil, errors.New(ErrNotFound)
 	}
 	return plant, nil
 }
 
-// UpdatePlant will create the item if it does not exist in the db
-// TODO: fix this
-func (db *DB) UpdatePlant(plant pkg.Plant, context context.Context) error {
+// UpdatePlant replaces the published plant's fields. Plants which do not exist, or are in the
+// trash or the archive, are not found.
+func (db *DB) UpdatePlant(plant pkg.Plant, author string, context context.Context) error {
 	if plant.Name == "" || plant.Description == "" {
 		return errors.New("missing name or description")
 	}
+	previous, err := db.GetPlant(plant.Name, context)
+	if err != nil {
+		return err
+	}
+	// images are managed through AddPlantImage and kept as they are, as is the owner
+	plant.Images = previous.Images
+	plant.Owner = previous.Owner
+	// translations are kept unless the plant has its own, which replace them
+	if plant.Translations == nil {
+		plant.Translations = previous.Translations
+	}
+	// the id and slug are kept too, and given to plants written before they had them
+	plant.ID = previous.ID
+	plant.Slug = previous.Slug
+	identify := plant.ID == "" && pkg.Slugify(plant.Name) != ""
+	if identify {
+		err = assignIdentity(&plant)
+		if err != nil {
+			return err
+		}
+	}
 	nameattribute, err := attributevalue.Marshal(plant.Name)
 	if err != nil {
 		return err
 	}
-	_, err = db.client.UpdateItem(context, &dynamodb.UpdateItemInput{
-		TableName: aws.String("plants_v1"), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set description = :description"), ExpressionAttributeValues: map[string]types.AttributeValue{
-            ":description": &types.AttributeValueMemberS{Value: plant.Description}, 
-        },
-	})
+	summary := pkg.SummarizeChanges(pkg.DiffPlants(*previous, plant))
+	revision := newRevision(plant, pkg.RevisionUpdate, author, summary)
+	updatedattribute, err := attributevalue.Marshal(revision.Timestamp)
+	if err != nil {
+		return err
+	}
+	// the write only lands while the plant is as it was read, so concurrent updates are not lost
+	condition, values, err := unchangedCondition(*previous)
 	if err != nil {
 		return err
 	}
+	if values == nil {
+		values = map[string]types.AttributeValue{}
+	}
+	set := []string{"description = :description", "updated_at = :now"}
+	remove := []string{}
+	values[":description"] = &types.AttributeValueMemberS{Value: plant.Description}
+	values[":now"] = updatedattribute
+	// optional attributes are set when the plant has them and removed when it does not
+	optional := []struct {
+		name  string
+		value interface{}
+		set   bool
+	}{
+		{"care", plant.Care, plant.Care != nil},
+		{"taxonomy", plant.Taxonomy, plant.Taxonomy != nil},
+		{"synonyms", plant.Synonyms, len(plant.Synonyms) > 0},
+		{"translations", plant.Translations, len(plant.Translations) > 0},
+	}
+	for _, attribute := range optional {
+		if !attribute.set {
+			remove = append(remove, attribute.name)
+			continue
+		}
+		value, err := attributevalue.Marshal(attribute.value)
+		if err != nil {
+			return err
+		}
+		set = append(set, attribute.name+" = :"+attribute.name)
+		values[":"+attribute.name] = value
+	}
+	if identify {
+		set = append(set, "id = :id", "slug = :slug")
+		values[":id"] = &types.AttributeValueMemberS{Value: plant.ID}
+		values[":slug"] = &types.AttributeValueMemberS{Value: plant.Slug}
+	}
+	update := "set " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " remove " + strings.Join(remove, ", ")
+	}
+	change, err := changeItems(revision)
+	if err != nil {
+		return err
+	}
+	items := append([]types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String(update), ConditionExpression: aws.String(condition), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: values,
+		}},
+	}, change...)
+	if !identify {
+		_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	} else {
+		err = db.writeWithIdentity(plant, items, context)
+	}
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// the plant was written, deleted or archived since it was read
+		if conditionFailed(err, 0) {
+			_, err = db.GetPlant(plant.Name, context)
+			if err != nil {
+				return err
+			}
+			return ErrPlantChanged
+		}
+		if identify {
+			return ErrNameTaken
+		}
+	}
 	return err
 }
 
-func (db *DB) DeletePlant(name string, context context.Context) (*pkg.Plant, error) {
+// DeletePlant moves the plant to the trash and records a delete revision holding its last
+// state. Trashed plants are purged by the table's TTL once the retention period passes.
+func (db *DB) DeletePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
 	if name == "" {
 		return nil, errors.New("missing name or description")
 	}
+	plant, err := db.GetPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
 	nameattribute, err := attributevalue.Marshal(name)
 	if err != nil {
 		return nil, err
 	}
-	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
-		TableName: aws.String("plants_v1"), Key: map[string]types.AttributeValue{"name": nameattribute}, ReturnValues: types.ReturnValueAllOld,
-	})
+	revision := newRevision(*plant, pkg.RevisionDelete, author, "moved plant to trash")
+	change, err := changeItems(revision)
 	if err != nil {
 		return nil, err
 	}
-	plant := &pkg.Plant{}
-	err = attributevalue.UnmarshalMap(output.Attributes, &plant)
+	deletedAt := revision.Timestamp
+	deletedattribute, err := attributevalue.Marshal(deletedAt)
 	if err != nil {
 		return nil, err
 	}
-	if plant.Name == "" {
-		return nil, errors.New(ErrNotFound)
+	expiresAt := deletedAt.Add(db.retention()).Unix()
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set deleted_at = :deleted_at, updated_at = :deleted_at, expires_at = :expires_at"), ConditionExpression: aws.String("attribute_exists(#name) and attribute_not_exists(deleted_at)"), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+					":deleted_at": deletedattribute,
+					":expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt, 10)},
+				},
+			}},
+		}, change...),
+	})
+	if err != nil {
+		return nil, err
 	}
+	plant.UpdatedAt = &deletedAt
+	plant.DeletedAt = &deletedAt
+	plant.ExpiresAt = expiresAt
 	return plant, nil
-}
\ No newline at end of file
+}
+
+// ListPlants returns every published plant, which is neither in the trash nor the archive
+func (db *DB) ListPlants(context context.Context) ([]pkg.Plant, error) {
+	return db.scanPlants("attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)", context)
+}
+
+// scanPlants returns every plant matching the filter expression
+func (db *DB) scanPlants(filter string, context context.Context) ([]pkg.Plant, error) {
+	plants := []pkg.Plant{}
+	input := &dynamodb.ScanInput{TableName: aws.String(plantsTable), FilterExpression: aws.String(filter)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Plant
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		plants = append(plants, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return plants, nil
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"time"
 
+	"github.com/SevvyP/plants/internal/blob"
 	"github.com/SevvyP/plants/pkg"
 	"github.com/stretchr/testify/mock"
 )
@@ -11,8 +13,8 @@ type MockDB struct {
 	mock.Mock
 }
 
-func (m *MockDB) CreatePlant(plant pkg.Plant, context context.Context) error {
-	args := m.Called(plant, context)
+func (m *MockDB) CreatePlant(plant pkg.Plant, author string, context context.Context) error {
+	args := m.Called(plant, author, context)
 	return args.Error(0)
 }
 
@@ -21,12 +23,367 @@ func (m *MockDB) GetPlant(name string, context context.Context) (*pkg.Plant, err
 	return args.Get(0).(*pkg.Plant), args.Error(1)
 }
 
-func (m *MockDB) UpdatePlant(plant pkg.Plant, context context.Context) error {
-	args := m.Called(plant, context)
+func (m *MockDB) UpdatePlant(plant pkg.Plant, author string, context context.Context) error {
+	args := m.Called(plant, author, context)
 	return args.Error(0)
 }
 
-func (m *MockDB)DeletePlant(name string, context context.Context) (*pkg.Plant, error) {
+func (m *MockDB)DeletePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) GetRevisions(name string, context context.Context) ([]pkg.Revision, error) {
 	args := m.Called(name, context)
+	return args.Get(0).([]pkg.Revision), args.Error(1)
+}
+
+func (m *MockDB) GetRevision(name string, id string, context context.Context) (*pkg.Revision, error) {
+	args := m.Called(name, id, context)
+	return args.Get(0).(*pkg.Revision), args.Error(1)
+}
+
+func (m *MockDB) RevertPlant(name string, id string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, id, author, context)
 	return args.Get(0).(*pkg.Plant), args.Error(1)
-}
\ No newline at end of file
+}
+
+func (m *MockDB) ListPlants(context context.Context) ([]pkg.Plant, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) ListTrash(context context.Context) ([]pkg.Plant, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) RestorePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) PurgePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) AddPlantImage(name string, image pkg.Image, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, image, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) CreateCollectionEntry(entry pkg.CollectionEntry, context context.Context) error {
+	args := m.Called(entry, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetCollection(userID string, context context.Context) ([]pkg.CollectionEntry, error) {
+	args := m.Called(userID, context)
+	return args.Get(0).([]pkg.CollectionEntry), args.Error(1)
+}
+
+func (m *MockDB) GetCollectionEntry(userID string, id string, context context.Context) (*pkg.CollectionEntry, error) {
+	args := m.Called(userID, id, context)
+	return args.Get(0).(*pkg.CollectionEntry), args.Error(1)
+}
+
+func (m *MockDB) UpdateCollectionEntry(entry pkg.CollectionEntry, context context.Context) error {
+	args := m.Called(entry, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteCollectionEntry(userID string, id string, context context.Context) (*pkg.CollectionEntry, error) {
+	args := m.Called(userID, id, context)
+	return args.Get(0).(*pkg.CollectionEntry), args.Error(1)
+}
+
+func (m *MockDB) GetTaskStates(userID string, context context.Context) (map[string]pkg.TaskState, error) {
+	args := m.Called(userID, context)
+	return args.Get(0).(map[string]pkg.TaskState), args.Error(1)
+}
+
+func (m *MockDB) PutTaskState(state pkg.TaskState, context context.Context) error {
+	args := m.Called(state, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) CreateJournalEntry(entry pkg.JournalEntry, context context.Context) error {
+	args := m.Called(entry, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetJournal(userID string, collectionEntryID string, from time.Time, to time.Time, context context.Context) ([]pkg.JournalEntry, error) {
+	args := m.Called(userID, collectionEntryID, from, to, context)
+	return args.Get(0).([]pkg.JournalEntry), args.Error(1)
+}
+
+func (m *MockDB) GetJournalEntry(userID string, collectionEntryID string, id string, context context.Context) (*pkg.JournalEntry, error) {
+	args := m.Called(userID, collectionEntryID, id, context)
+	return args.Get(0).(*pkg.JournalEntry), args.Error(1)
+}
+
+func (m *MockDB) UpdateJournalEntry(previousID string, entry pkg.JournalEntry, context context.Context) error {
+	args := m.Called(previousID, entry, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteJournalEntry(userID string, collectionEntryID string, id string, context context.Context) (*pkg.JournalEntry, error) {
+	args := m.Called(userID, collectionEntryID, id, context)
+	return args.Get(0).(*pkg.JournalEntry), args.Error(1)
+}
+
+func (m *MockDB) GetCalendarUser(tokenHash string, context context.Context) (string, error) {
+	args := m.Called(tokenHash, context)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockDB) SetCalendarToken(userID string, tokenHash string, context context.Context) error {
+	args := m.Called(userID, tokenHash, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteCalendarToken(userID string, context context.Context) error {
+	args := m.Called(userID, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetNotificationPreferences(userID string, context context.Context) (*pkg.NotificationPreferences, error) {
+	args := m.Called(userID, context)
+	return args.Get(0).(*pkg.NotificationPreferences), args.Error(1)
+}
+
+func (m *MockDB) PutNotificationPreferences(preferences pkg.NotificationPreferences, context context.Context) error {
+	args := m.Called(preferences, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) ListNotificationPreferences(context context.Context) ([]pkg.NotificationPreferences, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.NotificationPreferences), args.Error(1)
+}
+
+func (m *MockDB) GetDeliveries(userID string, context context.Context) ([]pkg.Delivery, error) {
+	args := m.Called(userID, context)
+	return args.Get(0).([]pkg.Delivery), args.Error(1)
+}
+
+func (m *MockDB) PutDelivery(delivery pkg.Delivery, context context.Context) error {
+	args := m.Called(delivery, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) CreateSubscription(subscription pkg.Subscription, context context.Context) error {
+	args := m.Called(subscription, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) ListSubscriptions(context context.Context) ([]pkg.Subscription, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Subscription), args.Error(1)
+}
+
+func (m *MockDB) GetSubscription(id string, context context.Context) (*pkg.Subscription, error) {
+	args := m.Called(id, context)
+	return args.Get(0).(*pkg.Subscription), args.Error(1)
+}
+
+func (m *MockDB) DeleteSubscription(id string, context context.Context) (*pkg.Subscription, error) {
+	args := m.Called(id, context)
+	return args.Get(0).(*pkg.Subscription), args.Error(1)
+}
+
+func (m *MockDB) PutWebhookDelivery(delivery pkg.WebhookDelivery, context context.Context) error {
+	args := m.Called(delivery, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetWebhookDeliveries(subscriptionID string, context context.Context) ([]pkg.WebhookDelivery, error) {
+	args := m.Called(subscriptionID, context)
+	return args.Get(0).([]pkg.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockDB) GetWebhookDelivery(subscriptionID string, id string, context context.Context) (*pkg.WebhookDelivery, error) {
+	args := m.Called(subscriptionID, id, context)
+	return args.Get(0).(*pkg.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockDB) ListRetryableWebhookDeliveries(now time.Time, context context.Context) ([]pkg.WebhookDelivery, error) {
+	args := m.Called(now, context)
+	return args.Get(0).([]pkg.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockDB) GetCatalogVersion(context context.Context) (*pkg.CatalogVersion, error) {
+	args := m.Called(context)
+	return args.Get(0).(*pkg.CatalogVersion), args.Error(1)
+}
+
+func (m *MockDB) CreateAPIKey(key pkg.APIKey, context context.Context) error {
+	args := m.Called(key, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) ListAPIKeys(context context.Context) ([]pkg.APIKey, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.APIKey), args.Error(1)
+}
+
+func (m *MockDB) GetAPIKey(prefix string, context context.Context) (*pkg.APIKey, error) {
+	args := m.Called(prefix, context)
+	return args.Get(0).(*pkg.APIKey), args.Error(1)
+}
+
+func (m *MockDB) UpdateAPIKey(key pkg.APIKey, context context.Context) error {
+	args := m.Called(key, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) PutRole(role pkg.Role, context context.Context) error {
+	args := m.Called(role, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetRole(name string, context context.Context) (*pkg.Role, error) {
+	args := m.Called(name, context)
+	return args.Get(0).(*pkg.Role), args.Error(1)
+}
+
+func (m *MockDB) ListRoles(context context.Context) ([]pkg.Role, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Role), args.Error(1)
+}
+
+func (m *MockDB) DeleteRole(name string, context context.Context) (*pkg.Role, error) {
+	args := m.Called(name, context)
+	return args.Get(0).(*pkg.Role), args.Error(1)
+}
+
+func (m *MockDB) PutRoleAssignment(assignment pkg.RoleAssignment, context context.Context) error {
+	args := m.Called(assignment, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetRoleAssignments(subject string, context context.Context) ([]pkg.RoleAssignment, error) {
+	args := m.Called(subject, context)
+	return args.Get(0).([]pkg.RoleAssignment), args.Error(1)
+}
+
+func (m *MockDB) DeleteRoleAssignment(subject string, role string, context context.Context) (*pkg.RoleAssignment, error) {
+	args := m.Called(subject, role, context)
+	return args.Get(0).(*pkg.RoleAssignment), args.Error(1)
+}
+
+func (m *MockDB) CreateTenant(tenant pkg.Tenant, context context.Context) error {
+	args := m.Called(tenant, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetTenant(id string, context context.Context) (*pkg.Tenant, error) {
+	args := m.Called(id, context)
+	return args.Get(0).(*pkg.Tenant), args.Error(1)
+}
+
+func (m *MockDB) ListTenants(context context.Context) ([]pkg.Tenant, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Tenant), args.Error(1)
+}
+
+func (m *MockDB) UpdateTenant(tenant pkg.Tenant, context context.Context) error {
+	args := m.Called(tenant, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteTenant(id string, blobs blob.Store, context context.Context) (int, error) {
+	args := m.Called(id, blobs, context)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDB) ListArchive(context context.Context) ([]pkg.Plant, error) {
+	args := m.Called(context)
+	return args.Get(0).([]pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) ArchivePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) UnarchivePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) CreateProposal(proposal pkg.Proposal, context context.Context) error {
+	args := m.Called(proposal, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetProposal(id string, context context.Context) (*pkg.Proposal, error) {
+	args := m.Called(id, context)
+	return args.Get(0).(*pkg.Proposal), args.Error(1)
+}
+
+func (m *MockDB) ListProposals(author string, status string, context context.Context) ([]pkg.Proposal, error) {
+	args := m.Called(author, status, context)
+	return args.Get(0).([]pkg.Proposal), args.Error(1)
+}
+
+func (m *MockDB) UpdateProposal(proposal pkg.Proposal, status string, context context.Context) error {
+	args := m.Called(proposal, status, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteProposal(id string, context context.Context) (*pkg.Proposal, error) {
+	args := m.Called(id, context)
+	return args.Get(0).(*pkg.Proposal), args.Error(1)
+}
+
+func (m *MockDB) CreateSuggestion(suggestion pkg.Suggestion, context context.Context) error {
+	args := m.Called(suggestion, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetSuggestions(name string, context context.Context) ([]pkg.Suggestion, error) {
+	args := m.Called(name, context)
+	return args.Get(0).([]pkg.Suggestion), args.Error(1)
+}
+
+func (m *MockDB) GetSuggestion(name string, id string, context context.Context) (*pkg.Suggestion, error) {
+	args := m.Called(name, id, context)
+	return args.Get(0).(*pkg.Suggestion), args.Error(1)
+}
+
+func (m *MockDB) UpdateSuggestion(suggestion pkg.Suggestion, status string, context context.Context) error {
+	args := m.Called(suggestion, status, context)
+	return args.Error(0)
+}
+
+func (m *MockDB) MergePlants(into string, from string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(into, from, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) GetRedirect(name string, context context.Context) (*pkg.Redirect, error) {
+	args := m.Called(name, context)
+	return args.Get(0).(*pkg.Redirect), args.Error(1)
+}
+
+func (m *MockDB) RepointCollections(from string, to string, context context.Context) (int, error) {
+	args := m.Called(from, to, context)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDB) ResolvePlant(key string, context context.Context) (*pkg.PlantRef, error) {
+	args := m.Called(key, context)
+	return args.Get(0).(*pkg.PlantRef), args.Error(1)
+}
+
+func (m *MockDB) RenamePlant(name string, newName string, author string, context context.Context) (*pkg.Plant, error) {
+	args := m.Called(name, newName, author, context)
+	return args.Get(0).(*pkg.Plant), args.Error(1)
+}
+
+func (m *MockDB) CopyRevisions(oldName string, plant pkg.Plant, context context.Context) error {
+	args := m.Called(oldName, plant, context)
+	return args.Error(0)
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/smithy-go/middleware"
 )
 
@@ -40,10 +45,10 @@ func TestDB_CreatePlant(t *testing.T) {
 				withAPIOptionsFunc: func(stack *middleware.Stack) error {
                     return stack.Finalize.Add(
                         middleware.FinalizeMiddlewareFunc(
-                            "PutItemMock",
+                            "TransactWriteItemsMock",
                             func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
                                 return middleware.FinalizeOutput{
-                                    Result: &dynamodb.PutItemOutput{},
+                                    Result: &dynamodb.TransactWriteItemsOutput{},
                                 }, middleware.Metadata{}, nil
                             },
                         ),
@@ -63,12 +68,11 @@ func TestDB_CreatePlant(t *testing.T) {
                 withAPIOptionsFunc: func(stack *middleware.Stack) error {
                     return stack.Finalize.Add(
                         middleware.FinalizeMiddlewareFunc(
-                            "PutItemMock",
+                            "TransactWriteItemsMock",
                             func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-								attributes, err := attributevalue.MarshalMap(pkg.Plant{Name: "test", Description: "test"})
                                 return middleware.FinalizeOutput{
-                                    Result: &dynamodb.PutItemOutput{Attributes: attributes},
-                                }, middleware.Metadata{}, err
+                                    Result: &dynamodb.TransactWriteItemsOutput{},
+                                }, middleware.Metadata{}, nil
                             },
                         ),
                         middleware.Before,
@@ -85,11 +89,11 @@ func TestDB_CreatePlant(t *testing.T) {
                 withAPIOptionsFunc: func(stack *middleware.Stack) error {
                     return stack.Finalize.Add(
                         middleware.FinalizeMiddlewareFunc(
-                            "PutItemMock",
+                            "TransactWriteItemsMock",
                             func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
                                 return middleware.FinalizeOutput{
                                     Result: nil,
-                                }, middleware.Metadata{}, fmt.Errorf("PutItemError")
+                                }, middleware.Metadata{}, fmt.Errorf("TransactWriteItemsError")
                             },
                         ),
                         middleware.Before,
@@ -97,7 +101,7 @@ func TestDB_CreatePlant(t *testing.T) {
                 },
             },
             wantErr: true,
-			errText: "operation error DynamoDB: PutItem, PutItemError",
+			errText: "operation error DynamoDB: TransactWriteItems, TransactWriteItemsError",
 		},
 	}
 	for _, tt := range tests {
@@ -108,7 +112,7 @@ func TestDB_CreatePlant(t *testing.T) {
 			}
 			client := dynamodb.NewFromConfig(cfg)
 			db := &DB{client: client}
-			err = db.CreatePlant(tt.args.plant, tt.args.context)
+			err = db.CreatePlant(tt.args.plant, "test", tt.args.context)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DB.CreatePlant() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -259,17 +263,13 @@ func TestDB_GetPlant(t *testing.T) {
 }
 
 func TestDB_UpdatePlant(t *testing.T) {
-	type fields struct {
-		client *dynamodb.Client
-	}
 	type args struct {
 		plant   pkg.Plant
 		context context.Context
-		withAPIOptionsFunc func(*middleware.Stack) error
+		outputs map[string]mockOutput
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		wantErr bool
 		errText string
@@ -282,20 +282,7 @@ func TestDB_UpdatePlant(t *testing.T) {
 					Description: "",
 				},
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "MockUpdateItem",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-                                return middleware.FinalizeOutput{
-                                    Result: &dynamodb.UpdateItemOutput{},
-                                }, middleware.Metadata{}, nil
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{},
 			},
 			wantErr: true,
 			errText: "missing name or description",
@@ -308,23 +295,75 @@ func TestDB_UpdatePlant(t *testing.T) {
 					Description: "test",
 				},
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "MockUpdateItem",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-                                return middleware.FinalizeOutput{
-                                    Result: nil,
-                                }, middleware.Metadata{}, fmt.Errorf("UpdateItemError")
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "old"})}},
+					"TransactWriteItems": {err: fmt.Errorf("TransactWriteItemsError")},
+				},
 			},
 			wantErr: true,
-			errText: "operation error DynamoDB: UpdateItem, UpdateItemError",
+			errText: "operation error DynamoDB: TransactWriteItems, TransactWriteItemsError",
+		},
+		{
+			name: "update plant returns not found if the plant does not exist",
+			args: args{
+				plant: pkg.Plant{
+					Name: "test",
+					Description: "test",
+				},
+				context: context.TODO(),
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{}},
+				},
+			},
+			wantErr: true,
+			errText: ErrNotFound,
+		},
+		{
+			name: "update plant returns not found if the plant is in the trash",
+			args: args{
+				plant: pkg.Plant{
+					Name: "test",
+					Description: "test",
+				},
+				context: context.TODO(),
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "old", DeletedAt: &time.Time{}})}},
+				},
+			},
+			wantErr: true,
+			errText: ErrNotFound,
+		},
+		{
+			name: "update plant fails if the plant was written since it was read",
+			args: args{
+				plant: pkg.Plant{
+					Name: "test",
+					Description: "test",
+				},
+				context: context.TODO(),
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "old"})}},
+					"TransactWriteItems": {err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}}}},
+				},
+			},
+			wantErr: true,
+			errText: ErrPlantChanged.Error(),
+		},
+		{
+			name: "update plant returns error if reading the previous plant fails",
+			args: args{
+				plant: pkg.Plant{
+					Name: "test",
+					Description: "test",
+				},
+				context: context.TODO(),
+				outputs: map[string]mockOutput{
+					"GetItem": {err: fmt.Errorf("GetItemError")},
+					"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+				},
+			},
+			wantErr: true,
+			errText: "operation error DynamoDB: GetItem, GetItemError",
 		},
 		{
 			name: "update plant doesn't return an error if client is successful",
@@ -334,34 +373,18 @@ func TestDB_UpdatePlant(t *testing.T) {
 					Description: "test",
 				},
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "MockUpdateItem",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-                                attributes, err := attributevalue.MarshalMap(pkg.Plant{Name: "test", Description: "test"})
-                                return middleware.FinalizeOutput{
-                                    Result: &dynamodb.UpdateItemOutput{Attributes: attributes},
-                                }, middleware.Metadata{}, err
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "old"})}},
+					"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+				},
 			},
 			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"), config.WithAPIOptions([]func(*middleware.Stack) error{tt.args.withAPIOptionsFunc}))
-			if err != nil {
-				t.Fatal(err)
-			}
-			client := dynamodb.NewFromConfig(cfg)
-			db := &DB{client: client}
-			err = db.UpdatePlant(tt.args.plant, tt.args.context)
+			db := newMockedDB(t, tt.args.outputs)
+			err := db.UpdatePlant(tt.args.plant, "test", tt.args.context)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DB.UpdatePlant() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -373,17 +396,13 @@ func TestDB_UpdatePlant(t *testing.T) {
 }
 
 func TestDB_DeletePlant(t *testing.T) {
-	type fields struct {
-		client *dynamodb.Client
-	}
 	type args struct {
 		name    string
 		context context.Context
-		withAPIOptionsFunc func(*middleware.Stack) error
+		outputs map[string]mockOutput
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		want    *pkg.Plant
 		wantErr bool
@@ -394,20 +413,7 @@ func TestDB_DeletePlant(t *testing.T) {
 			args: args{
 				name: "",
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "DeleteItemMock",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-                                return middleware.FinalizeOutput{
-                                    Result: &dynamodb.DeleteItemOutput{},
-                                }, middleware.Metadata{}, nil
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{},
 			},
 			wantErr: true,
 			errText: "missing name or description",
@@ -417,47 +423,24 @@ func TestDB_DeletePlant(t *testing.T) {
 			args: args{
 				name: "test",
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "DeleteItemMock",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-                                return middleware.FinalizeOutput{
-                                    Result: nil,
-                                }, middleware.Metadata{}, fmt.Errorf("DeleteItemError")
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+					"TransactWriteItems": {err: fmt.Errorf("TransactWriteItemsError")},
+				},
 			},
-			want: &pkg.Plant{},
 			wantErr: true,
-			errText: "operation error DynamoDB: DeleteItem, DeleteItemError",
+			errText: "operation error DynamoDB: TransactWriteItems, TransactWriteItemsError",
 		},
 		{
 			name: "delete plant returns error if returned object is not a plant",
 			args: args{
 				name: "test",
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "DeleteItemMock",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-								attributes, err := attributevalue.MarshalMap(testStruct{Test: "test"})
-                                return middleware.FinalizeOutput{
-                                    Result: &dynamodb.DeleteItemOutput{Attributes: attributes},
-                                }, middleware.Metadata{}, err
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, testStruct{Test: "test"})}},
+					"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+				},
 			},
-			want: &pkg.Plant{},
 			wantErr: true,
 			errText: "item not found",
 		},
@@ -466,21 +449,10 @@ func TestDB_DeletePlant(t *testing.T) {
 			args: args{
 				name: "test",
 				context: context.TODO(),
-				withAPIOptionsFunc: func(stack *middleware.Stack) error {
-                    return stack.Finalize.Add(
-                        middleware.FinalizeMiddlewareFunc(
-                            "DeleteItemMock",
-                            func(context.Context, middleware.FinalizeInput, middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
-								attributes, err := attributevalue.MarshalMap(pkg.Plant{Name: "test", Description: "test"})
-                                return middleware.FinalizeOutput{
-                                    Result: &dynamodb.DeleteItemOutput{Attributes: attributes},
-                                }, middleware.Metadata{}, err
-                            },
-                        ),
-                        middleware.Before,
-                    )
-                },
-				
+				outputs: map[string]mockOutput{
+					"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+					"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+				},
 			},
 			want: &pkg.Plant{Name: "test", Description: "test"},
 			wantErr: false,
@@ -488,24 +460,104 @@ func TestDB_DeletePlant(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"), config.WithAPIOptions([]func(*middleware.Stack) error{tt.args.withAPIOptionsFunc}))
-			if err != nil {
-				t.Fatal(err)
-			}
-			client := dynamodb.NewFromConfig(cfg)
-			db := &DB{
-				client: client,
-			}
-			got, err := db.DeletePlant(tt.args.name, tt.args.context)
+			db := newMockedDB(t, tt.args.outputs)
+			got, err := db.DeletePlant(tt.args.name, "test", tt.args.context)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DB.DeletePlant() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if err != nil && tt.errText != err.Error() {
 				t.Errorf("DB.DeletePlant() error = %v, errText = %s", err, tt.errText)
 			}
+			if err == nil && (got.DeletedAt == nil || got.ExpiresAt == 0 || got.UpdatedAt == nil) {
+				t.Errorf("DB.DeletePlant() = %v, want plant moved to trash", got)
+			}
+			if err == nil {
+				got.DeletedAt, got.ExpiresAt, got.UpdatedAt = nil, 0, nil
+			}
 			if err == nil && !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("DB.DeletePlant() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+// mockOutput is the canned response to a single DynamoDB operation
+type mockOutput struct {
+	result interface{}
+	err    error
+}
+
+// newMockedDB returns a DB whose client answers each operation named in outputs with
+// the matching canned response, so tests can cover methods which make several calls
+func newMockedDB(t *testing.T, outputs map[string]mockOutput) *DB {
+	withAPIOptionsFunc := func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(
+			middleware.FinalizeMiddlewareFunc(
+				"OperationsMock",
+				func(ctx context.Context, _ middleware.FinalizeInput, _ middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+					operation := awsmiddleware.GetOperationName(ctx)
+					output, ok := outputs[operation]
+					if !ok {
+						return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected operation %s", operation)
+					}
+					return middleware.FinalizeOutput{Result: output.result}, middleware.Metadata{}, output.err
+				},
+			),
+			middleware.Before,
+		)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"), config.WithAPIOptions([]func(*middleware.Stack) error{withAPIOptionsFunc}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DB{client: dynamodb.NewFromConfig(cfg)}
+}
+
+func marshalItem(t *testing.T, in interface{}) map[string]types.AttributeValue {
+	item, err := attributevalue.MarshalMap(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestDB_GetPlant_Trashed(t *testing.T) {
+	deletedAt := time.Now().UTC()
+	db := newMockedDB(t, map[string]mockOutput{
+		"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test", DeletedAt: &deletedAt})}},
+	})
+	_, err := db.GetPlant("test", context.TODO())
+	if err == nil || err.Error() != ErrNotFound {
+		t.Errorf("DB.GetPlant() error = %v, want %s", err, ErrNotFound)
+	}
+}
+
+func TestDB_UpdatePlant_Unchanged(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var transaction *dynamodb.TransactWriteItemsInput
+	db := newPartitionedDB(t, func(input interface{}) interface{} {
+		switch input := input.(type) {
+		case *dynamodb.GetItemInput:
+			return &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "old", UpdatedAt: &updatedAt})}
+		case *dynamodb.TransactWriteItemsInput:
+			transaction = input
+		}
+		return &dynamodb.TransactWriteItemsOutput{}
+	})
+	err := db.UpdatePlant(pkg.Plant{Name: "test", Description: "new"}, "test", context.TODO())
+	if err != nil {
+		t.Fatalf("DB.UpdatePlant() error = %v", err)
+	}
+	// the write is conditioned on the plant's updated_at as it was read
+	update := transaction.TransactItems[0].Update
+	if !strings.Contains(aws.ToString(update.ConditionExpression), "updated_at = :updated_at") {
+		t.Errorf("DB.UpdatePlant() condition = %s, want it to hold only while updated_at is unchanged", aws.ToString(update.ConditionExpression))
+	}
+	want, err := attributevalue.Marshal(updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(update.ExpressionAttributeValues[":updated_at"], want) {
+		t.Errorf("DB.UpdatePlant() conditioned on updated_at = %v, want %v", update.ExpressionAttributeValues[":updated_at"], updatedAt)
+	}
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// identitiesTable is keyed by key, which is either a plant's id or one of its slugs
+const identitiesTable = "plants_v1_identities"
+
+// maxSlugRedirects is how many renames in a row an old slug is followed through
+const maxSlugRedirects = 5
+
+// renamedFromAttribute holds the old name on the item a rename writes, so readers of the
+// table's stream see a rename rather than a new plant. It only matters when the item is
+// inserted, so later writes of the plant need not keep it.
+const renamedFromAttribute = "renamed_from"
+
+// ErrNameTaken is returned when a plant's name has the same slug as another plant's
+var ErrNameTaken = errors.New("name is taken by another plant")
+
+// ErrPlantChanged is returned when a plant changed while it was being updated or renamed
+var ErrPlantChanged = errors.New("plant has changed")
+
+// ErrRevisionsNotCopied is returned with the renamed plant when a rename was made but the
+// revisions of the plant's old name could not all be copied to its new one
+var ErrRevisionsNotCopied = errors.New("plant was renamed but its revisions were not all copied")
+
+// identity is an item of the identities table. An id item finds a plant's current name and
+// slug. A slug item reserves the slug for the plant with the id, or redirects to the slug
+// the plant was renamed to.
+type identity struct {
+	Key        string `dynamodbav:"key"`
+	ID         string `dynamodbav:"id,omitempty"`
+	Name       string `dynamodbav:"name,omitempty"`
+	Slug       string `dynamodbav:"slug,omitempty"`
+	RedirectTo string `dynamodbav:"redirect_to,omitempty"`
+}
+
+func idKey(id string) string {
+	return "id#" + id
+}
+
+func slugKey(slug string) string {
+	return "slug#" + slug
+}
+
+// assignIdentity gives a plant without an id a new one, and the slug of its name
+func assignIdentity(plant *pkg.Plant) error {
+	if plant.ID == "" {
+		plant.ID = pkg.NewPlantID()
+	}
+	plant.Slug = pkg.Slugify(plant.Name)
+	if plant.Slug == "" {
+		return errors.New("name has no letters or digits")
+	}
+	return nil
+}
+
+// identityItems builds the transaction items which record the plant's id and reserve its
+// slug. A slug may be taken from a redirect, from the plant itself or from the plant with
+// the stale id, which no longer exists.
+func identityItems(plant pkg.Plant, stale string) ([]types.TransactWriteItem, error) {
+	ref := pkg.PlantRef{ID: plant.ID, Name: plant.Name, Slug: plant.Slug}
+	idItem, err := attributevalue.MarshalMap(identity{Key: idKey(ref.ID), ID: ref.ID, Name: ref.Name, Slug: ref.Slug})
+	if err != nil {
+		return nil, err
+	}
+	slugItem, err := attributevalue.MarshalMap(identity{Key: slugKey(ref.Slug), ID: ref.ID, Name: ref.Name, Slug: ref.Slug})
+	if err != nil {
+		return nil, err
+	}
+	condition := "attribute_not_exists(#key) or attribute_exists(redirect_to) or id = :id"
+	values := map[string]types.AttributeValue{":id": &types.AttributeValueMemberS{Value: ref.ID}}
+	if stale != "" {
+		condition += " or id = :stale"
+		values[":stale"] = &types.AttributeValueMemberS{Value: stale}
+	}
+	return []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(identitiesTable), Item: slugItem, ConditionExpression: aws.String(condition), ExpressionAttributeNames: map[string]string{"#key": "key"}, ExpressionAttributeValues: values,
+		}},
+		{Put: &types.Put{TableName: aws.String(identitiesTable), Item: idItem}},
+	}, nil
+}
+
+// releaseIdentity builds the transaction items which remove a plant's id and free its slug,
+// unless the slug has since been given to another plant
+func releaseIdentity(plant pkg.Plant) []types.TransactWriteItem {
+	return []types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(identitiesTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: slugKey(plant.Slug)}}, ConditionExpression: aws.String("attribute_not_exists(#key) or id = :id"), ExpressionAttributeNames: map[string]string{"#key": "key"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: plant.ID},
+			},
+		}},
+		{Delete: &types.Delete{TableName: aws.String(identitiesTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: idKey(plant.ID)}}}},
+	}
+}
+
+// writeWithIdentity writes the items along with the plant's identity items in one
+// transaction. If the plant's slug is reserved by a plant which no longer exists, such as
+// one purged from the trash, the slug is taken over. If it belongs to another plant
+// ErrNameTaken is returned.
+func (db *DB) writeWithIdentity(plant pkg.Plant, items []types.TransactWriteItem, context context.Context) error {
+	stale := ""
+	for {
+		reserve, err := identityItems(plant, stale)
+		if err != nil {
+			return err
+		}
+		_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: append(items, reserve...)})
+		if !conditionFailed(err, len(items)) || stale != "" {
+			return err
+		}
+		for i := range items {
+			if conditionFailed(err, i) {
+				// one of the caller's own items failed, which is theirs to report
+				return err
+			}
+		}
+		owner, lookupErr := db.getIdentity(slugKey(plant.Slug), context)
+		if lookupErr != nil || owner.ID == plant.ID || owner.RedirectTo != "" {
+			// the slug has since been freed, so it is worth another try by the caller
+			return err
+		}
+		if existing, lookupErr := db.getPlantItem(owner.Name, context); lookupErr == nil && existing.ID == owner.ID {
+			return ErrNameTaken
+		}
+		stale = owner.ID
+	}
+}
+
+// getIdentity returns the identity item with the key
+func (db *DB) getIdentity(key string, context context.Context) (*identity, error) {
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(identitiesTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := &identity{}
+	err = attributevalue.UnmarshalMap(output.Item, found)
+	if err != nil {
+		return nil, err
+	}
+	if found.Key == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return found, nil
+}
+
+// ResolvePlant finds the plant with an id, or with a slug which is the key's once slugified.
+// Slugs a plant had before it was renamed are followed to its current slug.
+func (db *DB) ResolvePlant(key string, context context.Context) (*pkg.PlantRef, error) {
+	if key == "" {
+		return nil, errors.New("missing key")
+	}
+	found, err := db.getIdentity(idKey(key), context)
+	if err == nil {
+		return &pkg.PlantRef{ID: found.ID, Name: found.Name, Slug: found.Slug}, nil
+	}
+	if err.Error() != ErrNotFound {
+		return nil, err
+	}
+	slug := pkg.Slugify(key)
+	for i := 0; i < maxSlugRedirects && slug != ""; i++ {
+		found, err := db.getIdentity(slugKey(slug), context)
+		if err != nil {
+			return nil, err
+		}
+		if found.RedirectTo == "" {
+			return &pkg.PlantRef{ID: found.ID, Name: found.Name, Slug: found.Slug}, nil
+		}
+		slug = found.RedirectTo
+	}
+	return nil, errors.New(ErrNotFound)
+}
+
+// RenamePlant gives a published plant a new name, keeping its id. The plant is moved to the
+// new name with a rename revision, its old slug redirects to its new one and its earlier
+// revisions are copied to the new name so its history follows it.
+func (db *DB) RenamePlant(name string, newName string, author string, context context.Context) (*pkg.Plant, error) {
+	if name == "" || newName == "" || name == newName {
+		return nil, errors.New("missing or identical plant names")
+	}
+	current, err := db.GetPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	renamed := *current
+	renamed.Name = newName
+	err = assignIdentity(&renamed)
+	if err != nil {
+		return nil, err
+	}
+	revision := newRevision(renamed, pkg.RevisionRename, author, "renamed from "+name)
+	item, err := attributevalue.MarshalMap(revision.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	// tells the table's stream the insert is a rename rather than a new plant
+	item[renamedFromAttribute] = &types.AttributeValueMemberS{Value: name}
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	condition, values, err := unchangedCondition(*current)
+	if err != nil {
+		return nil, err
+	}
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	items := append([]types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, ConditionExpression: aws.String(condition), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: values,
+		}},
+		{Put: &types.Put{
+			TableName: aws.String(plantsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#name)"), ExpressionAttributeNames: map[string]string{"#name": "name"},
+		}},
+	}, change...)
+	if current.Slug != "" && current.Slug != renamed.Slug {
+		redirect, err := attributevalue.MarshalMap(identity{Key: slugKey(current.Slug), RedirectTo: renamed.Slug})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, types.TransactWriteItem{Put: &types.Put{TableName: aws.String(identitiesTable), Item: redirect}})
+	}
+	err = db.writeWithIdentity(renamed, items, context)
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		if conditionFailed(err, 0) {
+			return nil, ErrPlantChanged
+		}
+		return nil, ErrNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.CopyRevisions(name, revision.Snapshot, context)
+	if err != nil {
+		return &revision.Snapshot, fmt.Errorf("%w: %w", ErrRevisionsNotCopied, err)
+	}
+	return &revision.Snapshot, nil
+}
+
+// CopyRevisions copies the revisions of the plant's old name to its new one, as snapshots of
+// the plant under its new name so they can be reverted to. Revisions already copied are
+// skipped, so a copy which failed part way can be made again.
+func (db *DB) CopyRevisions(oldName string, plant pkg.Plant, context context.Context) error {
+	revisions, err := db.GetRevisions(oldName, context)
+	if err != nil {
+		return err
+	}
+	var failed []error
+	for _, revision := range revisions {
+		revision.PlantName = plant.Name
+		revision.Snapshot.Name = plant.Name
+		revision.Snapshot.ID = plant.ID
+		revision.Snapshot.Slug = plant.Slug
+		put, err := revisionPut(revision)
+		if err == nil {
+			_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+				TableName: put.Put.TableName, Item: put.Put.Item, ConditionExpression: put.Put.ConditionExpression, ExpressionAttributeNames: put.Put.ExpressionAttributeNames,
+			})
+		}
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// copied by an earlier attempt
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("failed to copy revision %s of %s to %s: %w", revision.ID, oldName, plant.Name, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// conditionFailed reports whether err is a canceled transaction whose item at index failed
+// its condition
+func conditionFailed(err error, index int) bool {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || index >= len(canceled.CancellationReasons) {
+		return false
+	}
+	return aws.ToString(canceled.CancellationReasons[index].Code) == "ConditionalCheckFailed"
+}
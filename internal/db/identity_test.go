@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDB_ResolvePlant(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		outputs map[string]mockOutput
+		want    *pkg.PlantRef
+		wantErr error
+	}{
+		{
+			name:    "resolve plant returns error if key is missing",
+			wantErr: errors.New("missing key"),
+		},
+		{
+			name: "resolve plant returns not found if neither the id nor the slug is known",
+			key:  "Snake Plant",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{}},
+			},
+			wantErr: errors.New(ErrNotFound),
+		},
+		{
+			name: "resolve plant returns the plant with the id",
+			key:  "0123456789abcdef",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, identity{Key: idKey("0123456789abcdef"), ID: "0123456789abcdef", Name: "Snake Plant", Slug: "snake-plant"})}},
+			},
+			want: &pkg.PlantRef{ID: "0123456789abcdef", Name: "Snake Plant", Slug: "snake-plant"},
+		},
+		{
+			name: "resolve plant returns error if client fails",
+			key:  "snake-plant",
+			outputs: map[string]mockOutput{
+				"GetItem": {err: errors.New("test")},
+			},
+			wantErr: errors.New("test"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.ResolvePlant(tt.key, context.TODO())
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("DB.ResolvePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// client errors come back wrapped with the operation which failed
+			if err != nil && !strings.HasSuffix(err.Error(), tt.wantErr.Error()) {
+				t.Errorf("DB.ResolvePlant() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != nil && *got != *tt.want {
+				t.Errorf("DB.ResolvePlant() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_RenamePlant(t *testing.T) {
+	current := pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: "0123456789abcdef", Slug: "snake-plant"}
+	tests := []struct {
+		name    string
+		newName string
+		outputs map[string]mockOutput
+		wantErr error
+	}{
+		{
+			name:    "rename plant returns error if names are the same",
+			newName: "Snake Plant",
+			wantErr: errors.New("missing or identical plant names"),
+		},
+		{
+			name:    "rename plant returns error if the new name has no letters or digits",
+			newName: "--",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, current)}},
+			},
+			wantErr: errors.New("name has no letters or digits"),
+		},
+		{
+			name:    "rename plant returns plant changed if the plant changed during the rename",
+			newName: "Sansevieria",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, current)}},
+				"TransactWriteItems": {err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+					{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")},
+				}}},
+			},
+			wantErr: ErrPlantChanged,
+		},
+		{
+			name:    "rename plant returns name taken if another plant has the new name",
+			newName: "Sansevieria",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, current)}},
+				"TransactWriteItems": {err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+					{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+				}}},
+			},
+			wantErr: ErrNameTaken,
+		},
+		{
+			name:    "rename plant keeps the plant's id and gives it a new slug if client is successful",
+			newName: "Sansevieria",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, current)}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+				"Query":              {result: &dynamodb.QueryOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.RenamePlant("Snake Plant", tt.newName, "test", context.TODO())
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("DB.RenamePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err.Error() != tt.wantErr.Error() {
+				t.Errorf("DB.RenamePlant() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Name != tt.newName || got.ID != current.ID || got.Slug != "sansevieria") {
+				t.Errorf("DB.RenamePlant() = %v, want %s with id %s and slug sansevieria", got, tt.newName, current.ID)
+			}
+		})
+	}
+}
+
+func TestDB_RenamePlant_MarksRename(t *testing.T) {
+	current := pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: "0123456789abcdef", Slug: "snake-plant"}
+	var renamed map[string]types.AttributeValue
+	db := newRespondingDB(t, func(input interface{}) (interface{}, error) {
+		switch input := input.(type) {
+		case *dynamodb.GetItemInput:
+			return &dynamodb.GetItemOutput{Item: marshalItem(t, current)}, nil
+		case *dynamodb.TransactWriteItemsInput:
+			for _, item := range input.TransactItems {
+				if item.Put != nil && aws.ToString(item.Put.TableName) == plantsTable {
+					renamed = item.Put.Item
+				}
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		case *dynamodb.QueryInput:
+			return &dynamodb.QueryOutput{}, nil
+		}
+		return nil, errors.New("unexpected input")
+	})
+	_, err := db.RenamePlant("Snake Plant", "Sansevieria", "test", context.TODO())
+	if err != nil {
+		t.Fatalf("DB.RenamePlant() error = %v", err)
+	}
+	if from, ok := renamed[renamedFromAttribute].(*types.AttributeValueMemberS); !ok || from.Value != "Snake Plant" {
+		t.Errorf("DB.RenamePlant() wrote %v, want it marked as renamed from Snake Plant", renamed)
+	}
+}
+
+func TestDB_RenamePlant_CopiesRevisions(t *testing.T) {
+	current := pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: "0123456789abcdef", Slug: "snake-plant"}
+	revisions := []map[string]types.AttributeValue{
+		marshalItem(t, pkg.Revision{PlantName: "Snake Plant", ID: "1", Action: pkg.RevisionCreate, Snapshot: current}),
+		marshalItem(t, pkg.Revision{PlantName: "Snake Plant", ID: "2", Action: pkg.RevisionUpdate, Snapshot: current}),
+	}
+	tests := []struct {
+		name    string
+		putErr  map[string]error
+		wantErr error
+	}{
+		{
+			name:   "rename plant skips revisions copied by an earlier attempt",
+			putErr: map[string]error{"1": &types.ConditionalCheckFailedException{}},
+		},
+		{
+			name:    "rename plant returns the renamed plant and an error if revisions were not copied",
+			putErr:  map[string]error{"2": errors.New("throttled")},
+			wantErr: ErrRevisionsNotCopied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newRespondingDB(t, func(input interface{}) (interface{}, error) {
+				switch input := input.(type) {
+				case *dynamodb.GetItemInput:
+					return &dynamodb.GetItemOutput{Item: marshalItem(t, current)}, nil
+				case *dynamodb.TransactWriteItemsInput:
+					return &dynamodb.TransactWriteItemsOutput{}, nil
+				case *dynamodb.QueryInput:
+					return &dynamodb.QueryOutput{Items: revisions}, nil
+				case *dynamodb.PutItemInput:
+					if input.Item["plant_name"].(*types.AttributeValueMemberS).Value != "Sansevieria" {
+						t.Errorf("DB.RenamePlant() copied %v, want it under the new name", input.Item)
+					}
+					return &dynamodb.PutItemOutput{}, tt.putErr[input.Item["id"].(*types.AttributeValueMemberS).Value]
+				}
+				return nil, errors.New("unexpected input")
+			})
+			plant, err := db.RenamePlant("Snake Plant", "Sansevieria", "test", context.TODO())
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Errorf("DB.RenamePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if plant == nil || plant.Name != "Sansevieria" {
+				t.Errorf("DB.RenamePlant() = %v, want the renamed plant", plant)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AddPlantImage appends an image to the named plant and records an update revision
+func (db *DB) AddPlantImage(name string, image pkg.Image, author string, context context.Context) (*pkg.Plant, error) {
+	if name == "" || image.ID == "" {
+		return nil, errors.New("missing name or image id")
+	}
+	plant, err := db.GetPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	plant.Images = append(plant.Images, image)
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	imageattribute, err := attributevalue.Marshal([]pkg.Image{image})
+	if err != nil {
+		return nil, err
+	}
+	revision := newRevision(*plant, pkg.RevisionUpdate, author, "added image "+image.ID)
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	updatedattribute, err := attributevalue.Marshal(revision.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set images = list_append(if_not_exists(images, :empty), :image), updated_at = :updated_at"), ConditionExpression: aws.String("attribute_exists(#name) and attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)"), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+					":image":      imageattribute,
+					":empty":      &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+					":updated_at": updatedattribute,
+				},
+			}},
+		}, change...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Snapshot, nil
+}
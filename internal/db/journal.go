@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// journalTable is partitioned by owner, the user and collection entry joined by "#", and
+// sorted by id, which starts with the fixed width time the entry occurred at
+const journalTable = "plants_v1_journal"
+
+// journalTimeFormat keeps a constant width so ids sort in time order
+const journalTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// JournalID builds a journal entry id which sorts by the time the entry occurred
+func JournalID(occurredAt time.Time, suffix string) string {
+	return occurredAt.UTC().Format(journalTimeFormat) + "_" + suffix
+}
+
+func journalOwner(userID string, collectionEntryID string) string {
+	return userID + "#" + collectionEntryID
+}
+
+func journalItem(entry pkg.JournalEntry) (map[string]types.AttributeValue, error) {
+	if entry.UserID == "" || entry.CollectionEntryID == "" || entry.ID == "" {
+		return nil, errors.New("missing user, collection entry or journal id")
+	}
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return nil, err
+	}
+	item["owner"] = &types.AttributeValueMemberS{Value: journalOwner(entry.UserID, entry.CollectionEntryID)}
+	return item, nil
+}
+
+func journalKey(userID string, collectionEntryID string, id string) (map[string]types.AttributeValue, error) {
+	if userID == "" || collectionEntryID == "" || id == "" {
+		return nil, errors.New("missing user, collection entry or journal id")
+	}
+	return attributevalue.MarshalMap(map[string]string{"owner": journalOwner(userID, collectionEntryID), "id": id})
+}
+
+func (db *DB) CreateJournalEntry(entry pkg.JournalEntry, context context.Context) error {
+	item, err := journalItem(entry)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(journalTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	return err
+}
+
+// GetJournal returns the journal of a collection entry between from and to inclusive,
+// oldest first. A zero from or to leaves that end of the range open.
+func (db *DB) GetJournal(userID string, collectionEntryID string, from time.Time, to time.Time, context context.Context) ([]pkg.JournalEntry, error) {
+	if userID == "" || collectionEntryID == "" {
+		return nil, errors.New("missing user or collection entry")
+	}
+	lower := ""
+	upper := "~"
+	if !from.IsZero() {
+		lower = from.UTC().Format(journalTimeFormat)
+	}
+	if !to.IsZero() {
+		// "~" sorts after the "_" separating the time from the suffix
+		upper = to.UTC().Format(journalTimeFormat) + "~"
+	}
+	entries := []pkg.JournalEntry{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(journalTable), KeyConditionExpression: aws.String("#owner = :owner and #id between :lower and :upper"), ExpressionAttributeNames: map[string]string{"#owner": "owner", "#id": "id"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: journalOwner(userID, collectionEntryID)},
+			":lower": &types.AttributeValueMemberS{Value: lower},
+			":upper": &types.AttributeValueMemberS{Value: upper},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.JournalEntry
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+func (db *DB) GetJournalEntry(userID string, collectionEntryID string, id string, context context.Context) (*pkg.JournalEntry, error) {
+	key, err := journalKey(userID, collectionEntryID, id)
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{Key: key, TableName: aws.String(journalTable)})
+	if err != nil {
+		return nil, err
+	}
+	entry := &pkg.JournalEntry{}
+	err = attributevalue.UnmarshalMap(output.Item, entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return entry, nil
+}
+
+// UpdateJournalEntry replaces the entry stored under previousID. When the time of the
+// entry changes so does its id, and the old item is removed in the same transaction.
+func (db *DB) UpdateJournalEntry(previousID string, entry pkg.JournalEntry, context context.Context) error {
+	item, err := journalItem(entry)
+	if err != nil {
+		return err
+	}
+	if previousID == entry.ID {
+		_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+			TableName: aws.String(journalTable), Item: item, ConditionExpression: aws.String("attribute_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return errors.New(ErrNotFound)
+		}
+		return err
+	}
+	key, err := journalKey(entry.UserID, entry.CollectionEntryID, previousID)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: aws.String(journalTable), Key: key, ConditionExpression: aws.String("attribute_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"}}},
+			{Put: &types.Put{TableName: aws.String(journalTable), Item: item}},
+		},
+	})
+	return err
+}
+
+func (db *DB) DeleteJournalEntry(userID string, collectionEntryID string, id string, context context.Context) (*pkg.JournalEntry, error) {
+	key, err := journalKey(userID, collectionEntryID, id)
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(journalTable), Key: key, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry := &pkg.JournalEntry{}
+	err = attributevalue.UnmarshalMap(output.Attributes, entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return entry, nil
+}
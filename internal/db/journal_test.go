@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestJournalID(t *testing.T) {
+	early := JournalID(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), "b")
+	late := JournalID(time.Date(2024, 5, 1, 9, 0, 0, 500, time.UTC), "a")
+	if early >= late {
+		t.Errorf("JournalID() = %s, %s: ids do not sort by time", early, late)
+	}
+	local := JournalID(time.Date(2024, 5, 1, 11, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), "b")
+	if local != early {
+		t.Errorf("JournalID() = %s, want %s", local, early)
+	}
+}
+
+func TestDB_UpdateJournalEntry(t *testing.T) {
+	entry := pkg.JournalEntry{UserID: "user", CollectionEntryID: "1", ID: "2024-05-01T09:00:00.000000000Z_a", Kind: pkg.TaskWater}
+	tests := []struct {
+		name       string
+		previousID string
+		outputs    map[string]mockOutput
+		wantErr    bool
+		errText    string
+	}{
+		{
+			name:       "update journal entry returns not found if the entry does not exist",
+			previousID: entry.ID,
+			outputs: map[string]mockOutput{
+				"PutItem": {err: &types.ConditionalCheckFailedException{}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name:       "update journal entry puts the entry in place if its id is unchanged",
+			previousID: entry.ID,
+			outputs: map[string]mockOutput{
+				"PutItem": {result: &dynamodb.PutItemOutput{}},
+			},
+		},
+		{
+			name:       "update journal entry moves the entry if its id changed",
+			previousID: "2024-04-01T09:00:00.000000000Z_a",
+			outputs: map[string]mockOutput{
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			err := db.UpdateJournalEntry(tt.previousID, entry, context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.UpdateJournalEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.UpdateJournalEntry() error = %v, errText = %s", err, tt.errText)
+			}
+		})
+	}
+}
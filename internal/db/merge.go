@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// redirectsTable is keyed by name, the name of a plant which was merged into another
+const redirectsTable = "plants_v1_redirects"
+
+// ErrMergeConflict is returned when either plant of a merge changed while it was merged
+var ErrMergeConflict = errors.New("plants changed during merge")
+
+// MergePlants merges the plant named from into the plant named into, removing from and
+// leaving a redirect at its name. Fields are combined by pkg.CombinePlants, and both plants
+// record a merge revision. Both must be published, and neither may change under the merge.
+func (db *DB) MergePlants(into string, from string, author string, context context.Context) (*pkg.Plant, error) {
+	if into == "" || from == "" || into == from {
+		return nil, errors.New("missing or identical plant names")
+	}
+	target, err := db.GetPlant(into, context)
+	if err != nil {
+		return nil, err
+	}
+	source, err := db.GetPlant(from, context)
+	if err != nil {
+		return nil, err
+	}
+	combined := pkg.CombinePlants(*target, *source)
+	summary := "merged " + from + ", " + pkg.SummarizeChanges(pkg.DiffPlants(*target, combined))
+	revision := newRevision(combined, pkg.RevisionMerge, author, summary)
+	item, err := attributevalue.MarshalMap(revision.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	// the catalog version is bumped once, by the revision of the plant merged into
+	removed, err := revisionPut(newRevision(*source, pkg.RevisionMerge, author, "merged into "+into))
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := attributevalue.MarshalMap(pkg.Redirect{Name: from, To: into, CreatedBy: author, CreatedAt: revision.Timestamp})
+	if err != nil {
+		return nil, err
+	}
+	targetCondition, targetValues, err := unchangedCondition(*target)
+	if err != nil {
+		return nil, err
+	}
+	sourceCondition, sourceValues, err := unchangedCondition(*source)
+	if err != nil {
+		return nil, err
+	}
+	nameattribute, err := attributevalue.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+	items := append([]types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(plantsTable), Item: item, ConditionExpression: aws.String(targetCondition), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: targetValues,
+		}},
+		{Delete: &types.Delete{
+			TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, ConditionExpression: aws.String(sourceCondition), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: sourceValues,
+		}},
+		{Put: &types.Put{TableName: aws.String(redirectsTable), Item: redirect}},
+		removed,
+	}, change...)
+	if source.Slug != "" && target.Slug != "" {
+		// the merged plant's slug redirects to the slug of the plant it was merged into
+		slugRedirect, err := attributevalue.MarshalMap(identity{Key: slugKey(source.Slug), RedirectTo: target.Slug})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, types.TransactWriteItem{Put: &types.Put{TableName: aws.String(identitiesTable), Item: slugRedirect}})
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		return nil, ErrMergeConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Snapshot, nil
+}
+
+// unchangedCondition builds a condition which holds while the plant is published and has
+// not been written since it was read
+func unchangedCondition(plant pkg.Plant) (string, map[string]types.AttributeValue, error) {
+	condition := "attribute_exists(#name) and attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)"
+	if plant.UpdatedAt == nil {
+		return condition + " and attribute_not_exists(updated_at)", nil, nil
+	}
+	updatedattribute, err := attributevalue.Marshal(plant.UpdatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return condition + " and updated_at = :updated_at", map[string]types.AttributeValue{":updated_at": updatedattribute}, nil
+}
+
+// GetRedirect returns the redirect left at the name of a plant which was merged away
+func (db *DB) GetRedirect(name string, context context.Context) (*pkg.Redirect, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	key, err := attributevalue.MarshalMap(map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{Key: key, TableName: aws.String(redirectsTable)})
+	if err != nil {
+		return nil, err
+	}
+	redirect := &pkg.Redirect{}
+	err = attributevalue.UnmarshalMap(output.Item, redirect)
+	if err != nil {
+		return nil, err
+	}
+	if redirect.Name == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return redirect, nil
+}
+
+// RepointCollections moves every user's collection entries for the plant named from to the
+// plant named to, returning how many were moved. Entries are moved one at a time, since
+// there may be more than fit in a transaction. Every entry is tried even if some fail, and
+// the failures are returned together. Entries already moved are not found again, so it is
+// safe to call again until it succeeds.
+func (db *DB) RepointCollections(from string, to string, context context.Context) (int, error) {
+	if from == "" || to == "" {
+		return 0, errors.New("missing plant names")
+	}
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(collectionsTable), FilterExpression: aws.String("plant_name = :from"), ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: from},
+		},
+	}
+	entries := []pkg.CollectionEntry{}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return 0, err
+		}
+		var page []pkg.CollectionEntry
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	updatedattribute, err := attributevalue.Marshal(time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	var failed []error
+	for _, entry := range entries {
+		key, err := collectionKey(entry.UserID, entry.ID)
+		if err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		_, err = db.client.UpdateItem(context, &dynamodb.UpdateItemInput{
+			TableName: aws.String(collectionsTable), Key: key, UpdateExpression: aws.String("set plant_name = :to, updated_at = :updated_at"), ConditionExpression: aws.String("plant_name = :from"), ExpressionAttributeValues: map[string]types.AttributeValue{
+				":from":       &types.AttributeValueMemberS{Value: from},
+				":to":         &types.AttributeValueMemberS{Value: to},
+				":updated_at": updatedattribute,
+			},
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// the entry was changed or removed since it was read
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("failed to move collection entry %s of %s: %w", entry.ID, entry.UserID, err))
+			continue
+		}
+		moved++
+	}
+	return moved, errors.Join(failed...)
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDB_MergePlants(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		outputs map[string]mockOutput
+		wantErr error
+	}{
+		{
+			name:    "merge plants returns error if plants are the same",
+			from:    "pothos",
+			wantErr: errors.New("missing or identical plant names"),
+		},
+		{
+			name: "merge plants returns error if a plant is not found",
+			from: "golden pothos",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{}},
+			},
+			wantErr: errors.New(ErrNotFound),
+		},
+		{
+			name: "merge plants returns conflict if a plant changed during the merge",
+			from: "golden pothos",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "pothos", Description: "trailing vine"})}},
+				"TransactWriteItems": {err: &types.TransactionCanceledException{}},
+			},
+			wantErr: ErrMergeConflict,
+		},
+		{
+			name: "merge plants returns the merged plant if client is successful",
+			from: "golden pothos",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "pothos", Description: "trailing vine"})}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.MergePlants("pothos", tt.from, "test", context.TODO())
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("DB.MergePlants() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err.Error() != tt.wantErr.Error() {
+				t.Errorf("DB.MergePlants() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Name != "pothos" || got.UpdatedAt == nil) {
+				t.Errorf("DB.MergePlants() = %v, want pothos with updated_at set", got)
+			}
+		})
+	}
+}
+
+func TestDB_RepointCollections(t *testing.T) {
+	entries := []pkg.CollectionEntry{
+		{UserID: "user1", ID: "1", PlantName: "golden pothos"},
+		{UserID: "user2", ID: "2", PlantName: "golden pothos"},
+		{UserID: "user3", ID: "3", PlantName: "golden pothos"},
+	}
+	items := []map[string]types.AttributeValue{}
+	for _, entry := range entries {
+		items = append(items, marshalItem(t, entry))
+	}
+	db := newRespondingDB(t, func(input interface{}) (interface{}, error) {
+		switch input := input.(type) {
+		case *dynamodb.ScanInput:
+			return &dynamodb.ScanOutput{Items: items}, nil
+		case *dynamodb.UpdateItemInput:
+			switch input.Key["id"].(*types.AttributeValueMemberS).Value {
+			case "1":
+				return nil, errors.New("throttled")
+			case "2":
+				return nil, &types.ConditionalCheckFailedException{}
+			}
+			return &dynamodb.UpdateItemOutput{}, nil
+		}
+		return nil, errors.New("unexpected input")
+	})
+	moved, err := db.RepointCollections("golden pothos", "pothos", context.TODO())
+	if err == nil {
+		t.Errorf("DB.RepointCollections() error = nil, want the entry which failed to move")
+	}
+	if moved != 1 {
+		t.Errorf("DB.RepointCollections() moved = %v, want 1", moved)
+	}
+}
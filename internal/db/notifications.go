@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// preferencesTable holds each user's notification preferences, keyed by user_id
+	preferencesTable = "plants_v1_notification_preferences"
+	// deliveriesTable records reminders sent to each user, keyed by user_id and the delivery id
+	deliveriesTable = "plants_v1_deliveries"
+)
+
+// GetNotificationPreferences returns the user's preferences, or not found if they have never set any
+func (db *DB) GetNotificationPreferences(userID string, context context.Context) (*pkg.NotificationPreferences, error) {
+	if userID == "" {
+		return nil, errors.New("missing user")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(preferencesTable), Key: map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	preferences := &pkg.NotificationPreferences{}
+	err = attributevalue.UnmarshalMap(output.Item, preferences)
+	if err != nil {
+		return nil, err
+	}
+	if preferences.UserID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return preferences, nil
+}
+
+func (db *DB) PutNotificationPreferences(preferences pkg.NotificationPreferences, context context.Context) error {
+	if preferences.UserID == "" {
+		return errors.New("missing user")
+	}
+	item, err := attributevalue.MarshalMap(preferences)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(preferencesTable), Item: item})
+	return err
+}
+
+// ListNotificationPreferences returns the preferences of every user who has set them
+func (db *DB) ListNotificationPreferences(context context.Context) ([]pkg.NotificationPreferences, error) {
+	all := []pkg.NotificationPreferences{}
+	input := &dynamodb.ScanInput{TableName: aws.String(preferencesTable)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.NotificationPreferences
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return all, nil
+}
+
+// GetDeliveries returns the reminders recorded for the user which have not yet expired
+func (db *DB) GetDeliveries(userID string, context context.Context) ([]pkg.Delivery, error) {
+	if userID == "" {
+		return nil, errors.New("missing user")
+	}
+	deliveries := []pkg.Delivery{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(deliveriesTable), KeyConditionExpression: aws.String("#user_id = :user_id"), ExpressionAttributeNames: map[string]string{"#user_id": "user_id"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Delivery
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return deliveries, nil
+}
+
+func (db *DB) PutDelivery(delivery pkg.Delivery, context context.Context) error {
+	if delivery.UserID == "" || delivery.ID == "" {
+		return errors.New("missing user or delivery id")
+	}
+	item, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(deliveriesTable), Item: item})
+	return err
+}
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// tenantAttribute holds the tenant on items of tenants other than the default
+const tenantAttribute = "tenant"
+
+// partitionKeys names the partition key of every table whose items belong to a tenant.
+// The api keys and tenants tables are shared by every tenant.
+var partitionKeys = map[string]string{
+	plantsTable:            "name",
+	revisionsTable:         "plant_name",
+	catalogTable:           "catalog",
+	collectionsTable:       "user_id",
+	tasksTable:             "user_id",
+	journalTable:           "owner",
+	calendarTokensTable:    "key",
+	preferencesTable:       "user_id",
+	deliveriesTable:        "user_id",
+	webhooksTable:          "id",
+	webhookDeliveriesTable: "subscription_id",
+	rolesTable:             "name",
+	roleAssignmentsTable:   "subject",
+	proposalsTable:         "id",
+	suggestionsTable:       "plant_name",
+	redirectsTable:         "name",
+	identitiesTable:        "key",
+}
+
+// keyCondition matches each comparison in a key condition expression
+var keyCondition = regexp.MustCompile(`(#?[A-Za-z0-9_]+)\s*=\s*(:[A-Za-z0-9_]+)`)
+
+// setClause finds the set clause of an update expression
+var setClause = regexp.MustCompile(`(?i)\bset\b`)
+
+// PartitionByTenant is a client option which keeps each tenant's items apart, so that no
+// method can reach another tenant's data whatever it asks for. The tenant is read from the
+// context of each call. Partition keys are scoped to the tenant and items are marked with
+// it on the way in, scans only see the tenant's items, and on the way out keys are
+// unscoped and items of any other tenant are dropped.
+func PartitionByTenant(options *dynamodb.Options) {
+	options.APIOptions = append(options.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("PartitionByTenant", partitionByTenant), middleware.Before)
+	})
+}
+
+func partitionByTenant(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	p := partition{tenant: tenant.ID(ctx)}
+	var table string
+	switch input := in.Parameters.(type) {
+	case *dynamodb.GetItemInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.Key = p.key(table, input.Key)
+		in.Parameters = &copied
+	case *dynamodb.PutItemInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.Item = p.item(table, input.Item)
+		copied.ConditionExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues = p.condition(table, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		in.Parameters = &copied
+	case *dynamodb.UpdateItemInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.Key = p.key(table, input.Key)
+		copied.UpdateExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues = p.update(table, input.UpdateExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		copied.ConditionExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues = p.condition(table, input.ConditionExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues)
+		in.Parameters = &copied
+	case *dynamodb.DeleteItemInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.Key = p.key(table, input.Key)
+		copied.ConditionExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues = p.condition(table, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		in.Parameters = &copied
+	case *dynamodb.QueryInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.ExpressionAttributeValues = p.keyCondition(table, aws.ToString(input.KeyConditionExpression), input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		in.Parameters = &copied
+	case *dynamodb.ScanInput:
+		copied := *input
+		table = aws.ToString(input.TableName)
+		copied.FilterExpression, copied.ExpressionAttributeNames, copied.ExpressionAttributeValues = p.filter(table, input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		in.Parameters = &copied
+	case *dynamodb.TransactWriteItemsInput:
+		copied := *input
+		copied.TransactItems = make([]types.TransactWriteItem, len(input.TransactItems))
+		for i, item := range input.TransactItems {
+			copied.TransactItems[i] = p.transactItem(item)
+		}
+		in.Parameters = &copied
+	}
+
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	if err != nil {
+		return out, metadata, err
+	}
+	switch output := out.Result.(type) {
+	case *dynamodb.GetItemOutput:
+		output.Item = p.unscope(table, output.Item)
+	case *dynamodb.PutItemOutput:
+		output.Attributes = p.unscope(table, output.Attributes)
+	case *dynamodb.UpdateItemOutput:
+		output.Attributes = p.unscope(table, output.Attributes)
+	case *dynamodb.DeleteItemOutput:
+		output.Attributes = p.unscope(table, output.Attributes)
+	case *dynamodb.QueryOutput:
+		output.Items = p.unscopeAll(table, output.Items)
+	case *dynamodb.ScanOutput:
+		output.Items = p.unscopeAll(table, output.Items)
+	}
+	return out, metadata, err
+}
+
+// partition scopes requests to one tenant. Inputs are copied rather than changed, since
+// callers reuse them, e.g. to read the next page.
+type partition struct {
+	tenant string
+}
+
+// key scopes the partition key of a key or item
+func (p partition) key(table string, key map[string]types.AttributeValue) map[string]types.AttributeValue {
+	name, ok := partitionKeys[table]
+	if !ok || p.tenant == tenant.Default {
+		return key
+	}
+	value, ok := key[name].(*types.AttributeValueMemberS)
+	if !ok {
+		return key
+	}
+	copied := copyValues(key)
+	copied[name] = &types.AttributeValueMemberS{Value: tenant.Key(p.tenant, value.Value)}
+	return copied
+}
+
+// item scopes an item's key and marks it with the tenant
+func (p partition) item(table string, item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if _, ok := partitionKeys[table]; !ok || p.tenant == tenant.Default {
+		return item
+	}
+	copied := copyValues(p.key(table, item))
+	copied[tenantAttribute] = &types.AttributeValueMemberS{Value: p.tenant}
+	return copied
+}
+
+// update marks items the default tenant does not write with the tenant
+func (p partition) update(table string, expression *string, names map[string]string, values map[string]types.AttributeValue) (*string, map[string]string, map[string]types.AttributeValue) {
+	if _, ok := partitionKeys[table]; !ok || p.tenant == tenant.Default {
+		return expression, names, values
+	}
+	names, values = p.placeholders(names, values)
+	set := "#tenant = :tenant"
+	update := aws.ToString(expression)
+	if loc := setClause.FindStringIndex(update); loc != nil {
+		update = update[:loc[1]] + " " + set + "," + update[loc[1]:]
+	} else {
+		update = "set " + set + " " + update
+	}
+	return aws.String(update), names, values
+}
+
+// condition stops the default tenant writing over an item of another tenant whose scoped
+// key it names. Other tenants' keys cannot name any item but their own.
+func (p partition) condition(table string, expression *string, names map[string]string, values map[string]types.AttributeValue) (*string, map[string]string, map[string]types.AttributeValue) {
+	if _, ok := partitionKeys[table]; !ok || p.tenant != tenant.Default {
+		return expression, names, values
+	}
+	names = copyNames(names)
+	names["#tenant"] = tenantAttribute
+	condition := "attribute_not_exists(#tenant)"
+	if expression != nil && *expression != "" {
+		condition = "(" + *expression + ") and " + condition
+	}
+	return aws.String(condition), names, values
+}
+
+// keyCondition scopes the value a query compares the partition key with
+func (p partition) keyCondition(table string, expression string, names map[string]string, values map[string]types.AttributeValue) map[string]types.AttributeValue {
+	name, ok := partitionKeys[table]
+	if !ok || p.tenant == tenant.Default {
+		return values
+	}
+	for _, match := range keyCondition.FindAllStringSubmatch(expression, -1) {
+		attribute := match[1]
+		if strings.HasPrefix(attribute, "#") {
+			attribute = names[attribute]
+		}
+		value, ok := values[match[2]].(*types.AttributeValueMemberS)
+		if attribute != name || !ok {
+			continue
+		}
+		values = copyValues(values)
+		values[match[2]] = &types.AttributeValueMemberS{Value: tenant.Key(p.tenant, value.Value)}
+		break
+	}
+	return values
+}
+
+// filter limits a scan to the tenant's items
+func (p partition) filter(table string, expression *string, names map[string]string, values map[string]types.AttributeValue) (*string, map[string]string, map[string]types.AttributeValue) {
+	if _, ok := partitionKeys[table]; !ok {
+		return expression, names, values
+	}
+	condition := "attribute_not_exists(#tenant)"
+	if p.tenant == tenant.Default {
+		names = copyNames(names)
+		names["#tenant"] = tenantAttribute
+	} else {
+		names, values = p.placeholders(names, values)
+		condition = "#tenant = :tenant"
+	}
+	if expression != nil && *expression != "" {
+		condition = "(" + *expression + ") and " + condition
+	}
+	return aws.String(condition), names, values
+}
+
+// placeholders adds the tenant to copies of an expression's names and values
+func (p partition) placeholders(names map[string]string, values map[string]types.AttributeValue) (map[string]string, map[string]types.AttributeValue) {
+	names = copyNames(names)
+	names["#tenant"] = tenantAttribute
+	values = copyValues(values)
+	values[":tenant"] = &types.AttributeValueMemberS{Value: p.tenant}
+	return names, values
+}
+
+func (p partition) transactItem(item types.TransactWriteItem) types.TransactWriteItem {
+	if item.Put != nil {
+		put := *item.Put
+		table := aws.ToString(put.TableName)
+		put.Item = p.item(table, put.Item)
+		put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues = p.condition(table, put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues)
+		item.Put = &put
+	}
+	if item.Update != nil {
+		update := *item.Update
+		table := aws.ToString(update.TableName)
+		update.Key = p.key(table, update.Key)
+		update.UpdateExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues = p.update(table, update.UpdateExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues)
+		update.ConditionExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues = p.condition(table, update.ConditionExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues)
+		item.Update = &update
+	}
+	if item.Delete != nil {
+		remove := *item.Delete
+		table := aws.ToString(remove.TableName)
+		remove.Key = p.key(table, remove.Key)
+		remove.ConditionExpression, remove.ExpressionAttributeNames, remove.ExpressionAttributeValues = p.condition(table, remove.ConditionExpression, remove.ExpressionAttributeNames, remove.ExpressionAttributeValues)
+		item.Delete = &remove
+	}
+	if item.ConditionCheck != nil {
+		check := *item.ConditionCheck
+		table := aws.ToString(check.TableName)
+		check.Key = p.key(table, check.Key)
+		item.ConditionCheck = &check
+	}
+	return item
+}
+
+// unscope returns an item as it was written by the tenant, or nil if it belongs to
+// another tenant
+func (p partition) unscope(table string, item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	name, ok := partitionKeys[table]
+	if !ok || item == nil {
+		return item
+	}
+	owner := tenant.Default
+	if value, ok := item[tenantAttribute].(*types.AttributeValueMemberS); ok {
+		owner = value.Value
+	}
+	if owner != p.tenant {
+		return nil
+	}
+	if owner == tenant.Default {
+		return item
+	}
+	delete(item, tenantAttribute)
+	if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+		item[name] = &types.AttributeValueMemberS{Value: tenant.Unkey(owner, value.Value)}
+	}
+	return item
+}
+
+func (p partition) unscopeAll(table string, items []map[string]types.AttributeValue) []map[string]types.AttributeValue {
+	if _, ok := partitionKeys[table]; !ok {
+		return items
+	}
+	kept := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		if unscoped := p.unscope(table, item); unscoped != nil {
+			kept = append(kept, unscoped)
+		}
+	}
+	return kept
+}
+
+func copyNames(names map[string]string) map[string]string {
+	copied := make(map[string]string, len(names)+1)
+	for k, v := range names {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyValues(values map[string]types.AttributeValue) map[string]types.AttributeValue {
+	copied := make(map[string]types.AttributeValue, len(values)+1)
+	for k, v := range values {
+		copied[k] = v
+	}
+	return copied
+}
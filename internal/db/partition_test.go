@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPartitionedDB returns a DB partitioned by tenant whose client hands the input of each
+// call, as the partitioning left it, to respond for the output
+func newPartitionedDB(t *testing.T, respond func(input interface{}) interface{}) *DB {
+	capture := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(
+			middleware.InitializeMiddlewareFunc(
+				"CaptureInput",
+				func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					return middleware.InitializeOutput{Result: respond(in.Parameters)}, middleware.Metadata{}, nil
+				},
+			),
+			middleware.After,
+		)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"), config.WithAPIOptions([]func(*middleware.Stack) error{capture}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DB{client: dynamodb.NewFromConfig(cfg, PartitionByTenant)}
+}
+
+func stringValue(value string) *types.AttributeValueMemberS {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func TestPartitionByTenant_GetPlant(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		key    string
+		stored map[string]types.AttributeValue
+		found  bool
+	}{
+		{
+			name:   "default tenant reads its plant unscoped",
+			key:    "monstera",
+			stored: map[string]types.AttributeValue{"name": stringValue("monstera"), "description": stringValue("big leaves")},
+			found:  true,
+		},
+		{
+			name:   "tenant reads its plant under a scoped key",
+			tenant: "greenhouse",
+			key:    "greenhouse#monstera",
+			stored: map[string]types.AttributeValue{"name": stringValue("greenhouse#monstera"), "description": stringValue("big leaves"), "tenant": stringValue("greenhouse")},
+			found:  true,
+		},
+		{
+			name:   "default tenant cannot name another tenant's plant",
+			key:    "greenhouse#monstera",
+			stored: map[string]types.AttributeValue{"name": stringValue("greenhouse#monstera"), "description": stringValue("big leaves"), "tenant": stringValue("greenhouse")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key string
+			db := newPartitionedDB(t, func(input interface{}) interface{} {
+				key = input.(*dynamodb.GetItemInput).Key["name"].(*types.AttributeValueMemberS).Value
+				return &dynamodb.GetItemOutput{Item: tt.stored}
+			})
+			name := tenant.Unkey(tt.tenant, tt.key)
+			plant, err := db.GetPlant(name, tenant.WithID(context.TODO(), tt.tenant))
+			assert.Equal(t, tt.key, key)
+			if !tt.found {
+				assert.EqualError(t, err, ErrNotFound)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &pkg.Plant{Name: "monstera", Description: "big leaves"}, plant)
+		})
+	}
+}
+
+func TestPartitionByTenant_ListPlants(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		filter string
+		values map[string]types.AttributeValue
+	}{
+		{
+			name:   "default tenant only scans unmarked items",
+			filter: "(attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)) and attribute_not_exists(#tenant)",
+		},
+		{
+			name:   "tenant only scans its own items",
+			tenant: "greenhouse",
+			filter: "(attribute_not_exists(deleted_at) and attribute_not_exists(archived_at)) and #tenant = :tenant",
+			values: map[string]types.AttributeValue{":tenant": stringValue("greenhouse")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scan *dynamodb.ScanInput
+			db := newPartitionedDB(t, func(input interface{}) interface{} {
+				scan = input.(*dynamodb.ScanInput)
+				return &dynamodb.ScanOutput{}
+			})
+			_, err := db.ListPlants(tenant.WithID(context.TODO(), tt.tenant))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.filter, aws.ToString(scan.FilterExpression))
+			assert.Equal(t, "tenant", scan.ExpressionAttributeNames["#tenant"])
+			assert.Equal(t, tt.values, scan.ExpressionAttributeValues)
+		})
+	}
+}
+
+func TestPartitionByTenant_Query(t *testing.T) {
+	var query *dynamodb.QueryInput
+	db := newPartitionedDB(t, func(input interface{}) interface{} {
+		query = input.(*dynamodb.QueryInput)
+		return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{
+			{"plant_name": stringValue("greenhouse#monstera"), "id": stringValue("1"), "tenant": stringValue("greenhouse")},
+			// written by the default tenant under a name which looks scoped
+			{"plant_name": stringValue("greenhouse#monstera"), "id": stringValue("2")},
+		}}
+	})
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(revisionsTable), KeyConditionExpression: aws.String("#plant_name = :name"), ExpressionAttributeNames: map[string]string{"#plant_name": "plant_name"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":name": stringValue("monstera")},
+	}
+	output, err := db.client.Query(tenant.WithID(context.TODO(), "greenhouse"), input)
+	assert.NoError(t, err)
+	assert.Equal(t, stringValue("greenhouse#monstera"), query.ExpressionAttributeValues[":name"])
+	// the caller's input is left as it was, so it can be reused for the next page
+	assert.Equal(t, stringValue("monstera"), input.ExpressionAttributeValues[":name"])
+	assert.Equal(t, []map[string]types.AttributeValue{{"plant_name": stringValue("monstera"), "id": stringValue("1")}}, output.Items)
+}
+
+func TestPartitionByTenant_CreatePlant(t *testing.T) {
+	tests := []struct {
+		name      string
+		tenant    string
+		item      map[string]types.AttributeValue
+		condition string
+	}{
+		{
+			name:      "default tenant may not write over an existing plant or another tenant's items",
+			item:      map[string]types.AttributeValue{"name": stringValue("monstera"), "description": stringValue("big leaves")},
+			condition: "(attribute_not_exists(#name)) and attribute_not_exists(#tenant)",
+		},
+		{
+			name:      "tenant writes scoped items marked with the tenant",
+			tenant:    "greenhouse",
+			item:      map[string]types.AttributeValue{"name": stringValue("greenhouse#monstera"), "description": stringValue("big leaves"), "tenant": stringValue("greenhouse")},
+			condition: "attribute_not_exists(#name)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transaction *dynamodb.TransactWriteItemsInput
+			db := newPartitionedDB(t, func(input interface{}) interface{} {
+				transaction = input.(*dynamodb.TransactWriteItemsInput)
+				return &dynamodb.TransactWriteItemsOutput{}
+			})
+			err := db.CreatePlant(pkg.Plant{Name: "monstera", Description: "big leaves"}, "alice", tenant.WithID(context.TODO(), tt.tenant))
+			assert.NoError(t, err)
+			put := transaction.TransactItems[0].Put
+			assert.Equal(t, tt.item["name"], put.Item["name"])
+			assert.Equal(t, tt.item["tenant"], put.Item["tenant"])
+			assert.Equal(t, tt.condition, aws.ToString(put.ConditionExpression))
+			for _, item := range transaction.TransactItems[1:] {
+				if item.Update != nil && tt.tenant != tenant.Default {
+					assert.Contains(t, aws.ToString(item.Update.UpdateExpression), "#tenant = :tenant")
+				}
+			}
+		})
+	}
+}
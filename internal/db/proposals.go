@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// proposalsTable holds changes to the catalog awaiting review, keyed by id
+const proposalsTable = "plants_v1_proposals"
+
+// ErrProposalChanged is returned when a proposal is no longer in the status it was read in,
+// e.g. because another reviewer decided it first
+var ErrProposalChanged = errors.New("proposal has changed")
+
+func (db *DB) CreateProposal(proposal pkg.Proposal, context context.Context) error {
+	if proposal.ID == "" || proposal.PlantName == "" {
+		return errors.New("missing id or plant name")
+	}
+	item, err := attributevalue.MarshalMap(proposal)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(proposalsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	return err
+}
+
+func (db *DB) GetProposal(id string, context context.Context) (*pkg.Proposal, error) {
+	if id == "" {
+		return nil, errors.New("missing id")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(proposalsTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	proposal := &pkg.Proposal{}
+	err = attributevalue.UnmarshalMap(output.Item, proposal)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return proposal, nil
+}
+
+// ListProposals returns the proposals by the author in the status, either of which may be
+// empty to match any
+func (db *DB) ListProposals(author string, status string, context context.Context) ([]pkg.Proposal, error) {
+	input := &dynamodb.ScanInput{TableName: aws.String(proposalsTable)}
+	filters := []string{}
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	if author != "" {
+		filters = append(filters, "#author = :author")
+		names["#author"] = "author"
+		values[":author"] = &types.AttributeValueMemberS{Value: author}
+	}
+	if status != "" {
+		filters = append(filters, "#status = :status")
+		names["#status"] = "status"
+		values[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " and "))
+		input.ExpressionAttributeNames = names
+		input.ExpressionAttributeValues = values
+	}
+	proposals := []pkg.Proposal{}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Proposal
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return proposals, nil
+}
+
+// UpdateProposal replaces a proposal which is still in the status it was read in, returning
+// ErrProposalChanged if it is not
+func (db *DB) UpdateProposal(proposal pkg.Proposal, status string, context context.Context) error {
+	if proposal.ID == "" || proposal.PlantName == "" {
+		return errors.New("missing id or plant name")
+	}
+	item, err := attributevalue.MarshalMap(proposal)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(proposalsTable), Item: item, ConditionExpression: aws.String("#status = :status"), ExpressionAttributeNames: map[string]string{"#status": "status"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrProposalChanged
+	}
+	return err
+}
+
+// DeleteProposal removes a proposal, e.g. when its author withdraws it
+func (db *DB) DeleteProposal(id string, context context.Context) (*pkg.Proposal, error) {
+	if id == "" {
+		return nil, errors.New("missing id")
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(proposalsTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	proposal := &pkg.Proposal{}
+	err = attributevalue.UnmarshalMap(output.Attributes, proposal)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return proposal, nil
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const revisionsTable = "plants_v1_revisions"
+
+// newRevision builds a revision of plant, stamping the snapshot with when it was updated.
+// Revision ids are zero padded nanosecond timestamps so that they sort in the order they
+// were written.
+func newRevision(plant pkg.Plant, action string, author string, summary string) pkg.Revision {
+	now := time.Now().UTC()
+	plant.UpdatedAt = &now
+	return pkg.Revision{
+		PlantName: plant.Name,
+		ID:        fmt.Sprintf("%020d", now.UnixNano()),
+		Action:    action,
+		Author:    author,
+		Timestamp: now,
+		Summary:   summary,
+		Snapshot:  plant,
+	}
+}
+
+// revisionPut builds a transaction item which writes the revision. Revisions are never
+// overwritten.
+func revisionPut(revision pkg.Revision) (types.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(revision)
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: aws.String(revisionsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	}}, nil
+}
+
+// GetRevisions returns every revision of the named plant, oldest first
+func (db *DB) GetRevisions(name string, context context.Context) ([]pkg.Revision, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	revisions := []pkg.Revision{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(revisionsTable), KeyConditionExpression: aws.String("#plant_name = :name"), ExpressionAttributeNames: map[string]string{"#plant_name": "plant_name"}, ExpressionAttributeValues: map[string]types.AttributeValue{":name": nameattribute},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Revision
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a single revision of the named plant
+func (db *DB) GetRevision(name string, id string, context context.Context) (*pkg.Revision, error) {
+	if name == "" || id == "" {
+		return nil, errors.New("missing name or revision id")
+	}
+	key, err := attributevalue.MarshalMap(map[string]string{"plant_name": name, "id": id})
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{Key: key, TableName: aws.String(revisionsTable)})
+	if err != nil {
+		return nil, err
+	}
+	revision := &pkg.Revision{}
+	err = attributevalue.UnmarshalMap(output.Item, revision)
+	if err != nil {
+		return nil, err
+	}
+	if revision.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return revision, nil
+}
+
+// RevertPlant restores the published plant to the snapshot held by a revision, recording
+// the revert as a new revision. Plants in the trash or the archive are not found until they
+// are restored or unarchived, and revisions left at a name by a plant which was renamed or
+// merged away cannot be reverted to. The write only lands while the plant is as it was read.
+func (db *DB) RevertPlant(name string, id string, author string, context context.Context) (*pkg.Plant, error) {
+	target, err := db.GetRevision(name, id, context)
+	if err != nil {
+		return nil, err
+	}
+	current, err := db.GetPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := target.Snapshot
+	// the revision is of another plant which had the name before it was renamed or merged away
+	if snapshot.ID != "" && current.ID != "" && snapshot.ID != current.ID {
+		return nil, ErrPlantChanged
+	}
+	// the plant keeps the id and slug it has now, whatever it had at the revision, and a
+	// delete revision's snapshot does not send it back to the trash
+	snapshot.ID = current.ID
+	snapshot.Slug = current.Slug
+	snapshot.DeletedAt = nil
+	snapshot.ExpiresAt = 0
+	snapshot.ArchivedAt = nil
+	identify := pkg.Slugify(snapshot.Name) != ""
+	if identify {
+		err = assignIdentity(&snapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	revision := newRevision(snapshot, pkg.RevisionRevert, author, "reverted to revision "+id)
+	plant := revision.Snapshot
+	item, err := attributevalue.MarshalMap(plant)
+	if err != nil {
+		return nil, err
+	}
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	condition, values, err := unchangedCondition(*current)
+	if err != nil {
+		return nil, err
+	}
+	items := append([]types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(plantsTable), Item: item, ConditionExpression: aws.String(condition), ExpressionAttributeNames: map[string]string{"#name": "name"}, ExpressionAttributeValues: values,
+		}},
+	}, change...)
+	if !identify {
+		_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	} else {
+		err = db.writeWithIdentity(plant, items, context)
+	}
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// the plant was written, trashed, archived or renamed since it was read
+		if conditionFailed(err, 0) {
+			_, err = db.GetPlant(name, context)
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrPlantChanged
+		}
+		if identify {
+			return nil, ErrNameTaken
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plant, nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestDB_GetRevisions(t *testing.T) {
+	revision := pkg.Revision{PlantName: "test", ID: "1", Action: pkg.RevisionCreate, Timestamp: time.Unix(0, 0).UTC(), Snapshot: pkg.Plant{Name: "test", Description: "test"}}
+	tests := []struct {
+		name    string
+		plant   string
+		outputs map[string]mockOutput
+		want    []pkg.Revision
+		wantErr bool
+		errText string
+	}{
+		{
+			name:    "get revisions returns error if no name is provided",
+			plant:   "",
+			outputs: map[string]mockOutput{},
+			wantErr: true,
+			errText: "missing name",
+		},
+		{
+			name:  "get revisions returns error if client returns error",
+			plant: "test",
+			outputs: map[string]mockOutput{
+				"Query": {err: fmt.Errorf("QueryError")},
+			},
+			wantErr: true,
+			errText: "operation error DynamoDB: Query, QueryError",
+		},
+		{
+			name:  "get revisions returns revisions if client is successful",
+			plant: "test",
+			outputs: map[string]mockOutput{
+				"Query": {result: &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{marshalItem(t, revision)}}},
+			},
+			want: []pkg.Revision{revision},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.GetRevisions(tt.plant, context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.GetRevisions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.GetRevisions() error = %v, errText = %s", err, tt.errText)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.GetRevisions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newRespondingDB returns a DB whose client hands the input of each call to respond for
+// the output, so that calls can be told apart by more than their operation
+func newRespondingDB(t *testing.T, respond func(input interface{}) (interface{}, error)) *DB {
+	capture := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(
+			middleware.InitializeMiddlewareFunc(
+				"RespondToInput",
+				func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					result, err := respond(in.Parameters)
+					return middleware.InitializeOutput{Result: result}, middleware.Metadata{}, err
+				},
+			),
+			middleware.After,
+		)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"), config.WithAPIOptions([]func(*middleware.Stack) error{capture}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DB{client: dynamodb.NewFromConfig(cfg)}
+}
+
+func TestDB_RevertPlant(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deletedAt := updatedAt.Add(-time.Hour)
+	current := &pkg.Plant{Name: "test", Description: "new", ID: "0123456789abcdef", Slug: "test", UpdatedAt: &updatedAt}
+	revision := &pkg.Revision{PlantName: "test", ID: "1", Action: pkg.RevisionUpdate, Snapshot: pkg.Plant{Name: "test", Description: "old", ID: "0123456789abcdef", Slug: "test"}}
+	canceled := &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}}}
+	tests := []struct {
+		name     string
+		revision *pkg.Revision
+		plant    *pkg.Plant
+		writeErr error
+		want     *pkg.Plant
+		wantErr  error
+	}{
+		{
+			name:    "revert plant returns not found if the revision is not found",
+			plant:   current,
+			wantErr: errors.New(ErrNotFound),
+		},
+		{
+			name:     "revert plant returns not found if the plant is in the trash",
+			revision: revision,
+			plant:    &pkg.Plant{Name: "test", Description: "new", ID: "0123456789abcdef", Slug: "test", DeletedAt: &deletedAt},
+			wantErr:  errors.New(ErrNotFound),
+		},
+		{
+			name:     "revert plant returns not found if the plant was renamed away from the name",
+			revision: revision,
+			wantErr:  errors.New(ErrNotFound),
+		},
+		{
+			name:     "revert plant fails if the revision is of a plant which was renamed away before another took the name",
+			revision: revision,
+			plant:    &pkg.Plant{Name: "test", Description: "another", ID: "fedcba9876543210", Slug: "test"},
+			wantErr:  ErrPlantChanged,
+		},
+		{
+			name:     "revert plant fails if the plant changed since it was read",
+			revision: revision,
+			plant:    current,
+			writeErr: canceled,
+			wantErr:  ErrPlantChanged,
+		},
+		{
+			name:     "revert plant returns error if client returns error",
+			revision: revision,
+			plant:    current,
+			writeErr: fmt.Errorf("TransactWriteItemsError"),
+			wantErr:  fmt.Errorf("TransactWriteItemsError"),
+		},
+		{
+			name:     "revert plant returns the snapshot if client is successful",
+			revision: revision,
+			plant:    current,
+			want:     &pkg.Plant{Name: "test", Description: "old", ID: "0123456789abcdef", Slug: "test"},
+		},
+		{
+			name:     "revert plant to a delete revision publishes the plant rather than trashing it",
+			revision: &pkg.Revision{PlantName: "test", ID: "1", Action: pkg.RevisionDelete, Snapshot: pkg.Plant{Name: "test", Description: "old", DeletedAt: &deletedAt, ExpiresAt: 1}},
+			plant:    current,
+			want:     &pkg.Plant{Name: "test", Description: "old", ID: "0123456789abcdef", Slug: "test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transaction *dynamodb.TransactWriteItemsInput
+			db := newRespondingDB(t, func(input interface{}) (interface{}, error) {
+				switch input := input.(type) {
+				case *dynamodb.GetItemInput:
+					var item interface{}
+					switch aws.ToString(input.TableName) {
+					case revisionsTable:
+						item = tt.revision
+					case plantsTable:
+						item = tt.plant
+					}
+					if reflect.ValueOf(item).IsNil() {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					return &dynamodb.GetItemOutput{Item: marshalItem(t, item)}, nil
+				case *dynamodb.TransactWriteItemsInput:
+					transaction = input
+					return &dynamodb.TransactWriteItemsOutput{}, tt.writeErr
+				}
+				return nil, fmt.Errorf("unexpected input %T", input)
+			})
+			got, err := db.RevertPlant("test", "1", "test", context.TODO())
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("DB.RevertPlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !strings.HasSuffix(err.Error(), tt.wantErr.Error()) {
+					t.Errorf("DB.RevertPlant() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if got.UpdatedAt == nil {
+				t.Errorf("DB.RevertPlant() = %v, want updated_at set", got)
+			}
+			got.UpdatedAt = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.RevertPlant() = %v, want %v", got, tt.want)
+			}
+			// the revert only lands over the plant as it was read, and keeps its id and slug
+			put := transaction.TransactItems[0].Put
+			if !strings.Contains(aws.ToString(put.ConditionExpression), "updated_at = :updated_at") {
+				t.Errorf("DB.RevertPlant() condition = %s, want it to hold only while the plant is unchanged", aws.ToString(put.ConditionExpression))
+			}
+			reserved := false
+			for _, item := range transaction.TransactItems {
+				if item.Put != nil && aws.ToString(item.Put.TableName) == identitiesTable {
+					reserved = true
+				}
+			}
+			if !reserved {
+				t.Errorf("DB.RevertPlant() wrote %v, want the plant's identity reserved", transaction.TransactItems)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// rolesTable holds roles keyed by name
+	rolesTable = "plants_v1_roles"
+	// roleAssignmentsTable holds the roles of each subject, keyed by subject and role
+	roleAssignmentsTable = "plants_v1_role_assignments"
+)
+
+// PutRole creates the role or replaces its grants
+func (db *DB) PutRole(role pkg.Role, context context.Context) error {
+	if role.Name == "" {
+		return errors.New("missing name")
+	}
+	item, err := attributevalue.MarshalMap(role)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(rolesTable), Item: item})
+	return err
+}
+
+func (db *DB) GetRole(name string, context context.Context) (*pkg.Role, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(rolesTable), Key: map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: name}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	role := &pkg.Role{}
+	err = attributevalue.UnmarshalMap(output.Item, role)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return role, nil
+}
+
+func (db *DB) ListRoles(context context.Context) ([]pkg.Role, error) {
+	roles := []pkg.Role{}
+	input := &dynamodb.ScanInput{TableName: aws.String(rolesTable)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Role
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return roles, nil
+}
+
+func (db *DB) DeleteRole(name string, context context.Context) (*pkg.Role, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(rolesTable), Key: map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: name}}, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	role := &pkg.Role{}
+	err = attributevalue.UnmarshalMap(output.Attributes, role)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return role, nil
+}
+
+func (db *DB) PutRoleAssignment(assignment pkg.RoleAssignment, context context.Context) error {
+	if assignment.Subject == "" || assignment.Role == "" {
+		return errors.New("missing subject or role")
+	}
+	item, err := attributevalue.MarshalMap(assignment)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(roleAssignmentsTable), Item: item})
+	return err
+}
+
+// GetRoleAssignments returns the roles assigned to the subject
+func (db *DB) GetRoleAssignments(subject string, context context.Context) ([]pkg.RoleAssignment, error) {
+	if subject == "" {
+		return nil, errors.New("missing subject")
+	}
+	assignments := []pkg.RoleAssignment{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(roleAssignmentsTable), KeyConditionExpression: aws.String("#subject = :subject"), ExpressionAttributeNames: map[string]string{"#subject": "subject"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":subject": &types.AttributeValueMemberS{Value: subject},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.RoleAssignment
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return assignments, nil
+}
+
+func (db *DB) DeleteRoleAssignment(subject string, role string, context context.Context) (*pkg.RoleAssignment, error) {
+	if subject == "" || role == "" {
+		return nil, errors.New("missing subject or role")
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(roleAssignmentsTable), Key: map[string]types.AttributeValue{
+			"subject": &types.AttributeValueMemberS{Value: subject},
+			"role":    &types.AttributeValueMemberS{Value: role},
+		}, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	assignment := &pkg.RoleAssignment{}
+	err = attributevalue.UnmarshalMap(output.Attributes, assignment)
+	if err != nil {
+		return nil, err
+	}
+	if assignment.Subject == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return assignment, nil
+}
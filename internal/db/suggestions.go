@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// suggestionsTable is partitioned by plant_name and sorted by id
+const suggestionsTable = "plants_v1_suggestions"
+
+// ErrSuggestionChanged is returned when a suggestion is no longer in the status it was read
+// in, e.g. because another editor decided it first
+var ErrSuggestionChanged = errors.New("suggestion has changed")
+
+func (db *DB) CreateSuggestion(suggestion pkg.Suggestion, context context.Context) error {
+	if suggestion.PlantName == "" || suggestion.ID == "" {
+		return errors.New("missing plant name or id")
+	}
+	item, err := attributevalue.MarshalMap(suggestion)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(suggestionsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	return err
+}
+
+// GetSuggestions returns every suggestion made for the named plant
+func (db *DB) GetSuggestions(name string, context context.Context) ([]pkg.Suggestion, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	suggestions := []pkg.Suggestion{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(suggestionsTable), KeyConditionExpression: aws.String("#plant_name = :name"), ExpressionAttributeNames: map[string]string{"#plant_name": "plant_name"}, ExpressionAttributeValues: map[string]types.AttributeValue{":name": &types.AttributeValueMemberS{Value: name}},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Suggestion
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return suggestions, nil
+}
+
+func (db *DB) GetSuggestion(name string, id string, context context.Context) (*pkg.Suggestion, error) {
+	if name == "" || id == "" {
+		return nil, errors.New("missing name or suggestion id")
+	}
+	key, err := attributevalue.MarshalMap(map[string]string{"plant_name": name, "id": id})
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{Key: key, TableName: aws.String(suggestionsTable)})
+	if err != nil {
+		return nil, err
+	}
+	suggestion := &pkg.Suggestion{}
+	err = attributevalue.UnmarshalMap(output.Item, suggestion)
+	if err != nil {
+		return nil, err
+	}
+	if suggestion.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return suggestion, nil
+}
+
+// UpdateSuggestion replaces a suggestion which is still in the status it was read in,
+// returning ErrSuggestionChanged if it is not
+func (db *DB) UpdateSuggestion(suggestion pkg.Suggestion, status string, context context.Context) error {
+	if suggestion.PlantName == "" || suggestion.ID == "" {
+		return errors.New("missing plant name or id")
+	}
+	item, err := attributevalue.MarshalMap(suggestion)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(suggestionsTable), Item: item, ConditionExpression: aws.String("#status = :status"), ExpressionAttributeNames: map[string]string{"#status": "status"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrSuggestionChanged
+	}
+	return err
+}
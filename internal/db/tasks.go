@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tasksTable holds the state of each user's care tasks, keyed by user_id and the task id
+const tasksTable = "plants_v1_tasks"
+
+// GetTaskStates returns the state of every task the user has acted on, keyed by task id
+func (db *DB) GetTaskStates(userID string, context context.Context) (map[string]pkg.TaskState, error) {
+	if userID == "" {
+		return nil, errors.New("missing user")
+	}
+	states := map[string]pkg.TaskState{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(tasksTable), KeyConditionExpression: aws.String("#user_id = :user_id"), ExpressionAttributeNames: map[string]string{"#user_id": "user_id"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.TaskState
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range page {
+			states[state.ID] = state
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return states, nil
+}
+
+func (db *DB) PutTaskState(state pkg.TaskState, context context.Context) error {
+	if state.UserID == "" || state.ID == "" {
+		return errors.New("missing user or task id")
+	}
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(tasksTable), Item: item})
+	return err
+}
+
+// CareProfiles returns a lookup of the care profile of each catalog plant, which is nil
+// for plants without a profile or no longer in the catalog
+func CareProfiles(database DBInterface, context context.Context) func(string) (*pkg.CareProfile, error) {
+	return func(name string) (*pkg.CareProfile, error) {
+		plant, err := database.GetPlant(name, context)
+		if err != nil && err.Error() == ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return plant.Care, nil
+	}
+}
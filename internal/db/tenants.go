@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/SevvyP/plants/internal/blob"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tenantsTable holds the tenants and their config keyed by id. It is shared by every tenant.
+const tenantsTable = "plants_v1_tenants"
+
+// ErrTenantExists is returned when creating a tenant whose id is taken
+var ErrTenantExists = errors.New("tenant already exists")
+
+func (db *DB) CreateTenant(t pkg.Tenant, context context.Context) error {
+	if !tenant.Valid(t.ID) {
+		return errors.New("invalid tenant id")
+	}
+	item, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(tenantsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrTenantExists
+	}
+	return err
+}
+
+func (db *DB) GetTenant(id string, context context.Context) (*pkg.Tenant, error) {
+	if id == "" {
+		return nil, errors.New("missing id")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(tenantsTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &pkg.Tenant{}
+	err = attributevalue.UnmarshalMap(output.Item, t)
+	if err != nil {
+		return nil, err
+	}
+	if t.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return t, nil
+}
+
+func (db *DB) ListTenants(context context.Context) ([]pkg.Tenant, error) {
+	tenants := []pkg.Tenant{}
+	input := &dynamodb.ScanInput{TableName: aws.String(tenantsTable)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Tenant
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return tenants, nil
+}
+
+// UpdateTenant replaces the config of an existing tenant
+func (db *DB) UpdateTenant(t pkg.Tenant, context context.Context) error {
+	if t.ID == "" {
+		return errors.New("missing id")
+	}
+	item, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(tenantsTable), Item: item, ConditionExpression: aws.String("attribute_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return errors.New(ErrNotFound)
+	}
+	return err
+}
+
+// DeleteTenant revokes the tenant's api keys and deletes the blobs of its images and
+// photos, then every item the tenant has in each of its tables and finally the tenant
+// itself, returning how many items were deleted. A deletion which fails part way may be run
+// again to finish it.
+func (db *DB) DeleteTenant(id string, blobs blob.Store, ctx context.Context) (int, error) {
+	if id == tenant.Default {
+		return 0, errors.New("the default tenant cannot be deleted")
+	}
+	err := db.revokeTenantAPIKeys(id, ctx)
+	if err != nil {
+		return 0, err
+	}
+	scoped := tenant.WithID(ctx, id)
+	// the blobs are found through the items which hold them, so they go first
+	for _, table := range []string{plantsTable, revisionsTable, journalTable} {
+		err = db.deleteImageBlobs(table, blobs, scoped)
+		if err != nil {
+			return 0, err
+		}
+	}
+	deleted := 0
+	for table := range partitionKeys {
+		n, err := db.deleteAll(table, scoped)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	_, err = db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tenantsTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	return deleted, err
+}
+
+// revokeTenantAPIKeys revokes every api key acting for the tenant which is not yet revoked
+func (db *DB) revokeTenantAPIKeys(id string, ctx context.Context) error {
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, key := range keys {
+		if key.Tenant != id || (key.RevokedAt != nil && !now.Before(*key.RevokedAt)) {
+			continue
+		}
+		key.RevokedAt = &now
+		err = db.UpdateAPIKey(key, ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageHolder reads the images held by an item of the plants, revisions or journal table
+type imageHolder struct {
+	Images   []pkg.Image `dynamodbav:"images"`
+	Photos   []pkg.Image `dynamodbav:"photos"`
+	Snapshot struct {
+		Images []pkg.Image `dynamodbav:"images"`
+	} `dynamodbav:"snapshot"`
+}
+
+// deleteImageBlobs deletes the blob of every rendition of the images held by the items of a
+// table which the context's tenant can see
+func (db *DB) deleteImageBlobs(table string, blobs blob.Store, ctx context.Context) error {
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	for {
+		output, err := db.client.Scan(ctx, input)
+		if err != nil {
+			return err
+		}
+		var page []imageHolder
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return err
+		}
+		for _, holder := range page {
+			for _, image := range slices.Concat(holder.Images, holder.Photos, holder.Snapshot.Images) {
+				for _, rendition := range image.Renditions {
+					err = blobs.Delete(rendition.Key, ctx)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// deleteAll deletes every item of a table which the context's tenant can see
+func (db *DB) deleteAll(table string, ctx context.Context) (int, error) {
+	description, err := db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	for {
+		output, err := db.client.Scan(ctx, input)
+		if err != nil {
+			return deleted, err
+		}
+		for _, item := range output.Items {
+			key := map[string]types.AttributeValue{}
+			for _, element := range description.Table.KeySchema {
+				name := aws.ToString(element.AttributeName)
+				key[name] = item[name]
+			}
+			_, err = db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(table), Key: key})
+			if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return deleted, nil
+}
+
+// ForEachTenant calls do with a context acting for the default tenant and then for each
+// stored tenant, so background work reaches every tenant's data. A failure for one tenant
+// does not stop the others. Deployments without tenants need not create the tenants table.
+func ForEachTenant(database DBInterface, ctx context.Context, do func(context.Context) error) error {
+	tenants, err := database.ListTenants(ctx)
+	var missing *types.ResourceNotFoundException
+	if errors.As(err, &missing) {
+		tenants, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	ids := []string{tenant.Default}
+	for _, t := range tenants {
+		ids = append(ids, t.ID)
+	}
+	var errs []error
+	for _, id := range ids {
+		err = do(tenant.WithID(ctx, id))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
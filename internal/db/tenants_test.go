@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStore is a blob store which remembers the keys deleted from it
+type recordingStore struct {
+	deleted []string
+}
+
+func (r *recordingStore) Put(key string, data []byte, contentType string, context context.Context) error {
+	return nil
+}
+
+func (r *recordingStore) Get(key string, context context.Context) ([]byte, string, error) {
+	return nil, "", nil
+}
+
+func (r *recordingStore) Delete(key string, context context.Context) error {
+	r.deleted = append(r.deleted, key)
+	return nil
+}
+
+func TestDB_DeleteTenant(t *testing.T) {
+	image := pkg.Image{ID: "1", Renditions: []pkg.ImageRendition{{Name: "original", Key: "plants/monstera/1/original.jpg"}, {Name: "thumb", Key: "plants/monstera/1/thumb.webp"}}}
+	photo := pkg.Image{ID: "2", Renditions: []pkg.ImageRendition{{Name: "original", Key: "journal/abc/2/original.jpg"}}}
+	keys := map[string]pkg.APIKey{
+		"greenhouse": {Prefix: "greenhouse", Hash: "hash", Tenant: "greenhouse"},
+		"nursery":    {Prefix: "nursery", Hash: "hash", Tenant: "nursery"},
+	}
+	items := map[string][]interface{}{
+		plantsTable:    {pkg.Plant{Name: "monstera", Description: "big leaves", Images: []pkg.Image{image}}},
+		revisionsTable: {pkg.Revision{PlantName: "monstera", ID: "1", Snapshot: pkg.Plant{Name: "monstera", Images: []pkg.Image{image}}}},
+		journalTable:   {pkg.JournalEntry{UserID: "alice", ID: "1", Photos: []pkg.Image{photo}}},
+	}
+	db := newRespondingDB(t, func(input interface{}) (interface{}, error) {
+		switch input := input.(type) {
+		case *dynamodb.ScanInput:
+			output := &dynamodb.ScanOutput{}
+			if aws.ToString(input.TableName) == apiKeysTable {
+				for _, key := range keys {
+					output.Items = append(output.Items, marshalItem(t, key))
+				}
+			}
+			for _, item := range items[aws.ToString(input.TableName)] {
+				output.Items = append(output.Items, marshalItem(t, item))
+			}
+			return output, nil
+		case *dynamodb.PutItemInput:
+			var key pkg.APIKey
+			err := attributevalue.UnmarshalMap(input.Item, &key)
+			if err != nil {
+				return nil, err
+			}
+			keys[key.Prefix] = key
+			return &dynamodb.PutItemOutput{}, nil
+		case *dynamodb.DescribeTableInput:
+			return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(partitionKeys[aws.ToString(input.TableName)]), KeyType: types.KeyTypeHash},
+			}}}, nil
+		case *dynamodb.DeleteItemInput:
+			return &dynamodb.DeleteItemOutput{}, nil
+		}
+		return nil, fmt.Errorf("unexpected input %T", input)
+	})
+	blobs := &recordingStore{}
+	deleted, err := db.DeleteTenant("greenhouse", blobs, context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	// the tenant's key stops working, and other tenants' keys are left alone
+	assert.False(t, keys["greenhouse"].Active(time.Now()))
+	assert.True(t, keys["nursery"].Active(time.Now()))
+	assert.ElementsMatch(t, []string{
+		"plants/monstera/1/original.jpg", "plants/monstera/1/thumb.webp",
+		"plants/monstera/1/original.jpg", "plants/monstera/1/thumb.webp",
+		"journal/abc/2/original.jpg",
+	}, blobs.deleted)
+}
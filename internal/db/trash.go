@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// ErrPlantTrashed is returned when a plant is created with the name of a plant in the trash,
+// which must be restored or purged first
+var ErrPlantTrashed = errors.New("name is held by a plant in the trash")
+
+// trashRetentionFromEnv reads the trash retention in days from TRASH_RETENTION_DAYS
+func trashRetentionFromEnv() time.Duration {
+	days := os.Getenv("TRASH_RETENTION_DAYS")
+	if days == "" {
+		return defaultTrashRetention
+	}
+	parsed, err := strconv.Atoi(days)
+	if err != nil || parsed <= 0 {
+		log.Printf("invalid TRASH_RETENTION_DAYS %q, using default", days)
+		return defaultTrashRetention
+	}
+	return time.Duration(parsed) * 24 * time.Hour
+}
+
+func (db *DB) retention() time.Duration {
+	if db.trashRetention <= 0 {
+		return defaultTrashRetention
+	}
+	return db.trashRetention
+}
+
+// ListTrash returns every plant which is in the trash
+func (db *DB) ListTrash(context context.Context) ([]pkg.Plant, error) {
+	return db.scanPlants("attribute_exists(deleted_at)", context)
+}
+
+// RestorePlant takes the plant out of the trash and records a restore revision
+func (db *DB) RestorePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	plant, err := db.getTrashedPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	plant.DeletedAt = nil
+	plant.ExpiresAt = 0
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	revision := newRevision(*plant, pkg.RevisionRestore, author, "restored plant from trash")
+	change, err := changeItems(revision)
+	if err != nil {
+		return nil, err
+	}
+	updatedattribute, err := attributevalue.Marshal(revision.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, UpdateExpression: aws.String("set updated_at = :updated_at remove deleted_at, expires_at"), ConditionExpression: aws.String("attribute_exists(deleted_at)"), ExpressionAttributeValues: map[string]types.AttributeValue{
+					":updated_at": updatedattribute,
+				},
+			}},
+		}, change...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Snapshot, nil
+}
+
+// PurgePlant permanently removes a plant which is in the trash without waiting for
+// the retention period to pass
+func (db *DB) PurgePlant(name string, author string, context context.Context) (*pkg.Plant, error) {
+	plant, err := db.getTrashedPlant(name, context)
+	if err != nil {
+		return nil, err
+	}
+	nameattribute, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	change, err := changeItems(newRevision(*plant, pkg.RevisionPurge, author, "purged plant from trash"))
+	if err != nil {
+		return nil, err
+	}
+	items := append([]types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(plantsTable), Key: map[string]types.AttributeValue{"name": nameattribute}, ConditionExpression: aws.String("attribute_exists(deleted_at)"),
+		}},
+	}, change...)
+	if plant.ID != "" {
+		items = append(items, releaseIdentity(*plant)...)
+	}
+	_, err = db.client.TransactWriteItems(context, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		return nil, err
+	}
+	return plant, nil
+}
+
+// getTrashedPlant returns the named plant only if it is in the trash
+func (db *DB) getTrashedPlant(name string, context context.Context) (*pkg.Plant, error) {
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+	plant, err := db.getPlantItem(name, context)
+	if err != nil {
+		return nil, err
+	}
+	if plant.DeletedAt == nil {
+		return nil, errors.New(ErrNotFound)
+	}
+	return plant, nil
+}
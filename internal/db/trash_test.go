@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDB_RestorePlant(t *testing.T) {
+	deletedAt := time.Unix(0, 0).UTC()
+	trashed := pkg.Plant{Name: "test", Description: "test", DeletedAt: &deletedAt, ExpiresAt: 1}
+	tests := []struct {
+		name    string
+		outputs map[string]mockOutput
+		want    *pkg.Plant
+		wantErr bool
+		errText string
+	}{
+		{
+			name: "restore plant returns error if plant is not in the trash",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name: "restore plant returns error if client returns error",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, trashed)}},
+				"TransactWriteItems": {err: fmt.Errorf("TransactWriteItemsError")},
+			},
+			wantErr: true,
+			errText: "operation error DynamoDB: TransactWriteItems, TransactWriteItemsError",
+		},
+		{
+			name: "restore plant returns the restored plant if client is successful",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, trashed)}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+			want: &pkg.Plant{Name: "test", Description: "test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			got, err := db.RestorePlant("test", "test", context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.RestorePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.RestorePlant() error = %v, errText = %s", err, tt.errText)
+			}
+			if err == nil && got.UpdatedAt == nil {
+				t.Errorf("DB.RestorePlant() = %v, want updated_at set", got)
+			}
+			if err == nil {
+				got.UpdatedAt = nil
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DB.RestorePlant() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_PurgePlant(t *testing.T) {
+	deletedAt := time.Unix(0, 0).UTC()
+	trashed := pkg.Plant{Name: "test", Description: "test", DeletedAt: &deletedAt, ExpiresAt: 1}
+	tests := []struct {
+		name    string
+		outputs map[string]mockOutput
+		wantErr bool
+		errText string
+	}{
+		{
+			name: "purge plant returns error if plant is not in the trash",
+			outputs: map[string]mockOutput{
+				"GetItem": {result: &dynamodb.GetItemOutput{Item: marshalItem(t, pkg.Plant{Name: "test", Description: "test"})}},
+			},
+			wantErr: true,
+			errText: "item not found",
+		},
+		{
+			name: "purge plant doesn't return error if client is successful",
+			outputs: map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, trashed)}},
+				"TransactWriteItems": {result: &dynamodb.TransactWriteItemsOutput{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, tt.outputs)
+			_, err := db.PurgePlant("test", "test", context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DB.PurgePlant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && tt.errText != err.Error() {
+				t.Errorf("DB.PurgePlant() error = %v, errText = %s", err, tt.errText)
+			}
+		})
+	}
+}
+
+func TestDB_CreatePlant_Trashed(t *testing.T) {
+	deletedAt := time.Now().UTC()
+	canceled := &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}}}
+	tests := []struct {
+		name    string
+		item    *pkg.Plant
+		wantErr error
+	}{
+		{
+			name:    "create plant fails if the name is held by a plant in the trash",
+			item:    &pkg.Plant{Name: "test", Description: "old", DeletedAt: &deletedAt},
+			wantErr: ErrPlantTrashed,
+		},
+		{
+			name:    "create plant fails if the name is taken by a published plant",
+			item:    &pkg.Plant{Name: "test", Description: "old"},
+			wantErr: ErrNameTaken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMockedDB(t, map[string]mockOutput{
+				"GetItem":            {result: &dynamodb.GetItemOutput{Item: marshalItem(t, tt.item)}},
+				"TransactWriteItems": {err: canceled},
+			})
+			err := db.CreatePlant(pkg.Plant{Name: "test", Description: "new"}, "", context.TODO())
+			if err != tt.wantErr {
+				t.Errorf("DB.CreatePlant() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// webhooksTable holds webhook subscriptions keyed by id
+	webhooksTable = "plants_v1_webhooks"
+	// webhookDeliveriesTable is keyed by subscription_id and the delivery id
+	webhookDeliveriesTable = "plants_v1_webhook_deliveries"
+)
+
+func (db *DB) CreateSubscription(subscription pkg.Subscription, context context.Context) error {
+	if subscription.ID == "" || subscription.URL == "" {
+		return errors.New("missing id or url")
+	}
+	item, err := attributevalue.MarshalMap(subscription)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(webhooksTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#id)"), ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	return err
+}
+
+func (db *DB) ListSubscriptions(context context.Context) ([]pkg.Subscription, error) {
+	subscriptions := []pkg.Subscription{}
+	input := &dynamodb.ScanInput{TableName: aws.String(webhooksTable)}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.Subscription
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return subscriptions, nil
+}
+
+func (db *DB) GetSubscription(id string, context context.Context) (*pkg.Subscription, error) {
+	if id == "" {
+		return nil, errors.New("missing id")
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{
+		TableName: aws.String(webhooksTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	subscription := &pkg.Subscription{}
+	err = attributevalue.UnmarshalMap(output.Item, subscription)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return subscription, nil
+}
+
+// DeleteSubscription removes the subscription. Its delivery log is left to expire.
+func (db *DB) DeleteSubscription(id string, context context.Context) (*pkg.Subscription, error) {
+	if id == "" {
+		return nil, errors.New("missing id")
+	}
+	output, err := db.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(webhooksTable), Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}, ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	subscription := &pkg.Subscription{}
+	err = attributevalue.UnmarshalMap(output.Attributes, subscription)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return subscription, nil
+}
+
+func (db *DB) PutWebhookDelivery(delivery pkg.WebhookDelivery, context context.Context) error {
+	if delivery.SubscriptionID == "" || delivery.ID == "" {
+		return errors.New("missing subscription or delivery id")
+	}
+	item, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(webhookDeliveriesTable), Item: item})
+	return err
+}
+
+// GetWebhookDeliveries returns the delivery log of a subscription, oldest first
+func (db *DB) GetWebhookDeliveries(subscriptionID string, context context.Context) ([]pkg.WebhookDelivery, error) {
+	if subscriptionID == "" {
+		return nil, errors.New("missing subscription")
+	}
+	deliveries := []pkg.WebhookDelivery{}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(webhookDeliveriesTable), KeyConditionExpression: aws.String("#subscription_id = :subscription_id"), ExpressionAttributeNames: map[string]string{"#subscription_id": "subscription_id"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":subscription_id": &types.AttributeValueMemberS{Value: subscriptionID},
+		},
+	}
+	for {
+		output, err := db.client.Query(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.WebhookDelivery
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return deliveries, nil
+}
+
+func (db *DB) GetWebhookDelivery(subscriptionID string, id string, context context.Context) (*pkg.WebhookDelivery, error) {
+	if subscriptionID == "" || id == "" {
+		return nil, errors.New("missing subscription or delivery id")
+	}
+	key, err := attributevalue.MarshalMap(map[string]string{"subscription_id": subscriptionID, "id": id})
+	if err != nil {
+		return nil, err
+	}
+	output, err := db.client.GetItem(context, &dynamodb.GetItemInput{TableName: aws.String(webhookDeliveriesTable), Key: key})
+	if err != nil {
+		return nil, err
+	}
+	delivery := &pkg.WebhookDelivery{}
+	err = attributevalue.UnmarshalMap(output.Item, delivery)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.ID == "" {
+		return nil, errors.New(ErrNotFound)
+	}
+	return delivery, nil
+}
+
+// ListRetryableWebhookDeliveries returns every failed delivery due another attempt before now
+func (db *DB) ListRetryableWebhookDeliveries(now time.Time, context context.Context) ([]pkg.WebhookDelivery, error) {
+	nowattribute, err := attributevalue.Marshal(now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []pkg.WebhookDelivery{}
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(webhookDeliveriesTable), FilterExpression: aws.String("#status = :failed and next_attempt_at <= :now"), ExpressionAttributeNames: map[string]string{"#status": "status"}, ExpressionAttributeValues: map[string]types.AttributeValue{
+			":failed": &types.AttributeValueMemberS{Value: pkg.DeliveryFailed},
+			":now":    nowattribute,
+		},
+	}
+	for {
+		output, err := db.client.Scan(context, input)
+		if err != nil {
+			return nil, err
+		}
+		var page []pkg.WebhookDelivery
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,199 @@
+// Package devauth is a stand in for Auth0 when developing and testing. It signs tokens with
+// its own RSA key and serves the discovery document and JWKS the api fetches to check them,
+// so tokens it mints go through the same validation as Auth0's.
+package devauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+const (
+	DefaultKeyFile = ".dev-auth-key.pem"
+	DefaultAddress = "localhost:8090"
+	// DefaultAudience is used when AUTH0_AUDIENCE is not set
+	DefaultAudience = "plants-dev"
+	// DefaultTTL is how long minted tokens last unless told otherwise
+	DefaultTTL = time.Hour
+	keyBits    = 2048
+)
+
+// Issuer mints RS256 tokens and publishes the key they are signed with
+type Issuer struct {
+	// URL is the issuer claim of its tokens, ending in a slash as Auth0's does
+	URL string
+	// Audience is the audience of tokens minted without one
+	Audience string
+	key      *rsa.PrivateKey
+	kid      string
+}
+
+// NewIssuer returns an issuer at the given url which signs with key
+func NewIssuer(url string, audience string, key *rsa.PrivateKey) *Issuer {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	return &Issuer{URL: url, Audience: audience, key: key, kid: hex.EncodeToString(sum[:8])}
+}
+
+// NewIssuerFromEnv returns the issuer listening on DEV_AUTH_ADDRESS, signing with the key
+// in DEV_AUTH_KEY_FILE for the audience in AUTH0_AUDIENCE
+func NewIssuerFromEnv() (*Issuer, error) {
+	address := envOr("DEV_AUTH_ADDRESS", DefaultAddress)
+	key, err := LoadOrCreateKey(envOr("DEV_AUTH_KEY_FILE", DefaultKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return NewIssuer("http://"+address+"/", envOr("AUTH0_AUDIENCE", DefaultAudience), key), nil
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// TokenIssuer is the issuer as the middleware accepts it
+func (i *Issuer) TokenIssuer() middleware.TokenIssuer {
+	return middleware.TokenIssuer{
+		URL:        i.URL,
+		Kind:       middleware.IssuerOIDC,
+		Audiences:  []string{i.Audience},
+		Algorithms: []validator.SignatureAlgorithm{validator.RS256},
+		Claims:     middleware.ClaimMapping{Scope: "scope", ClientID: "azp", Tenant: "org_id"},
+		// it stands in for Auth0, so its subjects and claims are taken as Auth0's would be
+		Primary: true,
+	}
+}
+
+// ListenAndServe serves the issuer's documents on the host of its url
+func (i *Issuer) ListenAndServe() error {
+	parsed, err := url.Parse(i.URL)
+	if err != nil {
+		return err
+	}
+	return http.ListenAndServe(parsed.Host, i.Handler())
+}
+
+// GenerateKey returns a new RSA key for an issuer
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, keyBits)
+}
+
+// LoadOrCreateKey reads the PEM encoded key in path, creating it if there is none, so that
+// the api and the tokens minted by plantsctl share a key across restarts
+func LoadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		return key, os.WriteFile(path, pem.EncodeToMemory(block), 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM key in " + path)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// TokenOptions are the claims of a minted token
+type TokenOptions struct {
+	Subject  string
+	Scopes   []string
+	Audience string
+	// ClientID is the azp claim, the application the token was issued to
+	ClientID string
+	// Tenant is the org_id claim, the tenant the caller belongs to
+	Tenant string
+	TTL    time.Duration
+}
+
+type tokenClaims struct {
+	jwt.Claims
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"azp,omitempty"`
+	Tenant   string `json:"org_id,omitempty"`
+}
+
+// Mint signs a token with the given claims, valid from now
+func (i *Issuer) Mint(options TokenOptions, now time.Time) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: i.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", i.kid),
+	)
+	if err != nil {
+		return "", err
+	}
+	audience := options.Audience
+	if audience == "" {
+		audience = i.Audience
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	claims := tokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    i.URL,
+			Subject:   options.Subject,
+			Audience:  jwt.Audience{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Scope:    strings.Join(options.Scopes, " "),
+		ClientID: options.ClientID,
+		Tenant:   options.Tenant,
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// Handler serves the issuer's OpenID discovery document and JWKS
+func (i *Issuer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                i.URL,
+			"jwks_uri":                              i.URL + ".well-known/jwks.json",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"response_types_supported":              []string{"token"},
+			"subject_types_supported":               []string{"public"},
+		})
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &i.key.PublicKey, KeyID: i.kid, Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,77 @@
+package devauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuer(t *testing.T) {
+	key, err := GenerateKey()
+	assert.NoError(t, err)
+	other, err := GenerateKey()
+	assert.NoError(t, err)
+
+	// the issuer url is only known once the server is listening
+	var issuer *Issuer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	issuer = NewIssuer(server.URL, "plants-test", key)
+	impostor := NewIssuer(server.URL, "plants-test", other)
+
+	var scope string
+	protected := middleware.EnsureValidTokenFrom(issuer.TokenIssuer())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := middleware.GetClaims(r)
+		scope = claims.CustomClaims.(*middleware.CustomClaims).Scope
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		issuer   *Issuer
+		options  TokenOptions
+		now      time.Time
+		expected int
+	}{
+		{"accepts minted token", issuer, TokenOptions{Subject: "auth0|dev", Scopes: []string{"read:plants", "write:plants"}}, now, http.StatusOK},
+		{"rejects other audience", issuer, TokenOptions{Subject: "auth0|dev", Audience: "elsewhere"}, now, http.StatusUnauthorized},
+		{"rejects expired token", issuer, TokenOptions{Subject: "auth0|dev", TTL: time.Minute}, now.Add(-time.Hour), http.StatusUnauthorized},
+		{"rejects other key", impostor, TokenOptions{Subject: "auth0|dev"}, now, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.issuer.Mint(tt.options, tt.now)
+			assert.NoError(t, err)
+			req := httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			protected.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+			if tt.expected == http.StatusOK {
+				assert.Equal(t, "read:plants write:plants", scope)
+			}
+		})
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	created, err := LoadOrCreateKey(path)
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, err := LoadOrCreateKey(path)
+	assert.NoError(t, err)
+	assert.True(t, created.Equal(loaded))
+}
@@ -0,0 +1,128 @@
+// Package events fans catalog events out to live subscribers, keeping the most recent
+// events so subscribers which reconnect can resume where they left off
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+)
+
+const (
+	// DefaultBufferSize is how many events are kept for subscribers resuming
+	DefaultBufferSize = 1024
+	// subscriberQueue is how many events a subscriber may fall behind before it is dropped
+	subscriberQueue = 64
+)
+
+// Message is an event numbered in the order the broker received it
+type Message struct {
+	ID    uint64
+	Event pkg.Event
+}
+
+// Subscriber receives messages until it is closed. If it cannot keep up the broker closes
+// it, and it should reconnect resuming from the last message it handled.
+type Subscriber struct {
+	Messages <-chan Message
+	messages chan Message
+	closed   bool
+}
+
+// Broker keeps a ring buffer of recent messages and sends each new message to every subscriber
+type Broker struct {
+	mu sync.Mutex
+	// buffer holds count messages starting at index start, wrapping around
+	buffer      []Message
+	start       int
+	count       int
+	next        uint64
+	subscribers map[*Subscriber]struct{}
+}
+
+func NewBroker(size int) *Broker {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	// ids start from the time the broker was created, so that ids handed out before a
+	// restart are older than any handed out after it
+	return &Broker{buffer: make([]Message, size), next: uint64(time.Now().UnixMicro()), subscribers: map[*Subscriber]struct{}{}}
+}
+
+// Publish numbers the event, keeps it for resuming subscribers and sends it to every
+// subscriber. Subscribers whose queue is full are closed rather than block the publisher.
+func (b *Broker) Publish(event pkg.Event) Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	message := Message{ID: b.next, Event: event}
+	b.next++
+	if b.count < len(b.buffer) {
+		b.buffer[(b.start+b.count)%len(b.buffer)] = message
+		b.count++
+	} else {
+		b.buffer[b.start] = message
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber.messages <- message:
+		default:
+			b.close(subscriber)
+		}
+	}
+	return message
+}
+
+// Subscribe starts a subscription. With resume set, the messages after lastID still in the
+// buffer are returned to be sent first; complete is false if some have already been dropped.
+func (b *Broker) Subscribe(lastID uint64, resume bool) (subscriber *Subscriber, backlog []Message, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := make(chan Message, subscriberQueue)
+	subscriber = &Subscriber{Messages: messages, messages: messages}
+	b.subscribers[subscriber] = struct{}{}
+	complete = true
+	if !resume {
+		return subscriber, nil, complete
+	}
+	buffered := b.buffered()
+	if lastID >= b.next {
+		// the id is from before a restart, so nothing after it is known
+		return subscriber, buffered, false
+	}
+	if len(buffered) > 0 && lastID+1 < buffered[0].ID {
+		complete = false
+	}
+	for _, message := range buffered {
+		if message.ID > lastID {
+			backlog = append(backlog, message)
+		}
+	}
+	return subscriber, backlog, complete
+}
+
+// buffered returns the buffered messages, oldest first
+func (b *Broker) buffered() []Message {
+	messages := make([]Message, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		messages = append(messages, b.buffer[(b.start+i)%len(b.buffer)])
+	}
+	return messages
+}
+
+// Unsubscribe ends a subscription
+func (b *Broker) Unsubscribe(subscriber *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.close(subscriber)
+}
+
+func (b *Broker) close(subscriber *Subscriber) {
+	if subscriber.closed {
+		return
+	}
+	subscriber.closed = true
+	delete(b.subscribers, subscriber)
+	close(subscriber.messages)
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/SevvyP/plants/pkg"
+)
+
+func TestBroker_Subscribe(t *testing.T) {
+	b := NewBroker(3)
+	first := b.Publish(pkg.Event{Type: pkg.EventPlantCreated})
+	for i := 0; i < 3; i++ {
+		b.Publish(pkg.Event{Type: pkg.EventPlantUpdated})
+	}
+	tests := []struct {
+		name     string
+		lastID   uint64
+		resume   bool
+		backlog  int
+		complete bool
+	}{
+		{name: "subscribe without resuming sends no backlog", complete: true},
+		{name: "subscribe resumes after the last id", lastID: first.ID + 2, resume: true, backlog: 1, complete: true},
+		{name: "subscribe resumes from the oldest buffered event", lastID: first.ID, resume: true, backlog: 3, complete: true},
+		{name: "subscribe reports events dropped from the buffer", lastID: first.ID - 1, resume: true, backlog: 3, complete: false},
+		{name: "subscribe does not trust ids it has not handed out", lastID: first.ID + 100, resume: true, backlog: 3, complete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriber, backlog, complete := b.Subscribe(tt.lastID, tt.resume)
+			defer b.Unsubscribe(subscriber)
+			if len(backlog) != tt.backlog || complete != tt.complete {
+				t.Errorf("Broker.Subscribe() = %d messages, %v, want %d, %v", len(backlog), complete, tt.backlog, tt.complete)
+			}
+			for i := 1; i < len(backlog); i++ {
+				if backlog[i].ID != backlog[i-1].ID+1 {
+					t.Errorf("Broker.Subscribe() backlog out of order: %v", backlog)
+				}
+			}
+		})
+	}
+}
+
+func TestBroker_Publish(t *testing.T) {
+	b := NewBroker(10)
+	fast, _, _ := b.Subscribe(0, false)
+	slow, _, _ := b.Subscribe(0, false)
+	for i := 0; i < subscriberQueue+1; i++ {
+		b.Publish(pkg.Event{Type: pkg.EventPlantUpdated})
+		<-fast.Messages
+	}
+	count := 0
+	for range slow.Messages {
+		count++
+	}
+	if count != subscriberQueue {
+		t.Errorf("slow subscriber received %d messages before being closed, want %d", count, subscriberQueue)
+	}
+	b.Publish(pkg.Event{Type: pkg.EventPlantUpdated})
+	if _, ok := <-fast.Messages; !ok {
+		t.Error("fast subscriber was closed")
+	}
+	// unsubscribing a dropped subscriber is harmless
+	b.Unsubscribe(slow)
+	b.Unsubscribe(fast)
+}
@@ -0,0 +1,116 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// DefaultMaxBytes is the largest upload accepted when no limit is configured
+	DefaultMaxBytes = 10 << 20
+	// maxPixels guards against images which are small on disk but huge once decoded
+	maxPixels   = 50_000_000
+	jpegQuality = 85
+)
+
+var ErrUnsupportedType = errors.New("unsupported image type")
+
+// ThumbnailSizes maps each thumbnail name to the length of its longest edge
+var ThumbnailSizes = []Size{{Name: "small", Edge: 160}, {Name: "medium", Edge: 480}, {Name: "large", Edge: 1024}}
+
+type Size struct {
+	Name string
+	Edge int
+}
+
+// Rendition is an encoded image ready to be stored
+type Rendition struct {
+	Name        string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Extension returns the file extension matching the rendition's content type
+func (r Rendition) Extension() string {
+	if r.ContentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Process validates an uploaded image, strips its metadata and renders thumbnails.
+// The first rendition returned is the original.
+func Process(data []byte, maxBytes int) ([]Rendition, error) {
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxBytes)
+	}
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image is larger than %d pixels", maxPixels)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	var stripped []byte
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+		stripped, err = stripJPEG(data)
+	} else {
+		stripped, err = stripPNG(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	upright := orient(toRGBA(decoded), orientation)
+	width, height := upright.Bounds().Dx(), upright.Bounds().Dy()
+
+	original := Rendition{Name: "original", ContentType: contentType, Width: width, Height: height, Data: stripped}
+	// the orientation lived in the stripped exif data, so rotated images are re-encoded upright
+	if orientation != 1 {
+		original.Data, err = encode(upright, contentType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	renditions := []Rendition{original}
+	for _, size := range ThumbnailSizes {
+		w, h := fit(width, height, size.Edge)
+		if w == width && h == height {
+			continue
+		}
+		encoded, err := encode(resize(upright, w, h), contentType)
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{Name: size.Name, ContentType: contentType, Width: w, Height: h, Data: encoded})
+	}
+	return renditions, nil
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var out bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&out, img)
+	} else {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return out.Bytes(), err
+}
@@ -0,0 +1,134 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment holding only an orientation tag
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func testJPEG(t *testing.T, w int, h int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var out bytes.Buffer
+	err := jpeg.Encode(&out, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	comment := []byte{0xFF, 0xFE, 0, 9, 's', 'e', 'c', 'r', 'e', 't', 0}
+	return append(append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), comment...), data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       func(t *testing.T) []byte
+		maxBytes   int
+		width      int
+		height     int
+		renditions []string
+		wantErr    bool
+	}{
+		{
+			name:     "process rejects images over the size limit",
+			data:     func(t *testing.T) []byte { return testJPEG(t, 10, 10, 1) },
+			maxBytes: 10,
+			wantErr:  true,
+		},
+		{
+			name:     "process rejects files which are not images",
+			data:     func(t *testing.T) []byte { return []byte("<html></html>") },
+			maxBytes: DefaultMaxBytes,
+			wantErr:  true,
+		},
+		{
+			name:       "process strips metadata and renders thumbnails smaller than the original",
+			data:       func(t *testing.T) []byte { return testJPEG(t, 600, 300, 1) },
+			maxBytes:   DefaultMaxBytes,
+			width:      600,
+			height:     300,
+			renditions: []string{"original", "small", "medium"},
+		},
+		{
+			name:       "process rotates images according to their exif orientation",
+			data:       func(t *testing.T) []byte { return testJPEG(t, 200, 100, 6) },
+			maxBytes:   DefaultMaxBytes,
+			width:      100,
+			height:     200,
+			renditions: []string{"original", "small"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(tt.data(t), tt.maxBytes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			names := []string{}
+			for _, rendition := range got {
+				names = append(names, rendition.Name)
+				if bytes.Contains(rendition.Data, []byte("Exif")) || bytes.Contains(rendition.Data, []byte("secret")) {
+					t.Errorf("Process() rendition %s still holds metadata", rendition.Name)
+				}
+				config, err := jpeg.DecodeConfig(bytes.NewReader(rendition.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if config.Width != rendition.Width || config.Height != rendition.Height {
+					t.Errorf("Process() rendition %s is %dx%d, reported %dx%d", rendition.Name, config.Width, config.Height, rendition.Width, rendition.Height)
+				}
+			}
+			if got[0].Width != tt.width || got[0].Height != tt.height {
+				t.Errorf("Process() original is %dx%d, want %dx%d", got[0].Width, got[0].Height, tt.width, tt.height)
+			}
+			if len(names) != len(tt.renditions) {
+				t.Errorf("Process() renditions = %v, want %v", names, tt.renditions)
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var out bytes.Buffer
+	err := png.Encode(&out, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	text := []byte{0, 0, 0, 6, 't', 'E', 'X', 't', 'a', 0, 's', 'e', 'c', 'r', 0, 0, 0, 0}
+	// insert the text chunk straight after IHDR, which is always 25 bytes
+	withText := append(append(append([]byte{}, data[:33]...), text...), data[33:]...)
+	stripped, err := stripPNG(withText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stripped, data) {
+		t.Errorf("stripPNG() did not remove the text chunk")
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+)
+
+// APIKeyHeader carries an api key in place of a bearer token
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyVerifier checks an api key, returning the claims a request made with it has
+type APIKeyVerifier interface {
+	Verify(key string, ctx context.Context) (*validator.ValidatedClaims, error)
+}
+
+// EnsureValidCredentials accepts an api key in the X-API-Key header, and otherwise
+// requires a JWT from one of the issuers. A key's claims are attached to the request in the same
+// way as a token's, so GetClaims and RequireScope work for both.
+func EnsureValidCredentials(keys APIKeyVerifier, issuers []TokenIssuer) func(next http.Handler) http.Handler {
+	ensureValidToken := EnsureValidTokenFrom(issuers...)
+	return func(next http.Handler) http.Handler {
+		checkJWT := ensureValidToken(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				checkJWT.ServeHTTP(w, r)
+				return
+			}
+			claims, err := keys.Verify(key, r.Context())
+			if err != nil {
+				log.Printf("Encountered error while validating api key: %v", err)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message":"Failed to validate api key."}`))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtmiddleware.ContextKey{}, claims)))
+		})
+	}
+}
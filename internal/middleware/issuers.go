@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+)
+
+// Kinds of OpenID provider, which differ in where they put scopes, roles and the client id
+const (
+	IssuerAuth0    = "auth0"
+	IssuerKeycloak = "keycloak"
+	IssuerOkta     = "okta"
+	IssuerOIDC     = "oidc"
+)
+
+// ClaimMapping names the claims of a provider's tokens which hold scopes, roles, the client
+// id and the tenant the caller belongs to. A claim may be nested, e.g. "realm_access.roles", and may hold a space
+// separated string or a list of strings.
+type ClaimMapping struct {
+	Scope    string `json:"scope"`
+	Roles    string `json:"roles"`
+	ClientID string `json:"client_id"`
+	Tenant   string `json:"tenant"`
+}
+
+// claimMappings are where each kind of provider puts its claims by default
+var claimMappings = map[string]ClaimMapping{
+	// Auth0 only adds roles to tokens through an action, under a namespaced claim, and puts
+	// the organization the caller signed in to in org_id
+	IssuerAuth0:    {Scope: "scope", ClientID: "azp", Tenant: "org_id"},
+	IssuerKeycloak: {Scope: "scope", Roles: "realm_access.roles", ClientID: "azp"},
+	IssuerOkta:     {Scope: "scp", Roles: "groups", ClientID: "cid"},
+	IssuerOIDC:     {Scope: "scope", ClientID: "azp"},
+}
+
+// TokenIssuer is an OpenID provider whose tokens are accepted
+type TokenIssuer struct {
+	// URL is the issuer claim of its tokens, under which its discovery document is served
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+	// Audiences are those a token may be issued for, of which it needs one
+	Audiences  []string                       `json:"audiences"`
+	Algorithms []validator.SignatureAlgorithm `json:"algorithms"`
+	// JWKSURL is where its keys are fetched from, found through discovery if empty
+	JWKSURL string       `json:"jwks_url"`
+	Claims  ClaimMapping `json:"claims"`
+	// Primary marks the issuer whose subjects are used as they are. Subjects from other
+	// issuers are prefixed with the issuer's url, so that no issuer can mint a subject which
+	// stands for a caller of another.
+	Primary bool `json:"primary"`
+	// AllowedScopes, AllowedRoles and AllowedTenants are what the issuer's tokens may claim.
+	// Scopes and roles outside them are dropped, and a token claiming a tenant outside them
+	// is refused. The primary issuer may claim anything unless it is given a list, and other
+	// issuers nothing.
+	AllowedScopes  []string `json:"allowed_scopes"`
+	AllowedRoles   []string `json:"allowed_roles"`
+	AllowedTenants []string `json:"allowed_tenants"`
+}
+
+// Auth0Issuer is the Auth0 tenant named by AUTH0_DOMAIN, issuing tokens for AUTH0_AUDIENCE
+func Auth0Issuer() TokenIssuer {
+	return withDefaults(TokenIssuer{
+		URL:       "https://" + os.Getenv("AUTH0_DOMAIN") + "/",
+		Kind:      IssuerAuth0,
+		Audiences: []string{os.Getenv("AUTH0_AUDIENCE")},
+		Primary:   true,
+	})
+}
+
+// ParseIssuers reads the trusted issuers from json such as
+// [{"url": "https://sso.example.com/realms/nursery", "kind": "keycloak", "audiences": ["plants"], "algorithms": ["ES256"]}]
+// Algorithms default to RS256, and claims not mapped default to where that kind of
+// provider puts them. At most one issuer may be primary.
+func ParseIssuers(data string) ([]TokenIssuer, error) {
+	issuers := []TokenIssuer{}
+	err := json.Unmarshal([]byte(data), &issuers)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	primary := false
+	for i, issuer := range issuers {
+		if issuer.URL == "" || len(issuer.Audiences) == 0 {
+			return nil, errors.New("token issuers need a url and an audience")
+		}
+		if seen[issuer.URL] {
+			return nil, errors.New("token issuer listed twice: " + issuer.URL)
+		}
+		seen[issuer.URL] = true
+		if issuer.Primary && primary {
+			return nil, errors.New("only one token issuer may be primary")
+		}
+		primary = primary || issuer.Primary
+		if issuer.Kind == "" {
+			issuer.Kind = IssuerOIDC
+		}
+		if _, ok := claimMappings[issuer.Kind]; !ok {
+			return nil, errors.New("unknown kind of token issuer: " + issuer.Kind)
+		}
+		issuers[i] = withDefaults(issuer)
+	}
+	return issuers, nil
+}
+
+func withDefaults(issuer TokenIssuer) TokenIssuer {
+	if len(issuer.Algorithms) == 0 {
+		issuer.Algorithms = []validator.SignatureAlgorithm{validator.RS256}
+	}
+	defaults := claimMappings[issuer.Kind]
+	if issuer.Claims.Scope == "" {
+		issuer.Claims.Scope = defaults.Scope
+	}
+	if issuer.Claims.Roles == "" {
+		issuer.Claims.Roles = defaults.Roles
+	}
+	if issuer.Claims.ClientID == "" {
+		issuer.Claims.ClientID = defaults.ClientID
+	}
+	if issuer.Claims.Tenant == "" {
+		issuer.Claims.Tenant = defaults.Tenant
+	}
+	return issuer
+}
+
+// restrict prefixes the subject of a validated token from the issuer unless it is primary,
+// and drops the scopes and roles the issuer may not grant
+func (issuer TokenIssuer) restrict(claims *validator.ValidatedClaims) (*validator.ValidatedClaims, error) {
+	if !issuer.Primary {
+		claims.RegisteredClaims.Subject = issuer.URL + "|" + claims.RegisteredClaims.Subject
+	}
+	customClaims, ok := claims.CustomClaims.(*CustomClaims)
+	if !ok {
+		return claims, nil
+	}
+	customClaims.Scope = strings.Join(issuer.allowed(issuer.AllowedScopes, strings.Fields(customClaims.Scope)), " ")
+	customClaims.Roles = issuer.allowed(issuer.AllowedRoles, customClaims.Roles)
+	if customClaims.Tenant != "" && len(issuer.allowed(issuer.AllowedTenants, []string{customClaims.Tenant})) == 0 {
+		return nil, fmt.Errorf("token issuer %q may not claim tenant %q", issuer.URL, customClaims.Tenant)
+	}
+	return claims, nil
+}
+
+// allowed returns the values in the allow list, or all of them for a primary issuer
+// without one
+func (issuer TokenIssuer) allowed(allowList []string, values []string) []string {
+	if issuer.Primary && allowList == nil {
+		return values
+	}
+	kept := []string{}
+	for _, value := range values {
+		if slices.Contains(allowList, value) {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// UnmarshalJSON reads the claims of a token through the claim mapping of its issuer, or
+// from the scope and azp claims if it has none
+func (c *CustomClaims) UnmarshalJSON(data []byte) error {
+	mapping := claimMappings[IssuerOIDC]
+	if c.mapping != nil {
+		mapping = *c.mapping
+	}
+	claims := map[string]interface{}{}
+	err := json.Unmarshal(data, &claims)
+	if err != nil {
+		return err
+	}
+	c.Scope = strings.Join(claimStrings(claims, mapping.Scope), " ")
+	c.Roles = claimStrings(claims, mapping.Roles)
+	if clientID := claimStrings(claims, mapping.ClientID); len(clientID) > 0 {
+		c.ClientID = clientID[0]
+	}
+	if tenant := claimStrings(claims, mapping.Tenant); len(tenant) > 0 {
+		c.Tenant = tenant[0]
+	}
+	return nil
+}
+
+// claimStrings returns the strings in a claim. A name is looked up whole first, since
+// namespaced claims such as https://plants.example/roles contain dots, and otherwise as a
+// path through nested objects.
+func claimStrings(claims map[string]interface{}, name string) []string {
+	if name == "" {
+		return nil
+	}
+	value, ok := claims[name]
+	if !ok {
+		var current interface{} = claims
+		for _, part := range strings.Split(name, ".") {
+			object, isObject := current.(map[string]interface{})
+			if !isObject {
+				return nil
+			}
+			current = object[part]
+		}
+		value = current
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
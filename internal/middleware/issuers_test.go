@@ -0,0 +1,215 @@
+package middleware_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// testProvider serves a discovery document and a JWKS with a single key
+type testProvider struct {
+	*httptest.Server
+	key       crypto.Signer
+	algorithm jose.SignatureAlgorithm
+}
+
+func newTestProvider(t *testing.T, key crypto.Signer, algorithm jose.SignatureAlgorithm) *testProvider {
+	p := &testProvider{key: key, algorithm: algorithm}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL + "/", "jwks_uri": p.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "test", Algorithm: string(algorithm), Use: "sig"},
+		}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProvider) mint(t *testing.T, audience string, custom map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: p.algorithm, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	assert.NoError(t, err)
+	now := time.Now()
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer: p.URL + "/", Subject: "user", Audience: jwt.Audience{audience},
+		IssuedAt: jwt.NewNumericDate(now), Expiry: jwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(custom).CompactSerialize()
+	assert.NoError(t, err)
+	return token
+}
+
+func TestEnsureValidTokenFrom(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keycloak := newTestProvider(t, ecKey, jose.ES256)
+	okta := newTestProvider(t, edKey, jose.EdDSA)
+	untrusted := newTestProvider(t, rsaKey, jose.RS256)
+	// signs with a key type keycloak is not trusted to use
+	impostor := &testProvider{Server: keycloak.Server, key: rsaKey, algorithm: jose.RS256}
+
+	issuers, err := middleware.ParseIssuers(`[
+		{"url": "` + keycloak.URL + `/", "kind": "keycloak", "audiences": ["plants"], "algorithms": ["ES256"], "claims": {"tenant": "organization"},
+		 "allowed_scopes": ["read:plants", "write:plants"], "allowed_roles": ["editor"], "allowed_tenants": ["greenhouse"]},
+		{"url": "` + okta.URL + `/", "kind": "okta", "audiences": ["api://plants"], "algorithms": ["EdDSA"], "jwks_url": "` + okta.URL + `/keys", "allowed_scopes": ["read:plants"]}
+	]`)
+	assert.NoError(t, err)
+
+	var claims *middleware.CustomClaims
+	var subject string
+	protected := middleware.EnsureValidTokenFrom(issuers...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validated, _ := middleware.GetClaims(r)
+		claims = validated.CustomClaims.(*middleware.CustomClaims)
+		subject = middleware.GetSubject(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+		claims   *middleware.CustomClaims
+		subject  string
+	}{
+		{
+			"keycloak token",
+			keycloak.mint(t, "plants", map[string]interface{}{
+				"scope": "read:plants write:plants", "azp": "nursery-app",
+				"realm_access": map[string]interface{}{"roles": []string{"editor"}}, "organization": "greenhouse",
+			}),
+			http.StatusOK,
+			&middleware.CustomClaims{Scope: "read:plants write:plants", ClientID: "nursery-app", Roles: []string{"editor"}, Tenant: "greenhouse"},
+			keycloak.URL + "/|user",
+		},
+		{
+			"okta token loses the roles okta may not grant",
+			okta.mint(t, "api://plants", map[string]interface{}{
+				"scp": []string{"read:plants"}, "cid": "0oa1", "groups": []string{"moderators"},
+			}),
+			http.StatusOK,
+			&middleware.CustomClaims{Scope: "read:plants", ClientID: "0oa1", Roles: []string{}},
+			okta.URL + "/|user",
+		},
+		{
+			"keycloak token loses the scopes and roles keycloak may not grant",
+			keycloak.mint(t, "plants", map[string]interface{}{
+				"scope": "read:plants manage:roles", "realm_access": map[string]interface{}{"roles": []string{"editor", "admin"}},
+			}),
+			http.StatusOK,
+			&middleware.CustomClaims{Scope: "read:plants", Roles: []string{"editor"}},
+			keycloak.URL + "/|user",
+		},
+		{
+			"keycloak token claiming a tenant keycloak may not",
+			keycloak.mint(t, "plants", map[string]interface{}{"organization": "nursery"}),
+			http.StatusUnauthorized, nil, "",
+		},
+		{"wrong audience", keycloak.mint(t, "api://plants", nil), http.StatusUnauthorized, nil, ""},
+		{"untrusted issuer", untrusted.mint(t, "plants", nil), http.StatusUnauthorized, nil, ""},
+		{"algorithm not allowed for issuer", impostor.mint(t, "plants", nil), http.StatusUnauthorized, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims = nil
+			req := httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			protected.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+			if tt.claims != nil && assert.NotNil(t, claims) {
+				assert.Equal(t, tt.claims.Scope, claims.Scope)
+				assert.Equal(t, tt.claims.ClientID, claims.ClientID)
+				assert.Equal(t, tt.claims.Roles, claims.Roles)
+				assert.Equal(t, tt.claims.Tenant, claims.Tenant)
+				// subjects from issuers other than the primary one are namespaced by the issuer
+				assert.Equal(t, tt.subject, subject)
+			}
+		})
+	}
+}
+
+func TestParseIssuers(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []middleware.TokenIssuer
+		err      bool
+	}{
+		{
+			name: "defaults from kind",
+			data: `[{"url": "https://example.okta.com/oauth2/default", "kind": "okta", "audiences": ["api://plants"]}]`,
+			expected: []middleware.TokenIssuer{{
+				URL: "https://example.okta.com/oauth2/default", Kind: "okta", Audiences: []string{"api://plants"},
+				Algorithms: []validator.SignatureAlgorithm{validator.RS256},
+				Claims:     middleware.ClaimMapping{Scope: "scp", Roles: "groups", ClientID: "cid"},
+			}},
+		},
+		{
+			name: "mapped claims override defaults",
+			data: `[{"url": "https://tenant.auth0.com/", "kind": "auth0", "audiences": ["plants"], "claims": {"roles": "https://plants.example/roles"}}]`,
+			expected: []middleware.TokenIssuer{{
+				URL: "https://tenant.auth0.com/", Kind: "auth0", Audiences: []string{"plants"},
+				Algorithms: []validator.SignatureAlgorithm{validator.RS256},
+				Claims:     middleware.ClaimMapping{Scope: "scope", Roles: "https://plants.example/roles", ClientID: "azp", Tenant: "org_id"},
+			}},
+		},
+		{
+			name: "primary issuer",
+			data: `[{"url": "https://tenant.auth0.com/", "kind": "auth0", "audiences": ["plants"], "primary": true}, {"url": "https://sso.example.com/", "audiences": ["plants"], "allowed_scopes": ["read:plants"]}]`,
+			expected: []middleware.TokenIssuer{
+				{
+					URL: "https://tenant.auth0.com/", Kind: "auth0", Audiences: []string{"plants"},
+					Algorithms: []validator.SignatureAlgorithm{validator.RS256},
+					Claims:     middleware.ClaimMapping{Scope: "scope", ClientID: "azp", Tenant: "org_id"},
+					Primary:    true,
+				},
+				{
+					URL: "https://sso.example.com/", Kind: "oidc", Audiences: []string{"plants"},
+					Algorithms:    []validator.SignatureAlgorithm{validator.RS256},
+					Claims:        middleware.ClaimMapping{Scope: "scope", ClientID: "azp"},
+					AllowedScopes: []string{"read:plants"},
+				},
+			},
+		},
+		{
+			name: "two primary issuers",
+			data: `[{"url": "https://tenant.auth0.com/", "audiences": ["a"], "primary": true}, {"url": "https://sso.example.com/", "audiences": ["b"], "primary": true}]`,
+			err:  true,
+		},
+		{name: "missing audience", data: `[{"url": "https://sso.example.com/"}]`, err: true},
+		{name: "unknown kind", data: `[{"url": "https://sso.example.com/", "kind": "ldap", "audiences": ["plants"]}]`, err: true},
+		{
+			name: "duplicate issuer",
+			data: `[{"url": "https://sso.example.com/", "audiences": ["a"]}, {"url": "https://sso.example.com/", "audiences": ["b"]}]`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuers, err := middleware.ParseIssuers(tt.data)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, issuers)
+		})
+	}
+}
@@ -2,21 +2,30 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/jwks"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
 )
 
 // CustomClaims contains custom data we want from the token.
 type CustomClaims struct {
 	Scope string `json:"scope"`
+	// ClientID is the Auth0 application the token was issued to
+	ClientID string `json:"azp"`
+	// Roles are those the issuer gave the caller, if it maps any
+	Roles []string `json:"roles,omitempty"`
+	// Tenant is the organization the caller belongs to, if the issuer says
+	Tenant string `json:"tenant,omitempty"`
+	// mapping is where the issuer of the token puts these claims
+	mapping *ClaimMapping
 }
 
 // Validate does nothing for this example, but we need
@@ -27,27 +36,78 @@ func (c CustomClaims) Validate(ctx context.Context) error {
 
 // EnsureValidToken is a middleware that will check the validity of our JWT.
 func EnsureValidToken() func(next http.Handler) http.Handler {
-	issuerURL, err := url.Parse("https://" + os.Getenv("AUTH0_DOMAIN") + "/")
-	if err != nil {
-		log.Fatalf("Failed to parse the issuer url: %v", err)
+	return EnsureValidTokenFrom(Auth0Issuer())
+}
+
+// EnsureValidTokenFrom checks JWTs from any of the issuers, signed with one of the issuer's
+// algorithms by a key from its JWKS
+func EnsureValidTokenFrom(issuers ...TokenIssuer) func(next http.Handler) http.Handler {
+	validators := map[string]map[string]*validator.Validator{}
+	trusted := map[string]TokenIssuer{}
+	for _, issuer := range issuers {
+		issuerURL, err := url.Parse(issuer.URL)
+		if err != nil {
+			log.Fatalf("Failed to parse the issuer url: %v", err)
+		}
+
+		options := []jwks.ProviderOption{}
+		if issuer.JWKSURL != "" {
+			jwksURL, err := url.Parse(issuer.JWKSURL)
+			if err != nil {
+				log.Fatalf("Failed to parse the jwks url: %v", err)
+			}
+			options = append(options, jwks.WithCustomJWKSURI(jwksURL))
+		}
+		provider := jwks.NewCachingProvider(issuerURL, 5*time.Minute, options...)
+
+		mapping := issuer.Claims
+		trusted[issuerURL.String()] = issuer
+		validators[issuerURL.String()] = map[string]*validator.Validator{}
+		for _, algorithm := range issuer.Algorithms {
+			jwtValidator, err := validator.New(
+				provider.KeyFunc,
+				algorithm,
+				issuerURL.String(),
+				issuer.Audiences,
+				validator.WithCustomClaims(
+					func() validator.CustomClaims {
+						return &CustomClaims{mapping: &mapping}
+					},
+				),
+				validator.WithAllowedClockSkew(time.Minute),
+			)
+			if err != nil {
+				log.Fatalf("Failed to set up the jwt validator for %s: %v", issuer.URL, err)
+			}
+			validators[issuerURL.String()][string(algorithm)] = jwtValidator
+		}
 	}
 
-	provider := jwks.NewCachingProvider(issuerURL, 5*time.Minute)
-
-	jwtValidator, err := validator.New(
-		provider.KeyFunc,
-		validator.RS256,
-		issuerURL.String(),
-		[]string{os.Getenv("AUTH0_AUDIENCE")},
-		validator.WithCustomClaims(
-			func() validator.CustomClaims {
-				return &CustomClaims{}
-			},
-		),
-		validator.WithAllowedClockSkew(time.Minute),
-	)
-	if err != nil {
-		log.Fatalf("Failed to set up the jwt validator")
+	// the issuer and algorithm are read before the signature is checked, only to choose
+	// the validator which checks it
+	validateToken := func(ctx context.Context, token string) (interface{}, error) {
+		parsed, err := jwt.ParseSigned(token)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the token: %w", err)
+		}
+		claims := jwt.Claims{}
+		err = parsed.UnsafeClaimsWithoutVerification(&claims)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the token claims: %w", err)
+		}
+		byAlgorithm, ok := validators[claims.Issuer]
+		if !ok {
+			return nil, fmt.Errorf("token issuer %q is not trusted", claims.Issuer)
+		}
+		jwtValidator, ok := byAlgorithm[parsed.Headers[0].Algorithm]
+		if !ok {
+			return nil, fmt.Errorf("token issuer %q does not sign with %q", claims.Issuer, parsed.Headers[0].Algorithm)
+		}
+		validated, err := jwtValidator.ValidateToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return trusted[claims.Issuer].restrict(validated.(*validator.ValidatedClaims))
 	}
 
 	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
@@ -59,7 +119,7 @@ func EnsureValidToken() func(next http.Handler) http.Handler {
 	}
 
 	middleware := jwtmiddleware.New(
-		jwtValidator.ValidateToken,
+		validateToken,
 		jwtmiddleware.WithErrorHandler(errorHandler),
 	)
 
@@ -78,4 +138,42 @@ func (c CustomClaims) HasScope(expectedScope string) bool {
 	}
 
 	return false
-}
\ No newline at end of file
+}
+
+// GetClaims returns the validated claims attached to the request by EnsureValidToken.
+func GetClaims(r *http.Request) (*validator.ValidatedClaims, bool) {
+	claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+	return claims, ok
+}
+
+// GetSubject returns the subject of the token used to make the request,
+// or an empty string if the request is not authenticated.
+func GetSubject(r *http.Request) string {
+	claims, ok := GetClaims(r)
+	if !ok {
+		return ""
+	}
+	return claims.RegisteredClaims.Subject
+}
+
+// RequireScope is a middleware that rejects requests whose token does not have the
+// expected scope. It must run after EnsureValidToken.
+func RequireScope(expectedScope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaims(r)
+			if ok {
+				customClaims, ok := claims.CustomClaims.(*CustomClaims)
+				if ok && customClaims.HasScope(expectedScope) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			log.Printf("Request is missing required scope %s", expectedScope)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"Insufficient scope."}`))
+		})
+	}
+}
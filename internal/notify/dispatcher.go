@@ -0,0 +1,265 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/care"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is dead lettered
+	MaxAttempts   = 5
+	retryDelay    = time.Minute
+	maxRetryDelay = time.Hour
+	// deliveryRetention is how long delivery records are kept, which must be longer than
+	// the longest a task can stay due without being completed for reminders not to repeat
+	deliveryRetention = 90 * 24 * time.Hour
+)
+
+// Dispatcher periodically reminds users of their due care tasks. Only the replica holding
+// the lock sends reminders, and every delivery is recorded so none is sent twice.
+type Dispatcher struct {
+	db       db.DBInterface
+	senders  map[string]Sender
+	lock     Lock
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewDispatcher builds a dispatcher checking for due tasks every interval. Senders are
+// keyed by the kind of channel they deliver to.
+func NewDispatcher(database db.DBInterface, senders map[string]Sender, lock Lock, interval time.Duration) *Dispatcher {
+	return &Dispatcher{db: database, senders: senders, lock: lock, interval: interval, now: time.Now}
+}
+
+// Run checks for due tasks of every tenant every interval until the context is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		leader, err := d.lock.Acquire(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+		if leader {
+			err = db.ForEachTenant(d.db, ctx, d.Tick)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			err = d.lock.Release(context.Background())
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick sends every reminder which is due. A failure for one user does not stop the others.
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	all, err := d.db.ListNotificationPreferences(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, preferences := range all {
+		err = d.NotifyUser(preferences, ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notifying %s: %w", preferences.UserID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NotifyUser sends the user reminders of tasks which have fallen due and retries failed
+// deliveries, unless it is within their quiet hours
+func (d *Dispatcher) NotifyUser(preferences pkg.NotificationPreferences, ctx context.Context) error {
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	local := now.In(location)
+	if InQuietHours(preferences.QuietHours, local) || len(preferences.Channels) == 0 {
+		return nil
+	}
+	hemisphere := preferences.Hemisphere
+	if hemisphere == "" {
+		hemisphere = care.HemisphereNorth
+	}
+	entries, err := d.db.GetCollection(preferences.UserID, ctx)
+	if err != nil {
+		return err
+	}
+	states, err := d.db.GetTaskStates(preferences.UserID, ctx)
+	if err != nil {
+		return err
+	}
+	tasks, err := care.CollectionTasks(entries, states, care.Options{Location: location, Hemisphere: hemisphere, Now: now}, db.CareProfiles(d.db, ctx))
+	if err != nil {
+		return err
+	}
+	due := map[string]pkg.CareTask{}
+	dueTasks := []pkg.CareTask{}
+	for _, task := range tasks {
+		if !task.DueAt.After(now) {
+			due[task.ID] = task
+			dueTasks = append(dueTasks, task)
+		}
+	}
+	deliveries, err := d.db.GetDeliveries(preferences.UserID, ctx)
+	if err != nil {
+		return err
+	}
+	sent := map[string]bool{}
+	var errs []error
+	for _, delivery := range deliveries {
+		sent[delivery.ID] = true
+		if delivery.Status != pkg.DeliveryFailed || delivery.NextAttemptAt == nil || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		retry := []pkg.CareTask{}
+		for _, id := range delivery.TaskIDs {
+			if task, ok := due[id]; ok {
+				retry = append(retry, task)
+			}
+		}
+		errs = append(errs, d.deliver(preferences, delivery, retry, now, ctx))
+	}
+
+	// each reminder is identified by what it reminds of and the channel, so that it is
+	// sent once however many times the dispatcher runs
+	pending := map[string][]pkg.CareTask{}
+	if preferences.Digest {
+		if local.Hour() >= preferences.DigestHour && len(dueTasks) > 0 {
+			pending["digest@"+local.Format(time.DateOnly)] = dueTasks
+		}
+	} else {
+		for _, task := range dueTasks {
+			pending[task.ID+"@"+task.DueDate] = []pkg.CareTask{task}
+		}
+	}
+	for key, remind := range pending {
+		for i := range preferences.Channels {
+			id := key + "#" + strconv.Itoa(i)
+			if sent[id] {
+				continue
+			}
+			taskIDs := make([]string, len(remind))
+			for j := range remind {
+				taskIDs[j] = remind[j].ID
+			}
+			delivery := pkg.Delivery{
+				UserID: preferences.UserID, ID: id, Channel: i, TaskIDs: taskIDs, Status: pkg.DeliveryPending, CreatedAt: now.UTC(), ExpiresAt: now.Add(deliveryRetention).Unix(),
+			}
+			errs = append(errs, d.deliver(preferences, delivery, remind, now, ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver sends a delivery and records the outcome. Failures are retried with
+// exponential backoff until MaxAttempts, then dead lettered.
+func (d *Dispatcher) deliver(preferences pkg.NotificationPreferences, delivery pkg.Delivery, tasks []pkg.CareTask, now time.Time, ctx context.Context) error {
+	if len(tasks) == 0 {
+		// the tasks were completed before the delivery could be retried
+		delivery.Status = pkg.DeliveryDelivered
+		delivery.NextAttemptAt = nil
+		return d.db.PutDelivery(delivery, ctx)
+	}
+	delivery.Attempts++
+	err := d.send(preferences, delivery, tasks, now, ctx)
+	if err == nil {
+		at := now.UTC()
+		delivery.Status = pkg.DeliveryDelivered
+		delivery.DeliveredAt = &at
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	} else if delivery.Attempts >= MaxAttempts {
+		log.Printf("dead lettering delivery %s to %s: %v", delivery.ID, delivery.UserID, err)
+		delivery.Status = pkg.DeliveryDead
+		delivery.NextAttemptAt = nil
+		delivery.LastError = err.Error()
+	} else {
+		next := now.Add(RetryDelay(delivery.Attempts)).UTC()
+		delivery.Status = pkg.DeliveryFailed
+		delivery.NextAttemptAt = &next
+		delivery.LastError = err.Error()
+	}
+	return d.db.PutDelivery(delivery, ctx)
+}
+
+func (d *Dispatcher) send(preferences pkg.NotificationPreferences, delivery pkg.Delivery, tasks []pkg.CareTask, now time.Time, ctx context.Context) error {
+	if delivery.Channel >= len(preferences.Channels) {
+		return errors.New("channel was removed")
+	}
+	channel := preferences.Channels[delivery.Channel]
+	sender, ok := d.senders[channel.Kind]
+	if !ok {
+		return errors.New("no sender for " + channel.Kind + " channels")
+	}
+	return sender.Send(channel, NewMessage(delivery.ID, tasks, now), ctx)
+}
+
+// RetryDelay is how long to wait after the given number of failed attempts
+func RetryDelay(attempts int) time.Duration {
+	delay := retryDelay << (attempts - 1)
+	if delay > maxRetryDelay || delay <= 0 {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// NewMessage builds the reminder of the given tasks
+func NewMessage(deliveryID string, tasks []pkg.CareTask, now time.Time) Message {
+	subject := tasks[0].Summary()
+	if len(tasks) > 1 {
+		subject = fmt.Sprintf("%d plant care tasks are due", len(tasks))
+	}
+	var text strings.Builder
+	for _, task := range tasks {
+		text.WriteString("- " + task.Summary() + ", due " + task.DueDate + "\n")
+	}
+	return Message{DeliveryID: deliveryID, Subject: subject, Text: text.String(), Tasks: tasks, SentAt: now.UTC()}
+}
+
+// InQuietHours reports whether the local time falls within the quiet hours
+func InQuietHours(quiet *pkg.QuietHours, local time.Time) bool {
+	if quiet == nil {
+		return false
+	}
+	start, err := ParseClock(quiet.Start)
+	if err != nil {
+		return false
+	}
+	end, err := ParseClock(quiet.End)
+	if err != nil {
+		return false
+	}
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// ParseClock parses a "HH:MM" time of day into minutes after midnight
+func ParseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// locksTable holds lock items keyed by name
+const locksTable = "plants_v1_locks"
+
+// Lock elects one replica to do work which must not be done twice
+type Lock interface {
+	// Acquire takes or renews the lock, reporting whether this replica holds it
+	Acquire(context.Context) (bool, error)
+	Release(context.Context) error
+}
+
+// DynamoLock is a lease held in a DynamoDB item. The holder renews it before it expires;
+// if the holder dies another replica takes over once the lease runs out.
+type DynamoLock struct {
+	client *dynamodb.Client
+	name   string
+	owner  string
+	lease  time.Duration
+}
+
+func NewDynamoLock(client *dynamodb.Client, name string, lease time.Duration) *DynamoLock {
+	b := make([]byte, 4)
+	rand.Read(b)
+	host, _ := os.Hostname()
+	return &DynamoLock{client: client, name: name, owner: host + "-" + hex.EncodeToString(b), lease: lease}
+}
+
+func (l *DynamoLock) Acquire(context context.Context) (bool, error) {
+	now := time.Now()
+	_, err := l.client.PutItem(context, &dynamodb.PutItemInput{
+		TableName: aws.String(locksTable), Item: map[string]types.AttributeValue{
+			"name":       &types.AttributeValueMemberS{Value: l.name},
+			"owner":      &types.AttributeValueMemberS{Value: l.owner},
+			"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(l.lease).Unix(), 10)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#name) or expires_at < :now or #owner = :owner"),
+		ExpressionAttributeNames: map[string]string{"#name": "name", "#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":owner": &types.AttributeValueMemberS{Value: l.owner},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release gives up the lock if this replica holds it, so another can take over at once
+func (l *DynamoLock) Release(context context.Context) error {
+	_, err := l.client.DeleteItem(context, &dynamodb.DeleteItemInput{
+		TableName: aws.String(locksTable), Key: map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: l.name}},
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: l.owner}},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
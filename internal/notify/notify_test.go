@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeSender struct {
+	err  error
+	sent []Message
+}
+
+func (f *fakeSender) Send(channel pkg.NotificationChannel, message Message, context context.Context) error {
+	f.sent = append(f.sent, message)
+	return f.err
+}
+
+func TestDispatcher_NotifyUser(t *testing.T) {
+	// 10:00 in New York
+	now := time.Date(2024, 5, 10, 14, 0, 0, 0, time.UTC)
+	failedAt := now.Add(-time.Minute)
+	tests := []struct {
+		name       string
+		quiet      *pkg.QuietHours
+		digest     bool
+		digestHour int
+		deliveries []pkg.Delivery
+		sendErr    error
+		sent       int
+		verify     func(pkg.Delivery) bool
+	}{
+		{
+			name:   "notify user sends a reminder for each due task",
+			sent:   2,
+			verify: func(d pkg.Delivery) bool { return d.Status == pkg.DeliveryDelivered && d.Attempts == 1 },
+		},
+		{
+			name:  "notify user sends nothing in quiet hours",
+			quiet: &pkg.QuietHours{Start: "22:00", End: "11:00"},
+		},
+		{
+			name: "notify user does not repeat a reminder",
+			deliveries: []pkg.Delivery{
+				{UserID: "user", ID: "1.water@2024-05-03#0", Status: pkg.DeliveryDelivered},
+			},
+			sent:   1,
+			verify: func(d pkg.Delivery) bool { return d.ID == "1.repot@2024-05-09#0" },
+		},
+		{
+			name:   "notify user sends one digest of every due task",
+			digest: true,
+			sent:   1,
+			verify: func(d pkg.Delivery) bool { return d.ID == "digest@2024-05-10#0" && len(d.TaskIDs) == 2 },
+		},
+		{
+			name:       "notify user waits for the digest hour",
+			digest:     true,
+			digestHour: 18,
+		},
+		{
+			name:    "notify user schedules a retry when delivery fails",
+			sendErr: errors.New("connection refused"),
+			sent:    2,
+			verify: func(d pkg.Delivery) bool {
+				return d.Status == pkg.DeliveryFailed && d.NextAttemptAt.Equal(now.Add(time.Minute)) && d.LastError == "connection refused"
+			},
+		},
+		{
+			name:    "notify user dead letters a delivery after the last attempt",
+			sendErr: errors.New("connection refused"),
+			deliveries: []pkg.Delivery{
+				{UserID: "user", ID: "1.water@2024-05-03#0", TaskIDs: []string{"1.water"}, Status: pkg.DeliveryFailed, Attempts: MaxAttempts - 1, NextAttemptAt: &failedAt},
+				{UserID: "user", ID: "1.repot@2024-05-09#0", Status: pkg.DeliveryDelivered},
+			},
+			sent:   1,
+			verify: func(d pkg.Delivery) bool { return d.Status == pkg.DeliveryDead && d.Attempts == MaxAttempts },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCollection", "user", ctx).Return([]pkg.CollectionEntry{{UserID: "user", ID: "1", PlantName: "fern", AcquiredOn: "2024-04-26"}}, nil)
+			mockDB.On("GetTaskStates", "user", ctx).Return(map[string]pkg.TaskState{}, nil)
+			mockDB.On("GetPlant", "fern", ctx).Return(&pkg.Plant{Name: "fern", Care: &pkg.CareProfile{WaterEveryDays: 7, RepotEveryDays: 13, PruneEveryDays: 90}}, nil)
+			mockDB.On("GetDeliveries", "user", ctx).Return(tt.deliveries, nil)
+			if tt.verify != nil {
+				mockDB.On("PutDelivery", mock.MatchedBy(tt.verify), ctx).Return(nil)
+			}
+			sender := &fakeSender{err: tt.sendErr}
+			d := NewDispatcher(mockDB, map[string]Sender{pkg.ChannelWebhook: sender}, nil, time.Minute)
+			d.now = func() time.Time { return now }
+			preferences := pkg.NotificationPreferences{
+				UserID: "user", Timezone: "America/New_York", QuietHours: tt.quiet, Digest: tt.digest, DigestHour: tt.digestHour,
+				Channels: []pkg.NotificationChannel{{Kind: pkg.ChannelWebhook, URL: "https://example.com"}},
+			}
+			err := d.NotifyUser(preferences, ctx)
+			if err != nil {
+				t.Fatalf("Dispatcher.NotifyUser() error = %v", err)
+			}
+			if len(sender.sent) != tt.sent {
+				t.Errorf("Dispatcher.NotifyUser() sent %d messages, want %d", len(sender.sent), tt.sent)
+			}
+		})
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	overnight := &pkg.QuietHours{Start: "22:00", End: "07:00"}
+	afternoon := &pkg.QuietHours{Start: "13:00", End: "15:30"}
+	tests := []struct {
+		quiet *pkg.QuietHours
+		clock string
+		want  bool
+	}{
+		{nil, "03:00", false},
+		{overnight, "23:15", true},
+		{overnight, "06:59", true},
+		{overnight, "07:00", false},
+		{afternoon, "15:29", true},
+		{afternoon, "12:59", false},
+	}
+	for _, tt := range tests {
+		local, _ := time.Parse("15:04", tt.clock)
+		if got := InQuietHours(tt.quiet, local); got != tt.want {
+			t.Errorf("InQuietHours(%v, %s) = %v, want %v", tt.quiet, tt.clock, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if RetryDelay(1) != time.Minute || RetryDelay(3) != 4*time.Minute || RetryDelay(20) != time.Hour {
+		t.Errorf("RetryDelay() = %v, %v, %v", RetryDelay(1), RetryDelay(3), RetryDelay(20))
+	}
+}
+
+func TestWebhookSender_Send(t *testing.T) {
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+	at := time.Unix(1715349600, 0)
+	message := NewMessage("1.water@2024-05-10#0", []pkg.CareTask{{ID: "1.water", PlantName: "fern", Kind: pkg.TaskWater, DueDate: "2024-05-10"}}, at)
+	err := NewWebhookSender().Send(pkg.NotificationChannel{Kind: pkg.ChannelWebhook, URL: server.URL, Secret: "secret"}, message, context.TODO())
+	if err != nil {
+		t.Fatalf("WebhookSender.Send() error = %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1715349600." + body))
+	if signature != "t=1715349600,v1="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("WebhookSender.Send() signature = %s", signature)
+	}
+	if !strings.Contains(body, `"subject":"Water fern"`) {
+		t.Errorf("WebhookSender.Send() body = %s", body)
+	}
+}
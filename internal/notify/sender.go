@@ -0,0 +1,126 @@
+// Package notify reminds users of their due care tasks
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/pkg"
+)
+
+const (
+	// SignatureHeader carries the signature of a webhook body, "t={unix seconds},v1={hex hmac}"
+	SignatureHeader = "X-Plants-Signature"
+	// DeliveryHeader carries the delivery id, which stays the same when a delivery is retried
+	DeliveryHeader = "X-Plants-Delivery"
+)
+
+// Message is a reminder of one or more due tasks
+type Message struct {
+	DeliveryID string         `json:"delivery_id"`
+	Subject    string         `json:"subject"`
+	Text       string         `json:"text"`
+	Tasks      []pkg.CareTask `json:"tasks"`
+	SentAt     time.Time      `json:"sent_at"`
+}
+
+// Sender delivers messages over one kind of channel
+type Sender interface {
+	Send(channel pkg.NotificationChannel, message Message, context context.Context) error
+}
+
+// WebhookSender posts messages as json, signed with the channel's secret
+type WebhookSender struct {
+	client *http.Client
+}
+
+func NewWebhookSender() *WebhookSender {
+	return &WebhookSender{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSender) Send(channel pkg.NotificationChannel, message Message, context context.Context) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(context, http.MethodPost, channel.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(DeliveryHeader, message.DeliveryID)
+	request.Header.Set(SignatureHeader, Sign(channel.Secret, message.SentAt, body))
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header for a webhook body. Receivers recompute the hmac of
+// the timestamp, a dot and the body, and should reject old timestamps to prevent replays.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmailSender sends messages as plain text email through an SMTP server
+type EmailSender struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+// NewEmailSenderFromEnv configures email from SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM, returning nil if SMTP_HOST is not set
+func NewEmailSenderFromEnv() *EmailSender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if os.Getenv("SMTP_USERNAME") != "" {
+		auth = smtp.PlainAuth("", os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return &EmailSender{address: host + ":" + port, auth: auth, from: os.Getenv("SMTP_FROM")}
+}
+
+func (s *EmailSender) Send(channel pkg.NotificationChannel, message Message, context context.Context) error {
+	to, err := mail.ParseAddress(channel.Email)
+	if err != nil {
+		return err
+	}
+	var body strings.Builder
+	body.WriteString("From: " + s.from + "\r\n")
+	body.WriteString("To: " + to.String() + "\r\n")
+	body.WriteString("Subject: " + message.Subject + "\r\n")
+	body.WriteString("Date: " + message.SentAt.Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("Message-ID: <" + message.DeliveryID + "@plants>\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(message.Text, "\n", "\r\n"))
+	return smtp.SendMail(s.address, s.auth, s.from, []string{to.Address}, []byte(body.String()))
+}
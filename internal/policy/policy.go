@@ -0,0 +1,327 @@
+// Package policy decides whether a caller may do something, from the scopes in its token
+// and the roles it has been given, taking into account who owns the resource and what state
+// it is in
+package policy
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+)
+
+// cacheTTL is how long roles and assignments are trusted before they are looked up again,
+// so a change made on another replica takes effect within this time
+const cacheTTL = time.Minute
+
+// maxCached bounds how many subjects' assignments are remembered
+const maxCached = 10000
+
+// Built in roles, which may be replaced by storing a role with the same name
+const (
+	RoleViewer      = "viewer"
+	RoleContributor = "contributor"
+	RoleEditor      = "editor"
+	RoleModerator   = "moderator"
+	RoleAdmin       = "admin"
+)
+
+var builtInRoles = map[string]pkg.Role{
+	RoleViewer: {Name: RoleViewer, Description: "may read the catalog", Grants: []pkg.Grant{
+		{Permission: pkg.PermissionReadPlants},
+	}},
+	RoleContributor: {Name: RoleContributor, Description: "may add plants and edit or delete their own", Grants: []pkg.Grant{
+		{Permission: pkg.PermissionReadPlants},
+		{Permission: pkg.PermissionCreatePlants},
+		{Permission: pkg.PermissionUpdatePlants, OwnOnly: true, Statuses: []string{pkg.PlantStatusActive}},
+		{Permission: pkg.PermissionDeletePlants, OwnOnly: true},
+	}},
+	RoleEditor: {Name: RoleEditor, Description: "may add and edit any plant without review", Grants: []pkg.Grant{
+		{Permission: pkg.PermissionReadPlants},
+		{Permission: pkg.PermissionCreatePlants},
+		{Permission: pkg.PermissionUpdatePlants},
+		{Permission: pkg.PermissionRevertPlants},
+		{Permission: pkg.PermissionPublishPlants},
+	}},
+	RoleModerator: {Name: RoleModerator, Description: "may edit, delete and restore any plant and review proposed changes", Grants: []pkg.Grant{
+		{Permission: pkg.PermissionReadPlants},
+		{Permission: pkg.PermissionCreatePlants},
+		{Permission: pkg.PermissionUpdatePlants},
+		{Permission: pkg.PermissionDeletePlants},
+		{Permission: pkg.PermissionRevertPlants},
+		{Permission: pkg.PermissionRestorePlants},
+		{Permission: pkg.PermissionPublishPlants},
+		{Permission: pkg.PermissionReviewPlants},
+	}},
+	RoleAdmin: {Name: RoleAdmin, Description: "may do anything", Grants: []pkg.Grant{
+		{Permission: pkg.PermissionAll},
+	}},
+}
+
+// BuiltInRoles returns the roles defined by the api, sorted by name
+func BuiltInRoles() []pkg.Role {
+	roles := []pkg.Role{}
+	for _, role := range builtInRoles {
+		role.BuiltIn = true
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// BuiltInRole returns the built in role with the name
+func BuiltInRole(name string) (pkg.Role, bool) {
+	role, ok := builtInRoles[name]
+	role.BuiltIn = ok
+	return role, ok
+}
+
+// Store is where roles and role assignments are kept
+type Store interface {
+	GetRole(string, context.Context) (*pkg.Role, error)
+	GetRoleAssignments(string, context.Context) ([]pkg.RoleAssignment, error)
+}
+
+// Subject is the caller a decision is made for
+type Subject struct {
+	ID     string
+	Scopes []string
+	// Roles are those given to the caller by the issuer of its token
+	Roles []string
+}
+
+// ResourceFunc loads the resource a permission is checked against. It is only called when
+// a role's grant depends on the resource's owner or status.
+type ResourceFunc func() (*pkg.Resource, error)
+
+// Engine makes decisions. Callers without a role, assigned or from their token, have the
+// default roles.
+type Engine struct {
+	store        Store
+	defaultRoles []string
+	now          func() time.Time
+
+	mu          sync.Mutex
+	roles       map[string]cachedRole
+	assignments map[string]cachedAssignments
+}
+
+type cachedRole struct {
+	role     *pkg.Role
+	loadedAt time.Time
+}
+
+type cachedAssignments struct {
+	roles    []string
+	loadedAt time.Time
+}
+
+// NewEngine returns an engine reading roles from the store. Without a store only the built in
+// roles and the default roles exist.
+func NewEngine(store Store, defaultRoles []string) *Engine {
+	return &Engine{
+		store:        store,
+		defaultRoles: defaultRoles,
+		now:          time.Now,
+		roles:        map[string]cachedRole{},
+		assignments:  map[string]cachedAssignments{},
+	}
+}
+
+// Decide reports whether the subject has the permission on the resource, and why
+func (e *Engine) Decide(subject Subject, permission string, resource ResourceFunc, ctx context.Context) (pkg.Decision, error) {
+	decision := pkg.Decision{Subject: subject.ID, Permission: permission, Roles: []string{}, Reasons: []string{}}
+	for _, scope := range subject.Scopes {
+		if scope == permission {
+			decision.Allowed = true
+			decision.Reasons = append(decision.Reasons, "token scope "+scope+" grants "+permission)
+		}
+	}
+
+	roles, err := e.subjectRoles(subject, ctx)
+	if err != nil {
+		return pkg.Decision{}, err
+	}
+	var loaded *pkg.Resource
+	for _, named := range roles {
+		decision.Roles = append(decision.Roles, named.name)
+		role, err := e.role(named.name, ctx)
+		if err != nil {
+			return pkg.Decision{}, err
+		}
+		if role == nil {
+			decision.Reasons = append(decision.Reasons, "role "+named.name+" ("+named.source+") is not defined")
+			continue
+		}
+		for _, grant := range role.Grants {
+			if grant.Permission != permission && grant.Permission != pkg.PermissionAll {
+				continue
+			}
+			prefix := "role " + role.Name + " (" + named.source + ") grants " + permission
+			if !grant.OwnOnly && len(grant.Statuses) == 0 {
+				decision.Allowed = true
+				decision.Reasons = append(decision.Reasons, prefix)
+				continue
+			}
+			if loaded == nil {
+				if resource == nil {
+					decision.Reasons = append(decision.Reasons, prefix+" only on particular resources, and there is none")
+					continue
+				}
+				loaded, err = resource()
+				if err != nil {
+					return pkg.Decision{}, err
+				}
+				decision.Resource = loaded
+			}
+			if reason, ok := conditionsMet(grant, subject, loaded); !ok {
+				decision.Reasons = append(decision.Reasons, prefix+" only "+reason)
+				continue
+			}
+			decision.Allowed = true
+			decision.Reasons = append(decision.Reasons, prefix+" on "+describe(loaded))
+		}
+	}
+	if len(decision.Reasons) == 0 {
+		decision.Reasons = append(decision.Reasons, "no scope or role grants "+permission)
+	}
+	return decision, nil
+}
+
+// conditionsMet checks the grant's conditions against the resource, returning what they
+// require if they are not met
+func conditionsMet(grant pkg.Grant, subject Subject, resource *pkg.Resource) (string, bool) {
+	if grant.OwnOnly && (resource.Owner == "" || resource.Owner != subject.ID) {
+		owner := resource.Owner
+		if owner == "" {
+			owner = "no one"
+		}
+		return "on the caller's own resources, and " + describe(resource) + " is owned by " + owner, false
+	}
+	if len(grant.Statuses) > 0 {
+		for _, status := range grant.Statuses {
+			if status == resource.Status {
+				return "", true
+			}
+		}
+		return "on resources which are " + strings.Join(grant.Statuses, " or ") + ", and " + describe(resource) + " is " + resource.Status, false
+	}
+	return "", true
+}
+
+func describe(resource *pkg.Resource) string {
+	if resource.Name == "" {
+		return resource.Kind
+	}
+	return resource.Kind + " " + resource.Name
+}
+
+type namedRole struct {
+	name string
+	// source is where the subject got the role: assigned, token or default
+	source string
+}
+
+// subjectRoles returns the roles assigned to the subject and those in its token, or the
+// default roles if it has neither
+func (e *Engine) subjectRoles(subject Subject, ctx context.Context) ([]namedRole, error) {
+	roles := []namedRole{}
+	assigned, err := e.assigned(subject.ID, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range assigned {
+		roles = append(roles, namedRole{name: name, source: "assigned"})
+	}
+	for _, name := range subject.Roles {
+		roles = append(roles, namedRole{name: name, source: "token"})
+	}
+	if len(roles) == 0 {
+		for _, name := range e.defaultRoles {
+			roles = append(roles, namedRole{name: name, source: "default"})
+		}
+	}
+	return roles, nil
+}
+
+func (e *Engine) assigned(subject string, ctx context.Context) ([]string, error) {
+	if e.store == nil || subject == "" {
+		return nil, nil
+	}
+	now := e.now()
+	key := tenant.Key(tenant.ID(ctx), subject)
+	e.mu.Lock()
+	cached, ok := e.assignments[key]
+	e.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < cacheTTL {
+		return cached.roles, nil
+	}
+	assignments, err := e.store.GetRoleAssignments(subject, ctx)
+	if err != nil {
+		return nil, err
+	}
+	roles := []string{}
+	for _, assignment := range assignments {
+		roles = append(roles, assignment.Role)
+	}
+	e.mu.Lock()
+	if len(e.assignments) >= maxCached {
+		clear(e.assignments)
+	}
+	e.assignments[key] = cachedAssignments{roles: roles, loadedAt: now}
+	e.mu.Unlock()
+	return roles, nil
+}
+
+// role returns the stored role with the name, or the built in one, or nil if neither exists
+func (e *Engine) role(name string, ctx context.Context) (*pkg.Role, error) {
+	builtIn, isBuiltIn := builtInRoles[name]
+	if e.store == nil {
+		if !isBuiltIn {
+			return nil, nil
+		}
+		return &builtIn, nil
+	}
+	now := e.now()
+	key := tenant.Key(tenant.ID(ctx), name)
+	e.mu.Lock()
+	cached, ok := e.roles[key]
+	e.mu.Unlock()
+	if ok && now.Sub(cached.loadedAt) < cacheTTL {
+		return cached.role, nil
+	}
+	role, err := e.store.GetRole(name, ctx)
+	if err != nil && err.Error() != db.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		role = nil
+		if isBuiltIn {
+			role = &builtIn
+		}
+	}
+	e.mu.Lock()
+	e.roles[key] = cachedRole{role: role, loadedAt: now}
+	e.mu.Unlock()
+	return role, nil
+}
+
+// InvalidateRole makes the next decision of the context's tenant read the role again
+func (e *Engine) InvalidateRole(name string, ctx context.Context) {
+	e.mu.Lock()
+	delete(e.roles, tenant.Key(tenant.ID(ctx), name))
+	e.mu.Unlock()
+}
+
+// InvalidateSubject makes the next decision of the context's tenant read the subject's
+// assignments again
+func (e *Engine) InvalidateSubject(subject string, ctx context.Context) {
+	e.mu.Lock()
+	delete(e.assignments, tenant.Key(tenant.ID(ctx), subject))
+	e.mu.Unlock()
+}
@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	roles       map[string]pkg.Role
+	assignments map[string][]string
+	reads       int
+}
+
+func (f *fakeStore) GetRole(name string, ctx context.Context) (*pkg.Role, error) {
+	f.reads++
+	role, ok := f.roles[name]
+	if !ok {
+		return nil, errors.New(db.ErrNotFound)
+	}
+	return &role, nil
+}
+
+func (f *fakeStore) GetRoleAssignments(subject string, ctx context.Context) ([]pkg.RoleAssignment, error) {
+	f.reads++
+	assignments := []pkg.RoleAssignment{}
+	for _, role := range f.assignments[subject] {
+		assignments = append(assignments, pkg.RoleAssignment{Subject: subject, Role: role})
+	}
+	return assignments, nil
+}
+
+func plant(owner string, status string) ResourceFunc {
+	return func() (*pkg.Resource, error) {
+		return &pkg.Resource{Kind: "plant", Name: "monstera", Owner: owner, Status: status}, nil
+	}
+}
+
+func TestEngine_Decide(t *testing.T) {
+	store := &fakeStore{
+		roles: map[string]pkg.Role{
+			// replaces the built in editor
+			RoleEditor: {Name: RoleEditor, Grants: []pkg.Grant{{Permission: pkg.PermissionReadPlants}}},
+			"curator":  {Name: "curator", Grants: []pkg.Grant{{Permission: pkg.PermissionDeletePlants, Statuses: []string{pkg.PlantStatusTrashed}}}},
+		},
+		assignments: map[string][]string{
+			"alice": {RoleContributor},
+			"bob":   {RoleEditor},
+			"carol": {"curator", "ghost"},
+		},
+	}
+	tests := []struct {
+		name       string
+		subject    Subject
+		permission string
+		resource   ResourceFunc
+		allowed    bool
+		reasons    []string
+	}{
+		{
+			name:       "contributor may update their own plant",
+			subject:    Subject{ID: "alice"},
+			permission: pkg.PermissionUpdatePlants,
+			resource:   plant("alice", pkg.PlantStatusActive),
+			allowed:    true,
+			reasons:    []string{"role contributor (assigned) grants update:plants on plant monstera"},
+		},
+		{
+			name:       "contributor may not update another's plant",
+			subject:    Subject{ID: "alice"},
+			permission: pkg.PermissionUpdatePlants,
+			resource:   plant("dave", pkg.PlantStatusActive),
+			reasons:    []string{"role contributor (assigned) grants update:plants only on the caller's own resources, and plant monstera is owned by dave"},
+		},
+		{
+			name:       "contributor may not update their own plant in the trash",
+			subject:    Subject{ID: "alice"},
+			permission: pkg.PermissionUpdatePlants,
+			resource:   plant("alice", pkg.PlantStatusTrashed),
+			reasons:    []string{"role contributor (assigned) grants update:plants only on resources which are active, and plant monstera is trashed"},
+		},
+		{
+			name:       "stored role replaces the built in role",
+			subject:    Subject{ID: "bob"},
+			permission: pkg.PermissionUpdatePlants,
+			resource:   plant("dave", pkg.PlantStatusActive),
+			reasons:    []string{"no scope or role grants update:plants"},
+		},
+		{
+			name:       "status condition is checked and undefined roles are explained",
+			subject:    Subject{ID: "carol"},
+			permission: pkg.PermissionDeletePlants,
+			resource:   plant("dave", pkg.PlantStatusTrashed),
+			allowed:    true,
+			reasons:    []string{"role curator (assigned) grants delete:plants on plant monstera", "role ghost (assigned) is not defined"},
+		},
+		{
+			name:       "token scope grants the permission of the same name",
+			subject:    Subject{ID: "erin", Scopes: []string{"purge:plants"}},
+			permission: pkg.PermissionPurgePlants,
+			allowed:    true,
+			reasons:    []string{"token scope purge:plants grants purge:plants"},
+		},
+		{
+			name:       "token roles are used instead of the default",
+			subject:    Subject{ID: "erin", Roles: []string{RoleAdmin}},
+			permission: pkg.PermissionManageRoles,
+			allowed:    true,
+			reasons:    []string{"role admin (token) grants manage:roles"},
+		},
+		{
+			name:       "callers without roles have the default",
+			subject:    Subject{ID: "erin"},
+			permission: pkg.PermissionCreatePlants,
+			reasons:    []string{"no scope or role grants create:plants"},
+		},
+		{
+			name:       "conditional grant without a resource is refused",
+			subject:    Subject{ID: "alice"},
+			permission: pkg.PermissionDeletePlants,
+			reasons:    []string{"role contributor (assigned) grants delete:plants only on particular resources, and there is none"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(store, []string{RoleViewer})
+			decision, err := engine.Decide(tt.subject, tt.permission, tt.resource, context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			assert.Equal(t, tt.reasons, decision.Reasons)
+		})
+	}
+}
+
+func TestEngine_DecideLoadsResourceOnlyWhenNeeded(t *testing.T) {
+	engine := NewEngine(nil, []string{RoleModerator})
+	loads := 0
+	decision, err := engine.Decide(Subject{ID: "alice"}, pkg.PermissionDeletePlants, func() (*pkg.Resource, error) {
+		loads++
+		return nil, errors.New("should not be loaded")
+	}, context.TODO())
+	assert.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 0, loads)
+}
+
+func TestEngine_Cache(t *testing.T) {
+	store := &fakeStore{roles: map[string]pkg.Role{}, assignments: map[string][]string{"alice": {RoleViewer}}}
+	engine := NewEngine(store, nil)
+	now := time.Unix(1000, 0)
+	engine.now = func() time.Time { return now }
+
+	decide := func() bool {
+		decision, err := engine.Decide(Subject{ID: "alice"}, pkg.PermissionCreatePlants, nil, context.TODO())
+		assert.NoError(t, err)
+		return decision.Allowed
+	}
+	assert.False(t, decide())
+	reads := store.reads
+
+	// a new assignment is not seen until the cache expires or the subject is invalidated
+	store.assignments["alice"] = []string{RoleEditor}
+	assert.False(t, decide())
+	assert.Equal(t, reads, store.reads)
+	engine.InvalidateSubject("alice", context.TODO())
+	assert.True(t, decide())
+
+	store.roles[RoleEditor] = pkg.Role{Name: RoleEditor}
+	assert.True(t, decide())
+	now = now.Add(cacheTTL)
+	assert.False(t, decide())
+}
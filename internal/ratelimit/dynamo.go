@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rateLimitsTable holds a bucket per key, expired by a TTL on expires_at once it has refilled
+const rateLimitsTable = "plants_v1_rate_limits"
+
+// maxConflicts is how many times a take is retried when another replica updated the
+// bucket between reading and writing it
+const maxConflicts = 3
+
+// DynamoAPI is the part of the DynamoDB client used to hold buckets
+type DynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoBackend holds buckets in DynamoDB so that every replica counts against the same
+// limits. Each take reads the bucket and writes it back only if no other replica has
+// written it since.
+type DynamoBackend struct {
+	client DynamoAPI
+}
+
+func NewDynamoBackend(client DynamoAPI) *DynamoBackend {
+	return &DynamoBackend{client: client}
+}
+
+type dynamoBucket struct {
+	Key string `dynamodbav:"key"`
+	bucket
+	ExpiresAt int64 `dynamodbav:"expires_at"`
+}
+
+func (d *DynamoBackend) Take(key string, limit Limit, now time.Time, ctx context.Context) (Result, error) {
+	for attempt := 0; ; attempt++ {
+		output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(rateLimitsTable), Key: map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}}, ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		var previous *dynamoBucket
+		if output.Item != nil {
+			previous = &dynamoBucket{}
+			err = attributevalue.UnmarshalMap(output.Item, previous)
+			if err != nil {
+				return Result{}, err
+			}
+		}
+		var state *bucket
+		if previous != nil {
+			state = &previous.bucket
+		}
+		next, result := take(state, limit, now)
+		if !result.Allowed {
+			// nothing was taken, so there is nothing to write
+			return result, nil
+		}
+		err = d.put(dynamoBucket{Key: key, bucket: next, ExpiresAt: now.Add(result.Reset).Unix() + 1}, previous, ctx)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) && attempt < maxConflicts {
+			continue
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		return result, nil
+	}
+}
+
+// put writes the bucket if it is unchanged since previous was read, or still missing if
+// previous is nil
+func (d *DynamoBackend) put(b dynamoBucket, previous *dynamoBucket, ctx context.Context) error {
+	item, err := attributevalue.MarshalMap(b)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(rateLimitsTable), Item: item, ConditionExpression: aws.String("attribute_not_exists(#key)"), ExpressionAttributeNames: map[string]string{"#key": "key"},
+	}
+	if previous != nil {
+		updatedattribute, err := attributevalue.Marshal(previous.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = aws.String("updated_at = :updated_at")
+		input.ExpressionAttributeNames = nil
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":updated_at": updatedattribute}
+	}
+	_, err = d.client.PutItem(ctx, input)
+	return err
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory backend drops buckets which have refilled
+const sweepInterval = time.Minute
+
+// MemoryBackend holds buckets in memory, so each replica limits the requests it serves
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	// full is when the bucket will have refilled, after which it can be forgotten
+	full time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: map[string]*memoryBucket{}}
+}
+
+func (m *MemoryBackend) Take(key string, limit Limit, now time.Time, ctx context.Context) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+	var previous *bucket
+	if existing, ok := m.buckets[key]; ok {
+		previous = &existing.bucket
+	}
+	next, result := take(previous, limit, now)
+	m.buckets[key] = &memoryBucket{bucket: next, full: now.Add(result.Reset)}
+	return result, nil
+}
+
+// sweep drops full buckets, which behave the same as ones never seen, with mu held
+func (m *MemoryBackend) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
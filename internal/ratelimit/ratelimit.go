@@ -0,0 +1,200 @@
+// Package ratelimit limits how often callers may make requests with token buckets, held in
+// memory for a single replica or in DynamoDB when they are shared between replicas
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Per, in bursts of up to Requests. A long period makes a quota,
+// e.g. 10000 requests a day.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit reads a limit written as requests/period, e.g. "100/1m"
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, errors.New("limit must be requests/period: " + s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Limit{}, errors.New("limit must allow a positive number of requests: " + s)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || per <= 0 {
+		return Limit{}, errors.New("limit must have a positive period: " + s)
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Per.String()
+}
+
+func (l *Limit) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*l, err = ParseLimit(s)
+	return err
+}
+
+// rate is how many tokens are added to the bucket each second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Config is the limits applied to requests. Every caller is held to Default, or to the
+// most generous limit of the scopes in its token, and requests to a route are also held
+// to that route's limit. Every request from an address, with or without valid
+// credentials, is held to Address.
+type Config struct {
+	Default Limit `json:"default"`
+	// Address limits all requests from one address before they are authenticated. It is
+	// ten times Default if not set, since many callers may share an address.
+	Address Limit `json:"address"`
+	// Routes are keyed by method and path pattern, e.g. "POST /v1/plant"
+	Routes map[string]Limit `json:"routes"`
+	Scopes map[string]Limit `json:"scopes"`
+}
+
+// ParseConfig reads a config from json such as
+// {"default": "100/1m", "routes": {"POST /v1/plant": "10/1m"}, "scopes": {"bulk:plants": "1000/1m"}}
+func ParseConfig(data string) (Config, error) {
+	config := Config{}
+	err := json.Unmarshal([]byte(data), &config)
+	if err != nil {
+		return Config{}, err
+	}
+	if config.Default.Requests == 0 {
+		return Config{}, errors.New("rate limits need a default")
+	}
+	return config, nil
+}
+
+// CallerLimit is the limit on all requests by a caller whose token has the given scopes
+func (c Config) CallerLimit(scopes []string) Limit {
+	limit := c.Default
+	for _, scope := range scopes {
+		if scoped, ok := c.Scopes[scope]; ok && scoped.rate() > limit.rate() {
+			limit = scoped
+		}
+	}
+	return limit
+}
+
+// AddressLimit is the limit on all requests from one address
+func (c Config) AddressLimit() Limit {
+	if c.Address.Requests > 0 {
+		return c.Address
+	}
+	return Limit{Requests: c.Default.Requests * 10, Per: c.Default.Per}
+}
+
+// RouteLimit is the limit on requests to a route, if it has one
+func (c Config) RouteLimit(method string, path string) (Limit, bool) {
+	limit, ok := c.Routes[method+" "+path]
+	return limit, ok
+}
+
+// Result is the state of a bucket after a request was counted against it
+type Result struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is how many more requests the bucket allows now
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed
+	RetryAfter time.Duration
+}
+
+// Backend holds buckets and takes a token from them
+type Backend interface {
+	Take(key string, limit Limit, now time.Time, ctx context.Context) (Result, error)
+}
+
+// bucket is the state of a token bucket as of UpdatedAt
+type bucket struct {
+	Tokens    float64   `dynamodbav:"tokens"`
+	UpdatedAt time.Time `dynamodbav:"updated_at"`
+}
+
+// take refills the bucket for the time since it was last updated and takes a token from
+// it if there is one. A bucket which has not been seen starts full.
+func take(b *bucket, limit Limit, now time.Time) (bucket, Result) {
+	tokens := float64(limit.Requests)
+	if b != nil {
+		elapsed := now.Sub(b.UpdatedAt).Seconds()
+		tokens = math.Min(float64(limit.Requests), b.Tokens+math.Max(0, elapsed)*limit.rate())
+	}
+	result := Result{Limit: limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((float64(limit.Requests) - tokens) / limit.rate())
+	return bucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limiter applies a config to callers' requests
+type Limiter struct {
+	config  Config
+	backend Backend
+}
+
+func NewLimiter(config Config, backend Backend) *Limiter {
+	return &Limiter{config: config, backend: backend}
+}
+
+// Take counts a request by the caller to a route against the route's limit and the
+// caller's, returning the result of whichever leaves the fewest requests. A request refused
+// by the route's limit does not count against the caller's.
+func (l *Limiter) Take(caller string, scopes []string, method string, path string, now time.Time, ctx context.Context) (Result, error) {
+	var route *Result
+	if limit, ok := l.config.RouteLimit(method, path); ok {
+		result, err := l.backend.Take(caller+"#"+method+" "+path, limit, now, ctx)
+		if err != nil || !result.Allowed {
+			return result, err
+		}
+		route = &result
+	}
+	result, err := l.backend.Take(caller, l.config.CallerLimit(scopes), now, ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	if route != nil && result.Allowed && route.Remaining < result.Remaining {
+		return *route, nil
+	}
+	return result, nil
+}
+
+// TakeAddress counts a request from an address against the address limit. It is taken
+// before the request is authenticated, so callers guessing credentials are limited too.
+func (l *Limiter) TakeAddress(address string, now time.Time, ctx context.Context) (Result, error) {
+	return l.backend.Take("addr#"+address, l.config.AddressLimit(), now, ctx)
+}
+
+// TakeShared counts a request against a limit shared by many callers, such as every caller
+// of a tenant
+func (l *Limiter) TakeShared(key string, limit Limit, now time.Time, ctx context.Context) (Result, error) {
+	return l.backend.Take(key, limit, now, ctx)
+}
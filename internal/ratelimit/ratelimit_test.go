@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "parse config reads limits", config: `{"default": "100/1m", "routes": {"POST /v1/plant": "10/1h"}, "scopes": {"bulk:plants": "1000/1m"}}`},
+		{name: "parse config needs a default", config: `{"routes": {"POST /v1/plant": "10/1h"}}`, wantErr: true},
+		{name: "parse config rejects a limit without a period", config: `{"default": "100"}`, wantErr: true},
+		{name: "parse config rejects a limit of no requests", config: `{"default": "0/1m"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.Default != (Limit{Requests: 100, Per: time.Minute}) {
+				t.Errorf("ParseConfig() default = %v", config.Default)
+			}
+			if limit, ok := config.RouteLimit("POST", "/v1/plant"); !ok || limit.String() != "10/1h0m0s" {
+				t.Errorf("ParseConfig() route limit = %v", limit)
+			}
+			if limit := config.CallerLimit([]string{"read:audit", "bulk:plants"}); limit.Requests != 1000 {
+				t.Errorf("ParseConfig() scope limit = %v", limit)
+			}
+		})
+	}
+}
+
+func testBackends(t *testing.T, run func(t *testing.T, backend Backend)) {
+	t.Run("memory", func(t *testing.T) { run(t, NewMemoryBackend()) })
+	t.Run("dynamo", func(t *testing.T) {
+		run(t, NewDynamoBackend(&fakeDynamo{items: map[string]map[string]types.AttributeValue{}}))
+	})
+}
+
+func TestBackend_Take(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		limit := Limit{Requests: 2, Per: time.Minute}
+		now := time.Unix(1000, 0)
+		for i, want := range []bool{true, true, false} {
+			result, err := backend.Take("user#test", limit, now, context.TODO())
+			if err != nil || result.Allowed != want {
+				t.Fatalf("Take() %d = %+v, %v, want allowed %v", i, result, err, want)
+			}
+			if !want && (result.RetryAfter != 30*time.Second || result.Remaining != 0 || result.Reset != time.Minute) {
+				t.Errorf("Take() refused = %+v, want retry after 30s", result)
+			}
+		}
+		// half the period refills one token
+		result, _ := backend.Take("user#test", limit, now.Add(30*time.Second), context.TODO())
+		if !result.Allowed || result.Remaining != 0 {
+			t.Errorf("Take() after refill = %+v", result)
+		}
+		// other callers have their own bucket
+		result, _ = backend.Take("user#other", limit, now, context.TODO())
+		if !result.Allowed || result.Remaining != 1 {
+			t.Errorf("Take() for another caller = %+v", result)
+		}
+	})
+}
+
+func TestLimiter_Take(t *testing.T) {
+	config, _ := ParseConfig(`{"default": "3/1m", "routes": {"POST /v1/plant": "1/1m"}}`)
+	limiter := NewLimiter(config, NewMemoryBackend())
+	now := time.Unix(1000, 0)
+	result, _ := limiter.Take("user#test", nil, "POST", "/v1/plant", now, context.TODO())
+	if !result.Allowed || result.Limit.Requests != 1 {
+		t.Errorf("Limiter.Take() = %+v, want the route's limit", result)
+	}
+	result, _ = limiter.Take("user#test", nil, "POST", "/v1/plant", now, context.TODO())
+	if result.Allowed {
+		t.Errorf("Limiter.Take() = %+v, want refused by the route's limit", result)
+	}
+	result, _ = limiter.Take("user#test", nil, "GET", "/v1/plants", now, context.TODO())
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("Limiter.Take() = %+v, want the refused request not counted", result)
+	}
+}
+
+func TestLimiter_TakeAddress(t *testing.T) {
+	config, _ := ParseConfig(`{"default": "3/1m"}`)
+	limiter := NewLimiter(config, NewMemoryBackend())
+	now := time.Unix(1000, 0)
+	result, _ := limiter.TakeAddress("192.0.2.1", now, context.TODO())
+	if !result.Allowed || result.Limit.Requests != 30 || result.Limit.Per != time.Minute {
+		t.Errorf("Limiter.TakeAddress() = %+v, want ten times the default", result)
+	}
+	result, _ = limiter.Take("192.0.2.1", nil, "GET", "/v1/plants", now, context.TODO())
+	if result.Remaining != 2 {
+		t.Errorf("Limiter.Take() = %+v, want the address kept apart from the caller", result)
+	}
+}
+
+func TestDynamoBackend_TakeRetriesConflicts(t *testing.T) {
+	fake := &fakeDynamo{items: map[string]map[string]types.AttributeValue{}, conflicts: 2}
+	backend := NewDynamoBackend(fake)
+	result, err := backend.Take("user#test", Limit{Requests: 2, Per: time.Minute}, time.Unix(1000, 0), context.TODO())
+	if err != nil || !result.Allowed || fake.puts != 3 {
+		t.Errorf("DynamoBackend.Take() = %+v, %v after %d puts", result, err, fake.puts)
+	}
+}
+
+// fakeDynamo keeps items by key, checking only whether a put's condition expects an item,
+// and fails the first conflicts puts as if another replica had written first
+type fakeDynamo struct {
+	items     map[string]map[string]types.AttributeValue
+	conflicts int
+	puts      int
+}
+
+func (f *fakeDynamo) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	key := params.Key["key"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[key]}, nil
+}
+
+func (f *fakeDynamo) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts++
+	if f.conflicts > 0 {
+		f.conflicts--
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	key := params.Item["key"].(*types.AttributeValueMemberS).Value
+	previous, exists := f.items[key]
+	if exists && *params.ConditionExpression == "attribute_not_exists(#key)" {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	if exists && previous["updated_at"].(*types.AttributeValueMemberS).Value != params.ExpressionAttributeValues[":updated_at"].(*types.AttributeValueMemberS).Value {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.items[key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/apikeys"
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	rotateSuffix = ":rotate"
+	// defaultRotationGrace is how long a rotated key keeps working unless told otherwise
+	defaultRotationGrace = 24 * time.Hour
+)
+
+// apiKeyRequest is the body of a request to mint a key
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// HandleListAPIKeys lists the keys of the caller's tenant
+func (s *Server) HandleListAPIKeys(c *gin.Context) {
+	all, err := s.db.ListAPIKeys(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keys := []pkg.APIKey{}
+	for _, key := range all {
+		if key.Tenant == tenant.ID(c) {
+			keys = append(keys, key)
+		}
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey mints a key with some of the caller's own scopes. The response is the
+// only time the key is shown.
+func (s *Server) HandleCreateAPIKey(c *gin.Context) {
+	var request apiKeyRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || len(request.Scopes) == 0 {
+		log.Println("api key request missing name or scopes")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		log.Println("api key request expires in the past")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !hasScope(c, scope) {
+			log.Println("api key request for a scope the caller does not have: " + scope)
+			c.Writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	key, err := s.mintAPIKey(c, pkg.APIKey{Name: request.Name, Scopes: request.Scopes, ExpiresAt: request.ExpiresAt})
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+	s.recordAudit(c, audit.ActionCreate, auditResource("api-key", key.Prefix), nil, storedAPIKey(*key))
+}
+
+// storedAPIKey is the key as it is stored, without the secret shown when it is minted
+func storedAPIKey(key pkg.APIKey) pkg.APIKey {
+	key.Key = ""
+	return key
+}
+
+// mintAPIKey generates a key with the template's name, scopes and expiry and stores it
+func (s *Server) mintAPIKey(c *gin.Context, template pkg.APIKey) (*pkg.APIKey, error) {
+	secret, prefix, hash := apikeys.Mint()
+	key := template
+	key.Prefix = prefix
+	key.Hash = hash
+	key.CreatedBy = middleware.GetSubject(c.Request)
+	key.CreatedAt = time.Now().UTC()
+	key.Tenant = tenant.ID(c)
+	key.RevokedAt = nil
+	key.ReplacedBy = ""
+	err := s.db.CreateAPIKey(key, c)
+	if err != nil {
+		return nil, err
+	}
+	key.Key = secret
+	return &key, nil
+}
+
+// getAPIKey reads a key of the caller's tenant. The api keys table is shared by every
+// tenant, so keys of other tenants are not found.
+func (s *Server) getAPIKey(c *gin.Context, prefix string) (*pkg.APIKey, error) {
+	key, err := s.db.GetAPIKey(prefix, c)
+	if err != nil {
+		return nil, err
+	}
+	if key.Tenant != tenant.ID(c) {
+		return nil, errors.New(db.ErrNotFound)
+	}
+	return key, nil
+}
+
+func (s *Server) HandleGetAPIKey(c *gin.Context) {
+	key, err := s.getAPIKey(c, c.Param("prefix"))
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// HandleRevokeAPIKey stops a key from working. The key is kept so that what it did can
+// still be traced.
+func (s *Server) HandleRevokeAPIKey(c *gin.Context) {
+	key, err := s.getAPIKey(c, c.Param("prefix"))
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if key.RevokedAt == nil {
+		before := *key
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		err = s.db.UpdateAPIKey(*key, c)
+		if err != nil {
+			writeDBError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, key)
+		s.recordAudit(c, audit.ActionRevoke, auditResource("api-key", key.Prefix), before, key)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// HandleAPIKeyAction handles custom methods on a key, e.g. POST /v1/admin/api-keys/{prefix}:rotate,
+// which mints a replacement with the same name and scopes. The old key keeps working for
+// the grace period given as a duration in ?grace=, a day by default, so callers can
+// switch over without downtime.
+func (s *Server) HandleAPIKeyAction(c *gin.Context) {
+	prefix, found := strings.CutSuffix(c.Param("prefix"), rotateSuffix)
+	if !found {
+		log.Println("unknown api key action " + c.Param("prefix"))
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	grace := defaultRotationGrace
+	if value := c.Query("grace"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Println("invalid rotation grace period " + value)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		grace = parsed
+	}
+	old, err := s.getAPIKey(c, prefix)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	now := time.Now().UTC()
+	if !old.Active(now) {
+		log.Println("cannot rotate an inactive api key " + prefix)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	// the replacement lasts as long as the key it replaces did
+	template := *old
+	if old.ExpiresAt != nil {
+		expiresAt := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		template.ExpiresAt = &expiresAt
+	}
+	key, err := s.mintAPIKey(c, template)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	before := *old
+	retireAt := now.Add(grace)
+	if old.ExpiresAt == nil || retireAt.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &retireAt
+	}
+	old.ReplacedBy = key.Prefix
+	err = s.db.UpdateAPIKey(*old, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+	s.recordAudit(c, audit.ActionRotate, auditResource("api-key", old.Prefix), before, old)
+	s.recordAudit(c, audit.ActionCreate, auditResource("api-key", key.Prefix), nil, storedAPIKey(*key))
+}
+
+// hasScope reports whether the request's token or key has the scope
+func hasScope(c *gin.Context, scope string) bool {
+	claims, ok := middleware.GetClaims(c.Request)
+	if !ok {
+		return false
+	}
+	custom, ok := claims.CustomClaims.(*middleware.CustomClaims)
+	return ok && custom.HasScope(scope)
+}
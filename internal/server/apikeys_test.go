@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleCreateAPIKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		scope string
+		code  int
+	}{
+		{
+			name:  "handle create api key fails without a name",
+			body:  `{"scopes": ["read:audit"]}`,
+			scope: "manage:api_keys read:audit",
+			code:  400,
+		},
+		{
+			name:  "handle create api key fails if it expires in the past",
+			body:  `{"name": "sensor", "scopes": ["read:audit"], "expires_at": "2000-01-01T00:00:00Z"}`,
+			scope: "manage:api_keys read:audit",
+			code:  400,
+		},
+		{
+			name:  "handle create api key fails for scopes the caller does not have",
+			body:  `{"name": "sensor", "scopes": ["purge:plants"]}`,
+			scope: "manage:api_keys read:audit",
+			code:  403,
+		},
+		{
+			name:  "handle create api key mints the key",
+			body:  `{"name": "sensor", "scopes": ["read:audit"]}`,
+			scope: "manage:api_keys read:audit",
+			code:  200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/admin/api-keys", bytes.NewBufferString(tt.body)), "admin", tt.scope)
+			mockDB := new(db.MockDB)
+			mockDB.On("CreateAPIKey", mock.MatchedBy(func(key pkg.APIKey) bool {
+				return key.Name == "sensor" && key.Prefix != "" && strings.HasPrefix(key.Hash, "$argon2id$") && key.Key == "" && key.CreatedBy == "admin"
+			}), mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleCreateAPIKey(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreateAPIKey response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code == 200 && (!strings.Contains(w.Body.String(), `"key":"plk_`) || strings.Contains(w.Body.String(), "argon2id")) {
+				t.Errorf("HandleCreateAPIKey body %s, want the key and not its hash", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_HandleAPIKeyAction(t *testing.T) {
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour)
+	expires := now.Add(2 * time.Hour)
+	tests := []struct {
+		name   string
+		param  string
+		query  string
+		stored *pkg.APIKey
+		err    error
+		code   int
+		// wantRetire is how long the old key should keep working
+		wantRetire time.Duration
+	}{
+		{
+			name:  "handle api key action fails if action is unknown",
+			param: "abc:renew",
+			code:  404,
+		},
+		{
+			name:  "handle api key action fails if the grace period is invalid",
+			param: "abc:rotate",
+			query: "?grace=soon",
+			code:  400,
+		},
+		{
+			name:  "handle api key action fails if key is not found",
+			param: "abc:rotate",
+			err:   errors.New(db.ErrNotFound),
+			code:  404,
+		},
+		{
+			name:   "handle api key action fails if key is revoked",
+			param:  "abc:rotate",
+			stored: &pkg.APIKey{Prefix: "abc", Hash: "hash", RevokedAt: &past},
+			code:   409,
+		},
+		{
+			name:       "handle api key action rotates the key with a day's grace",
+			param:      "abc:rotate",
+			stored:     &pkg.APIKey{Prefix: "abc", Hash: "hash", Name: "sensor", Scopes: []string{"read:audit"}},
+			code:       200,
+			wantRetire: 24 * time.Hour,
+		},
+		{
+			name:       "handle api key action keeps an earlier expiry",
+			param:      "abc:rotate",
+			query:      "?grace=72h",
+			stored:     &pkg.APIKey{Prefix: "abc", Hash: "hash", Name: "sensor", Scopes: []string{"read:audit"}, CreatedAt: now.Add(-time.Hour), ExpiresAt: &expires},
+			code:       200,
+			wantRetire: 2 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/admin/api-keys/"+tt.param+tt.query, nil), "admin", "manage:api_keys")
+			c.Params = append(c.Params, gin.Param{Key: "prefix", Value: tt.param})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetAPIKey", "abc", mock.Anything).Return(tt.stored, tt.err)
+			mockDB.On("CreateAPIKey", mock.MatchedBy(func(key pkg.APIKey) bool {
+				return key.Name == "sensor" && key.Prefix != "abc" && len(key.Scopes) == 1
+			}), mock.Anything).Return(nil)
+			mockDB.On("UpdateAPIKey", mock.Anything, mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleAPIKeyAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandleAPIKeyAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			old := mockDB.Calls[len(mockDB.Calls)-1].Arguments.Get(0).(pkg.APIKey)
+			if old.ReplacedBy == "" || old.ExpiresAt == nil || old.ExpiresAt.Sub(now).Round(time.Minute) != tt.wantRetire {
+				t.Errorf("HandleAPIKeyAction retired the old key %+v, want it to work for %s", old, tt.wantRetire)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRevokeAPIKey(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/v1/admin/api-keys/abc", nil)
+	c.Params = append(c.Params, gin.Param{Key: "prefix", Value: "abc"})
+	mockDB := new(db.MockDB)
+	mockDB.On("GetAPIKey", "abc", mock.Anything).Return(&pkg.APIKey{Prefix: "abc", Hash: "hash"}, nil)
+	mockDB.On("UpdateAPIKey", mock.MatchedBy(func(key pkg.APIKey) bool { return key.RevokedAt != nil }), mock.Anything).Return(nil)
+	s := &Server{db: mockDB}
+	s.HandleRevokeAPIKey(c)
+	if c.Writer.Status() != 200 {
+		t.Errorf("HandleRevokeAPIKey response code: %d, expected 200", c.Writer.Status())
+	}
+	mockDB.AssertExpectations(t)
+}
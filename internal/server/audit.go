@@ -0,0 +1,120 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/gin-gonic/gin"
+)
+
+const claimsKey = "claims"
+
+// captureClaims keeps the validated claims on the gin context. The adapter restores the
+// original request once the chain returns, so middleware which runs before
+// EnsureValidToken can only see the claims through the context keys.
+func captureClaims(c *gin.Context) {
+	claims, ok := middleware.GetClaims(c.Request)
+	if ok {
+		c.Set(claimsKey, claims)
+	}
+	c.Next()
+}
+
+// auditAuthorizationFailures records every request rejected by the auth middleware
+func (s *Server) auditAuthorizationFailures(c *gin.Context) {
+	c.Next()
+	if c.Writer.Status() == http.StatusUnauthorized || c.Writer.Status() == http.StatusForbidden {
+		s.recordAudit(c, audit.ActionAuthorizationFailure, c.Request.Method+" "+c.Request.URL.Path, nil, nil)
+	}
+}
+
+// recordAudit appends an entry describing the request to the audit log. Failing to
+// write the entry is logged rather than failing a request which has already been served.
+func (s *Server) recordAudit(c *gin.Context, action string, resource string, before interface{}, after interface{}) {
+	if s.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Action:     action,
+		Resource:   resource,
+		Status:     c.Writer.Status(),
+		SourceIP:   c.ClientIP(),
+		RequestID:  middleware.GetRequestID(c.Request),
+		Tenant:     c.GetString(tenantKey),
+		BeforeHash: audit.HashState(before),
+		AfterHash:  audit.HashState(after),
+	}
+	claims, ok := requestClaims(c)
+	if ok {
+		entry.Subject = claims.RegisteredClaims.Subject
+		customClaims, ok := claims.CustomClaims.(*middleware.CustomClaims)
+		if ok {
+			entry.ClientID = customClaims.ClientID
+			entry.Scopes = customClaims.Scope
+		}
+	}
+	_, err := s.audit.Record(entry, c)
+	if err != nil {
+		log.Printf("failed to write audit entry for request %s: %v", entry.RequestID, err)
+	}
+}
+
+// auditResource names a resource other than a plant in the audit log by its kind and
+// ids, e.g. api-key/abc123, so it cannot be mistaken for a plant's name
+func auditResource(kind string, ids ...string) string {
+	return kind + "/" + strings.Join(ids, "/")
+}
+
+// auditBefore returns the current state of a plant so a write can be audited. The
+// lookup is skipped when auditing is disabled.
+func (s *Server) auditBefore(c *gin.Context, name string) *pkg.Plant {
+	if s.audit == nil {
+		return nil
+	}
+	plant, err := s.db.GetPlant(name, c)
+	if err != nil {
+		return nil
+	}
+	return plant
+}
+
+// requestClaims returns the validated claims of the request, falling back to the
+// claims kept by captureClaims once the adapter has restored the original request
+func requestClaims(c *gin.Context) (*validator.ValidatedClaims, bool) {
+	claims, ok := middleware.GetClaims(c.Request)
+	if ok {
+		return claims, true
+	}
+	value, _ := c.Get(claimsKey)
+	claims, ok = value.(*validator.ValidatedClaims)
+	return claims, ok
+}
+
+// HandleListAudit returns audit entries after the sequence given by the after query parameter
+func (s *Server) HandleListAudit(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		log.Println("invalid audit limit " + c.Query("limit"))
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	entries, err := s.audit.List(after, limit, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_recordAudit(t *testing.T) {
+	sink := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	plant := &pkg.Plant{Name: "test", Description: "test"}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/v1/plant/test", nil)
+	c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"})
+	mockDB := new(db.MockDB)
+	mockDB.On("DeletePlant", "test", "", c).Return(plant, nil)
+	s := &Server{db: mockDB, audit: audit.NewLog(sink)}
+	s.HandleDeletePlant(c)
+
+	entries, err := sink.List(0, 0, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("HandleDeletePlant wrote %d audit entries, want 1", len(entries))
+	}
+	if entries[0].Action != audit.ActionDelete || entries[0].BeforeHash != audit.HashState(plant) || entries[0].AfterHash != "" {
+		t.Errorf("HandleDeletePlant wrote audit entry %v", entries[0])
+	}
+}
+
+func TestServer_auditAuthorizationFailures(t *testing.T) {
+	sink := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	s := &Server{audit: audit.NewLog(sink)}
+	r := gin.New()
+	r.Use(s.auditAuthorizationFailures)
+	r.GET("/unauthorized", func(c *gin.Context) { c.AbortWithStatus(http.StatusUnauthorized) })
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unauthorized", nil))
+
+	entries, err := sink.List(0, 0, context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != audit.ActionAuthorizationFailure || entries[0].Resource != "GET /unauthorized" {
+		t.Errorf("auditAuthorizationFailures wrote %v, want a single authorization failure", entries)
+	}
+}
+
+func TestServer_recordAudit_Resources(t *testing.T) {
+	revokedKey := &pkg.APIKey{Prefix: "abc123", Name: "sensor", Scopes: []string{"read:audit"}}
+	role := &pkg.Role{Name: "gardener", Grants: []pkg.Grant{{Permission: "write:plants"}}}
+	subscription := &pkg.Subscription{ID: "search", URL: "https://example.com/hook", Secret: "secret"}
+	journalEntry := &pkg.JournalEntry{UserID: "alice", CollectionEntryID: "1", ID: "2"}
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		params   gin.Params
+		mock     func(mockDB *db.MockDB, c *gin.Context)
+		handle   func(s *Server) gin.HandlerFunc
+		action   string
+		resource string
+		before   interface{}
+	}{
+		{
+			name:   "revoking an api key is audited",
+			method: "DELETE",
+			params: gin.Params{{Key: "prefix", Value: "abc123"}},
+			mock: func(mockDB *db.MockDB, c *gin.Context) {
+				mockDB.On("GetAPIKey", "abc123", c).Return(revokedKey, nil)
+				mockDB.On("UpdateAPIKey", mock.Anything, c).Return(nil)
+			},
+			handle:   func(s *Server) gin.HandlerFunc { return s.HandleRevokeAPIKey },
+			action:   audit.ActionRevoke,
+			resource: "api-key/abc123",
+			before:   *revokedKey,
+		},
+		{
+			name:   "replacing a role is audited with the role it replaced",
+			method: "PUT",
+			body:   `{"grants":[{"permission":"read:plants"}]}`,
+			params: gin.Params{{Key: "role", Value: "gardener"}},
+			mock: func(mockDB *db.MockDB, c *gin.Context) {
+				mockDB.On("GetRole", "gardener", c).Return(role, nil)
+				mockDB.On("PutRole", mock.Anything, c).Return(nil)
+			},
+			handle:   func(s *Server) gin.HandlerFunc { return s.HandlePutRole },
+			action:   audit.ActionUpdate,
+			resource: "role/gardener",
+			before:   role,
+		},
+		{
+			name:   "deleting a webhook subscription is audited",
+			method: "DELETE",
+			params: gin.Params{{Key: "id", Value: "search"}},
+			mock: func(mockDB *db.MockDB, c *gin.Context) {
+				deleted := *subscription
+				mockDB.On("DeleteSubscription", "search", c).Return(&deleted, nil)
+			},
+			handle:   func(s *Server) gin.HandlerFunc { return s.HandleDeleteSubscription },
+			action:   audit.ActionDelete,
+			resource: "webhook/search",
+			before:   *subscription,
+		},
+		{
+			name:   "deleting a journal entry is audited",
+			method: "DELETE",
+			params: gin.Params{{Key: "id", Value: "1"}, {Key: "entry", Value: "2"}},
+			mock: func(mockDB *db.MockDB, c *gin.Context) {
+				mockDB.On("DeleteJournalEntry", "alice", "1", "2", c).Return(journalEntry, nil)
+			},
+			handle:   func(s *Server) gin.HandlerFunc { return s.HandleDeleteJournalEntry },
+			action:   audit.ActionDelete,
+			resource: "journal/alice/2",
+			before:   journalEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)), "alice", "")
+			c.Params = tt.params
+			mockDB := new(db.MockDB)
+			tt.mock(mockDB, c)
+			tt.handle(&Server{db: mockDB, audit: audit.NewLog(sink)})(c)
+
+			entries, err := sink.List(0, 0, context.TODO())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("wrote %d audit entries, want 1", len(entries))
+			}
+			entry := entries[0]
+			if entry.Action != tt.action || entry.Resource != tt.resource || entry.Subject != "alice" || entry.BeforeHash != audit.HashState(tt.before) {
+				t.Errorf("wrote audit entry %+v", entry)
+			}
+			if tt.action != audit.ActionDelete && (entry.AfterHash == "" || entry.AfterHash == entry.BeforeHash) {
+				t.Errorf("wrote audit entry %+v without the state after the write", entry)
+			}
+		})
+	}
+}
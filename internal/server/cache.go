@@ -0,0 +1,21 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetCacheStats returns the plant cache's hit and miss counts, or not found if
+// plants are not cached
+func (s *Server) HandleGetCacheStats(c *gin.Context) {
+	cache, ok := s.db.(*db.CachedDB)
+	if !ok {
+		log.Println("plant cache is disabled")
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, cache.Stats())
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+func TestServer_HandleGetCacheStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		database db.DBInterface
+		code     int
+	}{
+		{
+			name:     "handle get cache stats fails if plants are not cached",
+			database: new(db.MockDB),
+			code:     404,
+		},
+		{
+			name:     "handle get cache stats returns the cache's stats",
+			database: db.NewCachedDB(new(db.MockDB), db.CacheOptions{}),
+			code:     200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = &http.Request{
+				Header: make(http.Header),
+			}
+			c.Request.Method = "GET"
+			s := &Server{db: tt.database}
+			s.HandleGetCacheStats(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleGetCacheStats response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/calendar"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	calendarFeedPath = "/v1/me/calendar.ics"
+	// defaultReminderHour is the local hour care events start at, unless the feed asks for another
+	defaultReminderHour = 9
+	calendarEventLength = 15 * time.Minute
+	// calendarTenantSeparator follows the tenant at the start of the feed tokens of tenants
+	// other than the default
+	calendarTenantSeparator = "."
+)
+
+// hashCalendarToken returns the hash a calendar token is stored under, so a leaked table
+// does not leak working feed urls
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleCreateCalendarToken issues a new calendar feed token for the caller, revoking
+// the previous one, and returns the feed url. Calendar apps cannot send a JWT, so the
+// token in the url is what protects the feed.
+func (s *Server) HandleCreateCalendarToken(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	// the feed is read without a token saying which tenant the caller belongs to, so the
+	// tenant is kept in the feed token
+	if id := tenant.ID(c); id != tenant.Default {
+		token = id + calendarTenantSeparator + token
+	}
+	err = s.db.SetCalendarToken(userID, hashCalendarToken(token), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "url": calendarFeedPath + "?token=" + url.QueryEscape(token)})
+}
+
+// HandleDeleteCalendarToken revokes the caller's calendar feed token
+func (s *Server) HandleDeleteCalendarToken(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	err := s.db.DeleteCalendarToken(userID, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetCalendar serves the user's care tasks as an iCalendar feed. The feed is
+// authorized by the token query parameter instead of a JWT, and takes the same tz and
+// hemisphere parameters as the task list along with the hour events start at.
+func (s *Server) HandleGetCalendar(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		log.Println("calendar request missing token")
+		c.Writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if id, _, found := strings.Cut(token, calendarTenantSeparator); found && s.tenancy != nil {
+		if !s.actAsTenant(c, id) {
+			return
+		}
+		if !featureEnabled(c, pkg.FeatureCalendar) {
+			log.Println("calendar is turned off for tenant " + id)
+			c.Writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	userID, err := s.db.GetCalendarUser(hashCalendarToken(token), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	options, ok := scheduleOptions(c)
+	if !ok {
+		return
+	}
+	hour := defaultReminderHour
+	if c.Query("hour") != "" {
+		hour, err = strconv.Atoi(c.Query("hour"))
+		if err != nil || hour < 0 || hour > 23 {
+			log.Println("invalid calendar hour " + c.Query("hour"))
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	tasks, err := s.userTasks(c, userID, options)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	events := make([]calendar.Event, 0, len(tasks))
+	for _, task := range tasks {
+		events = append(events, taskEvent(task, hour, c.Request.Host))
+	}
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Cache-Control", "private, max-age=900")
+	c.Status(http.StatusOK)
+	err = calendar.Render(c.Writer, "Plant care", options.Location, events, options.Now)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// taskEvent renders a care task as an event repeating at the task's current interval.
+// The uid is built from the task id so that completing or snoozing a task moves its
+// event rather than adding another.
+func taskEvent(task pkg.CareTask, hour int, host string) calendar.Event {
+	name := task.PlantName
+	if task.Nickname != "" {
+		name = task.Nickname
+	}
+	due := task.DueAt
+	start := time.Date(due.Year(), due.Month(), due.Day(), hour, 0, 0, 0, due.Location())
+	return calendar.Event{
+		UID:          task.ID + "@" + host,
+		Summary:      task.Summary(),
+		Description:  fmt.Sprintf("%s (%s) needs care every %d days. Task %s.", name, task.PlantName, task.IntervalDays, task.ID),
+		Start:        start,
+		Duration:     calendarEventLength,
+		IntervalDays: task.IntervalDays,
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+func TestServer_HandleGetCalendar(t *testing.T) {
+	acquired := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		want  []string
+	}{
+		{
+			name:  "handle get calendar fails without a token",
+			query: "tz=America/New_York",
+			code:  401,
+		},
+		{
+			name:  "handle get calendar fails if the token is revoked",
+			query: "token=old&tz=America/New_York",
+			code:  401,
+		},
+		{
+			name:  "handle get calendar fails if the hour is invalid",
+			query: "token=secret&tz=America/New_York&hour=24",
+			code:  400,
+		},
+		{
+			name:  "handle get calendar renders the user's tasks",
+			query: "token=secret&tz=America/New_York&hour=8",
+			code:  200,
+			want: []string{
+				"TZID:America/New_York\r\n",
+				"UID:1.water@example.com\r\n",
+				"SUMMARY:Water Monty\r\n",
+				"RRULE:FREQ=DAILY;INTERVAL=",
+				"T080000\r\n",
+				"BEGIN:VALARM\r\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "http://example.com/v1/me/calendar.ics?"+tt.query, nil)
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCalendarUser", hashCalendarToken("secret"), c).Return("user", nil)
+			mockDB.On("GetCalendarUser", hashCalendarToken("old"), c).Return("", errors.New(db.ErrNotFound))
+			mockDB.On("GetCollection", "user", c).Return([]pkg.CollectionEntry{
+				{UserID: "user", ID: "1", PlantName: "monstera", Nickname: "Monty", AcquiredOn: acquired},
+			}, nil)
+			mockDB.On("GetTaskStates", "user", c).Return(map[string]pkg.TaskState{}, nil)
+			mockDB.On("GetPlant", "monstera", c).Return(&pkg.Plant{Name: "monstera", Care: &pkg.CareProfile{WaterEveryDays: 7}}, nil)
+			s := &Server{db: mockDB}
+			s.HandleGetCalendar(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandleGetCalendar response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("HandleGetCalendar response missing %q", want)
+				}
+			}
+		})
+	}
+}
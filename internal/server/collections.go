@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// collectionUser returns the subject owning the collection being accessed. Requests
+// without a subject are rejected, since an empty subject would be shared by every caller.
+func collectionUser(c *gin.Context) (string, bool) {
+	userID := middleware.GetSubject(c.Request)
+	if userID == "" {
+		log.Println("collection request without a subject")
+		c.Writer.WriteHeader(http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// decodeCollectionEntry reads and validates an entry from the request body
+func decodeCollectionEntry(c *gin.Context) (*pkg.CollectionEntry, bool) {
+	var entry pkg.CollectionEntry
+	err := json.NewDecoder(c.Request.Body).Decode(&entry)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if entry.PlantName == "" {
+		log.Println("collection entry missing plant name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if entry.AcquiredOn != "" {
+		_, err = time.Parse(time.DateOnly, entry.AcquiredOn)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if entry.PotDiameterCm < 0 {
+		log.Println("collection entry has a negative pot size")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return &entry, true
+}
+
+// catalogPlantExists responds with bad request if the entry refers to a plant which is
+// not in the catalog
+func (s *Server) catalogPlantExists(c *gin.Context, name string) bool {
+	_, err := s.db.GetPlant(name, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Server) HandleGetCollection(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entries, err := s.db.GetCollection(userID, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func (s *Server) HandleCreateCollectionEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, ok := decodeCollectionEntry(c)
+	if !ok || !s.catalogPlantExists(c, entry.PlantName) {
+		return
+	}
+	entry.UserID = userID
+	entry.ID = newID()
+	entry.CreatedAt = time.Now().UTC()
+	entry.UpdatedAt = entry.CreatedAt
+	err := s.db.CreateCollectionEntry(*entry, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+	s.recordAudit(c, audit.ActionCreate, auditResource("collection", userID, entry.ID), nil, entry)
+}
+
+func (s *Server) HandleGetCollectionEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.GetCollectionEntry(userID, c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+func (s *Server) HandleUpdateCollectionEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	existing, err := s.db.GetCollectionEntry(userID, c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	entry, ok := decodeCollectionEntry(c)
+	if !ok {
+		return
+	}
+	if entry.PlantName != existing.PlantName && !s.catalogPlantExists(c, entry.PlantName) {
+		return
+	}
+	entry.UserID = userID
+	entry.ID = existing.ID
+	entry.CreatedAt = existing.CreatedAt
+	entry.UpdatedAt = time.Now().UTC()
+	err = s.db.UpdateCollectionEntry(*entry, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+	s.recordAudit(c, audit.ActionUpdate, auditResource("collection", userID, entry.ID), existing, entry)
+}
+
+func (s *Server) HandleDeleteCollectionEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.DeleteCollectionEntry(userID, c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+	s.recordAudit(c, audit.ActionDelete, auditResource("collection", userID, entry.ID), entry, nil)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+// withSubject attaches validated claims for the subject to the request, as EnsureValidToken would
+func withSubject(r *http.Request, subject string, scope string) *http.Request {
+	claims := &validator.ValidatedClaims{
+		RegisteredClaims: validator.RegisteredClaims{Subject: subject},
+		CustomClaims:     &middleware.CustomClaims{Scope: scope},
+	}
+	return r.WithContext(context.WithValue(r.Context(), jwtmiddleware.ContextKey{}, claims))
+}
+
+func TestServer_HandleCreateCollectionEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		entry    pkg.CollectionEntry
+		plantErr error
+		code     int
+	}{
+		{
+			name:    "handle create collection entry fails without a subject",
+			subject: "",
+			entry:   pkg.CollectionEntry{PlantName: "test"},
+			code:    401,
+		},
+		{
+			name:    "handle create collection entry fails if acquisition date is invalid",
+			subject: "user",
+			entry:   pkg.CollectionEntry{PlantName: "test", AcquiredOn: "yesterday"},
+			code:    400,
+		},
+		{
+			name:     "handle create collection entry fails if plant is not in the catalog",
+			subject:  "user",
+			entry:    pkg.CollectionEntry{PlantName: "test"},
+			plantErr: errors.New(db.ErrNotFound),
+			code:     400,
+		},
+		{
+			name:    "handle create collection entry stores the entry for the subject",
+			subject: "user",
+			entry:   pkg.CollectionEntry{PlantName: "test", Nickname: "Fern", AcquiredOn: "2024-05-01", PotDiameterCm: 12},
+			code:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			body, err := json.Marshal(tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Request = withSubject(httptest.NewRequest("POST", "/v1/me/plants", bytes.NewReader(body)), tt.subject, "")
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "test", c).Return(&pkg.Plant{Name: "test"}, tt.plantErr)
+			mockDB.On("CreateCollectionEntry", mock.MatchedBy(func(entry pkg.CollectionEntry) bool {
+				return entry.UserID == "user" && entry.ID != "" && entry.Nickname == tt.entry.Nickname
+			}), c).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleCreateCollectionEntry(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreateCollectionEntry response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
+
+func TestServer_HandleUpdateCollectionEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *pkg.CollectionEntry
+		err      error
+		code     int
+	}{
+		{
+			name:     "handle update collection entry fails if the entry belongs to another user",
+			existing: nil,
+			err:      errors.New(db.ErrNotFound),
+			code:     404,
+		},
+		{
+			name:     "handle update collection entry keeps the id and creation time",
+			existing: &pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "test"},
+			code:     200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			body, err := json.Marshal(pkg.CollectionEntry{ID: "other", PlantName: "test", Location: "kitchen"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Request = withSubject(httptest.NewRequest("PUT", "/v1/me/plants/1", bytes.NewReader(body)), "user", "")
+			c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCollectionEntry", "user", "1", c).Return(tt.existing, tt.err)
+			mockDB.On("UpdateCollectionEntry", mock.MatchedBy(func(entry pkg.CollectionEntry) bool {
+				return entry.UserID == "user" && entry.ID == "1" && entry.Location == "kitchen"
+			}), c).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleUpdateCollectionEntry(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleUpdateCollectionEntry response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
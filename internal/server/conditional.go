@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// CachePolicy is the Cache-Control and Vary sent with cacheable reads
+type CachePolicy struct {
+	CacheControl string
+	Vary         string
+}
+
+// CachePolicies decide how responses may be cached, depending on whether the request was
+// authenticated with a bearer token or an api key
+type CachePolicies struct {
+	Authenticated CachePolicy
+	Public        CachePolicy
+}
+
+// ResolveCachePolicies reads CACHE_CONTROL_AUTHENTICATED, CACHE_VARY_AUTHENTICATED,
+// CACHE_CONTROL_PUBLIC and CACHE_VARY_PUBLIC. By default only the browser keeps
+// authenticated responses and must revalidate them, while public responses may be shared
+// by a CDN for a minute. Both vary by the headers credentials are sent in.
+func ResolveCachePolicies() CachePolicies {
+	return CachePolicies{
+		Authenticated: CachePolicy{
+			CacheControl: envOr("CACHE_CONTROL_AUTHENTICATED", "private, no-cache"),
+			Vary:         envOr("CACHE_VARY_AUTHENTICATED", "Authorization, "+middleware.APIKeyHeader),
+		},
+		Public: CachePolicy{
+			CacheControl: envOr("CACHE_CONTROL_PUBLIC", "public, max-age=60"),
+			Vary:         envOr("CACHE_VARY_PUBLIC", "Authorization, "+middleware.APIKeyHeader),
+		},
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// policy returns the cache policy for the request
+func (p CachePolicies) policy(r *http.Request) CachePolicy {
+	if r.Header.Get("Authorization") != "" || r.Header.Get(middleware.APIKeyHeader) != "" {
+		return p.Authenticated
+	}
+	return p.Public
+}
+
+// writeCacheable responds with body as json, or with not modified if the client's copy
+// is current. The ETag is hashed from the body unless one is given, and modified, if it
+// is not zero, is sent as Last-Modified.
+func (s *Server) writeCacheable(c *gin.Context, etag string, modified time.Time, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if etag == "" {
+		etag = contentETag(data)
+	}
+	s.writeValidators(c, etag, modified)
+	if notModified(c.Request, etag, modified) {
+		c.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// writeValidators sets the ETag, Last-Modified and cache policy headers of a response
+func (s *Server) writeValidators(c *gin.Context, etag string, modified time.Time) {
+	header := c.Writer.Header()
+	header.Set("ETag", etag)
+	if !modified.IsZero() {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	policy := s.cachePolicies.policy(c.Request)
+	if policy.CacheControl != "" {
+		header.Set("Cache-Control", policy.CacheControl)
+	}
+	if policy.Vary != "" {
+		header.Set("Vary", policy.Vary)
+	}
+	// a gateway's tenant header chooses whose catalog is read, so shared caches must keep
+	// each tenant's responses apart
+	if s.tenancy != nil && s.tenancy.Header != "" {
+		header.Add("Vary", s.tenancy.Header)
+	}
+	// localized responses differ by the languages the client accepts
+	if header.Get("Content-Language") != "" {
+		header.Add("Vary", "Accept-Language")
+	}
+}
+
+// contentETag is a strong ETag for a response body
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// versionETag is a strong ETag for a response which only changes with the catalog version
+func versionETag(prefix string, version int64) string {
+	return `"` + prefix + "-" + strconv.FormatInt(version, 10) + `"`
+}
+
+// notModified reports whether the client's copy is current. If-Modified-Since is only
+// checked when there is no If-None-Match, as RFC 9110 requires.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 9, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name: "not modified is false without conditions",
+			want: false,
+		},
+		{
+			name:    "not modified is true if an etag matches",
+			headers: map[string]string{"If-None-Match": `"other", "abc"`},
+			want:    true,
+		},
+		{
+			name:    "not modified matches weak etags",
+			headers: map[string]string{"If-None-Match": `W/"abc"`},
+			want:    true,
+		},
+		{
+			name:    "not modified is false if no etag matches",
+			headers: map[string]string{"If-None-Match": `"other"`},
+			want:    false,
+		},
+		{
+			name:    "not modified is true if unchanged since the date",
+			headers: map[string]string{"If-Modified-Since": "Wed, 01 May 2024 09:00:00 GMT"},
+			want:    true,
+		},
+		{
+			name:    "not modified is false if changed since the date",
+			headers: map[string]string{"If-Modified-Since": "Wed, 01 May 2024 08:59:59 GMT"},
+			want:    false,
+		},
+		{
+			name:    "not modified ignores the date if there is an etag",
+			headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Wed, 01 May 2024 09:00:00 GMT"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := notModified(r, `"abc"`, modified); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetPlantConditional(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	plant := &pkg.Plant{Name: "test", Description: "test", UpdatedAt: &updatedAt}
+	s := &Server{cachePolicies: ResolveCachePolicies()}
+	mockDB := new(db.MockDB)
+	mockDB.On("GetPlant", "test", mock.Anything).Return(plant, nil)
+	s.db = mockDB
+
+	get := func(header string, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v1/plant/test", nil)
+		c.Request.Header.Set("Authorization", "Bearer token")
+		if header != "" {
+			c.Request.Header.Set(header, value)
+		}
+		c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"})
+		s.HandleGetPlant(c)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+	first := get("", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || etag == "" || first.Header().Get("Last-Modified") != "Wed, 01 May 2024 09:00:00 GMT" {
+		t.Fatalf("HandleGetPlant response %d with headers %v", first.Code, first.Header())
+	}
+	if first.Header().Get("Cache-Control") != "private, no-cache" || first.Header().Get("Vary") != "Authorization, X-API-Key" {
+		t.Errorf("HandleGetPlant cache headers %v", first.Header())
+	}
+	if w := get("If-None-Match", etag); w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("HandleGetPlant with matching etag response code: %d, expected 304", w.Code)
+	}
+	if w := get("If-Modified-Since", "Wed, 01 May 2024 09:00:00 GMT"); w.Code != 304 {
+		t.Errorf("HandleGetPlant unmodified since response code: %d, expected 304", w.Code)
+	}
+	if w := get("If-None-Match", `"stale"`); w.Code != 200 {
+		t.Errorf("HandleGetPlant with stale etag response code: %d, expected 200", w.Code)
+	}
+}
+
+func TestServer_HandleListPlants(t *testing.T) {
+	version := &pkg.CatalogVersion{Version: 7, ChangedAt: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		code        int
+		listed      bool
+	}{
+		{
+			name:   "handle list plants lists the catalog",
+			code:   200,
+			listed: true,
+		},
+		{
+			name:        "handle list plants lists the catalog if it has changed",
+			ifNoneMatch: `"catalog-6"`,
+			code:        200,
+			listed:      true,
+		},
+		{
+			name:        "handle list plants is not modified if the catalog has not changed",
+			ifNoneMatch: `"catalog-7"`,
+			code:        304,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			if tt.ifNoneMatch != "" {
+				c.Request.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCatalogVersion", mock.Anything).Return(version, nil)
+			mockDB.On("ListPlants", mock.Anything).Return([]pkg.Plant{{Name: "test", Description: "test"}}, nil)
+			s := &Server{db: mockDB, cachePolicies: ResolveCachePolicies()}
+			s.HandleListPlants(c)
+			c.Writer.WriteHeaderNow()
+			if w.Code != tt.code {
+				t.Errorf("HandleListPlants response code: %d, expected %d", w.Code, tt.code)
+			}
+			if w.Header().Get("ETag") != `"catalog-7"` || w.Header().Get("Cache-Control") != "public, max-age=60" {
+				t.Errorf("HandleListPlants headers %v", w.Header())
+			}
+			if listed := len(mockDB.Calls) == 2; listed != tt.listed {
+				t.Errorf("HandleListPlants listed the catalog %v, expected %v", listed, tt.listed)
+			}
+		})
+	}
+}
+
+func TestCachePolicies_Policy(t *testing.T) {
+	policies := ResolveCachePolicies()
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    CachePolicy
+	}{
+		{
+			name: "requests without credentials get the public policy",
+			want: policies.Public,
+		},
+		{
+			name:    "requests with a bearer token get the authenticated policy",
+			headers: map[string]string{"Authorization": "Bearer token"},
+			want:    policies.Authenticated,
+		},
+		{
+			name:    "requests with an api key get the authenticated policy",
+			headers: map[string]string{"X-API-Key": "pk_test"},
+			want:    policies.Authenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := policies.policy(r); got != tt.want {
+				t.Errorf("CachePolicies.policy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_writeValidators_TenantHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenancy *Tenancy
+		want    []string
+	}{
+		{
+			name: "responses vary by credentials without tenants",
+			want: []string{"Authorization, X-API-Key"},
+		},
+		{
+			name:    "responses vary by the tenant header when a gateway chooses the tenant",
+			tenancy: NewTenancy("X-Tenant"),
+			want:    []string{"Authorization, X-API-Key", "X-Tenant"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/plants", nil)
+			s := &Server{cachePolicies: ResolveCachePolicies(), tenancy: tt.tenancy}
+			s.writeValidators(c, `"abc"`, time.Time{})
+			if got := w.Header().Values("Vary"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeValidators Vary = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
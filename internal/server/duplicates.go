@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRedirects is how many merges in a row a redirect is followed through
+const maxRedirects = 5
+
+// mergeRequest is the body of a request merging the plant named from into another
+type mergeRequest struct {
+	From string `json:"from"`
+}
+
+// mergeResponse describes a merge and what it changed
+type mergeResponse struct {
+	Plant    pkg.Plant    `json:"plant"`
+	Redirect pkg.Redirect `json:"redirect"`
+	// CollectionEntries is how many users' collection entries were moved to the plant
+	CollectionEntries int `json:"collection_entries"`
+}
+
+// HandleListDuplicates lists clusters of plants which are likely duplicates of each other,
+// most likely first, e.g. GET /v1/duplicates?threshold=0.6
+func (s *Server) HandleListDuplicates(c *gin.Context) {
+	threshold := pkg.DefaultDuplicateThreshold
+	if c.Query("threshold") != "" {
+		parsed, err := strconv.ParseFloat(c.Query("threshold"), 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			log.Printf("invalid duplicate threshold %q", c.Query("threshold"))
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		threshold = parsed
+	}
+	if !s.authorize(c, pkg.PermissionMergePlants, nil) {
+		return
+	}
+	plants, err := s.db.ListPlants(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, pkg.FindDuplicates(plants, threshold))
+}
+
+// mergePlant merges the plant named in the body into the named plant, e.g.
+// POST /v1/plant/golden pothos:merge {"from": "pothos"}. Users' collection entries are moved
+// once the merge has been made. If they cannot all be moved the request fails, and asking
+// for the same merge again moves the rest.
+func (s *Server) mergePlant(c *gin.Context, into string) {
+	var request mergeRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if into == "" || request.From == "" || into == request.From {
+		log.Println("merge request missing or repeating plant names")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionMergePlants, s.plantResource(c, into)) {
+		return
+	}
+	from, err := s.db.GetPlant(request.From, c)
+	if err != nil && err.Error() == db.ErrNotFound {
+		s.finishMerge(c, into, request.From)
+		return
+	}
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	author := middleware.GetSubject(c.Request)
+	plant, err := s.db.MergePlants(into, from.Name, author, c)
+	if err != nil {
+		if errors.Is(err, db.ErrMergeConflict) {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		writeDBError(c, err)
+		return
+	}
+	redirect := pkg.Redirect{Name: from.Name, To: into, CreatedBy: author}
+	if plant.UpdatedAt != nil {
+		redirect.CreatedAt = *plant.UpdatedAt
+	}
+	moved, err := s.db.RepointCollections(from.Name, into, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+	} else {
+		c.JSON(http.StatusOK, mergeResponse{Plant: *plant, Redirect: redirect, CollectionEntries: moved})
+	}
+	s.recordAudit(c, audit.ActionMerge, into, from, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, *plant)
+	s.publishEvent(c, pkg.EventPlantPurged, *from)
+}
+
+// finishMerge moves the collection entries left behind by a merge which has already been
+// made, found by the redirect it left at the merged plant's name
+func (s *Server) finishMerge(c *gin.Context, into string, from string) {
+	redirect, err := s.db.GetRedirect(from, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if redirect.To != into {
+		log.Printf("%s was merged into %s, not %s", from, redirect.To, into)
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	plant, err := s.db.GetPlant(into, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	moved, err := s.db.RepointCollections(from, into, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, mergeResponse{Plant: *plant, Redirect: *redirect, CollectionEntries: moved})
+}
+
+// followRedirects follows the redirect left at the name of a plant which was merged away to
+// the plant it was merged into, and on through later merges of that plant. It returns the
+// name of the plant at the end, or nothing if the name was never merged away.
+func (s *Server) followRedirects(c *gin.Context, name string) string {
+	to := ""
+	for i := 0; i < maxRedirects; i++ {
+		redirect, err := s.db.GetRedirect(name, c)
+		if err != nil {
+			if err.Error() != db.ErrNotFound {
+				log.Println(err)
+			}
+			break
+		}
+		to, name = redirect.To, redirect.To
+		if _, err := s.db.GetPlant(to, c); err == nil {
+			break
+		}
+	}
+	return to
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleListDuplicates(t *testing.T) {
+	tests := []struct {
+		name      string
+		scope     string
+		threshold string
+		code      int
+		clusters  int
+	}{
+		{
+			name:     "handle list duplicates clusters likely duplicates",
+			scope:    pkg.PermissionMergePlants,
+			code:     200,
+			clusters: 1,
+		},
+		{
+			name:      "handle list duplicates fails if threshold is out of range",
+			scope:     pkg.PermissionMergePlants,
+			threshold: "2",
+			code:      400,
+		},
+		{
+			name:  "handle list duplicates forbids callers without merge:plants",
+			scope: "",
+			code:  403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodGet, "/v1/duplicates?threshold="+tt.threshold, nil), "admin", tt.scope)
+			mockDB := new(db.MockDB)
+			mockDB.On("ListPlants", mock.Anything).Return([]pkg.Plant{
+				{Name: "Pothos", Description: "A trailing vine with heart shaped leaves"},
+				{Name: "Golden pothos", Description: "Trailing vine with heart shaped yellow marbled leaves"},
+				{Name: "Snake plant", Description: "Upright sword shaped leaves"},
+			}, nil)
+			s := &Server{db: mockDB}
+			s.HandleListDuplicates(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleListDuplicates response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			var clusters []pkg.DuplicateCluster
+			err := json.Unmarshal(w.Body.Bytes(), &clusters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(clusters) != tt.clusters || clusters[0].Plants[0] != "Golden pothos" || clusters[0].Plants[1] != "Pothos" {
+				t.Errorf("HandleListDuplicates clusters: %v, expected pothos and golden pothos", clusters)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePlantAction_Merge(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    string
+		body     string
+		mergeErr error
+		code     int
+	}{
+		{
+			name:  "handle plant action merges a plant and repoints collections",
+			scope: pkg.PermissionMergePlants,
+			body:  `{"from": "golden pothos"}`,
+			code:  200,
+		},
+		{
+			name:     "handle plant action fails if either plant changed during the merge",
+			scope:    pkg.PermissionMergePlants,
+			body:     `{"from": "golden pothos"}`,
+			mergeErr: db.ErrMergeConflict,
+			code:     409,
+		},
+		{
+			name:  "handle plant action fails to merge a plant into itself",
+			scope: pkg.PermissionMergePlants,
+			body:  `{"from": "pothos"}`,
+			code:  400,
+		},
+		{
+			name: "handle plant action forbids merging without merge:plants",
+			body: `{"from": "golden pothos"}`,
+			code: 403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant/pothos:merge", bytes.NewBufferString(tt.body)), "admin", tt.scope)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "pothos:merge"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "pothos", mock.Anything).Return(&pkg.Plant{Name: "pothos", Description: "trailing vine"}, nil)
+			mockDB.On("GetPlant", "golden pothos", mock.Anything).Return(&pkg.Plant{Name: "golden pothos", Description: "marbled vine"}, nil)
+			mockDB.On("RepointCollections", "golden pothos", "pothos", mock.Anything).Return(2, nil)
+			mockDB.On("MergePlants", "pothos", "golden pothos", "admin", mock.Anything).Return(&pkg.Plant{Name: "pothos", Description: "trailing vine", Synonyms: []string{"golden pothos"}}, tt.mergeErr)
+			s := &Server{db: mockDB}
+			s.HandlePlantAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePlantAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code == 400 || tt.code == 403 {
+				mockDB.AssertNotCalled(t, "RepointCollections", mock.Anything, mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "MergePlants", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetPlant_Redirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		redirects map[string]string
+		code      int
+		location  string
+	}{
+		{
+			name:      "handle get plant redirects a merged plant",
+			redirects: map[string]string{"golden pothos": "pothos"},
+			code:      301,
+			location:  "/v1/plant/pothos",
+		},
+		{
+			name:      "handle get plant follows a plant merged more than once",
+			redirects: map[string]string{"golden pothos": "devil's ivy", "devil's ivy": "pothos"},
+			code:      301,
+			location:  "/v1/plant/pothos",
+		},
+		{
+			name: "handle get plant does not find a plant which was never merged",
+			code: 404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/plant/golden%20pothos", nil)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "golden pothos"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "pothos", mock.Anything).Return(&pkg.Plant{Name: "pothos", Description: "trailing vine"}, nil)
+			mockDB.On("GetPlant", mock.Anything, mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			for from, to := range tt.redirects {
+				mockDB.On("GetRedirect", from, mock.Anything).Return(&pkg.Redirect{Name: from, To: to}, nil)
+			}
+			mockDB.On("GetRedirect", mock.Anything, mock.Anything).Return((*pkg.Redirect)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("ResolvePlant", mock.Anything, mock.Anything).Return((*pkg.PlantRef)(nil), errors.New(db.ErrNotFound))
+			s := &Server{db: mockDB}
+			s.HandleGetPlant(c)
+			c.Writer.WriteHeaderNow()
+			if w.Code != tt.code {
+				t.Errorf("HandleGetPlant response code: %d, expected %d", w.Code, tt.code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("HandleGetPlant location: %q, expected %q", location, tt.location)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePlantAction_MergeCollections(t *testing.T) {
+	tests := []struct {
+		name       string
+		merged     bool
+		redirectTo string
+		repointErr error
+		code       int
+	}{
+		{
+			name:       "handle plant action fails a merge whose collection entries could not all be moved",
+			repointErr: errors.New("throttled"),
+			code:       500,
+		},
+		{
+			name:       "handle plant action moves the rest of the collection entries when a merge is asked for again",
+			merged:     true,
+			redirectTo: "pothos",
+			code:       200,
+		},
+		{
+			name:       "handle plant action does not find a plant merged into another",
+			merged:     true,
+			redirectTo: "devil's ivy",
+			code:       404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant/pothos:merge", bytes.NewBufferString(`{"from": "golden pothos"}`)), "admin", pkg.PermissionMergePlants)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "pothos:merge"})
+			mockDB := new(db.MockDB)
+			merged := &pkg.Plant{Name: "pothos", Description: "trailing vine", Synonyms: []string{"golden pothos"}}
+			mockDB.On("GetPlant", "pothos", mock.Anything).Return(merged, nil)
+			if tt.merged {
+				mockDB.On("GetPlant", "golden pothos", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			} else {
+				mockDB.On("GetPlant", "golden pothos", mock.Anything).Return(&pkg.Plant{Name: "golden pothos", Description: "marbled vine"}, nil)
+			}
+			mockDB.On("GetRedirect", "golden pothos", mock.Anything).Return(&pkg.Redirect{Name: "golden pothos", To: tt.redirectTo}, nil)
+			mockDB.On("MergePlants", "pothos", "golden pothos", "admin", mock.Anything).Return(merged, nil)
+			mockDB.On("RepointCollections", "golden pothos", "pothos", mock.Anything).Return(1, tt.repointErr)
+			s := &Server{db: mockDB}
+			s.HandlePlantAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePlantAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.merged {
+				mockDB.AssertNotCalled(t, "MergePlants", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+			if tt.code == 404 {
+				mockDB.AssertNotCalled(t, "RepointCollections", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SevvyP/plants/internal/events"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// heartbeatInterval keeps idle connections from being closed by proxies
+	heartbeatInterval = 15 * time.Second
+	// resetEvent tells a client it missed events and should reload the catalog
+	resetEvent = "reset"
+)
+
+// HandlePlantEvents streams catalog changes as server-sent events. Clients reconnecting
+// with a Last-Event-ID header are sent the events they missed, or a reset event if some
+// are no longer buffered. Clients which fall too far behind are disconnected so they
+// reconnect and resume.
+func (s *Server) HandlePlantEvents(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	if s.events == nil {
+		log.Println("change feed is not configured")
+		c.Writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var lastID uint64
+	resume := false
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		var err error
+		lastID, err = strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resume = true
+	}
+	subscriber, backlog, complete := s.events.Subscribe(lastID, resume)
+	defer s.events.Unsubscribe(subscriber)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// stop nginx buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if !complete {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: {}\n\n", resetEvent)
+	}
+	for _, message := range backlog {
+		if !writeMessage(c, message) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case message, ok := <-subscriber.Messages:
+			if !ok {
+				// dropped for falling behind
+				return
+			}
+			if !writeMessage(c, message) {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeMessage sends a message to the client, skipping those of other tenants
+func writeMessage(c *gin.Context, message events.Message) bool {
+	if message.Event.Tenant != tenant.ID(c) {
+		return true
+	}
+	data, err := json.Marshal(message.Event)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", message.ID, message.Event.Type, data)
+	return err == nil
+}
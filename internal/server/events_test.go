@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/events"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+func TestServer_HandlePlantEvents(t *testing.T) {
+	broker := events.NewBroker(2)
+	first := broker.Publish(pkg.Event{ID: "a", Type: pkg.EventPlantCreated, Plant: pkg.Plant{Name: "fern"}})
+	broker.Publish(pkg.Event{ID: "b", Type: pkg.EventPlantUpdated, Plant: pkg.Plant{Name: "fern"}})
+	broker.Publish(pkg.Event{ID: "c", Type: pkg.EventPlantDeleted, Plant: pkg.Plant{Name: "fern"}})
+	tests := []struct {
+		name   string
+		lastID string
+		code   int
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "handle plant events fails if the last event id is malformed",
+			lastID: "abc",
+			code:   400,
+		},
+		{
+			name:   "handle plant events resumes after the last event id",
+			lastID: strconv.FormatUint(first.ID+1, 10),
+			code:   200,
+			want:   []string{"id: " + strconv.FormatUint(first.ID+2, 10) + "\nevent: plant.deleted\ndata: {\"id\":\"c\""},
+			absent: []string{"event: reset", "plant.updated"},
+		},
+		{
+			name:   "handle plant events sends a reset if events were missed",
+			lastID: strconv.FormatUint(first.ID-1, 10),
+			code:   200,
+			want:   []string{"event: reset\n", "event: plant.updated\n", "event: plant.deleted\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			c.Request = httptest.NewRequest("GET", "/v1/plants/events", nil).WithContext(ctx)
+			c.Request.Header.Set("Last-Event-ID", tt.lastID)
+			s := &Server{events: broker}
+			s.HandlePlantEvents(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandlePlantEvents response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("HandlePlantEvents response missing %q in %s", want, w.Body.String())
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(w.Body.String(), absent) {
+					t.Errorf("HandlePlantEvents response has %q", absent)
+				}
+			}
+		})
+	}
+}
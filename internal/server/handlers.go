@@ -1,11 +1,18 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/SevvyP/plants/internal/audit"
 	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
 	"github.com/SevvyP/plants/pkg"
 	"github.com/gin-gonic/gin"
 )
@@ -24,13 +31,37 @@ func (s *Server) HandleCreatePlant(c *gin.Context) {
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = s.db.CreatePlant(plant, c)
+	if pkg.Slugify(plant.Name) == "" {
+		log.Println("create request name has no letters or digits")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.validTranslations(plant.Translations) {
+		log.Println("create request has translations into unsupported locales")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionCreatePlants, nil) {
+		return
+	}
+	if !s.withinPlantQuota(c) {
+		return
+	}
+	if s.holdForReview(c, plant) {
+		return
+	}
+	plant.Owner = middleware.GetSubject(c.Request)
+	plant.ID = pkg.NewPlantID()
+	plant.Slug = pkg.Slugify(plant.Name)
+	before := s.auditBefore(c, plant.Name)
+	err = s.db.CreatePlant(plant, middleware.GetSubject(c.Request), c)
 	if err != nil {
-		log.Println(err)
-		c.Writer.WriteHeader(http.StatusInternalServerError)
+		writeDBError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionCreate, plant.Name, before, plant)
+	s.publishEvent(c, pkg.EventPlantCreated, plant)
 }
 
 func (s *Server) HandleGetPlant(c *gin.Context) {
@@ -40,6 +71,11 @@ func (s *Server) HandleGetPlant(c *gin.Context) {
 		return
 	}
 	plant, err := s.db.GetPlant(c.Param("name"), c)
+	location := ""
+	if err != nil && err.Error() == db.ErrNotFound {
+		// the name may be an id, a slug or the name of a plant which was merged away
+		plant, location, err = s.resolvePlant(c, c.Param("name"))
+	}
 	if err != nil {
 		if err.Error() == db.ErrNotFound {
 			log.Println(err)
@@ -50,7 +86,20 @@ func (s *Server) HandleGetPlant(c *gin.Context) {
 		c.Writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	c.JSON(http.StatusOK, plant)
+	if !s.authorize(c, pkg.PermissionReadPlants, func() (*pkg.Resource, error) { return plant.Resource(), nil }) {
+		return
+	}
+	if location != "" {
+		c.Redirect(http.StatusMovedPermanently, location)
+		return
+	}
+	modified := time.Time{}
+	if plant.UpdatedAt != nil {
+		modified = *plant.UpdatedAt
+	}
+	localized := plant.Localize(s.negotiateLocales(c), s.catalogLocales().Default())
+	c.Header("Content-Language", localized.Locale)
+	s.writeCacheable(c, "", modified, localized)
 }
 
 func (s *Server) HandleUpdatePlant(c *gin.Context) {
@@ -67,13 +116,26 @@ func (s *Server) HandleUpdatePlant(c *gin.Context) {
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = s.db.UpdatePlant(plant, c)
+	if !s.validTranslations(plant.Translations) {
+		log.Println("update request has translations into unsupported locales")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionUpdatePlants, s.plantResource(c, plant.Name)) {
+		return
+	}
+	if s.holdForReview(c, plant) {
+		return
+	}
+	before := s.auditBefore(c, plant.Name)
+	err = s.db.UpdatePlant(plant, middleware.GetSubject(c.Request), c)
 	if err != nil {
-		log.Println(err)
-		c.Writer.WriteHeader(http.StatusInternalServerError)
+		writeDBError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionUpdate, plant.Name, before, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, plant)
 }
 
 func (s *Server) HandleDeletePlant(c *gin.Context) {
@@ -82,7 +144,10 @@ func (s *Server) HandleDeletePlant(c *gin.Context) {
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	plant, err := s.db.DeletePlant(c.Param("name"), c)
+	if !s.authorize(c, pkg.PermissionDeletePlants, s.plantResource(c, c.Param("name"))) {
+		return
+	}
+	plant, err := s.db.DeletePlant(c.Param("name"), middleware.GetSubject(c.Request), c)
 	if err != nil {
 		if err.Error() == db.ErrNotFound {
 			log.Println(err)
@@ -94,4 +159,66 @@ func (s *Server) HandleDeletePlant(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, plant)
-}
\ No newline at end of file
+	s.recordAudit(c, audit.ActionDelete, plant.Name, plant, nil)
+	s.publishEvent(c, pkg.EventPlantDeleted, *plant)
+}
+
+// HandleListPlants lists the catalog. The list's ETag is the catalog's version, which is
+// read first so that a client whose list is current is answered without listing again.
+func (s *Server) HandleListPlants(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	version, err := s.db.GetCatalogVersion(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// the list is served in the most preferred locale, which each plant falls back from
+	chain := s.negotiateLocales(c)
+	c.Header("Content-Language", chain[0])
+	// lists in other locales are tagged with their chain, which always ends with the default
+	prefix := "catalog"
+	if len(chain) > 1 {
+		prefix += "-" + strings.Join(chain[:len(chain)-1], "-")
+	}
+	etag := versionETag(prefix, version.Version)
+	if notModified(c.Request, etag, version.ChangedAt) {
+		s.writeValidators(c, etag, version.ChangedAt)
+		c.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	plants, err := s.db.ListPlants(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	localized := make([]pkg.LocalizedPlant, len(plants))
+	for i, plant := range plants {
+		localized[i] = plant.Localize(chain, s.catalogLocales().Default())
+	}
+	s.writeCacheable(c, etag, version.ChangedAt, localized)
+}
+
+// writeDBError responds with not found for db.ErrNotFound and an internal error otherwise
+func writeDBError(c *gin.Context, err error) {
+	log.Println(err)
+	if err.Error() == db.ErrNotFound {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, db.ErrNameTaken) || errors.Is(err, db.ErrPlantChanged) || errors.Is(err, db.ErrPlantTrashed) {
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusInternalServerError)
+}
+
+// newID returns a random identifier for a new resource
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/SevvyP/plants/internal/db"
 	"github.com/SevvyP/plants/pkg"
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestServer_HandleCreatePlant(t *testing.T) {
@@ -49,6 +50,27 @@ func TestServer_HandleCreatePlant(t *testing.T) {
 			},
 			code: 500,
 		},
+		{
+			name: "handle create plant fails if the name's slug is taken",
+			fields: fields{
+				db: new(db.MockDB),
+			},
+			args: args{
+				plant: pkg.Plant{Name: "test", Description: "test"},
+				err: db.ErrNameTaken,
+			},
+			code: 409,
+		},
+		{
+			name: "handle create plant fails if the name has no letters or digits",
+			fields: fields{
+				db: new(db.MockDB),
+			},
+			args: args{
+				plant: pkg.Plant{Name: "--", Description: "test"},
+			},
+			code: 400,
+		},
 		{
 			name: "handle create plant is successful if db is successful",
 			fields: fields{
@@ -59,7 +81,7 @@ func TestServer_HandleCreatePlant(t *testing.T) {
 				err: nil,
 			},
 			code: 200,
-			want: pkg.Plant{Name: "test", Description: "test"},
+			want: pkg.Plant{Name: "test", Description: "test", Slug: "test"},
 			checkReturn: true,
 		},
 	}
@@ -81,7 +103,9 @@ func TestServer_HandleCreatePlant(t *testing.T) {
 				db: tt.fields.db,
 			}
 			if(tt.fields.db != nil) {
-				tt.fields.db.On("CreatePlant", tt.args.plant, c).Return(tt.args.err)
+				tt.fields.db.On("CreatePlant", mock.MatchedBy(func(plant pkg.Plant) bool {
+					return plant.Name == tt.args.plant.Name && plant.ID != "" && plant.Slug == pkg.Slugify(plant.Name)
+				}), "", c).Return(tt.args.err)
 			}
 			s.HandleCreatePlant(c)
 			if c.Writer.Status() != tt.code {
@@ -93,6 +117,10 @@ func TestServer_HandleCreatePlant(t *testing.T) {
 				if err != nil {
 					t.Error(err)
 				}
+				if got.ID == "" {
+					t.Errorf("Handle create plant returned %v without an id", got)
+				}
+				got.ID = ""
 				if !reflect.DeepEqual(got, tt.want) {
 					t.Errorf("Handle create plant returned %v, want %v", got, tt.want)
 				}
@@ -176,6 +204,8 @@ func TestServer_HandleGetPlant(t *testing.T) {
 			}
 			if(tt.fields.db != nil) {
 				tt.fields.db.On("GetPlant", tt.args.name, c).Return(tt.args.plant, tt.args.err)
+				tt.fields.db.On("GetRedirect", tt.args.name, c).Return((*pkg.Redirect)(nil), errors.New(db.ErrNotFound))
+				tt.fields.db.On("ResolvePlant", tt.args.name, c).Return((*pkg.PlantRef)(nil), errors.New(db.ErrNotFound))
 			}
 			s.HandleGetPlant(c)
 			if c.Writer.Status() != tt.code {
@@ -229,6 +259,28 @@ func TestServer_HandleUpdatePlant(t *testing.T) {
 			},
 			code: 500,
 		},
+		{
+			name: "handle update plant fails if the plant does not exist or is in the trash",
+			fields: fields{
+				db: new(db.MockDB),
+			},
+			args: args{
+				plant: pkg.Plant{Name: "test", Description: "test"},
+				err: errors.New(db.ErrNotFound),
+			},
+			code: 404,
+		},
+		{
+			name: "handle update plant fails if the plant changed since it was read",
+			fields: fields{
+				db: new(db.MockDB),
+			},
+			args: args{
+				plant: pkg.Plant{Name: "test", Description: "test"},
+				err: db.ErrPlantChanged,
+			},
+			code: 409,
+		},
 		{
 			name: "handle update plant is successful if db is successful",
 			fields: fields{
@@ -261,7 +313,7 @@ func TestServer_HandleUpdatePlant(t *testing.T) {
 				db: tt.fields.db,
 			}
 			if(tt.fields.db != nil) {
-				tt.fields.db.On("UpdatePlant", tt.args.plant, c).Return(tt.args.err)
+				tt.fields.db.On("UpdatePlant", tt.args.plant, "", c).Return(tt.args.err)
 			}
 			s.HandleUpdatePlant(c)
 			if c.Writer.Status() != tt.code {
@@ -355,7 +407,7 @@ func TestServer_HandleDeletePlant(t *testing.T) {
 				db: tt.fields.db,
 			}
 			if(tt.fields.db != nil) {
-				tt.fields.db.On("DeletePlant", tt.args.name, c).Return(tt.args.plant, tt.args.err)
+				tt.fields.db.On("DeletePlant", tt.args.name, "", c).Return(tt.args.plant, tt.args.err)
 			}
 			s.HandleDeletePlant(c)
 			if c.Writer.Status() != tt.code {
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/webhooks"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const renameSuffix = ":rename"
+
+// renameRequest is the body of a request giving a plant a new name
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+// resolvePlant finds a plant which is not stored under the key by its id or slug, or by the
+// name of a plant merged into it. The location is empty if the key is the plant's id or
+// canonical slug, and otherwise the path to redirect to.
+func (s *Server) resolvePlant(c *gin.Context, key string) (*pkg.Plant, string, error) {
+	ref, err := s.db.ResolvePlant(key, c)
+	if err == nil {
+		plant, err := s.db.GetPlant(ref.Name, c)
+		if err != nil {
+			return nil, "", err
+		}
+		if key == ref.ID || key == ref.Slug {
+			return plant, "", nil
+		}
+		return plant, "/v1/plant/" + url.PathEscape(ref.Slug), nil
+	}
+	if err.Error() != db.ErrNotFound {
+		return nil, "", err
+	}
+	to := s.followRedirects(c, key)
+	if to == "" {
+		return nil, "", errors.New(db.ErrNotFound)
+	}
+	plant, err := s.db.GetPlant(to, c)
+	if err != nil {
+		return nil, "", err
+	}
+	return plant, "/v1/plant/" + url.PathEscape(to), nil
+}
+
+// renamePlant gives the named plant the name in the body, keeping its id, e.g.
+// POST /v1/plant/snake plant:rename {"name": "Sansevieria"}. Its old slug redirects to its
+// new one, and its revisions are copied and users' collection entries moved to the new name.
+// If they cannot all be copied and moved the request fails, and asking for the same rename
+// again finishes it.
+func (s *Server) renamePlant(c *gin.Context, name string) {
+	var request renameRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if name == "" || request.Name == "" || name == request.Name || pkg.Slugify(request.Name) == "" {
+		log.Println("rename request missing, repeating or without a usable new name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	previous, err := s.db.GetPlant(name, c)
+	if err != nil && err.Error() == db.ErrNotFound {
+		s.finishRename(c, name, request.Name)
+		return
+	}
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if !s.mayRename(c, name) {
+		return
+	}
+	plant, err := s.db.RenamePlant(name, request.Name, middleware.GetSubject(c.Request), c)
+	if err != nil && !errors.Is(err, db.ErrRevisionsNotCopied) {
+		writeDBError(c, err)
+		return
+	}
+	_, moveErr := s.db.RepointCollections(name, plant.Name, c)
+	err = errors.Join(err, moveErr)
+	if err != nil {
+		log.Printf("failed to finish renaming %s to %s: %v", name, plant.Name, err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+	} else {
+		c.JSON(http.StatusOK, plant)
+	}
+	s.recordAudit(c, audit.ActionRename, plant.Name, previous, plant)
+	event := webhooks.NewEvent(pkg.EventPlantRenamed, *plant)
+	event.PreviousName = previous.Name
+	s.sendEvent(c, event)
+}
+
+// mayRename checks the caller may rename the named plant
+func (s *Server) mayRename(c *gin.Context, name string) bool {
+	if !s.authorize(c, pkg.PermissionUpdatePlants, s.plantResource(c, name)) {
+		return false
+	}
+	// a rename cannot be held for review, so under moderation only publishers may rename
+	return !s.moderation || s.authorize(c, pkg.PermissionPublishPlants, nil)
+}
+
+// finishRename copies the revisions and moves the collection entries left behind by a rename
+// which has already been made, found by the old name's slug leading to the plant under its
+// new name
+func (s *Server) finishRename(c *gin.Context, name string, newName string) {
+	ref, err := s.db.ResolvePlant(name, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if ref.Name != newName {
+		log.Printf("%s was not renamed to %s", name, newName)
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.mayRename(c, newName) {
+		return
+	}
+	plant, err := s.db.GetPlant(newName, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	err = s.db.CopyRevisions(name, *plant, c)
+	_, moveErr := s.db.RepointCollections(name, newName, c)
+	err = errors.Join(err, moveErr)
+	if err != nil {
+		log.Printf("failed to finish renaming %s to %s: %v", name, newName, err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, plant)
+}
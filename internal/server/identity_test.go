@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/events"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleGetPlant_Identity(t *testing.T) {
+	ref := &pkg.PlantRef{ID: "0123456789abcdef", Name: "Snake Plant", Slug: "snake-plant"}
+	tests := []struct {
+		name     string
+		key      string
+		code     int
+		location string
+	}{
+		{
+			name: "handle get plant finds a plant by its id",
+			key:  "0123456789abcdef",
+			code: 200,
+		},
+		{
+			name: "handle get plant finds a plant by its slug",
+			key:  "snake-plant",
+			code: 200,
+		},
+		{
+			name:     "handle get plant redirects a slug the plant had before it was renamed",
+			key:      "mother-in-law's-tongue",
+			code:     301,
+			location: "/v1/plant/snake-plant",
+		},
+		{
+			name:     "handle get plant redirects a name which differs only in case to the slug",
+			key:      "snake plant",
+			code:     301,
+			location: "/v1/plant/snake-plant",
+		},
+		{
+			name: "handle get plant does not find an unknown key",
+			key:  "cactus",
+			code: 404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/plant/"+url.PathEscape(tt.key), nil)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: tt.key})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "Snake Plant", mock.Anything).Return(&pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: ref.ID, Slug: ref.Slug}, nil)
+			mockDB.On("GetPlant", mock.Anything, mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("ResolvePlant", "cactus", mock.Anything).Return((*pkg.PlantRef)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("ResolvePlant", mock.Anything, mock.Anything).Return(ref, nil)
+			mockDB.On("GetRedirect", mock.Anything, mock.Anything).Return((*pkg.Redirect)(nil), errors.New(db.ErrNotFound))
+			s := &Server{db: mockDB}
+			s.HandleGetPlant(c)
+			c.Writer.WriteHeaderNow()
+			if w.Code != tt.code {
+				t.Errorf("HandleGetPlant response code: %d, expected %d", w.Code, tt.code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("HandleGetPlant location: %q, expected %q", location, tt.location)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePlantAction_Rename(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string
+		body      string
+		renameErr error
+		code      int
+	}{
+		{
+			name: "handle plant action renames a plant and moves collection entries",
+			body: `{"name": "Sansevieria"}`,
+			code: 200,
+		},
+		{
+			name:      "handle plant action fails if the new name's slug is taken",
+			body:      `{"name": "Sansevieria"}`,
+			renameErr: db.ErrNameTaken,
+			code:      409,
+		},
+		{
+			name:      "handle plant action fails if the plant changed during the rename",
+			body:      `{"name": "Sansevieria"}`,
+			renameErr: db.ErrPlantChanged,
+			code:      409,
+		},
+		{
+			name: "handle plant action fails to rename a plant to a name without letters or digits",
+			body: `{"name": "!!"}`,
+			code: 400,
+		},
+		{
+			name:    "handle plant action forbids renaming by a contributor, who may not publish, under moderation",
+			subject: "alice",
+			body:    `{"name": "Sansevieria"}`,
+			code:    403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/plant/Snake%20Plant:rename", bytes.NewBufferString(tt.body))
+			if tt.subject != "" {
+				c.Request = withSubject(c.Request, tt.subject, "")
+			}
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "Snake Plant:rename"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "Snake Plant", mock.Anything).Return(&pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: "0123456789abcdef", Slug: "snake-plant"}, nil)
+			mockDB.On("RenamePlant", "Snake Plant", "Sansevieria", mock.Anything, mock.Anything).Return(&pkg.Plant{Name: "Sansevieria", Description: "upright leaves", ID: "0123456789abcdef", Slug: "sansevieria"}, tt.renameErr)
+			mockDB.On("RepointCollections", "Snake Plant", "Sansevieria", mock.Anything).Return(1, nil)
+			s := &Server{db: mockDB}
+			if tt.subject != "" {
+				s = newModeratedServer(mockDB, map[string]string{tt.subject: policy.RoleContributor})
+			}
+			s.events = events.NewBroker(4)
+			subscriber, _, _ := s.events.Subscribe(0, false)
+			s.HandlePlantAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePlantAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code == 200 {
+				mockDB.AssertCalled(t, "RepointCollections", "Snake Plant", "Sansevieria", mock.Anything)
+			} else {
+				mockDB.AssertNotCalled(t, "RepointCollections", mock.Anything, mock.Anything, mock.Anything)
+			}
+			if tt.code == 400 || tt.code == 403 {
+				mockDB.AssertNotCalled(t, "RenamePlant", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+			// a rename is one event carrying both names, not the old plant purged and a new one created
+			published := []pkg.Event{}
+			for len(subscriber.Messages) > 0 {
+				published = append(published, (<-subscriber.Messages).Event)
+			}
+			if tt.code != 200 {
+				if len(published) != 0 {
+					t.Errorf("HandlePlantAction published %v, expected no events", published)
+				}
+				return
+			}
+			if len(published) != 1 || published[0].Type != pkg.EventPlantRenamed || published[0].Plant.Name != "Sansevieria" || published[0].PreviousName != "Snake Plant" {
+				t.Errorf("HandlePlantAction published %v, expected one %s event from Snake Plant to Sansevieria", published, pkg.EventPlantRenamed)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePlantAction_RenameCollections(t *testing.T) {
+	renamed := &pkg.Plant{Name: "Sansevieria", Description: "upright leaves", ID: "0123456789abcdef", Slug: "sansevieria"}
+	tests := []struct {
+		name       string
+		renamed    bool
+		renameErr  error
+		repointErr error
+		code       int
+	}{
+		{
+			name:       "handle plant action fails a rename whose collection entries could not all be moved",
+			repointErr: errors.New("throttled"),
+			code:       500,
+		},
+		{
+			name:      "handle plant action fails a rename whose revisions could not all be copied",
+			renameErr: db.ErrRevisionsNotCopied,
+			code:      500,
+		},
+		{
+			name:    "handle plant action finishes a rename when it is asked for again",
+			renamed: true,
+			code:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/plant/Snake%20Plant:rename", bytes.NewBufferString(`{"name": "Sansevieria"}`))
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "Snake Plant:rename"})
+			mockDB := new(db.MockDB)
+			if tt.renamed {
+				mockDB.On("GetPlant", "Snake Plant", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			} else {
+				mockDB.On("GetPlant", "Snake Plant", mock.Anything).Return(&pkg.Plant{Name: "Snake Plant", Description: "upright leaves", ID: "0123456789abcdef", Slug: "snake-plant"}, nil)
+			}
+			mockDB.On("GetPlant", "Sansevieria", mock.Anything).Return(renamed, nil)
+			mockDB.On("ResolvePlant", "Snake Plant", mock.Anything).Return(&pkg.PlantRef{ID: renamed.ID, Name: renamed.Name, Slug: renamed.Slug}, nil)
+			mockDB.On("RenamePlant", "Snake Plant", "Sansevieria", mock.Anything, mock.Anything).Return(renamed, tt.renameErr)
+			mockDB.On("CopyRevisions", "Snake Plant", *renamed, mock.Anything).Return(nil)
+			mockDB.On("RepointCollections", "Snake Plant", "Sansevieria", mock.Anything).Return(0, tt.repointErr)
+			s := &Server{db: mockDB}
+			s.events = events.NewBroker(4)
+			subscriber, _, _ := s.events.Subscribe(0, false)
+			s.HandlePlantAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePlantAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			mockDB.AssertCalled(t, "RepointCollections", "Snake Plant", "Sansevieria", mock.Anything)
+			// the rename itself is made and published once, by the first request
+			if tt.renamed {
+				mockDB.AssertNotCalled(t, "RenamePlant", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				mockDB.AssertCalled(t, "CopyRevisions", "Snake Plant", *renamed, mock.Anything)
+			}
+			if published := len(subscriber.Messages); (published == 1) == tt.renamed {
+				t.Errorf("HandlePlantAction published %d events", published)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/blob"
+	"github.com/SevvyP/plants/internal/images"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead allows for the form fields and boundaries around the uploaded file
+const multipartOverhead = 1 << 20
+
+// HandleUploadImage accepts a multipart form with the image in "file" and its "alt_text",
+// "license" and "attribution", stores the image and its thumbnails and attaches them to the plant
+func (s *Server) HandleUploadImage(c *gin.Context) {
+	name := c.Param("name")
+	if name == "" {
+		log.Println("image upload missing name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data, ok := s.readImageUpload(c)
+	if !ok {
+		return
+	}
+	// check the plant exists before doing the work of resizing
+	existing, err := s.db.GetPlant(name, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionUpdatePlants, func() (*pkg.Resource, error) { return existing.Resource(), nil }) {
+		return
+	}
+	image, ok := s.storeImage(c, data, "plants/"+url.PathEscape(name))
+	if !ok {
+		return
+	}
+	before := s.auditBefore(c, name)
+	plant, err := s.db.AddPlantImage(name, *image, middleware.GetSubject(c.Request), c)
+	if err != nil {
+		s.deleteRenditions(c, *image)
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, image)
+	s.recordAudit(c, audit.ActionUpdate, name, before, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, *plant)
+}
+
+// readImageUpload reads the image in the "file" field of a multipart form, which must
+// also have an "alt_text"
+func (s *Server) readImageUpload(c *gin.Context) ([]byte, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(s.maxImageBytes+multipartOverhead))
+	header, err := c.FormFile("file")
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if c.PostForm("alt_text") == "" {
+		log.Println("image upload missing alt text")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if header.Size > int64(s.maxImageBytes) {
+		log.Printf("image upload of %d bytes is over the limit", header.Size)
+		c.Writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	file, err := header.Open()
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(s.maxImageBytes)+1))
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// storeImage processes an uploaded image and stores its renditions under prefix, taking
+// the image's details from the rest of the form
+func (s *Server) storeImage(c *gin.Context, data []byte, prefix string) (*pkg.Image, bool) {
+	renditions, err := images.Process(data, s.maxImageBytes)
+	if errors.Is(err, images.ErrUnsupportedType) {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	image := pkg.Image{
+		ID:          newID(),
+		AltText:     c.PostForm("alt_text"),
+		License:     c.PostForm("license"),
+		Attribution: c.PostForm("attribution"),
+		UploadedAt:  time.Now().UTC(),
+	}
+	for _, rendition := range renditions {
+		key := prefix + "/" + image.ID + "/" + rendition.Name + rendition.Extension()
+		err = s.blobs.Put(key, rendition.Data, rendition.ContentType, c)
+		if err != nil {
+			log.Println(err)
+			s.deleteRenditions(c, image)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return nil, false
+		}
+		image.Renditions = append(image.Renditions, pkg.ImageRendition{
+			Name: rendition.Name, Key: key, ContentType: rendition.ContentType, Width: rendition.Width, Height: rendition.Height,
+		})
+	}
+	return &image, true
+}
+
+// HandleGetImage serves one rendition of an image attached to a plant
+func (s *Server) HandleGetImage(c *gin.Context) {
+	plant, err := s.db.GetPlant(c.Param("name"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, func() (*pkg.Resource, error) { return plant.Resource(), nil }) {
+		return
+	}
+	s.serveRendition(c, plant.Images, c.Param("id"), c.Param("rendition"))
+}
+
+// serveRendition writes the named rendition of the image with the given id
+func (s *Server) serveRendition(c *gin.Context, imageList []pkg.Image, id string, name string) {
+	for _, image := range imageList {
+		if image.ID != id {
+			continue
+		}
+		rendition, ok := image.Rendition(name)
+		if !ok {
+			break
+		}
+		data, contentType, err := s.blobs.Get(rendition.Key, c)
+		if errors.Is(err, blob.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if rendition.ContentType != "" {
+			contentType = rendition.ContentType
+		}
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+	log.Println("image not found")
+	c.Writer.WriteHeader(http.StatusNotFound)
+}
+
+// deleteRenditions removes stored renditions of an image which could not be attached
+func (s *Server) deleteRenditions(c *gin.Context, image pkg.Image) {
+	for _, rendition := range image.Renditions {
+		err := s.blobs.Delete(rendition.Key, c)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/blob"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/images"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func multipartImage(t *testing.T, file []byte, altText string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "plant.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(file)
+	writer.WriteField("alt_text", altText)
+	writer.WriteField("license", "CC-BY-4.0")
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestServer_HandleUploadImage(t *testing.T) {
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		file     []byte
+		altText  string
+		maxBytes int
+		code     int
+	}{
+		{
+			name:     "handle upload image fails if alt text is missing",
+			file:     encoded.Bytes(),
+			maxBytes: images.DefaultMaxBytes,
+			code:     400,
+		},
+		{
+			name:     "handle upload image fails if the file is too large",
+			file:     encoded.Bytes(),
+			altText:  "a plant",
+			maxBytes: 10,
+			code:     413,
+		},
+		{
+			name:     "handle upload image fails if the file is not an image",
+			file:     []byte("not an image"),
+			altText:  "a plant",
+			maxBytes: images.DefaultMaxBytes,
+			code:     415,
+		},
+		{
+			name:     "handle upload image stores the image and thumbnails",
+			file:     encoded.Bytes(),
+			altText:  "a plant",
+			maxBytes: images.DefaultMaxBytes,
+			code:     200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			body, contentType := multipartImage(t, tt.file, tt.altText)
+			c.Request = httptest.NewRequest("POST", "/v1/plant/test/images", body)
+			c.Request.Header.Set("Content-Type", contentType)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"})
+			mockDB := new(db.MockDB)
+			plant := &pkg.Plant{Name: "test", Description: "test"}
+			mockDB.On("GetPlant", "test", c).Return(plant, nil)
+			mockDB.On("AddPlantImage", "test", mock.Anything, "", c).Return(plant, nil)
+			store := blob.NewLocalStore(t.TempDir())
+			s := &Server{db: mockDB, blobs: store, maxImageBytes: tt.maxBytes}
+			s.HandleUploadImage(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandleUploadImage response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			var got pkg.Image
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.AltText != "a plant" || got.License != "CC-BY-4.0" || len(got.Renditions) != 2 {
+				t.Errorf("HandleUploadImage returned %v", got)
+			}
+			for _, rendition := range got.Renditions {
+				_, _, err := store.Get(rendition.Key, c)
+				if err != nil {
+					t.Errorf("HandleUploadImage did not store %s: %v", rendition.Key, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/care"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// decodeJournalEntry reads and validates a journal entry from the request body. Entries
+// without a time are taken to have happened now.
+func decodeJournalEntry(c *gin.Context) (*pkg.JournalEntry, bool) {
+	var entry pkg.JournalEntry
+	err := json.NewDecoder(c.Request.Body).Decode(&entry)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if !slices.Contains(pkg.JournalKinds, entry.Kind) {
+		log.Println("unknown journal entry kind " + entry.Kind)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if entry.Quantity < 0 {
+		log.Println("journal entry has a negative quantity")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now()
+	}
+	entry.OccurredAt = entry.OccurredAt.UTC()
+	if entry.OccurredAt.After(time.Now().Add(time.Minute)) {
+		log.Println("journal entry is in the future")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return &entry, true
+}
+
+// journalRange reads the from and to query parameters, which may be RFC 3339 timestamps
+// or dates in UTC
+func journalRange(c *gin.Context) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	var err error
+	if c.Query("from") != "" {
+		from, err = parseTime(c.Query("from"), time.UTC)
+	}
+	if err == nil && c.Query("to") != "" {
+		to, err = parseTime(c.Query("to"), time.UTC)
+		if err == nil && len(c.Query("to")) == len(time.DateOnly) {
+			// a date includes the whole day
+			to = to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// completeJournalTask treats a journal entry of a task kind as completing that task, so
+// the schedule counts from the last time the care was given. Entries older than the
+// task's current anchor leave it alone.
+func (s *Server) completeJournalTask(c *gin.Context, entry pkg.JournalEntry) error {
+	if !entry.IsTask() {
+		return nil
+	}
+	states, err := s.db.GetTaskStates(entry.UserID, c)
+	if err != nil {
+		return err
+	}
+	id := care.TaskID(entry.CollectionEntryID, entry.Kind)
+	state, ok := states[id]
+	if !ok {
+		state = pkg.TaskState{UserID: entry.UserID, ID: id}
+	}
+	if state.Anchor != nil && !entry.OccurredAt.After(*state.Anchor) {
+		return nil
+	}
+	return s.db.PutTaskState(care.Complete(state, entry.OccurredAt), c)
+}
+
+func (s *Server) HandleGetJournal(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	from, to, ok := journalRange(c)
+	if !ok {
+		return
+	}
+	_, err := s.db.GetCollectionEntry(userID, c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	entries, err := s.db.GetJournal(userID, c.Param("id"), from, to, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func (s *Server) HandleCreateJournalEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, ok := decodeJournalEntry(c)
+	if !ok {
+		return
+	}
+	_, err := s.db.GetCollectionEntry(userID, c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	entry.UserID = userID
+	entry.CollectionEntryID = c.Param("id")
+	entry.ID = db.JournalID(entry.OccurredAt, newID())
+	entry.Photos = nil
+	err = s.db.CreateJournalEntry(*entry, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.recordAudit(c, audit.ActionCreate, auditResource("journal", userID, entry.ID), nil, entry)
+	err = s.completeJournalTask(c, *entry)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+func (s *Server) HandleGetJournalEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.GetJournalEntry(userID, c.Param("id"), c.Param("entry"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandleUpdateJournalEntry replaces a journal entry. Photos are kept as they are, and
+// changing the time of the entry changes its id.
+func (s *Server) HandleUpdateJournalEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	existing, err := s.db.GetJournalEntry(userID, c.Param("id"), c.Param("entry"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	entry, ok := decodeJournalEntry(c)
+	if !ok {
+		return
+	}
+	entry.UserID = userID
+	entry.CollectionEntryID = existing.CollectionEntryID
+	entry.ID = existing.ID
+	if !entry.OccurredAt.Equal(existing.OccurredAt) {
+		entry.ID = db.JournalID(entry.OccurredAt, newID())
+	}
+	entry.Photos = existing.Photos
+	err = s.db.UpdateJournalEntry(existing.ID, *entry, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	s.recordAudit(c, audit.ActionUpdate, auditResource("journal", userID, existing.ID), existing, entry)
+	err = s.completeJournalTask(c, *entry)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandleDeleteJournalEntry removes a journal entry and its photos. The task schedule is
+// not moved back, since the entry may not have been the last time the care was given.
+func (s *Server) HandleDeleteJournalEntry(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.DeleteJournalEntry(userID, c.Param("id"), c.Param("entry"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	for _, photo := range entry.Photos {
+		s.deleteRenditions(c, photo)
+	}
+	c.JSON(http.StatusOK, entry)
+	s.recordAudit(c, audit.ActionDelete, auditResource("journal", userID, entry.ID), entry, nil)
+}
+
+// HandleUploadJournalPhoto attaches a photo to a journal entry. The form is the same as
+// for plant images.
+func (s *Server) HandleUploadJournalPhoto(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	data, ok := s.readImageUpload(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.GetJournalEntry(userID, c.Param("id"), c.Param("entry"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	photo, ok := s.storeImage(c, data, "journal/"+newID())
+	if !ok {
+		return
+	}
+	before := *entry
+	entry.Photos = append(slices.Clip(entry.Photos), *photo)
+	err = s.db.UpdateJournalEntry(entry.ID, *entry, c)
+	if err != nil {
+		s.deleteRenditions(c, *photo)
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, photo)
+	s.recordAudit(c, audit.ActionUpdate, auditResource("journal", userID, entry.ID), before, entry)
+}
+
+// HandleGetJournalPhoto serves one rendition of a photo attached to a journal entry
+func (s *Server) HandleGetJournalPhoto(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	entry, err := s.db.GetJournalEntry(userID, c.Param("id"), c.Param("entry"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	s.serveRendition(c, entry.Photos, c.Param("photo"), c.Param("rendition"))
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleCreateJournalEntry(t *testing.T) {
+	anchor := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		complete bool
+	}{
+		{
+			name: "handle create journal entry fails if the kind is unknown",
+			body: `{"kind":"sing"}`,
+			code: 400,
+		},
+		{
+			name: "handle create journal entry fails if the entry is in the future",
+			body: `{"kind":"water","occurred_at":"` + time.Now().AddDate(0, 0, 1).Format(time.RFC3339) + `"}`,
+			code: 400,
+		},
+		{
+			name:     "handle create journal entry completes the task",
+			body:     `{"kind":"water","quantity":250,"unit":"ml"}`,
+			code:     200,
+			complete: true,
+		},
+		{
+			name: "handle create journal entry leaves a later anchor alone",
+			body: `{"kind":"water","occurred_at":"2024-05-01T09:00:00Z"}`,
+			code: 200,
+		},
+		{
+			name: "handle create journal entry does not complete a task for observations",
+			body: `{"kind":"observation","notes":"new leaf"}`,
+			code: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest("POST", "/v1/me/plants/1/journal", strings.NewReader(tt.body)), "user", "")
+			c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCollectionEntry", "user", "1", c).Return(&pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "fern"}, nil)
+			mockDB.On("CreateJournalEntry", mock.MatchedBy(func(entry pkg.JournalEntry) bool {
+				return entry.UserID == "user" && entry.CollectionEntryID == "1" && strings.HasPrefix(entry.ID, entry.OccurredAt.Format("2006-01-02T"))
+			}), c).Return(nil)
+			mockDB.On("GetTaskStates", "user", c).Return(map[string]pkg.TaskState{
+				"1.water": {UserID: "user", ID: "1.water", Anchor: &anchor},
+			}, nil)
+			if tt.complete {
+				mockDB.On("PutTaskState", mock.MatchedBy(func(state pkg.TaskState) bool {
+					return state.ID == "1.water" && state.Anchor.After(anchor)
+				}), c).Return(nil)
+			}
+			s := &Server{db: mockDB}
+			s.HandleCreateJournalEntry(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandleCreateJournalEntry response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.complete {
+				mockDB.AssertCalled(t, "PutTaskState", mock.Anything, c)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetJournal(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = withSubject(httptest.NewRequest("GET", "/v1/me/plants/1/journal?from=2024-05-01&to=2024-05-31", nil), "user", "")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	mockDB := new(db.MockDB)
+	mockDB.On("GetCollectionEntry", "user", "1", c).Return(&pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "fern"}, nil)
+	mockDB.On("GetJournal", "user", "1", from, to, c).Return([]pkg.JournalEntry{}, nil)
+	s := &Server{db: mockDB}
+	s.HandleGetJournal(c)
+	if c.Writer.Status() != 200 {
+		t.Fatalf("HandleGetJournal response code: %d, expected 200", c.Writer.Status())
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Locales are the locales plant content is served in. The first is the locale plants' own
+// names and descriptions are written in, and the rest are those they may be translated into.
+type Locales struct {
+	tags    []language.Tag
+	matcher language.Matcher
+}
+
+// defaultLocales are used when the server is not configured with its own
+var defaultLocales = NewLocales([]string{pkg.DefaultLocale, "es", "fr", "de"})
+
+// NewLocales returns the locales with the given BCP 47 tags, the first being the default.
+// Tags which do not parse are skipped.
+func NewLocales(tags []string) *Locales {
+	locales := &Locales{}
+	for _, tag := range tags {
+		parsed, err := language.Parse(strings.TrimSpace(tag))
+		if err != nil {
+			log.Printf("skipping locale %q: %v", tag, err)
+			continue
+		}
+		locales.tags = append(locales.tags, parsed)
+	}
+	if len(locales.tags) == 0 {
+		locales.tags = []language.Tag{language.MustParse(pkg.DefaultLocale)}
+	}
+	locales.matcher = language.NewMatcher(locales.tags)
+	return locales
+}
+
+// ResolveLocales reads the locales from LOCALES, separated by commas, with the locale of
+// plants' own content first. By default plants are written in English and translated into
+// Spanish, French and German.
+func ResolveLocales() *Locales {
+	if value := os.Getenv("LOCALES"); value != "" {
+		return NewLocales(strings.Split(value, ","))
+	}
+	return defaultLocales
+}
+
+// Default is the locale plants' own content is written in
+func (l *Locales) Default() string {
+	return l.tags[0].String()
+}
+
+// Supports reports whether locale is one plants may be translated into, returning its
+// canonical tag
+func (l *Locales) Supports(locale string) (string, bool) {
+	parsed, err := language.Parse(locale)
+	if err != nil {
+		return "", false
+	}
+	for _, tag := range l.tags[1:] {
+		if tag == parsed {
+			return tag.String(), true
+		}
+	}
+	return "", false
+}
+
+// Translated returns the locales plants may be translated into
+func (l *Locales) Translated() []string {
+	locales := []string{}
+	for _, tag := range l.tags[1:] {
+		locales = append(locales, tag.String())
+	}
+	return locales
+}
+
+// Negotiate turns an Accept-Language header into the chain of locales content is looked up
+// in. Each accepted language, most preferred first, is matched to a supported locale and
+// followed by those of its parents which are supported, so es-MX falls back to es. The
+// default locale always ends the chain.
+func (l *Locales) Negotiate(header string) []string {
+	chain := []string{}
+	seen := map[language.Tag]bool{}
+	add := func(tag language.Tag) {
+		for ; !tag.IsRoot(); tag = tag.Parent() {
+			for _, supported := range l.tags {
+				if supported == tag && !seen[tag] {
+					seen[tag] = true
+					chain = append(chain, tag.String())
+				}
+			}
+		}
+	}
+	accepted, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		log.Printf("ignoring Accept-Language %q: %v", header, err)
+	}
+	for _, tag := range accepted {
+		_, index, confidence := l.matcher.Match(tag)
+		if confidence == language.No {
+			continue
+		}
+		add(l.tags[index])
+	}
+	add(l.tags[0])
+	return chain
+}
+
+// catalogLocales returns the server's locales, or the default ones if it has none
+func (s *Server) catalogLocales() *Locales {
+	if s.locales == nil {
+		return defaultLocales
+	}
+	return s.locales
+}
+
+// negotiateLocales returns the chain of locales the request's content is looked up in
+func (s *Server) negotiateLocales(c *gin.Context) []string {
+	return s.catalogLocales().Negotiate(c.GetHeader("Accept-Language"))
+}
+
+// validTranslations reports whether every translation is into a supported locale, written
+// as its canonical tag
+func (s *Server) validTranslations(translations map[string]pkg.Translation) bool {
+	for locale := range translations {
+		canonical, ok := s.catalogLocales().Supports(locale)
+		if !ok || canonical != locale {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleGetTranslations returns the translations of the named plant, keyed by locale
+func (s *Server) HandleGetTranslations(c *gin.Context) {
+	plant, err := s.db.GetPlant(c.Param("name"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, func() (*pkg.Resource, error) { return plant.Resource(), nil }) {
+		return
+	}
+	translations := plant.Translations
+	if translations == nil {
+		translations = map[string]pkg.Translation{}
+	}
+	c.JSON(http.StatusOK, translations)
+}
+
+// HandlePutTranslation sets the named plant's common name and description in a locale, e.g.
+// PUT /v1/plant/monstera/translations/es {"common_name": "costilla de Adán", "description": "..."}
+func (s *Server) HandlePutTranslation(c *gin.Context) {
+	locale, ok := s.catalogLocales().Supports(c.Param("locale"))
+	if !ok {
+		log.Printf("unsupported translation locale %q", c.Param("locale"))
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var translation pkg.Translation
+	err := json.NewDecoder(c.Request.Body).Decode(&translation)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if translation.CommonName == "" && translation.Description == "" {
+		log.Println("translation missing common name and description")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.translatePlant(c, locale, &translation)
+}
+
+// HandleDeleteTranslation removes the named plant's translation into a locale
+func (s *Server) HandleDeleteTranslation(c *gin.Context) {
+	locale, ok := s.catalogLocales().Supports(c.Param("locale"))
+	if !ok {
+		log.Printf("unsupported translation locale %q", c.Param("locale"))
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.translatePlant(c, locale, nil)
+}
+
+// translatePlant sets the named plant's translation into the locale, or removes it if the
+// translation is nil. Under moderation the change is held for review like any other.
+func (s *Server) translatePlant(c *gin.Context, locale string, translation *pkg.Translation) {
+	name := c.Param("name")
+	if !s.authorize(c, pkg.PermissionUpdatePlants, s.plantResource(c, name)) {
+		return
+	}
+	previous, err := s.db.GetPlant(name, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	plant := *previous
+	// an empty map rather than nil, so that removing the last translation is written
+	plant.Translations = map[string]pkg.Translation{}
+	for key, value := range previous.Translations {
+		plant.Translations[key] = value
+	}
+	if translation == nil {
+		if _, ok := plant.Translations[locale]; !ok {
+			log.Printf("%s has no %s translation", name, locale)
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(plant.Translations, locale)
+	} else {
+		plant.Translations[locale] = *translation
+	}
+	if s.holdForReview(c, plant) {
+		return
+	}
+	err = s.db.UpdatePlant(plant, middleware.GetSubject(c.Request), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plant.Translations)
+	s.recordAudit(c, audit.ActionUpdate, plant.Name, previous, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, plant)
+}
+
+// HandleGetMissingTranslations reports the plants not fully translated into each locale, or
+// only into those given, e.g. GET /v1/translations/missing?locale=es&locale=fr
+func (s *Server) HandleGetMissingTranslations(c *gin.Context) {
+	locales := s.catalogLocales().Translated()
+	if requested := c.QueryArray("locale"); len(requested) > 0 {
+		locales = []string{}
+		for _, locale := range requested {
+			canonical, ok := s.catalogLocales().Supports(locale)
+			if !ok {
+				log.Printf("unsupported translation locale %q", locale)
+				c.Writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			locales = append(locales, canonical)
+		}
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	plants, err := s.db.ListPlants(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	reports := []pkg.TranslationReport{}
+	for _, locale := range locales {
+		reports = append(reports, pkg.ReportMissingTranslations(plants, locale))
+	}
+	c.JSON(http.StatusOK, reports)
+}
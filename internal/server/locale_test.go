@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLocales_Negotiate(t *testing.T) {
+	locales := NewLocales([]string{"en", "es", "es-MX", "fr", "de"})
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{
+			name: "negotiate falls back to the default without an Accept-Language",
+			want: []string{"en"},
+		},
+		{
+			name:   "negotiate follows the client's preferences and ends with the default",
+			header: "fr;q=0.5, de",
+			want:   []string{"de", "fr", "en"},
+		},
+		{
+			name:   "negotiate falls back from a regional locale to its language",
+			header: "es-MX",
+			want:   []string{"es-MX", "es", "en"},
+		},
+		{
+			name:   "negotiate matches a region which is not supported to the closest which is",
+			header: "es-AR, de;q=0.1",
+			want:   []string{"es-MX", "es", "de", "en"},
+		},
+		{
+			name:   "negotiate matches a language's other regions to the language",
+			header: "es-ES",
+			want:   []string{"es", "en"},
+		},
+		{
+			name:   "negotiate skips unsupported languages",
+			header: "ja, fr;q=0.8",
+			want:   []string{"fr", "en"},
+		},
+		{
+			name:   "negotiate ignores a malformed header",
+			header: "not a;;language",
+			want:   []string{"en"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := locales.Negotiate(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Locales.Negotiate(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetPlant_Localized(t *testing.T) {
+	plant := &pkg.Plant{Name: "monstera", Description: "big leaves", Translations: map[string]pkg.Translation{
+		"es": {CommonName: "costilla de Adán", Description: "hojas grandes"},
+		"fr": {CommonName: "monstéra"},
+	}}
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		commonName     string
+		description    string
+		locale         string
+	}{
+		{
+			name:        "handle get plant serves the plant's own content by default",
+			commonName:  "monstera",
+			description: "big leaves",
+			locale:      "en",
+		},
+		{
+			name:           "handle get plant serves a translation the client accepts",
+			acceptLanguage: "es-ES,es;q=0.9",
+			commonName:     "costilla de Adán",
+			description:    "hojas grandes",
+			locale:         "es",
+		},
+		{
+			name:           "handle get plant falls back field by field through the client's languages",
+			acceptLanguage: "fr, es;q=0.5",
+			commonName:     "monstéra",
+			description:    "hojas grandes",
+			locale:         "es",
+		},
+		{
+			name:           "handle get plant falls back to the plant's own content",
+			acceptLanguage: "de",
+			commonName:     "monstera",
+			description:    "big leaves",
+			locale:         "en",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/plant/monstera", nil)
+			if tt.acceptLanguage != "" {
+				c.Request.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "monstera"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(plant, nil)
+			s := &Server{db: mockDB}
+			s.HandleGetPlant(c)
+			if w.Code != 200 {
+				t.Fatalf("HandleGetPlant response code: %d, expected 200", w.Code)
+			}
+			var got pkg.LocalizedPlant
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the plant's own description is kept, with the translation alongside it
+			if got.Name != "monstera" || got.Description != "big leaves" || got.CommonName != tt.commonName || got.LocalizedDescription != tt.description || got.Locale != tt.locale || got.Translations != nil {
+				t.Errorf("HandleGetPlant returned %+v, expected %s, %s in %s", got, tt.commonName, tt.description, tt.locale)
+			}
+			if language := w.Header().Get("Content-Language"); language != tt.locale {
+				t.Errorf("HandleGetPlant Content-Language: %q, expected %q", language, tt.locale)
+			}
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[len(vary)-1] != "Accept-Language" {
+				t.Errorf("HandleGetPlant Vary: %v, expected Accept-Language", vary)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePutTranslation(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		body   string
+		code   int
+		want   map[string]pkg.Translation
+	}{
+		{
+			name:   "handle put translation adds a translation",
+			locale: "es",
+			body:   `{"common_name": "costilla de Adán", "description": "hojas grandes"}`,
+			code:   200,
+			want: map[string]pkg.Translation{
+				"es": {CommonName: "costilla de Adán", Description: "hojas grandes"},
+				"fr": {CommonName: "monstéra"},
+			},
+		},
+		{
+			name:   "handle put translation replaces a translation, writing the locale's canonical tag",
+			locale: "FR",
+			body:   `{"common_name": "faux philodendron"}`,
+			code:   200,
+			want: map[string]pkg.Translation{
+				"fr": {CommonName: "faux philodendron"},
+			},
+		},
+		{
+			name:   "handle put translation fails for an unsupported locale",
+			locale: "ja",
+			body:   `{"common_name": "モンステラ"}`,
+			code:   400,
+		},
+		{
+			name:   "handle put translation fails for the locale of the plant's own content",
+			locale: "en",
+			body:   `{"common_name": "monstera"}`,
+			code:   400,
+		},
+		{
+			name:   "handle put translation fails for an empty translation",
+			locale: "es",
+			body:   `{}`,
+			code:   400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPut, "/v1/plant/monstera/translations/"+tt.locale, bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "monstera"}, gin.Param{Key: "locale", Value: tt.locale})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(&pkg.Plant{Name: "monstera", Description: "big leaves", Translations: map[string]pkg.Translation{
+				"fr": {CommonName: "monstéra"},
+			}}, nil)
+			mockDB.On("UpdatePlant", mock.MatchedBy(func(plant pkg.Plant) bool {
+				return plant.Name == "monstera" && reflect.DeepEqual(plant.Translations, tt.want)
+			}), mock.Anything, mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandlePutTranslation(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePutTranslation response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "UpdatePlant", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestServer_HandleDeleteTranslation(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		code   int
+	}{
+		{
+			name:   "handle delete translation removes the last translation",
+			locale: "fr",
+			code:   200,
+		},
+		{
+			name:   "handle delete translation does not find a locale the plant is not translated into",
+			locale: "es",
+			code:   404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodDelete, "/v1/plant/monstera/translations/"+tt.locale, nil)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "monstera"}, gin.Param{Key: "locale", Value: tt.locale})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(&pkg.Plant{Name: "monstera", Description: "big leaves", Translations: map[string]pkg.Translation{
+				"fr": {CommonName: "monstéra"},
+			}}, nil)
+			// an empty map, not nil, so that the translation is removed rather than kept
+			mockDB.On("UpdatePlant", mock.MatchedBy(func(plant pkg.Plant) bool {
+				return plant.Translations != nil && len(plant.Translations) == 0
+			}), mock.Anything, mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleDeleteTranslation(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleDeleteTranslation response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetMissingTranslations(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		want  []pkg.TranslationReport
+	}{
+		{
+			name:  "handle get missing translations reports a requested locale",
+			query: "?locale=es",
+			code:  200,
+			want: []pkg.TranslationReport{
+				{Locale: "es", Plants: 2, Translated: 1, Missing: []pkg.MissingTranslation{{Name: "pothos", Fields: []string{"common_name", "description"}}}},
+			},
+		},
+		{
+			name: "handle get missing translations reports every translated locale by default",
+			code: 200,
+			want: []pkg.TranslationReport{
+				{Locale: "es", Plants: 2, Translated: 1, Missing: []pkg.MissingTranslation{{Name: "pothos", Fields: []string{"common_name", "description"}}}},
+				{Locale: "fr", Plants: 2, Translated: 0, Missing: []pkg.MissingTranslation{{Name: "monstera", Fields: []string{"description"}}, {Name: "pothos", Fields: []string{"common_name", "description"}}}},
+				{Locale: "de", Plants: 2, Translated: 0, Missing: []pkg.MissingTranslation{{Name: "monstera", Fields: []string{"common_name", "description"}}, {Name: "pothos", Fields: []string{"common_name", "description"}}}},
+			},
+		},
+		{
+			name:  "handle get missing translations fails for an unsupported locale",
+			query: "?locale=ja",
+			code:  400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/translations/missing"+tt.query, nil)
+			mockDB := new(db.MockDB)
+			mockDB.On("ListPlants", mock.Anything).Return([]pkg.Plant{
+				{Name: "pothos", Description: "trailing vine"},
+				{Name: "monstera", Description: "big leaves", Translations: map[string]pkg.Translation{
+					"es": {CommonName: "costilla de Adán", Description: "hojas grandes"},
+					"fr": {CommonName: "monstéra"},
+				}},
+			}, nil)
+			s := &Server{db: mockDB}
+			s.HandleGetMissingTranslations(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleGetMissingTranslations response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			var got []pkg.TranslationReport
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HandleGetMissingTranslations returned %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_HandleGetTranslations(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/plant/cactus/translations", nil)
+	c.Params = append(c.Params, gin.Param{Key: "name", Value: "cactus"})
+	mockDB := new(db.MockDB)
+	mockDB.On("GetPlant", "cactus", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+	s := &Server{db: mockDB}
+	s.HandleGetTranslations(c)
+	if c.Writer.Status() != 404 {
+		t.Errorf("HandleGetTranslations response code: %d, expected 404", c.Writer.Status())
+	}
+}
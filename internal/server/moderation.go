@@ -0,0 +1,553 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	submitSuffix    = ":submit"
+	approveSuffix   = ":approve"
+	rejectSuffix    = ":reject"
+	archiveSuffix   = ":archive"
+	unarchiveSuffix = ":unarchive"
+)
+
+// proposalRequest is the body of a request creating or editing a proposal
+type proposalRequest struct {
+	Plant pkg.Plant `json:"plant"`
+	// Comment is left on the proposal for reviewers
+	Comment string `json:"comment,omitempty"`
+	// Submit sends the proposal for review rather than keeping it as a draft
+	Submit bool `json:"submit,omitempty"`
+}
+
+// reviewRequest is the body of a request approving or rejecting a proposal
+type reviewRequest struct {
+	Comment string `json:"comment"`
+}
+
+// proposedPlant keeps the fields of a plant which a proposal may change. Images, the owner
+// and the plant's state are managed by their own requests.
+func proposedPlant(plant pkg.Plant) pkg.Plant {
+	return pkg.Plant{Name: plant.Name, Description: plant.Description, Care: plant.Care, Taxonomy: plant.Taxonomy, Synonyms: plant.Synonyms, Translations: plant.Translations}
+}
+
+// withChanges fills in the fields the proposal changes from its base
+func withChanges(proposal pkg.Proposal) pkg.Proposal {
+	base := pkg.Plant{}
+	if proposal.Base != nil {
+		base = proposedPlant(*proposal.Base)
+	}
+	proposal.Changes = pkg.DiffPlants(base, proposal.Plant)
+	return proposal
+}
+
+// newProposal builds a proposal by the caller for the plant, updating the published plant
+// of the same name if there is one and otherwise creating it
+func (s *Server) newProposal(c *gin.Context, plant pkg.Plant) (*pkg.Proposal, error) {
+	now := time.Now().UTC()
+	proposal := &pkg.Proposal{
+		ID:        newID(),
+		PlantName: plant.Name,
+		Kind:      pkg.ProposalCreate,
+		Status:    pkg.ProposalDraft,
+		Plant:     proposedPlant(plant),
+		Author:    middleware.GetSubject(c.Request),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	published, err := s.db.GetPlant(plant.Name, c)
+	if err != nil && err.Error() != db.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		proposal.Kind = pkg.ProposalUpdate
+		proposal.Base = published
+		// as when it is published, a change without translations keeps the plant's own
+		if proposal.Plant.Translations == nil {
+			proposal.Plant.Translations = published.Translations
+		}
+	}
+	return proposal, nil
+}
+
+// authorizeProposal checks the caller may make the change the proposal holds
+func (s *Server) authorizeProposal(c *gin.Context, proposal *pkg.Proposal) bool {
+	if proposal.Kind == pkg.ProposalCreate {
+		return s.authorize(c, pkg.PermissionCreatePlants, nil)
+	}
+	return s.authorize(c, pkg.PermissionUpdatePlants, func() (*pkg.Resource, error) { return proposal.Base.Resource(), nil })
+}
+
+// submit sends the proposal for review
+func submit(proposal *pkg.Proposal, now time.Time) {
+	proposal.Status = pkg.ProposalInReview
+	proposal.SubmittedAt = &now
+	proposal.UpdatedAt = now
+}
+
+// comment leaves a comment by the caller on the proposal, if there is one
+func comment(c *gin.Context, proposal *pkg.Proposal, text string, now time.Time) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	proposal.Comments = append(proposal.Comments, pkg.Comment{Author: middleware.GetSubject(c.Request), Text: text, CreatedAt: now})
+}
+
+// holdForReview proposes a change for review instead of publishing it, when moderation is
+// on and the caller may not publish, and responds with the proposal. It reports whether the
+// request has been answered.
+func (s *Server) holdForReview(c *gin.Context, plant pkg.Plant) bool {
+	if !s.moderation {
+		return false
+	}
+	allowed, err := s.permitted(c, pkg.PermissionPublishPlants, nil)
+	if err != nil {
+		writeDBError(c, err)
+		return true
+	}
+	if allowed {
+		return false
+	}
+	proposal, err := s.newProposal(c, plant)
+	if err != nil {
+		writeDBError(c, err)
+		return true
+	}
+	submit(proposal, proposal.CreatedAt)
+	err = s.db.CreateProposal(*proposal, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	c.JSON(http.StatusAccepted, withChanges(*proposal))
+	s.recordAudit(c, audit.ActionPropose, plant.Name, nil, proposal.Plant)
+	return true
+}
+
+// HandleCreateProposal proposes a new plant, or a change to a published one, as a draft or
+// for review, e.g. POST /v1/proposals {"plant": {...}, "submit": true}
+func (s *Server) HandleCreateProposal(c *gin.Context) {
+	var request proposalRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if request.Plant.Name == "" || request.Plant.Description == "" {
+		log.Println("proposal request missing name or description")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	proposal, err := s.newProposal(c, request.Plant)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if !s.authorizeProposal(c, proposal) {
+		return
+	}
+	comment(c, proposal, request.Comment, proposal.CreatedAt)
+	if request.Submit {
+		submit(proposal, proposal.CreatedAt)
+	}
+	err = s.db.CreateProposal(*proposal, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, withChanges(*proposal))
+	if request.Submit {
+		s.recordAudit(c, audit.ActionPropose, proposal.PlantName, nil, proposal.Plant)
+	}
+}
+
+// HandleListProposals lists the caller's proposals, optionally only those in a ?status=
+func (s *Server) HandleListProposals(c *gin.Context) {
+	proposals, err := s.db.ListProposals(middleware.GetSubject(c.Request), c.Query("status"), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(proposals, func(i, j int) bool { return proposals[i].CreatedAt.Before(proposals[j].CreatedAt) })
+	for i := range proposals {
+		proposals[i] = withChanges(proposals[i])
+	}
+	c.JSON(http.StatusOK, proposals)
+}
+
+// HandleGetModerationQueue lists the proposals awaiting review, longest waiting first
+func (s *Server) HandleGetModerationQueue(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReviewPlants, nil) {
+		return
+	}
+	proposals, err := s.db.ListProposals("", pkg.ProposalInReview, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(proposals, func(i, j int) bool { return submittedBefore(proposals[i], proposals[j]) })
+	for i := range proposals {
+		proposals[i] = withChanges(proposals[i])
+	}
+	c.JSON(http.StatusOK, proposals)
+}
+
+func submittedBefore(a pkg.Proposal, b pkg.Proposal) bool {
+	if a.SubmittedAt == nil || b.SubmittedAt == nil {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.SubmittedAt.Before(*b.SubmittedAt)
+}
+
+// getProposal returns a proposal the caller may see: their own, or any if they review
+// proposals. Others' proposals are not found.
+func (s *Server) getProposal(c *gin.Context, id string) (*pkg.Proposal, error) {
+	proposal, err := s.db.GetProposal(id, c)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Author == middleware.GetSubject(c.Request) {
+		return proposal, nil
+	}
+	allowed, err := s.permitted(c, pkg.PermissionReviewPlants, func() (*pkg.Resource, error) { return proposal.Resource(), nil })
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New(db.ErrNotFound)
+	}
+	return proposal, nil
+}
+
+// getOwnProposal returns one of the caller's proposals which they may still change
+func (s *Server) getOwnProposal(c *gin.Context, id string) (*pkg.Proposal, bool) {
+	proposal, err := s.db.GetProposal(id, c)
+	if err == nil && proposal.Author != middleware.GetSubject(c.Request) {
+		err = errors.New(db.ErrNotFound)
+	}
+	if err != nil {
+		writeDBError(c, err)
+		return nil, false
+	}
+	if proposal.Status != pkg.ProposalDraft && proposal.Status != pkg.ProposalRejected {
+		log.Printf("proposal %s is %s and cannot be changed by its author", proposal.ID, proposal.Status)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return nil, false
+	}
+	return proposal, true
+}
+
+func (s *Server) HandleGetProposal(c *gin.Context) {
+	proposal, err := s.getProposal(c, c.Param("id"))
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, withChanges(*proposal))
+}
+
+// HandleUpdateProposal edits a draft or rejected proposal, which becomes a draft again
+// unless it is submitted
+func (s *Server) HandleUpdateProposal(c *gin.Context) {
+	var request proposalRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if request.Plant.Description == "" {
+		log.Println("proposal request missing description")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	proposal, ok := s.getOwnProposal(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if request.Plant.Name != "" && request.Plant.Name != proposal.PlantName {
+		log.Println("a proposal cannot change which plant it is for")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the proposal is made against the plant as it is now, so a rejected proposal revised
+	// after the plant changed can still be approved
+	request.Plant.Name = proposal.PlantName
+	current, err := s.newProposal(c, request.Plant)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	proposal.Kind, proposal.Base, proposal.Plant = current.Kind, current.Base, current.Plant
+	if !s.authorizeProposal(c, proposal) {
+		return
+	}
+	status := proposal.Status
+	now := time.Now().UTC()
+	proposal.Status = pkg.ProposalDraft
+	proposal.UpdatedAt = now
+	comment(c, proposal, request.Comment, now)
+	if request.Submit {
+		submit(proposal, now)
+	}
+	err = s.db.UpdateProposal(*proposal, status, c)
+	if err != nil {
+		writeProposalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, withChanges(*proposal))
+	if request.Submit {
+		s.recordAudit(c, audit.ActionPropose, proposal.PlantName, nil, proposal.Plant)
+	}
+}
+
+// HandleDeleteProposal withdraws one of the caller's proposals which has not been approved
+func (s *Server) HandleDeleteProposal(c *gin.Context) {
+	proposal, err := s.db.GetProposal(c.Param("id"), c)
+	if err == nil && proposal.Author != middleware.GetSubject(c.Request) {
+		err = errors.New(db.ErrNotFound)
+	}
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if proposal.Status == pkg.ProposalApproved {
+		log.Printf("proposal %s has been approved and cannot be withdrawn", proposal.ID)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	proposal, err = s.db.DeleteProposal(proposal.ID, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, proposal)
+}
+
+// HandleProposalAction handles custom methods on a proposal: POST /v1/proposals/{id}:submit
+// by its author, and :approve or :reject by a reviewer with a {"comment": "..."} body
+func (s *Server) HandleProposalAction(c *gin.Context) {
+	param := c.Param("id")
+	switch {
+	case strings.HasSuffix(param, submitSuffix):
+		s.submitProposal(c, strings.TrimSuffix(param, submitSuffix))
+	case strings.HasSuffix(param, approveSuffix):
+		s.reviewProposal(c, strings.TrimSuffix(param, approveSuffix), true)
+	case strings.HasSuffix(param, rejectSuffix):
+		s.reviewProposal(c, strings.TrimSuffix(param, rejectSuffix), false)
+	default:
+		log.Println("unknown proposal action " + param)
+		c.Writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) submitProposal(c *gin.Context, id string) {
+	proposal, ok := s.getOwnProposal(c, id)
+	if !ok {
+		return
+	}
+	status := proposal.Status
+	submit(proposal, time.Now().UTC())
+	err := s.db.UpdateProposal(*proposal, status, c)
+	if err != nil {
+		writeProposalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, withChanges(*proposal))
+	s.recordAudit(c, audit.ActionPropose, proposal.PlantName, nil, proposal.Plant)
+}
+
+// reviewProposal approves a proposal in review, publishing its plant, or rejects it. A
+// rejection must say why. The proposal is decided before the plant is published so two
+// reviewers cannot both publish it.
+func (s *Server) reviewProposal(c *gin.Context, id string, approve bool) {
+	var request reviewRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !approve && strings.TrimSpace(request.Comment) == "" {
+		log.Println("reject request missing comment")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReviewPlants, nil) {
+		return
+	}
+	proposal, err := s.db.GetProposal(id, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if proposal.Status != pkg.ProposalInReview {
+		log.Printf("proposal %s is %s, not in review", proposal.ID, proposal.Status)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	var published *pkg.Plant
+	if approve {
+		published, err = s.checkPublishable(c, proposal)
+		if err != nil {
+			writeProposalError(c, err)
+			return
+		}
+		if proposal.Kind == pkg.ProposalCreate && !s.withinPlantQuota(c) {
+			return
+		}
+	}
+	undecided := *proposal
+	now := time.Now().UTC()
+	proposal.Status = pkg.ProposalRejected
+	if approve {
+		proposal.Status = pkg.ProposalApproved
+	}
+	proposal.Reviewer = middleware.GetSubject(c.Request)
+	proposal.ReviewedAt = &now
+	proposal.UpdatedAt = now
+	comment(c, proposal, request.Comment, now)
+	err = s.db.UpdateProposal(*proposal, pkg.ProposalInReview, c)
+	if err != nil {
+		writeProposalError(c, err)
+		return
+	}
+	if !approve {
+		c.JSON(http.StatusOK, withChanges(*proposal))
+		s.recordAudit(c, audit.ActionReject, proposal.PlantName, nil, nil)
+		return
+	}
+	plant, err := s.publishProposal(c, proposal)
+	if err != nil {
+		// put the proposal back in review so it can be approved again
+		if err := s.db.UpdateProposal(undecided, pkg.ProposalApproved, c); err != nil {
+			log.Printf("failed to return proposal %s to review: %v", proposal.ID, err)
+		}
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, withChanges(*proposal))
+	s.recordAudit(c, audit.ActionApprove, proposal.PlantName, published, plant)
+	if proposal.Kind == pkg.ProposalCreate {
+		s.publishEvent(c, pkg.EventPlantCreated, plant)
+	} else {
+		s.publishEvent(c, pkg.EventPlantUpdated, plant)
+	}
+}
+
+// errStaleProposal is returned when the catalog has changed since a proposal was made, so
+// publishing it would undo the change
+var errStaleProposal = errors.New("the plant has changed since the proposal was made")
+
+// checkPublishable checks the proposal can still be published as it was made, returning the
+// plant it replaces, if any. A new plant must not have been published since, nor an update's
+// plant changed or withdrawn.
+func (s *Server) checkPublishable(c *gin.Context, proposal *pkg.Proposal) (*pkg.Plant, error) {
+	published, err := s.db.GetPlant(proposal.PlantName, c)
+	if err != nil && err.Error() != db.ErrNotFound {
+		return nil, err
+	}
+	if proposal.Kind == pkg.ProposalCreate {
+		if published != nil {
+			return nil, errStaleProposal
+		}
+		return nil, nil
+	}
+	if published == nil || proposal.Base == nil || !sameTime(published.UpdatedAt, proposal.Base.UpdatedAt) {
+		return nil, errStaleProposal
+	}
+	return published, nil
+}
+
+// publishProposal writes the proposal's plant to the catalog, crediting its author
+func (s *Server) publishProposal(c *gin.Context, proposal *pkg.Proposal) (pkg.Plant, error) {
+	plant := proposal.Plant
+	if proposal.Kind == pkg.ProposalCreate {
+		plant.Owner = proposal.Author
+		return plant, s.db.CreatePlant(plant, proposal.Author, c)
+	}
+	return plant, s.db.UpdatePlant(plant, proposal.Author, c)
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// writeProposalError responds with conflict when a proposal or its plant changed under the
+// request
+func writeProposalError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrProposalChanged) || errors.Is(err, errStaleProposal) {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	writeDBError(c, err)
+}
+
+// HandleListArchive lists the plants withdrawn from the published catalog
+func (s *Server) HandleListArchive(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReviewPlants, nil) {
+		return
+	}
+	plants, err := s.db.ListArchive(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, plants)
+}
+
+// archivePlant withdraws a published plant from the catalog, or publishes an archived one
+// again
+func (s *Server) archivePlant(c *gin.Context, name string, archive bool) {
+	if name == "" {
+		log.Println("archive request missing name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReviewPlants, nil) {
+		return
+	}
+	if archive {
+		plant, err := s.db.ArchivePlant(name, middleware.GetSubject(c.Request), c)
+		if err != nil {
+			writeDBError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, plant)
+		s.recordAudit(c, audit.ActionArchive, plant.Name, nil, plant)
+		s.publishEvent(c, pkg.EventPlantArchived, *plant)
+		return
+	}
+	plant, err := s.db.UnarchivePlant(name, middleware.GetSubject(c.Request), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionUnarchive, plant.Name, nil, plant)
+	s.publishEvent(c, pkg.EventPlantUnarchived, *plant)
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+// newModeratedServer returns a server with moderation on whose callers have the given roles
+func newModeratedServer(mockDB *db.MockDB, roles map[string]string) *Server {
+	for subject, role := range roles {
+		mockDB.On("GetRoleAssignments", subject, mock.Anything).Return([]pkg.RoleAssignment{{Subject: subject, Role: role}}, nil)
+	}
+	mockDB.On("GetRole", mock.Anything, mock.Anything).Return((*pkg.Role)(nil), errors.New(db.ErrNotFound))
+	return &Server{db: mockDB, policy: policy.NewEngine(mockDB, []string{policy.RoleViewer}), moderation: true}
+}
+
+func TestServer_HandleCreatePlant_Moderation(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		code    int
+		held    bool
+	}{
+		{
+			name:    "handle create plant holds a contributor's plant for review",
+			subject: "alice",
+			code:    202,
+			held:    true,
+		},
+		{
+			name:    "handle create plant publishes an editor's plant",
+			subject: "erin",
+			code:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant", bytes.NewBufferString(`{"name": "monstera", "description": "big leaves"}`)), tt.subject, "")
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("CreateProposal", mock.MatchedBy(func(proposal pkg.Proposal) bool {
+				return proposal.Kind == pkg.ProposalCreate && proposal.Status == pkg.ProposalInReview && proposal.Author == "alice"
+			}), mock.Anything).Return(nil)
+			mockDB.On("CreatePlant", mock.Anything, tt.subject, mock.Anything).Return(nil)
+			s := newModeratedServer(mockDB, map[string]string{"alice": policy.RoleContributor, "erin": policy.RoleEditor})
+			s.HandleCreatePlant(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreatePlant response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.held {
+				mockDB.AssertCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "CreatePlant", mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				mockDB.AssertNotCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestServer_HandleCreatePlant_LegacyModeration(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		code  int
+		held  bool
+	}{
+		{
+			name: "handle create plant holds a plant for review with roles off",
+			code: 202,
+			held: true,
+		},
+		{
+			name:  "handle create plant publishes the plant of a caller with the publish scope with roles off",
+			scope: pkg.PermissionPublishPlants,
+			code:  200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant", bytes.NewBufferString(`{"name": "monstera", "description": "big leaves"}`)), "alice", tt.scope)
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("CreateProposal", mock.Anything, mock.Anything).Return(nil)
+			mockDB.On("CreatePlant", mock.Anything, "alice", mock.Anything).Return(nil)
+			s := &Server{db: mockDB, moderation: true}
+			s.HandleCreatePlant(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreatePlant response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.held {
+				mockDB.AssertCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "CreatePlant", mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				mockDB.AssertNotCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+			}
+			// callers may still review what is held
+			allowed, err := s.permitted(c, pkg.PermissionReviewPlants, nil)
+			if err != nil || !allowed {
+				t.Errorf("permitted(%s) = %v, %v, want callers to review with roles off", pkg.PermissionReviewPlants, allowed, err)
+			}
+		})
+	}
+}
+
+func TestServer_HandleProposalAction(t *testing.T) {
+	published := time.Unix(100, 0).UTC()
+	changed := time.Unix(200, 0).UTC()
+	tests := []struct {
+		name      string
+		param     string
+		subject   string
+		body      string
+		proposal  *pkg.Proposal
+		plant     *pkg.Plant
+		code      int
+		published bool
+	}{
+		{
+			name:     "handle proposal action fails if action is unknown",
+			param:    "1:merge",
+			subject:  "mod",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalInReview},
+			code:     404,
+		},
+		{
+			name:      "handle proposal action approves a new plant and publishes it",
+			param:     "1:approve",
+			subject:   "mod",
+			proposal:  &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalInReview, Author: "alice", Plant: pkg.Plant{Name: "monstera", Description: "big leaves"}},
+			code:      200,
+			published: true,
+		},
+		{
+			name:      "handle proposal action approves an update made against the published plant",
+			param:     "1:approve",
+			subject:   "mod",
+			body:      `{"comment": "thanks"}`,
+			proposal:  &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalUpdate, Status: pkg.ProposalInReview, Author: "alice", Plant: pkg.Plant{Name: "monstera", Description: "bigger leaves"}, Base: &pkg.Plant{Name: "monstera", Description: "big leaves", UpdatedAt: &published}},
+			plant:     &pkg.Plant{Name: "monstera", Description: "big leaves", UpdatedAt: &published},
+			code:      200,
+			published: true,
+		},
+		{
+			name:     "handle proposal action fails to approve an update once the plant has changed",
+			param:    "1:approve",
+			subject:  "mod",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalUpdate, Status: pkg.ProposalInReview, Author: "alice", Plant: pkg.Plant{Name: "monstera", Description: "bigger leaves"}, Base: &pkg.Plant{Name: "monstera", Description: "big leaves", UpdatedAt: &published}},
+			plant:    &pkg.Plant{Name: "monstera", Description: "huge leaves", UpdatedAt: &changed},
+			code:     409,
+		},
+		{
+			name:     "handle proposal action fails to approve a proposal which is not in review",
+			param:    "1:approve",
+			subject:  "mod",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalDraft, Author: "alice"},
+			code:     409,
+		},
+		{
+			name:     "handle proposal action forbids a contributor approving",
+			param:    "1:approve",
+			subject:  "alice",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalInReview, Author: "alice"},
+			code:     403,
+		},
+		{
+			name:     "handle proposal action fails to reject without a comment",
+			param:    "1:reject",
+			subject:  "mod",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalInReview, Author: "alice"},
+			code:     400,
+		},
+		{
+			name:     "handle proposal action rejects with a comment",
+			param:    "1:reject",
+			subject:  "mod",
+			body:     `{"comment": "please add care details"}`,
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalInReview, Author: "alice"},
+			code:     200,
+		},
+		{
+			name:     "handle proposal action submits the author's draft",
+			param:    "1:submit",
+			subject:  "alice",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalDraft, Author: "alice"},
+			code:     200,
+		},
+		{
+			name:     "handle proposal action does not find another's draft to submit",
+			param:    "1:submit",
+			subject:  "dave",
+			proposal: &pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: pkg.ProposalDraft, Author: "alice"},
+			code:     404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/proposals/"+tt.param, bytes.NewBufferString(tt.body)), tt.subject, "")
+			c.Params = append(c.Params, gin.Param{Key: "id", Value: tt.param})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetProposal", "1", mock.Anything).Return(tt.proposal, nil)
+			mockDB.On("UpdateProposal", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			if tt.plant != nil {
+				mockDB.On("GetPlant", "monstera", mock.Anything).Return(tt.plant, nil)
+			} else {
+				mockDB.On("GetPlant", "monstera", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			}
+			mockDB.On("CreatePlant", mock.MatchedBy(func(plant pkg.Plant) bool { return plant.Owner == "alice" }), "alice", mock.Anything).Return(nil)
+			mockDB.On("UpdatePlant", mock.Anything, "alice", mock.Anything).Return(nil)
+			s := newModeratedServer(mockDB, map[string]string{"alice": policy.RoleContributor, "mod": policy.RoleModerator, "dave": policy.RoleContributor})
+			s.HandleProposalAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleProposalAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.published {
+				mockDB.AssertCalled(t, "UpdateProposal", mock.MatchedBy(func(proposal pkg.Proposal) bool {
+					return proposal.Status == pkg.ProposalApproved && proposal.Reviewer == "mod"
+				}), pkg.ProposalInReview, mock.Anything)
+			} else {
+				mockDB.AssertNotCalled(t, "CreatePlant", mock.Anything, mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "UpdatePlant", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestServer_HandleUpdateProposal(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		code   int
+	}{
+		{
+			name:   "handle update proposal revises a rejected proposal as a draft",
+			status: pkg.ProposalRejected,
+			code:   200,
+		},
+		{
+			name:   "handle update proposal fails while the proposal is in review",
+			status: pkg.ProposalInReview,
+			code:   409,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPut, "/v1/proposals/1", bytes.NewBufferString(`{"plant": {"description": "big leaves"}}`)), "alice", "")
+			c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetProposal", "1", mock.Anything).Return(&pkg.Proposal{ID: "1", PlantName: "monstera", Kind: pkg.ProposalCreate, Status: tt.status, Author: "alice"}, nil)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("UpdateProposal", mock.MatchedBy(func(proposal pkg.Proposal) bool {
+				return proposal.Status == pkg.ProposalDraft && proposal.Plant.Description == "big leaves"
+			}), tt.status, mock.Anything).Return(nil)
+			s := newModeratedServer(mockDB, map[string]string{"alice": policy.RoleContributor})
+			s.HandleUpdateProposal(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleUpdateProposal response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/SevvyP/plants/internal/care"
+	"github.com/SevvyP/plants/internal/notify"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// validatePreferences checks the preferences a user sent are complete and well formed
+func validatePreferences(preferences pkg.NotificationPreferences) error {
+	_, err := time.LoadLocation(preferences.Timezone)
+	if err != nil || preferences.Timezone == "" {
+		return errors.New("unknown timezone " + preferences.Timezone)
+	}
+	if preferences.Hemisphere != "" && preferences.Hemisphere != care.HemisphereNorth && preferences.Hemisphere != care.HemisphereSouth {
+		return errors.New("invalid hemisphere " + preferences.Hemisphere)
+	}
+	if preferences.DigestHour < 0 || preferences.DigestHour > 23 {
+		return errors.New("digest hour must be between 0 and 23")
+	}
+	if preferences.QuietHours != nil {
+		_, startErr := notify.ParseClock(preferences.QuietHours.Start)
+		_, endErr := notify.ParseClock(preferences.QuietHours.End)
+		if startErr != nil || endErr != nil {
+			return errors.New("quiet hours must be given as HH:MM")
+		}
+	}
+	for _, channel := range preferences.Channels {
+		switch channel.Kind {
+		case pkg.ChannelWebhook:
+			parsed, err := url.Parse(channel.URL)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return errors.New("webhook channels need an http or https url")
+			}
+		case pkg.ChannelEmail:
+			_, err := mail.ParseAddress(channel.Email)
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.New("unknown channel kind " + channel.Kind)
+		}
+	}
+	return nil
+}
+
+func (s *Server) HandleGetNotificationPreferences(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	preferences, err := s.db.GetNotificationPreferences(userID, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, preferences)
+}
+
+// HandlePutNotificationPreferences replaces the caller's preferences. Webhook channels
+// without a secret keep the secret they had for the same url, or are given a new one.
+func (s *Server) HandlePutNotificationPreferences(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	var preferences pkg.NotificationPreferences
+	err := json.NewDecoder(c.Request.Body).Decode(&preferences)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = validatePreferences(preferences)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	preferences.UserID = userID
+	secrets := map[string]string{}
+	existing, err := s.db.GetNotificationPreferences(userID, c)
+	if err == nil {
+		for _, channel := range existing.Channels {
+			secrets[channel.URL] = channel.Secret
+		}
+	}
+	for i := range preferences.Channels {
+		channel := &preferences.Channels[i]
+		if channel.Kind != pkg.ChannelWebhook || channel.Secret != "" {
+			continue
+		}
+		channel.Secret = secrets[channel.URL]
+		if channel.Secret == "" {
+			b := make([]byte, 32)
+			rand.Read(b)
+			channel.Secret = hex.EncodeToString(b)
+		}
+	}
+	err = s.db.PutNotificationPreferences(preferences, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, preferences)
+}
+
+// HandleGetDeliveries lists the reminders sent to the caller, only those with the given
+// status if there is a status query parameter, e.g. status=dead for the dead letters
+func (s *Server) HandleGetDeliveries(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	deliveries, err := s.db.GetDeliveries(userID, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status := c.Query("status"); status != "" {
+		filtered := []pkg.Delivery{}
+		for _, delivery := range deliveries {
+			if delivery.Status == status {
+				filtered = append(filtered, delivery)
+			}
+		}
+		deliveries = filtered
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandlePutNotificationPreferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		existing *pkg.NotificationPreferences
+		code     int
+		verify   func(pkg.NotificationPreferences) bool
+	}{
+		{
+			name: "handle put notification preferences fails if the timezone is unknown",
+			body: `{"timezone":"Nowhere/Special","channels":[]}`,
+			code: 400,
+		},
+		{
+			name: "handle put notification preferences fails if quiet hours are malformed",
+			body: `{"timezone":"UTC","quiet_hours":{"start":"10pm","end":"07:00"}}`,
+			code: 400,
+		},
+		{
+			name: "handle put notification preferences fails if a webhook url is not http",
+			body: `{"timezone":"UTC","channels":[{"kind":"webhook","url":"file:///etc/passwd"}]}`,
+			code: 400,
+		},
+		{
+			name: "handle put notification preferences generates a webhook secret",
+			body: `{"timezone":"UTC","channels":[{"kind":"webhook","url":"https://example.com/hook"},{"kind":"email","email":"me@example.com"}]}`,
+			code: 200,
+			verify: func(p pkg.NotificationPreferences) bool {
+				return p.UserID == "user" && len(p.Channels[0].Secret) == 64 && p.Channels[1].Secret == ""
+			},
+		},
+		{
+			name: "handle put notification preferences keeps the secret of an existing webhook",
+			body: `{"timezone":"UTC","channels":[{"kind":"webhook","url":"https://example.com/hook"}]}`,
+			existing: &pkg.NotificationPreferences{UserID: "user", Channels: []pkg.NotificationChannel{
+				{Kind: pkg.ChannelWebhook, URL: "https://example.com/hook", Secret: "kept"},
+			}},
+			code:   200,
+			verify: func(p pkg.NotificationPreferences) bool { return p.Channels[0].Secret == "kept" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest("PUT", "/v1/me/notifications", strings.NewReader(tt.body)), "user", "")
+			mockDB := new(db.MockDB)
+			if tt.existing != nil {
+				mockDB.On("GetNotificationPreferences", "user", c).Return(tt.existing, nil)
+			} else {
+				mockDB.On("GetNotificationPreferences", "user", c).Return((*pkg.NotificationPreferences)(nil), errors.New(db.ErrNotFound))
+			}
+			if tt.verify != nil {
+				mockDB.On("PutNotificationPreferences", mock.MatchedBy(tt.verify), c).Return(nil)
+			}
+			s := &Server{db: mockDB}
+			s.HandlePutNotificationPreferences(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePutNotificationPreferences response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// legacyPolicy is used when role based access control is off. Every caller may do what any
+// caller could before roles existed, and purging still needs the purge:plants scope.
+// Publishing without review is not one of those things, so under moderation only callers
+// with the publish:plants scope skip review.
+var legacyPolicy = policy.NewEngine(legacyRoles{}, []string{policy.RoleModerator})
+
+// legacyRoles stores no roles or assignments of its own. It serves the built in roles, with
+// the moderator role limited to reviewing changes rather than publishing them.
+type legacyRoles struct{}
+
+func (legacyRoles) GetRole(name string, ctx context.Context) (*pkg.Role, error) {
+	role, ok := policy.BuiltInRole(name)
+	if !ok {
+		return nil, errors.New(db.ErrNotFound)
+	}
+	if name == policy.RoleModerator {
+		grants := []pkg.Grant{}
+		for _, grant := range role.Grants {
+			if grant.Permission != pkg.PermissionPublishPlants {
+				grants = append(grants, grant)
+			}
+		}
+		role.Grants = grants
+	}
+	return &role, nil
+}
+
+func (legacyRoles) GetRoleAssignments(subject string, ctx context.Context) ([]pkg.RoleAssignment, error) {
+	return nil, nil
+}
+
+// policyEngine returns the engine decisions are made with
+func (s *Server) policyEngine() *policy.Engine {
+	if s.policy == nil {
+		return legacyPolicy
+	}
+	return s.policy
+}
+
+// policySubject describes the caller of the request for a decision
+func policySubject(c *gin.Context) policy.Subject {
+	subject := policy.Subject{}
+	claims, ok := requestClaims(c)
+	if !ok {
+		return subject
+	}
+	subject.ID = claims.RegisteredClaims.Subject
+	customClaims, ok := claims.CustomClaims.(*middleware.CustomClaims)
+	if ok {
+		subject.Scopes = strings.Fields(customClaims.Scope)
+		subject.Roles = customClaims.Roles
+	}
+	return subject
+}
+
+// authorize checks the caller has the permission on the resource, responding with forbidden
+// if it does not. Requests without credentials only reach public routes, so they are not
+// checked.
+func (s *Server) authorize(c *gin.Context, permission string, resource policy.ResourceFunc) bool {
+	if _, ok := requestClaims(c); !ok {
+		return true
+	}
+	decision, err := s.policyEngine().Decide(policySubject(c), permission, resource, c)
+	if err != nil {
+		writeDBError(c, err)
+		return false
+	}
+	if !decision.Allowed {
+		log.Printf("%s denied %s: %s", decision.Subject, permission, strings.Join(decision.Reasons, "; "))
+		c.Writer.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// permitted reports whether the caller has the permission on the resource, without
+// responding. Like authorize, it allows requests without credentials.
+func (s *Server) permitted(c *gin.Context, permission string, resource policy.ResourceFunc) (bool, error) {
+	if _, ok := requestClaims(c); !ok {
+		return true, nil
+	}
+	decision, err := s.policyEngine().Decide(policySubject(c), permission, resource, c)
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+// plantResource loads the named plant for a decision
+func (s *Server) plantResource(c *gin.Context, name string) policy.ResourceFunc {
+	return func() (*pkg.Resource, error) {
+		plant, err := s.db.GetPlant(name, c)
+		if err != nil {
+			return nil, err
+		}
+		return plant.Resource(), nil
+	}
+}
+
+// trashedPlantResource loads the named plant from the trash for a decision
+func (s *Server) trashedPlantResource(c *gin.Context, name string) policy.ResourceFunc {
+	return func() (*pkg.Resource, error) {
+		plants, err := s.db.ListTrash(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, plant := range plants {
+			if plant.Name == name {
+				return plant.Resource(), nil
+			}
+		}
+		return &pkg.Resource{Kind: "plant", Name: name, Status: pkg.PlantStatusTrashed}, nil
+	}
+}
+
+// HandleExplain describes the decision for a permission, e.g.
+// GET /v1/policy/explain?permission=update:plants&plant=monstera. Callers may explain their
+// own decisions, and with manage:roles those of another ?subject=, for whom only assigned
+// and default roles are known.
+func (s *Server) HandleExplain(c *gin.Context) {
+	permission := c.Query("permission")
+	if permission == "" {
+		log.Println("explain request missing permission")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	subject := policySubject(c)
+	if other := c.Query("subject"); other != "" && other != subject.ID {
+		if !s.authorize(c, pkg.PermissionManageRoles, nil) {
+			return
+		}
+		subject = policy.Subject{ID: other}
+	}
+	var resource policy.ResourceFunc
+	if name := c.Query("plant"); name != "" {
+		resource = s.plantResource(c, name)
+	}
+	decision, err := s.policyEngine().Decide(subject, permission, resource, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+// HandleListRoles lists the stored roles along with the built in roles they do not replace
+func (s *Server) HandleListRoles(c *gin.Context) {
+	stored, err := s.db.ListRoles(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	roles := []pkg.Role{}
+	replaced := map[string]bool{}
+	for _, role := range stored {
+		replaced[role.Name] = true
+		roles = append(roles, role)
+	}
+	for _, role := range policy.BuiltInRoles() {
+		if !replaced[role.Name] {
+			roles = append(roles, role)
+		}
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func (s *Server) HandleGetRole(c *gin.Context) {
+	role, err := s.db.GetRole(c.Param("role"), c)
+	if err != nil && err.Error() == db.ErrNotFound {
+		if builtIn, ok := policy.BuiltInRole(c.Param("role")); ok {
+			c.JSON(http.StatusOK, builtIn)
+			return
+		}
+	}
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// HandlePutRole creates a role or replaces its grants. Storing a role with the name of a
+// built in role replaces it.
+func (s *Server) HandlePutRole(c *gin.Context) {
+	var role pkg.Role
+	err := json.NewDecoder(c.Request.Body).Decode(&role)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	role.Name = c.Param("role")
+	for _, grant := range role.Grants {
+		if grant.Permission == "" {
+			log.Println("role grant missing permission")
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	role.UpdatedBy = middleware.GetSubject(c.Request)
+	role.UpdatedAt = time.Now().UTC()
+	role.BuiltIn = false
+	before := s.auditBeforeRole(c, role.Name)
+	err = s.db.PutRole(role, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.policyEngine().InvalidateRole(role.Name, c)
+	c.JSON(http.StatusOK, role)
+	action := audit.ActionUpdate
+	if before == nil {
+		action = audit.ActionCreate
+	}
+	s.recordAudit(c, action, auditResource("role", role.Name), before, role)
+}
+
+// auditBeforeRole returns the stored role a write replaces so it can be audited. The
+// lookup is skipped when auditing is disabled.
+func (s *Server) auditBeforeRole(c *gin.Context, name string) *pkg.Role {
+	if s.audit == nil {
+		return nil
+	}
+	role, err := s.db.GetRole(name, c)
+	if err != nil {
+		return nil
+	}
+	return role
+}
+
+// HandleDeleteRole deletes a stored role. A built in role it replaced comes back into effect.
+func (s *Server) HandleDeleteRole(c *gin.Context) {
+	role, err := s.db.DeleteRole(c.Param("role"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	s.policyEngine().InvalidateRole(role.Name, c)
+	c.JSON(http.StatusOK, role)
+	s.recordAudit(c, audit.ActionDelete, auditResource("role", role.Name), role, nil)
+}
+
+func (s *Server) HandleGetRoleAssignments(c *gin.Context) {
+	assignments, err := s.db.GetRoleAssignments(c.Param("subject"), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+// HandlePutRoleAssignment gives a subject a role, which must exist
+func (s *Server) HandlePutRoleAssignment(c *gin.Context) {
+	name := c.Param("role")
+	_, err := s.db.GetRole(name, c)
+	if err != nil && err.Error() == db.ErrNotFound {
+		if _, ok := policy.BuiltInRole(name); !ok {
+			log.Println("cannot assign undefined role " + name)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = nil
+	}
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	assignment := pkg.RoleAssignment{
+		Subject:    c.Param("subject"),
+		Role:       name,
+		AssignedBy: middleware.GetSubject(c.Request),
+		AssignedAt: time.Now().UTC(),
+	}
+	err = s.db.PutRoleAssignment(assignment, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.policyEngine().InvalidateSubject(assignment.Subject, c)
+	c.JSON(http.StatusOK, assignment)
+	s.recordAudit(c, audit.ActionCreate, auditResource("role-assignment", assignment.Subject, assignment.Role), nil, assignment)
+}
+
+func (s *Server) HandleDeleteRoleAssignment(c *gin.Context) {
+	assignment, err := s.db.DeleteRoleAssignment(c.Param("subject"), c.Param("role"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	s.policyEngine().InvalidateSubject(assignment.Subject, c)
+	c.JSON(http.StatusOK, assignment)
+	s.recordAudit(c, audit.ActionDelete, auditResource("role-assignment", assignment.Subject, assignment.Role), assignment, nil)
+}
+
+// requirePermission is a route middleware which rejects callers without the permission
+func (s *Server) requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.authorize(c, permission, nil) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
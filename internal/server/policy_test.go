@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleUpdatePlant_Policy(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		roles   []string
+		owner   string
+		code    int
+	}{
+		{
+			name:    "handle update plant lets a contributor update their own plant",
+			subject: "alice",
+			roles:   []string{policy.RoleContributor},
+			owner:   "alice",
+			code:    200,
+		},
+		{
+			name:    "handle update plant forbids a contributor updating another's plant",
+			subject: "alice",
+			roles:   []string{policy.RoleContributor},
+			owner:   "dave",
+			code:    403,
+		},
+		{
+			name:    "handle update plant forbids a viewer",
+			subject: "erin",
+			owner:   "erin",
+			code:    403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPut, "/v1/plant", bytes.NewBufferString(`{"name": "monstera", "description": "big leaves"}`)), tt.subject, "")
+			mockDB := new(db.MockDB)
+			assignments := []pkg.RoleAssignment{}
+			for _, role := range tt.roles {
+				assignments = append(assignments, pkg.RoleAssignment{Subject: tt.subject, Role: role})
+			}
+			mockDB.On("GetRoleAssignments", tt.subject, mock.Anything).Return(assignments, nil)
+			mockDB.On("GetRole", mock.Anything, mock.Anything).Return((*pkg.Role)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(&pkg.Plant{Name: "monstera", Description: "leaves", Owner: tt.owner}, nil)
+			mockDB.On("UpdatePlant", mock.Anything, tt.subject, mock.Anything).Return(nil)
+			s := &Server{db: mockDB, policy: policy.NewEngine(mockDB, []string{policy.RoleViewer})}
+			s.HandleUpdatePlant(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleUpdatePlant response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "UpdatePlant", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestServer_HandleCreatePlant_Owner(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant", bytes.NewBufferString(`{"name": "monstera", "description": "big leaves", "owner": "someone else"}`)), "alice", "")
+	mockDB := new(db.MockDB)
+	mockDB.On("CreatePlant", mock.MatchedBy(func(plant pkg.Plant) bool { return plant.Owner == "alice" }), "alice", mock.Anything).Return(nil)
+	s := &Server{db: mockDB}
+	s.HandleCreatePlant(c)
+	if c.Writer.Status() != 200 {
+		t.Errorf("HandleCreatePlant response code: %d, expected 200", c.Writer.Status())
+	}
+}
+
+func TestServer_HandleExplain(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		scope string
+		code  int
+		body  string
+	}{
+		{
+			name:  "handle explain fails without a permission",
+			query: "",
+			code:  400,
+		},
+		{
+			name:  "handle explain explains the caller's own decision",
+			query: "?permission=purge:plants",
+			scope: "purge:plants",
+			code:  200,
+			body:  `"token scope purge:plants grants purge:plants"`,
+		},
+		{
+			name:  "handle explain forbids explaining another subject without manage:roles",
+			query: "?permission=read:plants&subject=bob",
+			code:  403,
+		},
+		{
+			name:  "handle explain explains another subject with manage:roles",
+			query: "?permission=delete:plants&subject=bob",
+			scope: "manage:roles",
+			code:  200,
+			body:  `"role moderator (assigned) grants delete:plants"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodGet, "/v1/policy/explain"+tt.query, nil), "alice", tt.scope)
+			mockDB := new(db.MockDB)
+			mockDB.On("GetRoleAssignments", "alice", mock.Anything).Return([]pkg.RoleAssignment{}, nil)
+			mockDB.On("GetRoleAssignments", "bob", mock.Anything).Return([]pkg.RoleAssignment{{Subject: "bob", Role: policy.RoleModerator}}, nil)
+			mockDB.On("GetRole", mock.Anything, mock.Anything).Return((*pkg.Role)(nil), errors.New(db.ErrNotFound))
+			s := &Server{db: mockDB, policy: policy.NewEngine(mockDB, []string{policy.RoleViewer})}
+			s.HandleExplain(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleExplain response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("HandleExplain body %s, want it to contain %s", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePutRoleAssignment(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		code int
+	}{
+		{
+			name: "handle put role assignment assigns a built in role",
+			role: policy.RoleEditor,
+			code: 200,
+		},
+		{
+			name: "handle put role assignment assigns a stored role",
+			role: "curator",
+			code: 200,
+		},
+		{
+			name: "handle put role assignment fails for an undefined role",
+			role: "ghost",
+			code: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPut, "/v1/admin/role-assignments/bob/"+tt.role, nil), "admin", "manage:roles")
+			c.Params = gin.Params{{Key: "subject", Value: "bob"}, {Key: "role", Value: tt.role}}
+			mockDB := new(db.MockDB)
+			mockDB.On("GetRole", "curator", mock.Anything).Return(&pkg.Role{Name: "curator"}, nil)
+			mockDB.On("GetRole", mock.Anything, mock.Anything).Return((*pkg.Role)(nil), errors.New(db.ErrNotFound))
+			mockDB.On("PutRoleAssignment", mock.MatchedBy(func(assignment pkg.RoleAssignment) bool {
+				return assignment.Subject == "bob" && assignment.Role == tt.role && assignment.AssignedBy == "admin"
+			}), mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandlePutRoleAssignment(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePutRoleAssignment response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimit refuses requests over the caller's limits, or over the limit of the caller's
+// tenant, with too many requests, and tells every caller how many requests it has left with
+// RateLimit headers. Requests are still served if the limits cannot be checked.
+func (s *Server) rateLimit(c *gin.Context) {
+	if s.limiter == nil {
+		c.Next()
+		return
+	}
+	caller, scopes := rateLimitCaller(c)
+	now := time.Now()
+	result, err := s.limiter.Take(caller, scopes, c.Request.Method, c.FullPath(), now, c)
+	if err != nil {
+		log.Println(err)
+		c.Next()
+		return
+	}
+	if limit, ok := tenantRateLimit(c); ok && result.Allowed {
+		shared, err := s.limiter.TakeShared("tenant#"+c.GetString(tenantKey), limit, now, c)
+		if err != nil {
+			log.Println(err)
+		} else if !shared.Allowed || shared.Remaining < result.Remaining {
+			caller = "tenant " + c.GetString(tenantKey)
+			result = shared
+		}
+	}
+	if !writeRateLimit(c, caller, result) {
+		return
+	}
+	c.Next()
+}
+
+// limitAddress refuses requests over the limit of the address they come from. It runs
+// before authentication, so requests with missing or invalid credentials are limited too
+// and cannot be used to hammer the token and api key checks.
+func (s *Server) limitAddress(c *gin.Context) {
+	if s.limiter == nil {
+		c.Next()
+		return
+	}
+	result, err := s.limiter.TakeAddress(c.ClientIP(), time.Now(), c)
+	if err != nil {
+		log.Println(err)
+		c.Next()
+		return
+	}
+	if !writeRateLimit(c, "address "+c.ClientIP(), result) {
+		return
+	}
+	c.Next()
+}
+
+// writeRateLimit sets the RateLimit headers of a result, and refuses the request with too
+// many requests if it was not allowed. It reports whether the request may go on.
+func writeRateLimit(c *gin.Context, caller string, result ratelimit.Result) bool {
+	header := c.Writer.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+	header.Set("RateLimit-Policy", strconv.Itoa(result.Limit.Requests)+";w="+ceilSeconds(result.Limit.Per))
+	if !result.Allowed {
+		log.Printf("rate limited %s on %s %s", caller, c.Request.Method, c.FullPath())
+		header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// rateLimitCaller identifies who is making a request and the scopes of its token.
+// Applications using client credentials are limited by their Auth0 client id, users by
+// their subject and requests without a token by their address.
+func rateLimitCaller(c *gin.Context) (string, []string) {
+	claims, ok := middleware.GetClaims(c.Request)
+	if !ok {
+		return "ip#" + c.ClientIP(), nil
+	}
+	subject := claims.RegisteredClaims.Subject
+	var scopes []string
+	custom, ok := claims.CustomClaims.(*middleware.CustomClaims)
+	if ok {
+		scopes = strings.Fields(custom.Scope)
+		if custom.ClientID != "" && strings.HasSuffix(subject, "@clients") {
+			return "client#" + custom.ClientID, scopes
+		}
+	}
+	if subject == "" {
+		return "ip#" + c.ClientIP(), scopes
+	}
+	return "user#" + subject, scopes
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
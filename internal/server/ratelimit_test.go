@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/ratelimit"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func TestServer_rateLimit(t *testing.T) {
+	config, _ := ratelimit.ParseConfig(`{"default": "2/1m", "scopes": {"bulk:plants": "3/1m"}}`)
+	s := &Server{limiter: ratelimit.NewLimiter(config, ratelimit.NewMemoryBackend())}
+	r := gin.New()
+	r.GET("/v1/plants", s.rateLimit, func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(r *http.Request) *http.Request { return r }
+	tests := []struct {
+		name      string
+		request   func(*http.Request) *http.Request
+		codes     []int
+		remaining string
+	}{
+		{
+			name:      "rate limit refuses a user over the default limit",
+			request:   func(r *http.Request) *http.Request { return withSubject(r, "user", "") },
+			codes:     []int{200, 200, 429},
+			remaining: "0",
+		},
+		{
+			name:      "rate limit allows more to scopes with a higher limit",
+			request:   func(r *http.Request) *http.Request { return withSubject(r, "bulk", "bulk:plants") },
+			codes:     []int{200, 200, 200, 429},
+			remaining: "0",
+		},
+		{
+			name:      "rate limit limits requests without a token by address",
+			request:   request,
+			codes:     []int{200, 200, 429},
+			remaining: "0",
+		},
+		{
+			name: "rate limit limits applications by client id",
+			request: func(r *http.Request) *http.Request {
+				claims := &validator.ValidatedClaims{
+					RegisteredClaims: validator.RegisteredClaims{Subject: "abc@clients"},
+					CustomClaims:     &middleware.CustomClaims{ClientID: "abc"},
+				}
+				return r.WithContext(context.WithValue(r.Context(), jwtmiddleware.ContextKey{}, claims))
+			},
+			codes:     []int{200, 200, 429},
+			remaining: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w *httptest.ResponseRecorder
+			for i, code := range tt.codes {
+				w = httptest.NewRecorder()
+				r.ServeHTTP(w, tt.request(httptest.NewRequest(http.MethodGet, "/v1/plants", nil)))
+				if w.Code != code {
+					t.Fatalf("rateLimit request %d response code: %d, expected %d", i, w.Code, code)
+				}
+			}
+			if w.Header().Get("RateLimit-Remaining") != tt.remaining || w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Policy") == "" {
+				t.Errorf("rateLimit headers %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestServer_limitAddress(t *testing.T) {
+	config, _ := ratelimit.ParseConfig(`{"default": "5/1m", "address": "2/1m"}`)
+	s := &Server{limiter: ratelimit.NewLimiter(config, ratelimit.NewMemoryBackend())}
+	r := gin.New()
+	// stands in for the credential checks, which refuse every request here
+	r.Use(s.limitAddress, func(c *gin.Context) { c.AbortWithStatus(http.StatusUnauthorized) })
+	r.GET("/v1/plants", func(c *gin.Context) { c.Status(http.StatusOK) })
+	codes := []int{}
+	for range 3 {
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/v1/plants", nil)
+		request.Header.Set("Authorization", "Bearer guessed")
+		r.ServeHTTP(w, request)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 401 || codes[1] != 401 || codes[2] != 429 {
+		t.Errorf("limitAddress response codes %v, want the address refused before authentication", codes)
+	}
+}
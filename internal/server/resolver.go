@@ -1,32 +1,389 @@
 package server
 
 import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/apikeys"
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/blob"
 	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/devauth"
+	"github.com/SevvyP/plants/internal/events"
+	"github.com/SevvyP/plants/internal/images"
 	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/notify"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/internal/ratelimit"
+	"github.com/SevvyP/plants/internal/stream"
+	"github.com/SevvyP/plants/internal/webhooks"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	"github.com/gin-gonic/gin"
 	adapter "github.com/gwatts/gin-adapter"
 )
 
+const (
+	// ScopeReadAudit is required to read the audit log
+	ScopeReadAudit = "read:audit"
+	// ScopeManageWebhooks is required to manage webhook subscriptions and their deliveries
+	ScopeManageWebhooks = "manage:webhooks"
+	// ScopeReadMetrics is required to read the plant cache's metrics
+	ScopeReadMetrics = "read:metrics"
+	// ScopeManageAPIKeys is required to mint, rotate and revoke api keys
+	ScopeManageAPIKeys = "manage:api_keys"
+)
+
 type Server struct {
-	db db.DBInterface
+	db            db.DBInterface
+	audit         *audit.Log
+	blobs         blob.Store
+	maxImageBytes int
+	// notifier sends care reminders, or is nil if notifications are disabled
+	notifier *notify.Dispatcher
+	// webhooks sends catalog change events to subscriptions
+	webhooks *webhooks.Publisher
+	// events streams catalog changes to clients of the change feed
+	events *events.Broker
+	// consumers read the plants table's stream. When there are any, catalog events are
+	// published from the stream rather than by the handlers.
+	consumers []*stream.Consumer
+	// cachePolicies decide the caching headers of catalog reads
+	cachePolicies CachePolicies
+	// publicCatalog lets catalog reads be made without a token
+	publicCatalog bool
+	// limiter limits how often each caller may make requests, or is nil if they are not limited
+	limiter *ratelimit.Limiter
+	// apiKeys checks api keys used in place of a token
+	apiKeys *apikeys.Verifier
+	// issuers are where bearer tokens are accepted from
+	issuers []middleware.TokenIssuer
+	// devIssuer stands in for Auth0 during development, or is nil
+	devIssuer *devauth.Issuer
+	// policy decides what callers may do from their roles, or is nil to let any caller do
+	// anything but purge
+	policy *policy.Engine
+	// tenancy decides which tenant each request acts for, or is nil if every request acts
+	// for the default tenant
+	tenancy *Tenancy
+	// moderation holds changes by callers who may not publish as proposals for review
+	moderation bool
+	// locales are those plant content is served and translated in, or nil for the defaults
+	locales *Locales
 }
 
 func ResolveServer() *Server {
-	return &Server{db: ResolveDB()}
+	database := ResolveDB()
+	server := &Server{db: database, audit: ResolveAuditLog(), blobs: blob.NewStoreFromEnv(), maxImageBytes: ResolveMaxImageBytes(), notifier: ResolveNotifier(database), webhooks: ResolveWebhooks(database), events: events.NewBroker(events.DefaultBufferSize), cachePolicies: ResolveCachePolicies(), publicCatalog: os.Getenv("CATALOG_PUBLIC") == "true", limiter: ResolveRateLimiter(), apiKeys: apikeys.NewVerifier(database)}
+	server.consumers = ResolveConsumers(server)
+	server.issuers, server.devIssuer = ResolveIssuers()
+	server.policy = ResolvePolicy(server.db)
+	server.tenancy = ResolveTenancy()
+	server.moderation = os.Getenv("MODERATION") == "true"
+	server.locales = ResolveLocales()
+	return server
+}
+
+// ResolvePolicy turns on role based access control if RBAC is true. Callers without a role
+// have those in RBAC_DEFAULT_ROLES, separated by commas, or are viewers if it is not set.
+func ResolvePolicy(store policy.Store) *policy.Engine {
+	if os.Getenv("RBAC") != "true" {
+		return nil
+	}
+	defaultRoles := []string{policy.RoleViewer}
+	if value := os.Getenv("RBAC_DEFAULT_ROLES"); value != "" {
+		defaultRoles = strings.Split(value, ",")
+	}
+	return policy.NewEngine(store, defaultRoles)
 }
 
+// ResolveIssuers accepts tokens from the issuers in TOKEN_ISSUERS, or from Auth0 if it is
+// not set. If DEV_AUTH is true the api also serves its own issuer for development, in place
+// of Auth0, whose tokens are minted with plantsctl token mint.
+func ResolveIssuers() ([]middleware.TokenIssuer, *devauth.Issuer) {
+	issuers := []middleware.TokenIssuer{}
+	if data := os.Getenv("TOKEN_ISSUERS"); data != "" {
+		parsed, err := middleware.ParseIssuers(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		issuers = parsed
+	}
+	if os.Getenv("DEV_AUTH") != "true" {
+		if len(issuers) == 0 {
+			issuers = append(issuers, middleware.Auth0Issuer())
+		}
+		return issuers, nil
+	}
+	issuer, err := devauth.NewIssuerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("accepting development tokens from %s", issuer.URL)
+	return append(issuers, issuer.TokenIssuer()), issuer
+}
 
+// ResolveDB caches plants in front of DynamoDB unless PLANT_CACHE_SIZE is 0. The cache's
+// size and how long it keeps plants and misses are read from PLANT_CACHE_SIZE,
+// PLANT_CACHE_TTL and PLANT_CACHE_NEGATIVE_TTL.
 func ResolveDB() db.DBInterface {
-	return db.NewDB()
+	database := db.NewDB()
+	if os.Getenv("PLANT_CACHE_SIZE") == "0" {
+		return database
+	}
+	options := db.CacheOptions{}
+	options.Size, _ = strconv.Atoi(os.Getenv("PLANT_CACHE_SIZE"))
+	options.TTL, _ = time.ParseDuration(os.Getenv("PLANT_CACHE_TTL"))
+	options.NegativeTTL, _ = time.ParseDuration(os.Getenv("PLANT_CACHE_NEGATIVE_TTL"))
+	return db.NewCachedDB(database, options)
+}
+
+// ResolveRateLimiter limits requests as configured by the json in RATE_LIMITS, if it is set.
+// Buckets are kept in memory unless RATE_LIMIT_BACKEND is dynamo, which shares them between
+// replicas in the plants_v1_rate_limits table.
+func ResolveRateLimiter() *ratelimit.Limiter {
+	limits := os.Getenv("RATE_LIMITS")
+	if limits == "" {
+		return nil
+	}
+	rateLimits, err := ratelimit.ParseConfig(limits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("RATE_LIMIT_BACKEND") != "dynamo" {
+		return ratelimit.NewLimiter(rateLimits, ratelimit.NewMemoryBackend())
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ratelimit.NewLimiter(rateLimits, ratelimit.NewDynamoBackend(dynamodb.NewFromConfig(cfg)))
+}
+
+// ResolveAuditLog writes the audit log to the file named by AUDIT_LOG_FILE if it is set,
+// otherwise to the plants_v1_audit DynamoDB table
+func ResolveAuditLog() *audit.Log {
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		return audit.NewLog(audit.NewFileSink(path))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return audit.NewLog(audit.NewDynamoSink(dynamodb.NewFromConfig(cfg)))
+}
+
+// ResolveMaxImageBytes reads the upload size limit from IMAGE_MAX_BYTES
+func ResolveMaxImageBytes() int {
+	limit, err := strconv.Atoi(os.Getenv("IMAGE_MAX_BYTES"))
+	if err != nil || limit <= 0 {
+		return images.DefaultMaxBytes
+	}
+	return limit
+}
+
+// ResolveNotifier returns a reminder dispatcher if NOTIFICATIONS_ENABLED is true. It checks
+// for due tasks every NOTIFY_INTERVAL, a minute by default, and emails through the SMTP
+// server configured by NewEmailSenderFromEnv.
+func ResolveNotifier(database db.DBInterface) *notify.Dispatcher {
+	if os.Getenv("NOTIFICATIONS_ENABLED") != "true" {
+		return nil
+	}
+	interval, err := time.ParseDuration(os.Getenv("NOTIFY_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	senders := map[string]notify.Sender{pkg.ChannelWebhook: notify.NewWebhookSender()}
+	if email := notify.NewEmailSenderFromEnv(); email != nil {
+		senders[pkg.ChannelEmail] = email
+	}
+	lock := notify.NewDynamoLock(dynamodb.NewFromConfig(cfg), "notifications", 3*interval)
+	return notify.NewDispatcher(database, senders, lock, interval)
+}
+
+// ResolveWebhooks returns the publisher of catalog events, which retries failed
+// deliveries every WEBHOOK_RETRY_INTERVAL, a minute by default
+func ResolveWebhooks(database db.DBInterface) *webhooks.Publisher {
+	interval, err := time.ParseDuration(os.Getenv("WEBHOOK_RETRY_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	lock := notify.NewDynamoLock(dynamodb.NewFromConfig(cfg), "webhooks", 3*interval)
+	return webhooks.NewPublisher(database, lock, interval)
+}
+
+// ResolveConsumers reads the stream named by PLANTS_STREAM_ARN, if it is set, so that
+// changes made outside the api reach the change feed and webhooks too
+func ResolveConsumers(s *Server) []*stream.Consumer {
+	arn := os.Getenv("PLANTS_STREAM_ARN")
+	if arn == "" {
+		return nil
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := dynamodbstreams.NewFromConfig(cfg)
+	dynamo := dynamodb.NewFromConfig(cfg)
+	// every replica streams changes to its own change feed clients, starting from now
+	local := stream.NewConsumer(client, arn, stream.Options{StartAtLatest: true})
+	local.Subscribe(stream.PlantHandler(func(event pkg.Event, ctx context.Context) error {
+		if cache, ok := s.db.(*db.CachedDB); ok {
+			cache.Invalidate(event.Plant.Name, ctx)
+			if event.PreviousName != "" {
+				cache.Invalidate(event.PreviousName, ctx)
+			}
+		}
+		s.events.Publish(event)
+		return nil
+	}, s.db))
+	// one replica at a time publishes changes to webhooks, resuming where the last left off
+	interval := 10 * time.Second
+	shared := stream.NewConsumer(client, arn, stream.Options{
+		Checkpoints: stream.NewDynamoCheckpoints(dynamo, "webhooks"),
+		Lock:        notify.NewDynamoLock(dynamo, "stream-webhooks", 3*interval),
+		Interval:    interval,
+	})
+	shared.Subscribe(stream.PlantHandler(s.webhooks.Publish, s.db))
+	return []*stream.Consumer{local, shared}
 }
 
 func (s *Server) Run() {
+	if s.devIssuer != nil {
+		go func() {
+			log.Fatal(s.devIssuer.ListenAndServe())
+		}()
+	}
+	for _, consumer := range s.consumers {
+		go consumer.Run(context.Background())
+	}
+	if s.notifier != nil {
+		go s.notifier.Run(context.Background())
+	}
+	if s.webhooks != nil {
+		go s.webhooks.Run(context.Background())
+	}
+	s.Router().Run()
+}
+
+// Router builds the engine serving every route of the api
+func (s *Server) Router() *gin.Engine {
 	r := gin.Default()
+	// lets the tenant set on the request's context reach the database through the gin context
+	r.ContextWithFallback = true
 	r.Use(gin.Recovery())
-	r.Use(adapter.Wrap(middleware.EnsureValidToken()))
-	r.GET("/v1/plant/:name", s.HandleGetPlant)
+	r.Use(adapter.Wrap(middleware.RequestID()))
+	r.Use(s.auditAuthorizationFailures)
+	// every address is limited before credentials are checked, so failed attempts count too
+	r.Use(s.limitAddress)
+	// calendar apps cannot send a JWT, so the feed is registered before the token is
+	// required and checks its own token instead
+	r.GET(calendarFeedPath, s.rateLimit, s.HandleGetCalendar)
+	// the catalog is the same for everyone, so it may be opened to readers without a token
+	if s.publicCatalog {
+		r.GET("/v1/plant/:name", s.resolveTenant, s.rateLimit, s.HandleGetPlant)
+		r.GET("/v1/plants", s.resolveTenant, s.rateLimit, s.HandleListPlants)
+	}
+	r.Use(adapter.Wrap(middleware.EnsureValidCredentials(s.apiKeys, s.issuers)))
+	r.Use(captureClaims)
+	r.Use(s.resolveTenant)
+	r.Use(s.rateLimit)
+	if !s.publicCatalog {
+		r.GET("/v1/plant/:name", s.HandleGetPlant)
+		r.GET("/v1/plants", s.HandleListPlants)
+	}
 	r.POST("/v1/plant", s.HandleCreatePlant)
 	r.PUT("/v1/plant", s.HandleUpdatePlant)
 	r.DELETE("/v1/plant/:name", s.HandleDeletePlant)
-	r.Run()
+	r.GET("/v1/plant/:name/revisions", s.HandleGetRevisions)
+	r.GET("/v1/plant/:name/diff", s.HandleDiffRevisions)
+	r.POST("/v1/plant/:name/revisions/:id", s.HandleRevisionAction)
+	r.GET("/v1/plant/:name/suggestions", s.HandleGetSuggestions)
+	r.POST("/v1/plant/:name/suggestions", s.HandleCreateSuggestion)
+	r.GET("/v1/plant/:name/suggestions/:id", s.HandleGetSuggestion)
+	r.POST("/v1/plant/:name/suggestions/:id", s.HandleSuggestionAction)
+	r.GET("/v1/plant/:name/translations", s.HandleGetTranslations)
+	r.PUT("/v1/plant/:name/translations/:locale", s.HandlePutTranslation)
+	r.DELETE("/v1/plant/:name/translations/:locale", s.HandleDeleteTranslation)
+	r.GET("/v1/translations/missing", s.HandleGetMissingTranslations)
+	r.POST("/v1/plant/:name", s.HandlePlantAction)
+	r.GET("/v1/plants/events", s.requireFeature(pkg.FeatureEvents), s.HandlePlantEvents)
+	r.GET("/v1/trash", s.HandleListTrash)
+	r.DELETE("/v1/trash/:name", s.HandlePurgePlant)
+	r.GET("/v1/archive", s.HandleListArchive)
+	r.GET("/v1/duplicates", s.HandleListDuplicates)
+	r.GET("/v1/proposals", s.HandleListProposals)
+	r.POST("/v1/proposals", s.HandleCreateProposal)
+	r.GET("/v1/proposals/:id", s.HandleGetProposal)
+	r.PUT("/v1/proposals/:id", s.HandleUpdateProposal)
+	r.DELETE("/v1/proposals/:id", s.HandleDeleteProposal)
+	r.POST("/v1/proposals/:id", s.HandleProposalAction)
+	r.GET("/v1/moderation/queue", s.HandleGetModerationQueue)
+	imagesFeature := s.requireFeature(pkg.FeatureImages)
+	r.POST("/v1/plant/:name/images", imagesFeature, s.HandleUploadImage)
+	r.GET("/v1/plant/:name/images/:id/:rendition", imagesFeature, s.HandleGetImage)
+	collectionsFeature := s.requireFeature(pkg.FeatureCollections)
+	r.GET("/v1/me/plants", collectionsFeature, s.HandleGetCollection)
+	r.POST("/v1/me/plants", collectionsFeature, s.HandleCreateCollectionEntry)
+	r.GET("/v1/me/plants/:id", collectionsFeature, s.HandleGetCollectionEntry)
+	r.PUT("/v1/me/plants/:id", collectionsFeature, s.HandleUpdateCollectionEntry)
+	r.DELETE("/v1/me/plants/:id", collectionsFeature, s.HandleDeleteCollectionEntry)
+	journalFeature := s.requireFeature(pkg.FeatureJournal)
+	r.GET("/v1/me/plants/:id/journal", journalFeature, s.HandleGetJournal)
+	r.POST("/v1/me/plants/:id/journal", journalFeature, s.HandleCreateJournalEntry)
+	r.GET("/v1/me/plants/:id/journal/:entry", journalFeature, s.HandleGetJournalEntry)
+	r.PUT("/v1/me/plants/:id/journal/:entry", journalFeature, s.HandleUpdateJournalEntry)
+	r.DELETE("/v1/me/plants/:id/journal/:entry", journalFeature, s.HandleDeleteJournalEntry)
+	r.POST("/v1/me/plants/:id/journal/:entry/photos", journalFeature, s.HandleUploadJournalPhoto)
+	r.GET("/v1/me/plants/:id/journal/:entry/photos/:photo/:rendition", journalFeature, s.HandleGetJournalPhoto)
+	tasksFeature := s.requireFeature(pkg.FeatureTasks)
+	r.GET("/v1/me/tasks", tasksFeature, s.HandleGetTasks)
+	r.POST("/v1/me/tasks/:id", tasksFeature, s.HandleTaskAction)
+	calendarFeature := s.requireFeature(pkg.FeatureCalendar)
+	r.POST("/v1/me/calendar/token", calendarFeature, s.HandleCreateCalendarToken)
+	r.DELETE("/v1/me/calendar/token", calendarFeature, s.HandleDeleteCalendarToken)
+	notificationsFeature := s.requireFeature(pkg.FeatureNotifications)
+	r.GET("/v1/me/notifications", notificationsFeature, s.HandleGetNotificationPreferences)
+	r.PUT("/v1/me/notifications", notificationsFeature, s.HandlePutNotificationPreferences)
+	r.GET("/v1/me/notifications/deliveries", notificationsFeature, s.HandleGetDeliveries)
+	r.GET("/v1/admin/audit", defaultTenantOnly, adapter.Wrap(middleware.RequireScope(ScopeReadAudit)), s.HandleListAudit)
+	r.GET("/v1/admin/cache", defaultTenantOnly, adapter.Wrap(middleware.RequireScope(ScopeReadMetrics)), s.HandleGetCacheStats)
+	manageWebhooks := adapter.Wrap(middleware.RequireScope(ScopeManageWebhooks))
+	webhooksFeature := s.requireFeature(pkg.FeatureWebhooks)
+	r.GET("/v1/admin/webhooks", webhooksFeature, manageWebhooks, s.HandleListSubscriptions)
+	r.POST("/v1/admin/webhooks", webhooksFeature, manageWebhooks, s.HandleCreateSubscription)
+	r.GET("/v1/admin/webhooks/:id", webhooksFeature, manageWebhooks, s.HandleGetSubscription)
+	r.DELETE("/v1/admin/webhooks/:id", webhooksFeature, manageWebhooks, s.HandleDeleteSubscription)
+	r.GET("/v1/admin/webhooks/:id/deliveries", webhooksFeature, manageWebhooks, s.HandleGetWebhookDeliveries)
+	r.POST("/v1/admin/webhooks/:id/deliveries/:delivery", webhooksFeature, manageWebhooks, s.HandleWebhookDeliveryAction)
+	manageAPIKeys := adapter.Wrap(middleware.RequireScope(ScopeManageAPIKeys))
+	r.GET("/v1/admin/api-keys", manageAPIKeys, s.HandleListAPIKeys)
+	r.POST("/v1/admin/api-keys", manageAPIKeys, s.HandleCreateAPIKey)
+	r.GET("/v1/admin/api-keys/:prefix", manageAPIKeys, s.HandleGetAPIKey)
+	r.DELETE("/v1/admin/api-keys/:prefix", manageAPIKeys, s.HandleRevokeAPIKey)
+	r.POST("/v1/admin/api-keys/:prefix", manageAPIKeys, s.HandleAPIKeyAction)
+	r.GET("/v1/policy/explain", s.HandleExplain)
+	manageRoles := s.requirePermission(pkg.PermissionManageRoles)
+	r.GET("/v1/admin/roles", manageRoles, s.HandleListRoles)
+	r.GET("/v1/admin/roles/:role", manageRoles, s.HandleGetRole)
+	r.PUT("/v1/admin/roles/:role", manageRoles, s.HandlePutRole)
+	r.DELETE("/v1/admin/roles/:role", manageRoles, s.HandleDeleteRole)
+	r.GET("/v1/admin/role-assignments/:subject", manageRoles, s.HandleGetRoleAssignments)
+	r.PUT("/v1/admin/role-assignments/:subject/:role", manageRoles, s.HandlePutRoleAssignment)
+	r.DELETE("/v1/admin/role-assignments/:subject/:role", manageRoles, s.HandleDeleteRoleAssignment)
+	return r
 }
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/devauth"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/mock"
+)
+
+// newServedIssuer returns a development issuer serving its discovery document and keys
+func newServedIssuer(t *testing.T) *devauth.Issuer {
+	key, err := devauth.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the issuer url is only known once the server is listening
+	var issuer *devauth.Issuer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	issuer = devauth.NewIssuer(server.URL, "plants-test", key)
+	return issuer
+}
+
+func TestServer_Router_DevIssuer(t *testing.T) {
+	// the development issuer is served where DEV_AUTH_ADDRESS says, once it is resolved
+	var served atomic.Pointer[devauth.Issuer]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer := served.Load()
+		if issuer == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		issuer.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	t.Setenv("DEV_AUTH_ADDRESS", strings.TrimPrefix(server.URL, "http://"))
+	t.Setenv("DEV_AUTH_KEY_FILE", filepath.Join(t.TempDir(), "key.pem"))
+	t.Setenv("AUTH0_AUDIENCE", "plants-test")
+	// mints as plantsctl token mint does, with the key the api checks tokens against
+	dev, err := devauth.NewIssuerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signs as Auth0 would, so its tokens are checked as Auth0's are
+	auth0 := newServedIssuer(t)
+	auth0Issuers := `[{"url": "` + auth0.URL + `", "kind": "auth0", "audiences": ["plants-test"], "primary": true}]`
+	tests := []struct {
+		name         string
+		devAuth      string
+		tokenIssuers string
+		minter       *devauth.Issuer
+		code         int
+	}{
+		{
+			name:    "router accepts development tokens when DEV_AUTH is true",
+			devAuth: "true",
+			minter:  dev,
+			code:    200,
+		},
+		{
+			name:         "router refuses development tokens when DEV_AUTH is not true",
+			tokenIssuers: auth0Issuers,
+			minter:       dev,
+			code:         401,
+		},
+		{
+			name:         "router accepts auth0 tokens",
+			tokenIssuers: auth0Issuers,
+			minter:       auth0,
+			code:         200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.minter.Mint(devauth.TokenOptions{Subject: "auth0|dev", Scopes: []string{"read:plants"}, ClientID: "nursery-app", Tenant: "greenhouse"}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mockDB := new(db.MockDB)
+			mockDB.On("GetTenant", "greenhouse", mock.Anything).Return(&pkg.Tenant{ID: "greenhouse", Name: "Greenhouse"}, nil)
+			mockDB.On("GetRoleAssignments", "auth0|dev", mock.Anything).Return([]pkg.RoleAssignment{{Subject: "auth0|dev", Role: policy.RoleEditor}}, nil)
+			mockDB.On("GetRole", mock.Anything, mock.Anything).Return((*pkg.Role)(nil), errors.New(db.ErrNotFound))
+			t.Setenv("DEV_AUTH", tt.devAuth)
+			t.Setenv("TOKEN_ISSUERS", tt.tokenIssuers)
+			issuers, issuer := ResolveIssuers()
+			served.Store(issuer)
+			s := &Server{db: mockDB, issuers: issuers, policy: policy.NewEngine(mockDB, []string{policy.RoleViewer}), tenancy: NewTenancy("")}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/v1/policy/explain?permission="+pkg.PermissionUpdatePlants, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			s.Router().ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("Router() response code: %d, expected %d", w.Code, tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "GetTenant", mock.Anything, mock.Anything)
+				return
+			}
+			// the subject is taken as it is, so it has the roles assigned to it, and the
+			// org_id claim picks the tenant, whichever of the two issued the token
+			var decision pkg.Decision
+			err = json.Unmarshal(w.Body.Bytes(), &decision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decision.Allowed || decision.Subject != "auth0|dev" || len(decision.Roles) != 1 || decision.Roles[0] != policy.RoleEditor {
+				t.Errorf("Router() decided %v, expected auth0|dev allowed as an editor", decision)
+			}
+			mockDB.AssertCalled(t, "GetTenant", "greenhouse", mock.Anything)
+		})
+	}
+}
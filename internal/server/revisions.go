@@ -0,0 +1,95 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const revertSuffix = ":revert"
+
+func (s *Server) HandleGetRevisions(c *gin.Context) {
+	if c.Param("name") == "" {
+		log.Println("revisions request missing name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	revisions, err := s.db.GetRevisions(c.Param("name"), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// HandleDiffRevisions returns the field level changes between the revisions given by
+// the from and to query parameters
+func (s *Server) HandleDiffRevisions(c *gin.Context) {
+	if c.Param("name") == "" || c.Query("from") == "" || c.Query("to") == "" {
+		log.Println("diff request missing name, from or to")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	from, err := s.db.GetRevision(c.Param("name"), c.Query("from"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	to, err := s.db.GetRevision(c.Param("name"), c.Query("to"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pkg.DiffPlants(from.Snapshot, to.Snapshot))
+}
+
+// HandleRevisionAction handles custom methods on a revision, e.g. POST .../revisions/{id}:revert
+func (s *Server) HandleRevisionAction(c *gin.Context) {
+	id, found := strings.CutSuffix(c.Param("id"), revertSuffix)
+	if !found {
+		log.Println("unknown revision action " + c.Param("id"))
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if c.Param("name") == "" || id == "" {
+		log.Println("revert request missing name or revision id")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionRevertPlants, s.plantResource(c, c.Param("name"))) {
+		return
+	}
+	// under moderation a revert is a change like any other, held for review unless the
+	// caller may publish
+	if s.moderation {
+		revision, err := s.db.GetRevision(c.Param("name"), id, c)
+		if err != nil {
+			writeDBError(c, err)
+			return
+		}
+		if s.holdForReview(c, revision.Snapshot) {
+			return
+		}
+	}
+	before := s.auditBefore(c, c.Param("name"))
+	plant, err := s.db.RevertPlant(c.Param("name"), id, middleware.GetSubject(c.Request), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionRevert, plant.Name, before, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, *plant)
+}
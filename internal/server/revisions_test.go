@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleDiffRevisions(t *testing.T) {
+	from := &pkg.Revision{ID: "1", Snapshot: pkg.Plant{Name: "test", Description: "old"}}
+	to := &pkg.Revision{ID: "2", Snapshot: pkg.Plant{Name: "test", Description: "new"}}
+	tests := []struct {
+		name    string
+		query   string
+		from    *pkg.Revision
+		fromErr error
+		code    int
+		want    []pkg.FieldChange
+	}{
+		{
+			name:  "handle diff revisions fails if from or to is missing",
+			query: "from=1",
+			code:  400,
+		},
+		{
+			name:    "handle diff revisions fails if a revision is not found",
+			query:   "from=1&to=2",
+			from:    nil,
+			fromErr: errors.New(db.ErrNotFound),
+			code:    404,
+		},
+		{
+			name:  "handle diff revisions returns changed fields",
+			query: "from=1&to=2",
+			from:  from,
+			code:  200,
+			want:  []pkg.FieldChange{{Field: "description", From: "old", To: "new"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/plant/test/diff?"+tt.query, nil)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetRevision", "test", "1", c).Return(tt.from, tt.fromErr)
+			mockDB.On("GetRevision", "test", "2", c).Return(to, nil)
+			s := &Server{db: mockDB}
+			s.HandleDiffRevisions(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleDiffRevisions response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.want != nil {
+				var got []pkg.FieldChange
+				err := json.Unmarshal(w.Body.Bytes(), &got)
+				if err != nil {
+					t.Error(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("HandleDiffRevisions returned %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleRevisionAction(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		plant *pkg.Plant
+		err   error
+		code  int
+	}{
+		{
+			name: "handle revision action fails if action is unknown",
+			id:   "1:undo",
+			code: 404,
+		},
+		{
+			name: "handle revision action fails if revision is missing",
+			id:   ":revert",
+			code: 400,
+		},
+		{
+			name:  "handle revision action fails if db returns an error",
+			id:    "1:revert",
+			plant: nil,
+			err:   errors.New("test"),
+			code:  500,
+		},
+		{
+			name:  "handle revision action reverts the plant",
+			id:    "1:revert",
+			plant: &pkg.Plant{Name: "test", Description: "old"},
+			code:  200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = &http.Request{
+				Header: make(http.Header),
+			}
+			c.Request.Method = "POST"
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"}, gin.Param{Key: "id", Value: tt.id})
+			mockDB := new(db.MockDB)
+			mockDB.On("RevertPlant", "test", "1", "", c).Return(tt.plant, tt.err)
+			s := &Server{db: mockDB}
+			s.HandleRevisionAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleRevisionAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRevisionAction_Moderation(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		code    int
+		held    bool
+	}{
+		{
+			name:    "handle revision action holds a revert by a caller who may not publish for review",
+			subject: "rita",
+			code:    202,
+			held:    true,
+		},
+		{
+			name:    "handle revision action reverts for an editor, who may publish",
+			subject: "erin",
+			code:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant/test/revisions/1:revert", nil), tt.subject, "")
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "test"}, gin.Param{Key: "id", Value: "1:revert"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetRole", "reverter", mock.Anything).Return(&pkg.Role{Name: "reverter", Grants: []pkg.Grant{{Permission: pkg.PermissionReadPlants}, {Permission: pkg.PermissionRevertPlants}}}, nil)
+			mockDB.On("GetRevision", "test", "1", mock.Anything).Return(&pkg.Revision{ID: "1", Snapshot: pkg.Plant{Name: "test", Description: "old"}}, nil)
+			mockDB.On("GetPlant", "test", mock.Anything).Return(&pkg.Plant{Name: "test", Description: "new"}, nil)
+			mockDB.On("CreateProposal", mock.MatchedBy(func(proposal pkg.Proposal) bool {
+				return proposal.Kind == pkg.ProposalUpdate && proposal.Plant.Description == "old" && proposal.Author == "rita"
+			}), mock.Anything).Return(nil)
+			mockDB.On("RevertPlant", "test", "1", tt.subject, mock.Anything).Return(&pkg.Plant{Name: "test", Description: "old"}, nil)
+			s := newModeratedServer(mockDB, map[string]string{"rita": "reverter", "erin": policy.RoleEditor})
+			s.HandleRevisionAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleRevisionAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.held {
+				mockDB.AssertCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "RevertPlant", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				mockDB.AssertNotCalled(t, "CreateProposal", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
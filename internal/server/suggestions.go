@@ -0,0 +1,369 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reviewSuffix = ":review"
+	mergeSuffix  = ":merge"
+)
+
+// Decisions an editor makes on each suggested change
+const (
+	decisionAccept = "accept"
+	decisionReject = "reject"
+)
+
+// suggestionRequest is the body of a request suggesting changes to a plant. Without a base
+// revision the changes are made against the plant's latest revision.
+type suggestionRequest struct {
+	BaseRevision string `json:"base_revision"`
+	Changes      []struct {
+		Field string      `json:"field"`
+		To    interface{} `json:"to"`
+	} `json:"changes"`
+	Comment string `json:"comment"`
+}
+
+// suggestionReview is the body of a request deciding a suggestion. A review decides every
+// change, while a merge only takes a comment.
+type suggestionReview struct {
+	Decisions map[string]string `json:"decisions"`
+	Comment   string            `json:"comment"`
+}
+
+// HandleCreateSuggestion suggests changes to some fields of a plant, which any caller who
+// can read the catalog may do, e.g. POST /v1/plant/{name}/suggestions
+// {"base_revision": "...", "changes": [{"field": "description", "to": "..."}]}
+func (s *Server) HandleCreateSuggestion(c *gin.Context) {
+	var request suggestionRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if c.Param("name") == "" || len(request.Changes) == 0 {
+		log.Println("suggestion request missing name or changes")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	plant, err := s.db.GetPlant(c.Param("name"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	base, baseRevision, err := s.suggestionBase(c, *plant, request.BaseRevision)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	suggestion := pkg.Suggestion{
+		PlantName:    plant.Name,
+		ID:           newID(),
+		BaseRevision: baseRevision,
+		Changes:      []pkg.SuggestedChange{},
+		Comment:      request.Comment,
+		Status:       pkg.SuggestionOpen,
+		Author:       middleware.GetSubject(c.Request),
+		CreatedAt:    time.Now().UTC(),
+	}
+	seen := map[string]bool{}
+	for _, change := range request.Changes {
+		to, err := pkg.FieldValue(change.Field, change.To)
+		if err == nil && seen[change.Field] {
+			err = errors.New(change.Field + " is suggested more than once")
+		}
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		seen[change.Field] = true
+		from, err := pkg.PlantField(base, change.Field)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if from == to {
+			continue
+		}
+		suggestion.Changes = append(suggestion.Changes, pkg.SuggestedChange{Field: change.Field, From: from, To: to})
+	}
+	if len(suggestion.Changes) == 0 {
+		log.Println("suggestion changes nothing")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = s.db.CreateSuggestion(suggestion, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	merged, err := s.withMerge(c, suggestion)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, merged)
+}
+
+// suggestionBase returns the plant as it was at the revision a suggestion is made against,
+// and that revision's id. Plants written before revisions were recorded have none, so
+// suggestions for them are made against the plant as it is.
+func (s *Server) suggestionBase(c *gin.Context, plant pkg.Plant, id string) (pkg.Plant, string, error) {
+	if id != "" {
+		revision, err := s.db.GetRevision(plant.Name, id, c)
+		if err != nil {
+			return pkg.Plant{}, "", err
+		}
+		return revision.Snapshot, revision.ID, nil
+	}
+	latest, err := s.latestRevision(c, plant.Name)
+	if err != nil || latest == nil {
+		return plant, "", err
+	}
+	return latest.Snapshot, latest.ID, nil
+}
+
+// latestRevision returns the plant's most recent revision, or nil if it has none
+func (s *Server) latestRevision(c *gin.Context, name string) (*pkg.Revision, error) {
+	revisions, err := s.db.GetRevisions(name, c)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return &revisions[len(revisions)-1], nil
+}
+
+// withMerge fills in how an open suggestion merges with the plant as it is now
+func (s *Server) withMerge(c *gin.Context, suggestion pkg.Suggestion) (pkg.Suggestion, error) {
+	if suggestion.Status != pkg.SuggestionOpen {
+		return suggestion, nil
+	}
+	plant, err := s.db.GetPlant(suggestion.PlantName, c)
+	if err != nil {
+		return pkg.Suggestion{}, err
+	}
+	merge, err := s.mergeSuggestion(c, *plant, suggestion)
+	if err != nil {
+		return pkg.Suggestion{}, err
+	}
+	suggestion.Merge = merge
+	return suggestion, nil
+}
+
+// mergeSuggestion merges a suggestion's changes with the plant as it is now
+func (s *Server) mergeSuggestion(c *gin.Context, plant pkg.Plant, suggestion pkg.Suggestion) (*pkg.SuggestionMerge, error) {
+	fields, err := pkg.MergeSuggestion(plant, suggestion.Changes)
+	if err != nil {
+		return nil, err
+	}
+	merge := &pkg.SuggestionMerge{Fields: fields}
+	latest, err := s.latestRevision(c, plant.Name)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		merge.CurrentRevision = latest.ID
+	}
+	merge.Stale = merge.CurrentRevision != suggestion.BaseRevision
+	return merge, nil
+}
+
+// HandleGetSuggestions lists the suggestions made for a plant, oldest first, optionally only
+// those in a ?status=
+func (s *Server) HandleGetSuggestions(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	suggestions, err := s.db.GetSuggestions(c.Param("name"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	filtered := []pkg.Suggestion{}
+	for _, suggestion := range suggestions {
+		if c.Query("status") == "" || suggestion.Status == c.Query("status") {
+			filtered = append(filtered, suggestion)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].CreatedAt.Before(filtered[j].CreatedAt) })
+	c.JSON(http.StatusOK, filtered)
+}
+
+// HandleGetSuggestion returns a suggestion and, while it is open, how it merges with the
+// plant as it is now
+func (s *Server) HandleGetSuggestion(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	suggestion, err := s.db.GetSuggestion(c.Param("name"), c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	merged, err := s.withMerge(c, *suggestion)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, merged)
+}
+
+// HandleSuggestionAction handles custom methods on a suggestion. POST .../{id}:review with
+// {"decisions": {"description": "accept", ...}} accepts or rejects each change, and :merge
+// accepts every change which merges cleanly and rejects those which conflict.
+func (s *Server) HandleSuggestionAction(c *gin.Context) {
+	param := c.Param("id")
+	switch {
+	case strings.HasSuffix(param, reviewSuffix):
+		s.decideSuggestion(c, strings.TrimSuffix(param, reviewSuffix), false)
+	case strings.HasSuffix(param, mergeSuffix):
+		s.decideSuggestion(c, strings.TrimSuffix(param, mergeSuffix), true)
+	default:
+		log.Println("unknown suggestion action " + param)
+		c.Writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// decideSuggestion applies an editor's decisions on each change of an open suggestion,
+// publishing the accepted changes on top of the plant as it is now. The suggestion is
+// decided before the plant is updated so two editors cannot both apply it.
+func (s *Server) decideSuggestion(c *gin.Context, id string, merge bool) {
+	var request suggestionReview
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name := c.Param("name")
+	if name == "" || id == "" {
+		log.Println("suggestion request missing name or id")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionUpdatePlants, s.plantResource(c, name)) {
+		return
+	}
+	if s.moderation && !s.authorize(c, pkg.PermissionPublishPlants, nil) {
+		return
+	}
+	suggestion, err := s.db.GetSuggestion(name, id, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	if suggestion.Status != pkg.SuggestionOpen {
+		log.Printf("suggestion %s is %s, not open", suggestion.ID, suggestion.Status)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	current, err := s.db.GetPlant(name, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	fields, err := pkg.MergeSuggestion(*current, suggestion.Changes)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	plant := *current
+	decided := *suggestion
+	decided.Changes = make([]pkg.SuggestedChange, len(suggestion.Changes))
+	accepted := 0
+	for i, field := range fields {
+		accept := field.Status != pkg.MergeConflict
+		if !merge {
+			decision, ok := request.Decisions[field.Field]
+			if decision != decisionAccept && decision != decisionReject {
+				log.Printf("review missing a decision to accept or reject %s, got %q", field.Field, decision)
+				c.Writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			accept = ok && decision == decisionAccept
+		}
+		decided.Changes[i] = suggestion.Changes[i]
+		decided.Changes[i].Accepted = &accept
+		if !accept {
+			continue
+		}
+		accepted++
+		err = pkg.SetPlantField(&plant, field.Field, field.Suggested)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	decided.Status = pkg.SuggestionPartiallyAccepted
+	switch accepted {
+	case 0:
+		decided.Status = pkg.SuggestionRejected
+	case len(fields):
+		decided.Status = pkg.SuggestionAccepted
+	}
+	decided.Reviewer = middleware.GetSubject(c.Request)
+	decided.ReviewedAt = &now
+	decided.ReviewComment = request.Comment
+	err = s.db.UpdateSuggestion(decided, pkg.SuggestionOpen, c)
+	if err != nil {
+		writeSuggestionError(c, err)
+		return
+	}
+	if len(pkg.DiffPlants(*current, plant)) == 0 {
+		// nothing was accepted, or only changes the plant already has
+		c.JSON(http.StatusOK, decided)
+		action := audit.ActionApprove
+		if decided.Status == pkg.SuggestionRejected {
+			action = audit.ActionReject
+		}
+		s.recordAudit(c, action, name, nil, nil)
+		return
+	}
+	// the suggestion's author is credited with the revision
+	err = s.db.UpdatePlant(plant, suggestion.Author, c)
+	if err != nil {
+		// reopen the suggestion so it can be decided again
+		if err := s.db.UpdateSuggestion(*suggestion, decided.Status, c); err != nil {
+			log.Printf("failed to reopen suggestion %s: %v", suggestion.ID, err)
+		}
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, decided)
+	s.recordAudit(c, audit.ActionApprove, name, current, plant)
+	s.publishEvent(c, pkg.EventPlantUpdated, plant)
+}
+
+// writeSuggestionError responds with conflict when a suggestion was decided under the request
+func writeSuggestionError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrSuggestionChanged) {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	writeDBError(c, err)
+}
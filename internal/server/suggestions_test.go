@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/policy"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleCreateSuggestion(t *testing.T) {
+	revisions := []pkg.Revision{
+		{PlantName: "monstera", ID: "1", Snapshot: pkg.Plant{Name: "monstera", Description: "big leaves"}},
+		{PlantName: "monstera", ID: "2", Snapshot: pkg.Plant{Name: "monstera", Description: "huge leaves"}},
+	}
+	tests := []struct {
+		name  string
+		body  string
+		code  int
+		base  string
+		stale bool
+	}{
+		{
+			name: "handle create suggestion makes changes against the latest revision",
+			body: `{"changes": [{"field": "description", "to": "split leaves"}]}`,
+			code: 200,
+			base: "2",
+		},
+		{
+			name:  "handle create suggestion against an older revision is stale",
+			body:  `{"base_revision": "1", "changes": [{"field": "description", "to": "split leaves"}]}`,
+			code:  200,
+			base:  "1",
+			stale: true,
+		},
+		{
+			name: "handle create suggestion fails if a field cannot be suggested",
+			body: `{"changes": [{"field": "name", "to": "philodendron"}]}`,
+			code: 400,
+		},
+		{
+			name: "handle create suggestion fails if nothing changes",
+			body: `{"changes": [{"field": "description", "to": "huge leaves"}]}`,
+			code: 400,
+		},
+		{
+			name: "handle create suggestion fails if a care interval is not whole days",
+			body: `{"changes": [{"field": "care.water_every_days", "to": 1.5}]}`,
+			code: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant/monstera/suggestions", bytes.NewBufferString(tt.body)), "alice", "")
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "monstera"})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(&pkg.Plant{Name: "monstera", Description: "huge leaves"}, nil)
+			mockDB.On("GetRevisions", "monstera", mock.Anything).Return(revisions, nil)
+			mockDB.On("GetRevision", "monstera", "1", mock.Anything).Return(&revisions[0], nil)
+			mockDB.On("CreateSuggestion", mock.Anything, mock.Anything).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleCreateSuggestion(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreateSuggestion response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "CreateSuggestion", mock.Anything, mock.Anything)
+				return
+			}
+			mockDB.AssertCalled(t, "CreateSuggestion", mock.MatchedBy(func(suggestion pkg.Suggestion) bool {
+				return suggestion.BaseRevision == tt.base && suggestion.Author == "alice" && suggestion.Status == pkg.SuggestionOpen
+			}), mock.Anything)
+			if stale := bytes.Contains(w.Body.Bytes(), []byte(`"stale":true`)); stale != tt.stale {
+				t.Errorf("HandleCreateSuggestion stale: %t, expected %t", stale, tt.stale)
+			}
+		})
+	}
+}
+
+func TestServer_HandleSuggestionAction(t *testing.T) {
+	suggestion := &pkg.Suggestion{PlantName: "monstera", ID: "1", BaseRevision: "1", Status: pkg.SuggestionOpen, Author: "alice", Changes: []pkg.SuggestedChange{
+		{Field: "description", From: "big leaves", To: "split leaves"},
+		{Field: "care.water_every_days", From: 7, To: 10},
+	}}
+	tests := []struct {
+		name        string
+		param       string
+		subject     string
+		body        string
+		plant       *pkg.Plant
+		code        int
+		status      string
+		description string
+		water       int
+	}{
+		{
+			name:        "handle suggestion action accepts every change",
+			param:       "1:review",
+			subject:     "erin",
+			body:        `{"decisions": {"description": "accept", "care.water_every_days": "accept"}}`,
+			plant:       &pkg.Plant{Name: "monstera", Description: "big leaves", Care: &pkg.CareProfile{WaterEveryDays: 7}},
+			code:        200,
+			status:      pkg.SuggestionAccepted,
+			description: "split leaves",
+			water:       10,
+		},
+		{
+			name:        "handle suggestion action partially accepts",
+			param:       "1:review",
+			subject:     "erin",
+			body:        `{"decisions": {"description": "accept", "care.water_every_days": "reject"}, "comment": "water is fine"}`,
+			plant:       &pkg.Plant{Name: "monstera", Description: "big leaves", Care: &pkg.CareProfile{WaterEveryDays: 7}},
+			code:        200,
+			status:      pkg.SuggestionPartiallyAccepted,
+			description: "split leaves",
+			water:       7,
+		},
+		{
+			name:    "handle suggestion action fails to review without deciding every change",
+			param:   "1:review",
+			subject: "erin",
+			body:    `{"decisions": {"description": "accept"}}`,
+			plant:   &pkg.Plant{Name: "monstera", Description: "big leaves", Care: &pkg.CareProfile{WaterEveryDays: 7}},
+			code:    400,
+		},
+		{
+			name:        "handle suggestion action merges clean changes and rejects conflicts",
+			param:       "1:merge",
+			subject:     "erin",
+			plant:       &pkg.Plant{Name: "monstera", Description: "huge leaves", Care: &pkg.CareProfile{WaterEveryDays: 7}},
+			code:        200,
+			status:      pkg.SuggestionPartiallyAccepted,
+			description: "huge leaves",
+			water:       10,
+		},
+		{
+			name:    "handle suggestion action forbids a contributor reviewing",
+			param:   "1:merge",
+			subject: "alice",
+			plant:   &pkg.Plant{Name: "monstera", Description: "big leaves", Care: &pkg.CareProfile{WaterEveryDays: 7}},
+			code:    403,
+		},
+		{
+			name:    "handle suggestion action fails if action is unknown",
+			param:   "1:approve",
+			subject: "erin",
+			plant:   &pkg.Plant{Name: "monstera", Description: "big leaves"},
+			code:    404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest(http.MethodPost, "/v1/plant/monstera/suggestions/"+tt.param, bytes.NewBufferString(tt.body)), tt.subject, "")
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: "monstera"}, gin.Param{Key: "id", Value: tt.param})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetSuggestion", "monstera", "1", mock.Anything).Return(suggestion, nil)
+			mockDB.On("GetPlant", "monstera", mock.Anything).Return(tt.plant, nil)
+			mockDB.On("UpdateSuggestion", mock.Anything, pkg.SuggestionOpen, mock.Anything).Return(nil)
+			mockDB.On("UpdatePlant", mock.Anything, "alice", mock.Anything).Return(nil)
+			s := newModeratedServer(mockDB, map[string]string{"alice": policy.RoleContributor, "erin": policy.RoleEditor})
+			s.HandleSuggestionAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleSuggestionAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "UpdateSuggestion", mock.Anything, mock.Anything, mock.Anything)
+				mockDB.AssertNotCalled(t, "UpdatePlant", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			mockDB.AssertCalled(t, "UpdateSuggestion", mock.MatchedBy(func(decided pkg.Suggestion) bool {
+				return decided.Status == tt.status && decided.Reviewer == "erin"
+			}), pkg.SuggestionOpen, mock.Anything)
+			mockDB.AssertCalled(t, "UpdatePlant", mock.MatchedBy(func(plant pkg.Plant) bool {
+				return plant.Description == tt.description && plant.Care != nil && plant.Care.WaterEveryDays == tt.water
+			}), "alice", mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/care"
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	completeSuffix = ":complete"
+	snoozeSuffix   = ":snooze"
+	skipSuffix     = ":skip"
+)
+
+// snoozeRequest is the body of a snooze. Until takes precedence over Days, and without
+// either the task is snoozed for a day.
+type snoozeRequest struct {
+	Until string `json:"until"`
+	Days  int    `json:"days"`
+}
+
+// scheduleOptions reads the user's timezone from the tz query parameter or X-Timezone
+// header and their hemisphere from the hemisphere query parameter
+func scheduleOptions(c *gin.Context) (care.Options, bool) {
+	name := c.Query("tz")
+	if name == "" {
+		name = c.GetHeader("X-Timezone")
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return care.Options{}, false
+	}
+	hemisphere := c.DefaultQuery("hemisphere", care.HemisphereNorth)
+	if hemisphere != care.HemisphereNorth && hemisphere != care.HemisphereSouth {
+		log.Println("invalid hemisphere " + hemisphere)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return care.Options{}, false
+	}
+	return care.Options{Location: location, Hemisphere: hemisphere, Now: time.Now()}, true
+}
+
+// parseTime accepts either an RFC 3339 timestamp or a date, which is read as midnight
+// in the given location
+func parseTime(value string, location *time.Location) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, location)
+}
+
+// entryTasks computes the tasks for the given collection entries. Plants no longer in
+// the catalog have no tasks.
+func (s *Server) entryTasks(c *gin.Context, entries []pkg.CollectionEntry, states map[string]pkg.TaskState, options care.Options) ([]pkg.CareTask, error) {
+	return care.CollectionTasks(entries, states, options, db.CareProfiles(s.db, c))
+}
+
+// userTasks computes every care task for the plants in a user's collection
+func (s *Server) userTasks(c *gin.Context, userID string, options care.Options) ([]pkg.CareTask, error) {
+	entries, err := s.db.GetCollection(userID, c)
+	if err != nil {
+		return nil, err
+	}
+	states, err := s.db.GetTaskStates(userID, c)
+	if err != nil {
+		return nil, err
+	}
+	return s.entryTasks(c, entries, states, options)
+}
+
+// HandleGetTasks lists the user's care tasks, only those due before the due_before query
+// parameter if it is given
+func (s *Server) HandleGetTasks(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	options, ok := scheduleOptions(c)
+	if !ok {
+		return
+	}
+	var dueBefore time.Time
+	if c.Query("due_before") != "" {
+		var err error
+		dueBefore, err = parseTime(c.Query("due_before"), options.Location)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	tasks, err := s.userTasks(c, userID, options)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !dueBefore.IsZero() {
+		due := []pkg.CareTask{}
+		for _, task := range tasks {
+			if task.DueAt.Before(dueBefore) {
+				due = append(due, task)
+			}
+		}
+		tasks = due
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// HandleTaskAction handles custom methods on a task: {id}:complete, {id}:snooze and {id}:skip
+func (s *Server) HandleTaskAction(c *gin.Context) {
+	userID, ok := collectionUser(c)
+	if !ok {
+		return
+	}
+	param := c.Param("id")
+	i := strings.LastIndex(param, ":")
+	if i < 0 {
+		log.Println("unknown task action " + param)
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id, action := param[:i], param[i:]
+	if action != completeSuffix && action != snoozeSuffix && action != skipSuffix {
+		log.Println("unknown task action " + action)
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	options, ok := scheduleOptions(c)
+	if !ok {
+		return
+	}
+	entry, task, state, err := s.findTask(c, userID, id, options)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+
+	switch action {
+	case completeSuffix:
+		state = care.Complete(state, options.Now.UTC())
+	case skipSuffix:
+		state = care.Skip(state, task.DueAt.UTC())
+	case snoozeSuffix:
+		until, err := snoozeUntil(c, options)
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		state = care.Snooze(state, until.UTC())
+	}
+	err = s.db.PutTaskState(state, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tasks, err := s.entryTasks(c, []pkg.CollectionEntry{*entry}, map[string]pkg.TaskState{state.ID: state}, options)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, updated := range tasks {
+		if updated.ID == id {
+			c.JSON(http.StatusOK, updated)
+			return
+		}
+	}
+	c.Writer.WriteHeader(http.StatusNotFound)
+}
+
+// findTask returns the collection entry a task belongs to, the task's current occurrence and its state
+func (s *Server) findTask(c *gin.Context, userID string, id string, options care.Options) (*pkg.CollectionEntry, *pkg.CareTask, pkg.TaskState, error) {
+	entryID, _, ok := care.ParseTaskID(id)
+	if !ok {
+		return nil, nil, pkg.TaskState{}, errors.New(db.ErrNotFound)
+	}
+	entry, err := s.db.GetCollectionEntry(userID, entryID, c)
+	if err != nil {
+		return nil, nil, pkg.TaskState{}, err
+	}
+	states, err := s.db.GetTaskStates(userID, c)
+	if err != nil {
+		return nil, nil, pkg.TaskState{}, err
+	}
+	tasks, err := s.entryTasks(c, []pkg.CollectionEntry{*entry}, states, options)
+	if err != nil {
+		return nil, nil, pkg.TaskState{}, err
+	}
+	for i := range tasks {
+		if tasks[i].ID == id {
+			state, ok := states[id]
+			if !ok {
+				state = pkg.TaskState{UserID: userID, ID: id}
+			}
+			return entry, &tasks[i], state, nil
+		}
+	}
+	return nil, nil, pkg.TaskState{}, errors.New(db.ErrNotFound)
+}
+
+func snoozeUntil(c *gin.Context, options care.Options) (time.Time, error) {
+	var request snoozeRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return time.Time{}, err
+	}
+	if request.Until != "" {
+		return parseTime(request.Until, options.Location)
+	}
+	if request.Days < 0 {
+		return time.Time{}, errors.New("snooze days must not be negative")
+	}
+	return options.Now.AddDate(0, 0, max(1, request.Days)), nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleGetTasks(t *testing.T) {
+	acquired := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		kinds []string
+	}{
+		{
+			name:  "handle get tasks fails if the timezone is unknown",
+			query: "tz=Nowhere/Special",
+			code:  400,
+		},
+		{
+			name:  "handle get tasks returns every task",
+			query: "tz=America/New_York",
+			code:  200,
+			kinds: []string{pkg.TaskWater, pkg.TaskRepot},
+		},
+		{
+			name:  "handle get tasks filters by due date",
+			query: "tz=America/New_York&due_before=" + time.Now().AddDate(0, 0, 30).Format(time.DateOnly),
+			code:  200,
+			kinds: []string{pkg.TaskWater},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest("GET", "/v1/me/tasks?"+tt.query, nil), "user", "")
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCollection", "user", c).Return([]pkg.CollectionEntry{
+				{UserID: "user", ID: "1", PlantName: "fern", AcquiredOn: acquired},
+				{UserID: "user", ID: "2", PlantName: "gone", AcquiredOn: acquired},
+			}, nil)
+			mockDB.On("GetTaskStates", "user", c).Return(map[string]pkg.TaskState{}, nil)
+			mockDB.On("GetPlant", "fern", c).Return(&pkg.Plant{Name: "fern", Care: &pkg.CareProfile{WaterEveryDays: 7, RepotEveryDays: 365}}, nil)
+			mockDB.On("GetPlant", "gone", c).Return((*pkg.Plant)(nil), errors.New(db.ErrNotFound))
+			s := &Server{db: mockDB}
+			s.HandleGetTasks(c)
+			if c.Writer.Status() != tt.code {
+				t.Fatalf("HandleGetTasks response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			var got []pkg.CareTask
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kinds := []string{}
+			for _, task := range got {
+				kinds = append(kinds, task.Kind)
+			}
+			if strings.Join(kinds, ",") != strings.Join(tt.kinds, ",") {
+				t.Errorf("HandleGetTasks returned %v, want %v", kinds, tt.kinds)
+			}
+		})
+	}
+}
+
+func TestServer_HandleTaskAction(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		code   int
+		verify func(pkg.TaskState) bool
+	}{
+		{
+			name: "handle task action fails if the action is unknown",
+			id:   "1.water:water",
+			code: 404,
+		},
+		{
+			name: "handle task action fails if the task does not exist",
+			id:   "1.prune:complete",
+			code: 404,
+		},
+		{
+			name: "handle task action completes the task",
+			id:   "1.water:complete",
+			code: 200,
+			verify: func(state pkg.TaskState) bool {
+				return state.ID == "1.water" && state.CompletedAt != nil && state.Anchor != nil
+			},
+		},
+		{
+			name: "handle task action snoozes the task",
+			id:   "1.water:snooze",
+			code: 200,
+			verify: func(state pkg.TaskState) bool {
+				return state.SnoozedUntil != nil && state.SnoozedUntil.After(time.Now())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = withSubject(httptest.NewRequest("POST", "/v1/me/tasks/"+tt.id, nil), "user", "")
+			c.Params = append(c.Params, gin.Param{Key: "id", Value: tt.id})
+			mockDB := new(db.MockDB)
+			mockDB.On("GetCollectionEntry", "user", "1", c).Return(&pkg.CollectionEntry{UserID: "user", ID: "1", PlantName: "fern", AcquiredOn: "2024-01-01"}, nil)
+			mockDB.On("GetTaskStates", "user", c).Return(map[string]pkg.TaskState{}, nil)
+			mockDB.On("GetPlant", "fern", c).Return(&pkg.Plant{Name: "fern", Care: &pkg.CareProfile{WaterEveryDays: 7}}, nil)
+			if tt.verify != nil {
+				mockDB.On("PutTaskState", mock.MatchedBy(tt.verify), c).Return(nil)
+			}
+			s := &Server{db: mockDB}
+			s.HandleTaskAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleTaskAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/ratelimit"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tenantKey       = "tenant"
+	tenantConfigKey = "tenant_config"
+	// tenantConfigTTL is how long a tenant's config is used before it is read again, so
+	// changes made with plantsctl take effect within this time
+	tenantConfigTTL = time.Minute
+)
+
+// Tenancy decides which tenant each request acts for
+type Tenancy struct {
+	// Header names a request header trusted to carry the tenant, e.g. one set by a gateway
+	// in front of the api, or is empty if tenants only come from tokens
+	Header string
+
+	mu      sync.Mutex
+	configs map[string]cachedTenant
+	now     func() time.Time
+}
+
+type cachedTenant struct {
+	tenant *pkg.Tenant
+	readAt time.Time
+}
+
+func NewTenancy(header string) *Tenancy {
+	return &Tenancy{Header: header, configs: map[string]cachedTenant{}, now: time.Now}
+}
+
+// ResolveTenancy turns on tenants if TENANTS is true. Callers act for the tenant in their
+// token, or in the header named by TENANT_HEADER, and otherwise for the default tenant.
+func ResolveTenancy() *Tenancy {
+	if os.Getenv("TENANTS") != "true" {
+		return nil
+	}
+	return NewTenancy(os.Getenv("TENANT_HEADER"))
+}
+
+// resolveTenant makes the rest of the request act for the caller's tenant. A request whose
+// token and header name different tenants, or which names a tenant that does not exist,
+// is forbidden.
+func (s *Server) resolveTenant(c *gin.Context) {
+	if s.tenancy == nil {
+		c.Next()
+		return
+	}
+	id := ""
+	claims, ok := requestClaims(c)
+	if ok {
+		if custom, ok := claims.CustomClaims.(*middleware.CustomClaims); ok {
+			id = custom.Tenant
+		}
+	}
+	if s.tenancy.Header != "" {
+		if header := c.GetHeader(s.tenancy.Header); header != "" {
+			if id != "" && id != header {
+				log.Printf("token tenant %s does not match header tenant %s", id, header)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			id = header
+		}
+	}
+	if !s.actAsTenant(c, id) {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// actAsTenant makes the request act for the tenant, responding with an error if it cannot
+func (s *Server) actAsTenant(c *gin.Context, id string) bool {
+	if id != tenant.Default {
+		if !tenant.Valid(id) {
+			log.Println("invalid tenant " + id)
+			c.Writer.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		config, err := s.tenantConfig(c, id)
+		if err != nil && err.Error() == db.ErrNotFound {
+			log.Println("unknown tenant " + id)
+			c.Writer.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		if err != nil {
+			log.Println(err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+		c.Set(tenantConfigKey, config)
+	}
+	c.Set(tenantKey, id)
+	c.Request = c.Request.WithContext(tenant.WithID(c.Request.Context(), id))
+	return true
+}
+
+// tenantConfig returns the config of a tenant, read again once it is older than the TTL
+func (s *Server) tenantConfig(c *gin.Context, id string) (*pkg.Tenant, error) {
+	t := s.tenancy
+	t.mu.Lock()
+	cached, ok := t.configs[id]
+	t.mu.Unlock()
+	if ok && t.now().Sub(cached.readAt) < tenantConfigTTL {
+		return cached.tenant, nil
+	}
+	config, err := s.db.GetTenant(id, c)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.configs[id] = cachedTenant{tenant: config, readAt: t.now()}
+	t.mu.Unlock()
+	return config, nil
+}
+
+// requestTenantConfig returns the config of the tenant the request acts for, or nil for the
+// default tenant, which has no limits and every feature
+func requestTenantConfig(c *gin.Context) *pkg.Tenant {
+	value, ok := c.Get(tenantConfigKey)
+	if !ok {
+		return nil
+	}
+	return value.(*pkg.Tenant)
+}
+
+// featureEnabled reports whether the tenant the request acts for may use the feature
+func featureEnabled(c *gin.Context, feature string) bool {
+	config := requestTenantConfig(c)
+	return config == nil || config.Enabled(feature)
+}
+
+// requireFeature is a route middleware which forbids tenants which have the feature
+// turned off
+func (s *Server) requireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !featureEnabled(c, feature) {
+			log.Printf("feature %s is turned off for tenant %s", feature, c.GetString(tenantKey))
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// defaultTenantOnly is a route middleware for routes covering every tenant, such as the
+// audit log, which only callers of the default tenant may use
+func defaultTenantOnly(c *gin.Context) {
+	if c.GetString(tenantKey) != tenant.Default {
+		log.Printf("tenant %s cannot use %s", c.GetString(tenantKey), c.FullPath())
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+}
+
+// withinPlantQuota checks the tenant's catalog has room for another plant, responding with
+// forbidden if it does not
+func (s *Server) withinPlantQuota(c *gin.Context) bool {
+	config := requestTenantConfig(c)
+	if config == nil || config.MaxPlants <= 0 {
+		return true
+	}
+	plants, err := s.db.ListPlants(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if len(plants) >= config.MaxPlants {
+		log.Printf("tenant %s has reached its quota of %d plants", config.ID, config.MaxPlants)
+		c.Writer.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// tenantRateLimit returns the limit on the whole tenant's requests, if it has one
+func tenantRateLimit(c *gin.Context) (ratelimit.Limit, bool) {
+	config := requestTenantConfig(c)
+	if config == nil || config.RateLimit == "" {
+		return ratelimit.Limit{}, false
+	}
+	limit, err := ratelimit.ParseLimit(config.RateLimit)
+	if err != nil {
+		log.Printf("tenant %s has an invalid rate limit: %v", config.ID, err)
+		return ratelimit.Limit{}, false
+	}
+	return limit, true
+}
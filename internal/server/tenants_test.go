@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+// withTenant adds claims for a caller of the tenant to the request
+func withTenant(r *http.Request, subject string, id string) *http.Request {
+	claims := &validator.ValidatedClaims{
+		RegisteredClaims: validator.RegisteredClaims{Subject: subject},
+		CustomClaims:     &middleware.CustomClaims{Tenant: id},
+	}
+	return r.WithContext(context.WithValue(r.Context(), jwtmiddleware.ContextKey{}, claims))
+}
+
+func newTenantDB() *db.MockDB {
+	mockDB := new(db.MockDB)
+	mockDB.On("GetTenant", "greenhouse", mock.Anything).Return(&pkg.Tenant{ID: "greenhouse", DisabledFeatures: []string{pkg.FeatureWebhooks}}, nil)
+	mockDB.On("GetTenant", mock.Anything, mock.Anything).Return((*pkg.Tenant)(nil), errors.New(db.ErrNotFound))
+	return mockDB
+}
+
+func TestServer_resolveTenant(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenancy *Tenancy
+		request func(*http.Request) *http.Request
+		code    int
+		tenant  string
+	}{
+		{
+			name:    "resolve tenant acts for the tenant in the token",
+			tenancy: NewTenancy("X-Tenant"),
+			request: func(r *http.Request) *http.Request { return withTenant(r, "alice", "greenhouse") },
+			code:    200,
+			tenant:  "greenhouse",
+		},
+		{
+			name:    "resolve tenant acts for the tenant in the trusted header",
+			tenancy: NewTenancy("X-Tenant"),
+			request: func(r *http.Request) *http.Request {
+				r.Header.Set("X-Tenant", "greenhouse")
+				return withSubject(r, "alice", "")
+			},
+			code:   200,
+			tenant: "greenhouse",
+		},
+		{
+			name:    "resolve tenant forbids a header naming another tenant than the token",
+			tenancy: NewTenancy("X-Tenant"),
+			request: func(r *http.Request) *http.Request {
+				r.Header.Set("X-Tenant", "orchard")
+				return withTenant(r, "alice", "greenhouse")
+			},
+			code: 403,
+		},
+		{
+			name:    "resolve tenant forbids an unknown tenant",
+			tenancy: NewTenancy("X-Tenant"),
+			request: func(r *http.Request) *http.Request { return withTenant(r, "alice", "orchard") },
+			code:    403,
+		},
+		{
+			name:    "resolve tenant acts for the default tenant without one",
+			tenancy: NewTenancy("X-Tenant"),
+			request: func(r *http.Request) *http.Request { return withSubject(r, "alice", "") },
+			code:    200,
+			tenant:  tenant.Default,
+		},
+		{
+			name: "resolve tenant ignores tenants when they are turned off",
+			request: func(r *http.Request) *http.Request {
+				r.Header.Set("X-Tenant", "greenhouse")
+				return withTenant(r, "alice", "greenhouse")
+			},
+			code:   200,
+			tenant: tenant.Default,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: newTenantDB(), tenancy: tt.tenancy}
+			r := gin.New()
+			r.ContextWithFallback = true
+			var acting string
+			r.GET("/v1/plants", captureClaims, s.resolveTenant, func(c *gin.Context) {
+				acting = tenant.ID(c)
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.request(httptest.NewRequest(http.MethodGet, "/v1/plants", nil)))
+			if w.Code != tt.code {
+				t.Errorf("resolveTenant response code: %d, expected %d", w.Code, tt.code)
+			}
+			if acting != tt.tenant {
+				t.Errorf("resolveTenant acted for %q, expected %q", acting, tt.tenant)
+			}
+		})
+	}
+}
+
+func TestServer_requireFeature(t *testing.T) {
+	s := &Server{db: newTenantDB(), tenancy: NewTenancy("")}
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(captureClaims, s.resolveTenant)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/v1/admin/webhooks", s.requireFeature(pkg.FeatureWebhooks), ok)
+	r.GET("/v1/me/plants", s.requireFeature(pkg.FeatureCollections), ok)
+	tests := []struct {
+		name    string
+		path    string
+		request func(*http.Request) *http.Request
+		code    int
+	}{
+		{
+			name:    "require feature forbids a feature turned off for the tenant",
+			path:    "/v1/admin/webhooks",
+			request: func(r *http.Request) *http.Request { return withTenant(r, "alice", "greenhouse") },
+			code:    403,
+		},
+		{
+			name:    "require feature allows the tenant's other features",
+			path:    "/v1/me/plants",
+			request: func(r *http.Request) *http.Request { return withTenant(r, "alice", "greenhouse") },
+			code:    200,
+		},
+		{
+			name:    "require feature allows every feature to the default tenant",
+			path:    "/v1/admin/webhooks",
+			request: func(r *http.Request) *http.Request { return withSubject(r, "alice", "") },
+			code:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.request(httptest.NewRequest(http.MethodGet, tt.path, nil)))
+			if w.Code != tt.code {
+				t.Errorf("requireFeature response code: %d, expected %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestServer_HandleCreatePlant_Quota(t *testing.T) {
+	tests := []struct {
+		name   string
+		plants []pkg.Plant
+		code   int
+	}{
+		{
+			name:   "handle create plant creates a plant within the tenant's quota",
+			plants: []pkg.Plant{{Name: "fern"}},
+			code:   200,
+		},
+		{
+			name:   "handle create plant forbids a plant over the tenant's quota",
+			plants: []pkg.Plant{{Name: "fern"}, {Name: "palm"}},
+			code:   403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(db.MockDB)
+			mockDB.On("GetTenant", "greenhouse", mock.Anything).Return(&pkg.Tenant{ID: "greenhouse", MaxPlants: 2}, nil)
+			mockDB.On("ListPlants", mock.Anything).Return(tt.plants, nil)
+			mockDB.On("CreatePlant", mock.Anything, "alice", mock.MatchedBy(func(ctx context.Context) bool {
+				return tenant.ID(ctx) == "greenhouse"
+			})).Return(nil)
+			s := &Server{db: mockDB, tenancy: NewTenancy("")}
+			r := gin.New()
+			r.ContextWithFallback = true
+			r.POST("/v1/plant", captureClaims, s.resolveTenant, s.HandleCreatePlant)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, withTenant(httptest.NewRequest(http.MethodPost, "/v1/plant", bytes.NewBufferString(`{"name": "monstera", "description": "big leaves"}`)), "alice", "greenhouse"))
+			if w.Code != tt.code {
+				t.Errorf("HandleCreatePlant response code: %d, expected %d", w.Code, tt.code)
+			}
+			if tt.code != 200 {
+				mockDB.AssertNotCalled(t, "CreatePlant", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
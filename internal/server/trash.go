@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/middleware"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const restoreSuffix = ":restore"
+
+func (s *Server) HandleListTrash(c *gin.Context) {
+	if !s.authorize(c, pkg.PermissionReadPlants, nil) {
+		return
+	}
+	plants, err := s.db.ListTrash(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, plants)
+}
+
+// HandlePlantAction handles custom methods on a plant, e.g. POST /v1/plant/{name}:restore,
+// :archive, :unarchive and :merge
+func (s *Server) HandlePlantAction(c *gin.Context) {
+	param := c.Param("name")
+	switch {
+	case strings.HasSuffix(param, restoreSuffix):
+		s.restorePlant(c, strings.TrimSuffix(param, restoreSuffix))
+	case strings.HasSuffix(param, archiveSuffix):
+		s.archivePlant(c, strings.TrimSuffix(param, archiveSuffix), true)
+	case strings.HasSuffix(param, unarchiveSuffix):
+		s.archivePlant(c, strings.TrimSuffix(param, unarchiveSuffix), false)
+	case strings.HasSuffix(param, mergeSuffix):
+		s.mergePlant(c, strings.TrimSuffix(param, mergeSuffix))
+	case strings.HasSuffix(param, renameSuffix):
+		s.renamePlant(c, strings.TrimSuffix(param, renameSuffix))
+	default:
+		log.Println("unknown plant action " + param)
+		c.Writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// restorePlant takes a plant out of the trash
+func (s *Server) restorePlant(c *gin.Context, name string) {
+	if name == "" {
+		log.Println("restore request missing name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionRestorePlants, s.trashedPlantResource(c, name)) {
+		return
+	}
+	plant, err := s.db.RestorePlant(name, middleware.GetSubject(c.Request), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionRestore, plant.Name, nil, plant)
+	s.publishEvent(c, pkg.EventPlantRestored, *plant)
+}
+
+// HandlePurgePlant permanently deletes a plant from the trash
+func (s *Server) HandlePurgePlant(c *gin.Context) {
+	if c.Param("name") == "" {
+		log.Println("purge request missing name")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !s.authorize(c, pkg.PermissionPurgePlants, s.trashedPlantResource(c, c.Param("name"))) {
+		return
+	}
+	plant, err := s.db.PurgePlant(c.Param("name"), middleware.GetSubject(c.Request), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plant)
+	s.recordAudit(c, audit.ActionPurge, plant.Name, plant, nil)
+	s.publishEvent(c, pkg.EventPlantPurged, *plant)
+}
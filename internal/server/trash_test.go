@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+func TestServer_HandlePlantAction(t *testing.T) {
+	tests := []struct {
+		name  string
+		param string
+		plant *pkg.Plant
+		err   error
+		code  int
+	}{
+		{
+			name:  "handle plant action fails if action is unknown",
+			param: "test:compost",
+			code:  404,
+		},
+		{
+			name:  "handle plant action fails if name is missing",
+			param: ":restore",
+			code:  400,
+		},
+		{
+			name:  "handle plant action fails if plant is not in the trash",
+			param: "test:restore",
+			plant: nil,
+			err:   errors.New(db.ErrNotFound),
+			code:  404,
+		},
+		{
+			name:  "handle plant action restores the plant",
+			param: "test:restore",
+			plant: &pkg.Plant{Name: "test", Description: "test"},
+			code:  200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = &http.Request{
+				Header: make(http.Header),
+			}
+			c.Request.Method = "POST"
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: tt.param})
+			mockDB := new(db.MockDB)
+			mockDB.On("RestorePlant", "test", "", c).Return(tt.plant, tt.err)
+			s := &Server{db: mockDB}
+			s.HandlePlantAction(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandlePlantAction response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/plants/internal/audit"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/internal/webhooks"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+const replaySuffix = ":replay"
+
+// publishEvent sends a catalog event to the change feed and the webhook subscriptions,
+// unless the table's stream is being read, which publishes the change instead. Failing
+// to publish does not fail the request, which has already been written.
+func (s *Server) publishEvent(c *gin.Context, eventType string, plant pkg.Plant) {
+	s.sendEvent(c, webhooks.NewEvent(eventType, plant))
+}
+
+// sendEvent publishes an event built by the caller, for events which carry more than the plant
+func (s *Server) sendEvent(c *gin.Context, event pkg.Event) {
+	if len(s.consumers) > 0 {
+		return
+	}
+	event.Tenant = tenant.ID(c)
+	if s.events != nil {
+		s.events.Publish(event)
+	}
+	if s.webhooks == nil || !featureEnabled(c, pkg.FeatureWebhooks) {
+		return
+	}
+	err := s.webhooks.Publish(event, c)
+	if err != nil {
+		log.Printf("failed to publish %s event for %s: %v", event.Type, event.Plant.Name, err)
+	}
+}
+
+func (s *Server) HandleListSubscriptions(c *gin.Context) {
+	subscriptions, err := s.db.ListSubscriptions(c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// HandleCreateSubscription subscribes a url to the given events. The url must resolve to
+// public addresses only. The response holds the secret deliveries are signed with, which
+// is not returned again.
+func (s *Server) HandleCreateSubscription(c *gin.Context) {
+	var subscription pkg.Subscription
+	err := json.NewDecoder(c.Request.Body).Decode(&subscription)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		log.Println("webhook subscription needs an http or https url")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = webhooks.CheckURL(subscription.URL, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(subscription.Events) == 0 {
+		log.Println("webhook subscription has no events")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, event := range subscription.Events {
+		if !slices.Contains(pkg.EventTypes, event) {
+			log.Println("unknown webhook event " + event)
+			c.Writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	subscription.ID = newID()
+	subscription.Secret = hex.EncodeToString(b)
+	subscription.CreatedAt = time.Now().UTC()
+	err = s.db.CreateSubscription(subscription, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+	s.recordAudit(c, audit.ActionCreate, auditResource("webhook", subscription.ID), nil, subscription)
+}
+
+func (s *Server) HandleGetSubscription(c *gin.Context) {
+	subscription, err := s.db.GetSubscription(c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	subscription.Secret = ""
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (s *Server) HandleDeleteSubscription(c *gin.Context) {
+	subscription, err := s.db.DeleteSubscription(c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	before := *subscription
+	subscription.Secret = ""
+	c.JSON(http.StatusOK, subscription)
+	s.recordAudit(c, audit.ActionDelete, auditResource("webhook", before.ID), before, nil)
+}
+
+// HandleGetWebhookDeliveries returns the delivery log of a subscription, oldest first
+func (s *Server) HandleGetWebhookDeliveries(c *gin.Context) {
+	_, err := s.db.GetSubscription(c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	deliveries, err := s.db.GetWebhookDeliveries(c.Param("id"), c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// HandleWebhookDeliveryAction handles custom methods on a delivery, e.g.
+// POST .../deliveries/{id}:replay, which sends the delivery's event again
+func (s *Server) HandleWebhookDeliveryAction(c *gin.Context) {
+	id, found := strings.CutSuffix(c.Param("delivery"), replaySuffix)
+	if !found {
+		log.Println("unknown delivery action " + c.Param("delivery"))
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if s.webhooks == nil {
+		log.Println("webhooks are not configured")
+		c.Writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	subscription, err := s.db.GetSubscription(c.Param("id"), c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	original, err := s.db.GetWebhookDelivery(subscription.ID, id, c)
+	if err != nil {
+		writeDBError(c, err)
+		return
+	}
+	delivery, err := s.webhooks.Replay(*subscription, *original, c)
+	if err != nil {
+		log.Println(err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
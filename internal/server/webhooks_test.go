@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServer_HandleCreateSubscription(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{
+			name: "handle create subscription fails without an http url",
+			body: `{"url":"ftp://example.com","events":["plant.created"]}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription fails for unknown events",
+			body: `{"url":"https://example.com/hook","events":["plant.watered"]}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription fails without events",
+			body: `{"url":"https://example.com/hook"}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription fails for a loopback url",
+			body: `{"url":"http://localhost:8080/hook","events":["plant.created","plant.deleted"]}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription fails for the metadata address",
+			body: `{"url":"http://169.254.169.254/latest/meta-data","events":["plant.created","plant.deleted"]}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription fails for a private url",
+			body: `{"url":"http://10.0.0.1/hook","events":["plant.created","plant.deleted"]}`,
+			code: 400,
+		},
+		{
+			name: "handle create subscription generates an id and secret",
+			body: `{"url":"https://93.184.215.14/hook","events":["plant.created","plant.deleted"]}`,
+			code: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/v1/admin/webhooks", strings.NewReader(tt.body))
+			mockDB := new(db.MockDB)
+			mockDB.On("CreateSubscription", mock.MatchedBy(func(s pkg.Subscription) bool {
+				return s.ID != "" && len(s.Secret) == 64 && len(s.Events) == 2
+			}), c).Return(nil)
+			s := &Server{db: mockDB}
+			s.HandleCreateSubscription(c)
+			if c.Writer.Status() != tt.code {
+				t.Errorf("HandleCreateSubscription response code: %d, expected %d", c.Writer.Status(), tt.code)
+			}
+			if tt.code == 200 && !strings.Contains(w.Body.String(), `"secret"`) {
+				t.Errorf("HandleCreateSubscription response %s has no secret", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_Subscriptions_HideSecret(t *testing.T) {
+	subscription := pkg.Subscription{ID: "search", URL: "https://example.com/hook", Events: []string{pkg.EventPlantCreated}, Secret: "secret"}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		handle func(s *Server) gin.HandlerFunc
+	}{
+		{name: "list", method: "GET", path: "/v1/admin/webhooks", handle: func(s *Server) gin.HandlerFunc { return s.HandleListSubscriptions }},
+		{name: "get", method: "GET", path: "/v1/admin/webhooks/search", handle: func(s *Server) gin.HandlerFunc { return s.HandleGetSubscription }},
+		{name: "delete", method: "DELETE", path: "/v1/admin/webhooks/search", handle: func(s *Server) gin.HandlerFunc { return s.HandleDeleteSubscription }},
+	}
+	for _, tt := range tests {
+		t.Run("handle "+tt.name+" subscription leaves out the secret", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(tt.method, tt.path, nil)
+			c.Params = gin.Params{{Key: "id", Value: "search"}}
+			mockDB := new(db.MockDB)
+			listed, got, deleted := subscription, subscription, subscription
+			mockDB.On("ListSubscriptions", c).Return([]pkg.Subscription{listed}, nil)
+			mockDB.On("GetSubscription", "search", c).Return(&got, nil)
+			mockDB.On("DeleteSubscription", "search", c).Return(&deleted, nil)
+			tt.handle(&Server{db: mockDB})(c)
+			if c.Writer.Status() != 200 || strings.Contains(w.Body.String(), "secret") {
+				t.Errorf("Handle%sSubscription response %d %s", tt.name, c.Writer.Status(), w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// checkpointsTable is keyed by the consumer group and shard_id
+const checkpointsTable = "plants_v1_stream_checkpoints"
+
+// Checkpoint is how far a consumer has read a shard
+type Checkpoint struct {
+	// SequenceNumber is the last record handled, or empty if none has been
+	SequenceNumber string `dynamodbav:"sequence_number"`
+	// Finished is set once a closed shard has been read to its end
+	Finished bool `dynamodbav:"finished"`
+}
+
+// CheckpointStore remembers how far each shard has been read
+type CheckpointStore interface {
+	Get(shardID string, context context.Context) (Checkpoint, error)
+	Put(shardID string, checkpoint Checkpoint, context context.Context) error
+}
+
+// MemoryCheckpoints keeps checkpoints for the life of the process
+type MemoryCheckpoints struct {
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+func NewMemoryCheckpoints() *MemoryCheckpoints {
+	return &MemoryCheckpoints{checkpoints: map[string]Checkpoint{}}
+}
+
+func (m *MemoryCheckpoints) Get(shardID string, context context.Context) (Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkpoints[shardID], nil
+}
+
+func (m *MemoryCheckpoints) Put(shardID string, checkpoint Checkpoint, context context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints[shardID] = checkpoint
+	return nil
+}
+
+// DynamoCheckpoints keeps the checkpoints of a consumer group in DynamoDB, so the group
+// resumes where it left off after a restart or when another replica takes over
+type DynamoCheckpoints struct {
+	client *dynamodb.Client
+	group  string
+}
+
+func NewDynamoCheckpoints(client *dynamodb.Client, group string) *DynamoCheckpoints {
+	return &DynamoCheckpoints{client: client, group: group}
+}
+
+func (d *DynamoCheckpoints) key(shardID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"group":    &types.AttributeValueMemberS{Value: d.group},
+		"shard_id": &types.AttributeValueMemberS{Value: shardID},
+	}
+}
+
+func (d *DynamoCheckpoints) Get(shardID string, context context.Context) (Checkpoint, error) {
+	if shardID == "" {
+		return Checkpoint{}, errors.New("missing shard")
+	}
+	output, err := d.client.GetItem(context, &dynamodb.GetItemInput{TableName: aws.String(checkpointsTable), Key: d.key(shardID), ConsistentRead: aws.Bool(true)})
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	var checkpoint Checkpoint
+	err = attributevalue.UnmarshalMap(output.Item, &checkpoint)
+	return checkpoint, err
+}
+
+func (d *DynamoCheckpoints) Put(shardID string, checkpoint Checkpoint, context context.Context) error {
+	item, err := attributevalue.MarshalMap(checkpoint)
+	if err != nil {
+		return err
+	}
+	for name, value := range d.key(shardID) {
+		item[name] = value
+	}
+	_, err = d.client.PutItem(context, &dynamodb.PutItemInput{TableName: aws.String(checkpointsTable), Item: item})
+	return err
+}
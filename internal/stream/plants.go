@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"context"
+	"log"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// renamedFromAttribute is set on the item a rename writes under the plant's new name, with
+// its old name
+const renamedFromAttribute = "renamed_from"
+
+// PlantEvent describes a change to the plants table as a catalog event. Moving a plant to
+// or from the trash or the archive is told apart from other updates by its deleted_at and
+// archived_at attributes, and the tenant whose catalog changed by its tenant attribute. A
+// plant inserted under a new name by a rename is renamed rather than created.
+func PlantEvent(record Record) (pkg.Event, error) {
+	var before, after pkg.Plant
+	err := attributevalue.UnmarshalMap(record.OldImage, &before)
+	if err != nil {
+		return pkg.Event{}, err
+	}
+	err = attributevalue.UnmarshalMap(record.NewImage, &after)
+	if err != nil {
+		return pkg.Event{}, err
+	}
+	event := pkg.Event{ID: record.EventID, OccurredAt: record.At.UTC(), Plant: after}
+	renamedFrom, _ := record.NewImage[renamedFromAttribute].(*types.AttributeValueMemberS)
+	switch {
+	case record.EventName == EventInsert && renamedFrom != nil:
+		event.Type = pkg.EventPlantRenamed
+		event.PreviousName = renamedFrom.Value
+	case record.EventName == EventInsert:
+		event.Type = pkg.EventPlantCreated
+	case record.EventName == EventRemove:
+		event.Type = pkg.EventPlantPurged
+		event.Plant = before
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		event.Type = pkg.EventPlantDeleted
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		event.Type = pkg.EventPlantRestored
+	case before.ArchivedAt == nil && after.ArchivedAt != nil:
+		event.Type = pkg.EventPlantArchived
+	case before.ArchivedAt != nil && after.ArchivedAt == nil:
+		event.Type = pkg.EventPlantUnarchived
+	default:
+		event.Type = pkg.EventPlantUpdated
+	}
+	event.Tenant = recordTenant(record)
+	event.Plant.Name = tenant.Unkey(event.Tenant, event.Plant.Name)
+	return event, nil
+}
+
+// recordTenant returns the tenant of the item a record is about
+func recordTenant(record Record) string {
+	for _, image := range []map[string]types.AttributeValue{record.NewImage, record.OldImage} {
+		if value, ok := image["tenant"].(*types.AttributeValueMemberS); ok {
+			return value.Value
+		}
+	}
+	return tenant.Default
+}
+
+// Plants finds a plant by its id
+type Plants interface {
+	ResolvePlant(key string, ctx context.Context) (*pkg.PlantRef, error)
+}
+
+// PlantHandler adapts a function taking catalog events into a record handler, called with
+// a context acting for the event's tenant. Records which are not plants are logged and
+// skipped, since reading them again would not help.
+//
+// A rename removes the plant's old name in the same transaction as it inserts the new one,
+// which is described by the plant.renamed event. A published plant's removal is skipped
+// rather than taken for a purge when its id has moved to another name.
+func PlantHandler(handle func(pkg.Event, context.Context) error, plants Plants) Handler {
+	return func(record Record, ctx context.Context) error {
+		event, err := PlantEvent(record)
+		if err != nil {
+			log.Printf("skipping stream record %s: %v", record.EventID, err)
+			return nil
+		}
+		ctx = tenant.WithID(ctx, event.Tenant)
+		if event.Type == pkg.EventPlantPurged && event.Plant.DeletedAt == nil && event.Plant.ID != "" {
+			ref, err := plants.ResolvePlant(event.Plant.ID, ctx)
+			if err != nil && err.Error() != db.ErrNotFound {
+				return err
+			}
+			if err == nil && ref.Name != event.Plant.Name {
+				return nil
+			}
+		}
+		return handle(event, ctx)
+	}
+}
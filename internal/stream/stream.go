@@ -0,0 +1,347 @@
+// Package stream reads the change stream of the plants table and hands each change to
+// subscribers, so changes made outside the api are seen as well as those made through it
+package stream
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/plants/internal/notify"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+const (
+	// pollInterval is how long a shard reader waits when there are no new records
+	pollInterval = time.Second
+	// retryInterval is how long a shard reader waits after a failure before reading again
+	retryInterval = 5 * time.Second
+)
+
+// Record is a change to one item, with its images before and after as written to the table
+type Record struct {
+	// EventID is unique to the change, so subscribers can drop records delivered twice
+	EventID        string
+	EventName      string
+	SequenceNumber string
+	At             time.Time
+	Keys           map[string]types.AttributeValue
+	OldImage       map[string]types.AttributeValue
+	NewImage       map[string]types.AttributeValue
+}
+
+// Handler handles a change. Returning an error delivers the record again later, so
+// handlers must cope with seeing a record more than once.
+type Handler func(Record, context.Context) error
+
+// StreamsAPI is the part of the DynamoDB Streams client the consumer uses
+type StreamsAPI interface {
+	DescribeStream(context.Context, *dynamodbstreams.DescribeStreamInput, ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(context.Context, *dynamodbstreams.GetShardIteratorInput, ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(context.Context, *dynamodbstreams.GetRecordsInput, ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// Options configure a consumer
+type Options struct {
+	// Checkpoints remember how far each shard has been read
+	Checkpoints CheckpointStore
+	// Lock, if set, makes the consumer read only while this replica holds it
+	Lock notify.Lock
+	// StartAtLatest skips the changes already in an open shard which has no checkpoint,
+	// rather than reading from the oldest record kept
+	StartAtLatest bool
+	// Interval is how often the consumer looks for new shards
+	Interval time.Duration
+}
+
+// Consumer reads every shard of a stream, each in its own goroutine. Child shards are
+// read once their parent is finished so the changes to an item are seen in order.
+type Consumer struct {
+	client    StreamsAPI
+	streamARN string
+	options   Options
+	handlers  []Handler
+
+	mu      sync.Mutex
+	readers map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewConsumer(client StreamsAPI, streamARN string, options Options) *Consumer {
+	if options.Checkpoints == nil {
+		options.Checkpoints = NewMemoryCheckpoints()
+	}
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Second
+	}
+	return &Consumer{client: client, streamARN: streamARN, options: options, readers: map[string]context.CancelFunc{}}
+}
+
+// Subscribe adds a handler for every record. Handlers must be added before Run.
+func (c *Consumer) Subscribe(handler Handler) {
+	c.handlers = append(c.handlers, handler)
+}
+
+// Run reads the stream until the context is done
+func (c *Consumer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+	for {
+		leader := true
+		if c.options.Lock != nil {
+			var err error
+			leader, err = c.options.Lock.Acquire(ctx)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		if leader {
+			err := c.startReaders(ctx)
+			if err != nil {
+				log.Println(err)
+			}
+		} else {
+			c.stopReaders()
+		}
+		select {
+		case <-ctx.Done():
+			c.stopReaders()
+			c.wg.Wait()
+			if c.options.Lock != nil {
+				err := c.options.Lock.Release(context.Background())
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startReaders starts a reader for each shard which is ready to be read and not already
+func (c *Consumer) startReaders(ctx context.Context) error {
+	shards, err := c.shards(ctx)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, shard := range shards {
+		known[aws.ToString(shard.ShardId)] = true
+	}
+	for _, shard := range shards {
+		id := aws.ToString(shard.ShardId)
+		if c.reading(id) {
+			continue
+		}
+		checkpoint, err := c.options.Checkpoints.Get(id, ctx)
+		if err != nil {
+			return err
+		}
+		if checkpoint.Finished {
+			continue
+		}
+		if parent := aws.ToString(shard.ParentShardId); parent != "" && known[parent] {
+			parentCheckpoint, err := c.options.Checkpoints.Get(parent, ctx)
+			if err != nil {
+				return err
+			}
+			if !parentCheckpoint.Finished {
+				continue
+			}
+		}
+		c.startReader(ctx, shard, checkpoint)
+	}
+	return nil
+}
+
+func (c *Consumer) shards(ctx context.Context) ([]streamtypes.Shard, error) {
+	shards := []streamtypes.Shard{}
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(c.streamARN)}
+	for {
+		output, err := c.client.DescribeStream(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		if output.StreamDescription == nil {
+			return shards, nil
+		}
+		shards = append(shards, output.StreamDescription.Shards...)
+		if output.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = output.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+func (c *Consumer) reading(shardID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.readers[shardID]
+	return ok
+}
+
+func (c *Consumer) startReader(ctx context.Context, shard streamtypes.Shard, checkpoint Checkpoint) {
+	id := aws.ToString(shard.ShardId)
+	readerCtx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.readers[id] = cancel
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.readers, id)
+			c.mu.Unlock()
+			cancel()
+		}()
+		open := shard.SequenceNumberRange == nil || shard.SequenceNumberRange.EndingSequenceNumber == nil
+		c.readShard(readerCtx, id, checkpoint, open)
+	}()
+}
+
+func (c *Consumer) stopReaders() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cancel := range c.readers {
+		cancel()
+	}
+}
+
+// readShard hands each record of the shard to the handlers, checkpointing after each
+// batch, until the shard is finished or the context is done
+func (c *Consumer) readShard(ctx context.Context, shardID string, checkpoint Checkpoint, open bool) {
+	iterator, err := c.iterator(ctx, shardID, checkpoint, open)
+	for ctx.Err() == nil {
+		if err != nil {
+			log.Printf("reading stream shard %s: %v", shardID, err)
+			if !sleep(ctx, retryInterval) {
+				return
+			}
+			// start again from the last checkpoint, delivering any failed records again
+			checkpoint, err = c.options.Checkpoints.Get(shardID, ctx)
+			if err == nil {
+				iterator, err = c.iterator(ctx, shardID, checkpoint, open)
+			}
+			continue
+		}
+		var output *dynamodbstreams.GetRecordsOutput
+		output, err = c.client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+		var trimmed *streamtypes.TrimmedDataAccessException
+		if errors.As(err, &trimmed) {
+			// records past the checkpoint have expired, so carry on from the oldest kept
+			log.Printf("stream shard %s was trimmed past its checkpoint, some changes were missed", shardID)
+			checkpoint = Checkpoint{}
+			open = false
+			iterator, err = c.iterator(ctx, shardID, checkpoint, open)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, item := range output.Records {
+			var record Record
+			record, err = convert(item)
+			if err == nil {
+				err = c.handle(record, ctx)
+			}
+			if err != nil {
+				break
+			}
+			checkpoint.SequenceNumber = record.SequenceNumber
+		}
+		if err != nil {
+			c.saveCheckpoint(ctx, shardID, checkpoint)
+			continue
+		}
+		if output.NextShardIterator == nil {
+			checkpoint.Finished = true
+			c.saveCheckpoint(ctx, shardID, checkpoint)
+			return
+		}
+		if len(output.Records) > 0 {
+			c.saveCheckpoint(ctx, shardID, checkpoint)
+		} else if !sleep(ctx, pollInterval) {
+			return
+		}
+		iterator = output.NextShardIterator
+	}
+}
+
+// iterator starts reading after the checkpoint, or from the start or end of the shard
+func (c *Consumer) iterator(ctx context.Context, shardID string, checkpoint Checkpoint, open bool) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{StreamArn: aws.String(c.streamARN), ShardId: aws.String(shardID), ShardIteratorType: streamtypes.ShardIteratorTypeTrimHorizon}
+	if checkpoint.SequenceNumber != "" {
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeAfterSequenceNumber
+		input.SequenceNumber = aws.String(checkpoint.SequenceNumber)
+	} else if open && c.options.StartAtLatest {
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeLatest
+	}
+	output, err := c.client.GetShardIterator(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output.ShardIterator, nil
+}
+
+func (c *Consumer) handle(record Record, ctx context.Context) error {
+	for _, handler := range c.handlers {
+		err := handler(record, ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) saveCheckpoint(ctx context.Context, shardID string, checkpoint Checkpoint) {
+	err := c.options.Checkpoints.Put(shardID, checkpoint, ctx)
+	if err != nil {
+		log.Printf("checkpointing stream shard %s: %v", shardID, err)
+	}
+}
+
+func convert(item streamtypes.Record) (Record, error) {
+	record := Record{EventID: aws.ToString(item.EventID), EventName: string(item.EventName)}
+	if item.Dynamodb == nil {
+		return record, errors.New("stream record " + record.EventID + " has no change")
+	}
+	record.SequenceNumber = aws.ToString(item.Dynamodb.SequenceNumber)
+	record.At = aws.ToTime(item.Dynamodb.ApproximateCreationDateTime)
+	var err error
+	record.Keys, err = attributevalue.FromDynamoDBStreamsMap(item.Dynamodb.Keys)
+	if err != nil {
+		return record, err
+	}
+	record.OldImage, err = attributevalue.FromDynamoDBStreamsMap(item.Dynamodb.OldImage)
+	if err != nil {
+		return record, err
+	}
+	record.NewImage, err = attributevalue.FromDynamoDBStreamsMap(item.Dynamodb.NewImage)
+	return record, err
+}
+
+// sleep waits for the duration, returning false if the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
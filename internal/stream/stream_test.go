@@ -0,0 +1,251 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// fakeStream serves shards whose records are read one per GetRecords call. Closed shards
+// end after their last record.
+type fakeStream struct {
+	mu      sync.Mutex
+	shards  []streamtypes.Shard
+	records map[string][]streamtypes.Record
+}
+
+func (f *fakeStream) DescribeStream(ctx context.Context, input *dynamodbstreams.DescribeStreamInput, options ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &streamtypes.StreamDescription{Shards: f.shards}}, nil
+}
+
+func (f *fakeStream) GetShardIterator(ctx context.Context, input *dynamodbstreams.GetShardIteratorInput, options ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	position := 0
+	if input.ShardIteratorType == streamtypes.ShardIteratorTypeAfterSequenceNumber {
+		for i, record := range f.records[*input.ShardId] {
+			if *record.Dynamodb.SequenceNumber == *input.SequenceNumber {
+				position = i + 1
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(iterator(*input.ShardId, position))}, nil
+}
+
+func (f *fakeStream) GetRecords(ctx context.Context, input *dynamodbstreams.GetRecordsInput, options ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	shardID, position := parseIterator(*input.ShardIterator)
+	records := f.records[shardID]
+	output := &dynamodbstreams.GetRecordsOutput{}
+	if position < len(records) {
+		output.Records = records[position : position+1]
+	}
+	closed := false
+	for _, shard := range f.shards {
+		if *shard.ShardId == shardID {
+			closed = shard.SequenceNumberRange.EndingSequenceNumber != nil
+		}
+	}
+	if !closed || position+1 < len(records) {
+		output.NextShardIterator = aws.String(iterator(shardID, min(position+1, len(records))))
+	}
+	return output, nil
+}
+
+func iterator(shardID string, position int) string {
+	return shardID + "/" + string(rune('0'+position))
+}
+
+func parseIterator(iterator string) (string, int) {
+	return iterator[:len(iterator)-2], int(iterator[len(iterator)-1] - '0')
+}
+
+func streamRecord(id string, name streamtypes.OperationType, sequence string, plant string) streamtypes.Record {
+	return streamtypes.Record{EventID: aws.String(id), EventName: name, Dynamodb: &streamtypes.StreamRecord{
+		SequenceNumber: aws.String(sequence),
+		NewImage:       map[string]streamtypes.AttributeValue{"name": &streamtypes.AttributeValueMemberS{Value: plant}},
+	}}
+}
+
+func TestConsumer_Run(t *testing.T) {
+	fake := &fakeStream{
+		shards: []streamtypes.Shard{
+			{ShardId: aws.String("child"), ParentShardId: aws.String("parent"), SequenceNumberRange: &streamtypes.SequenceNumberRange{StartingSequenceNumber: aws.String("3")}},
+			{ShardId: aws.String("parent"), SequenceNumberRange: &streamtypes.SequenceNumberRange{StartingSequenceNumber: aws.String("1"), EndingSequenceNumber: aws.String("2")}},
+		},
+		records: map[string][]streamtypes.Record{
+			"parent": {streamRecord("a", "INSERT", "1", "fern"), streamRecord("b", "MODIFY", "2", "fern")},
+			"child":  {streamRecord("c", "MODIFY", "3", "fern")},
+		},
+	}
+	checkpoints := NewMemoryCheckpoints()
+	checkpoints.Put("parent", Checkpoint{SequenceNumber: "1"}, context.TODO())
+	consumer := NewConsumer(fake, "arn", Options{Checkpoints: checkpoints, Interval: 10 * time.Millisecond})
+	seen := make(chan string, 10)
+	failed := false
+	consumer.Subscribe(func(record Record, ctx context.Context) error {
+		if record.EventID == "c" && !failed {
+			failed = true
+			return errors.New("index unavailable")
+		}
+		seen <- record.EventID
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	got := []string{}
+	timeout := time.After(retryInterval + 5*time.Second)
+	for len(got) < 2 {
+		select {
+		case id := <-seen:
+			got = append(got, id)
+		case <-timeout:
+			t.Fatalf("Consumer.Run() handled %v", got)
+		}
+	}
+	cancel()
+	<-done
+	// the record before the checkpoint is skipped, the parent is read before the child and
+	// the failed record is delivered again
+	if got[0] != "b" || got[1] != "c" {
+		t.Errorf("Consumer.Run() handled %v, want [b c]", got)
+	}
+	parent, _ := checkpoints.Get("parent", context.TODO())
+	child, _ := checkpoints.Get("child", context.TODO())
+	if !parent.Finished || child.Finished || child.SequenceNumber != "3" {
+		t.Errorf("Consumer.Run() checkpoints parent %+v, child %+v", parent, child)
+	}
+}
+
+func TestPlantEvent(t *testing.T) {
+	deleted := &types.AttributeValueMemberS{Value: "2024-05-01T00:00:00Z"}
+	name := &types.AttributeValueMemberS{Value: "fern"}
+	tests := []struct {
+		name   string
+		record Record
+		want   string
+	}{
+		{
+			name:   "plant event for an insert is created",
+			record: Record{EventName: EventInsert, NewImage: map[string]types.AttributeValue{"name": name}},
+			want:   pkg.EventPlantCreated,
+		},
+		{
+			name:   "plant event for an edit is updated",
+			record: Record{EventName: EventModify, OldImage: map[string]types.AttributeValue{"name": name}, NewImage: map[string]types.AttributeValue{"name": name}},
+			want:   pkg.EventPlantUpdated,
+		},
+		{
+			name:   "plant event for moving to the trash is deleted",
+			record: Record{EventName: EventModify, OldImage: map[string]types.AttributeValue{"name": name}, NewImage: map[string]types.AttributeValue{"name": name, "deleted_at": deleted}},
+			want:   pkg.EventPlantDeleted,
+		},
+		{
+			name:   "plant event for leaving the trash is restored",
+			record: Record{EventName: EventModify, OldImage: map[string]types.AttributeValue{"name": name, "deleted_at": deleted}, NewImage: map[string]types.AttributeValue{"name": name}},
+			want:   pkg.EventPlantRestored,
+		},
+		{
+			name:   "plant event for archiving is archived",
+			record: Record{EventName: EventModify, OldImage: map[string]types.AttributeValue{"name": name}, NewImage: map[string]types.AttributeValue{"name": name, "archived_at": deleted}},
+			want:   pkg.EventPlantArchived,
+		},
+		{
+			name:   "plant event for an insert by a rename is renamed",
+			record: Record{EventName: EventInsert, NewImage: map[string]types.AttributeValue{"name": name, "renamed_from": &types.AttributeValueMemberS{Value: "boston fern"}}},
+			want:   pkg.EventPlantRenamed,
+		},
+		{
+			name:   "plant event for a removal is purged",
+			record: Record{EventName: EventRemove, OldImage: map[string]types.AttributeValue{"name": name, "deleted_at": deleted}},
+			want:   pkg.EventPlantPurged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := PlantEvent(tt.record)
+			if err != nil {
+				t.Fatalf("PlantEvent() error = %v", err)
+			}
+			if event.Type != tt.want || event.Plant.Name != "fern" {
+				t.Errorf("PlantEvent() = %s for %s, want %s", event.Type, event.Plant.Name, tt.want)
+			}
+		})
+	}
+}
+
+// plantsByID resolves plant ids to the names of plants
+type plantsByID map[string]string
+
+func (p plantsByID) ResolvePlant(key string, ctx context.Context) (*pkg.PlantRef, error) {
+	name, ok := p[key]
+	if !ok {
+		return nil, errors.New(db.ErrNotFound)
+	}
+	return &pkg.PlantRef{ID: key, Name: name}, nil
+}
+
+func TestPlantHandler(t *testing.T) {
+	deleted := &types.AttributeValueMemberS{Value: "2024-05-01T00:00:00Z"}
+	plants := plantsByID{"renamed": "sansevieria", "merged": "fern"}
+	tests := []struct {
+		name   string
+		record Record
+		want   []string
+	}{
+		{
+			name: "plant handler sends a rename once",
+			record: Record{EventName: EventInsert, NewImage: map[string]types.AttributeValue{
+				"name": &types.AttributeValueMemberS{Value: "sansevieria"}, "id": &types.AttributeValueMemberS{Value: "renamed"}, "renamed_from": &types.AttributeValueMemberS{Value: "snake plant"},
+			}},
+			want: []string{pkg.EventPlantRenamed},
+		},
+		{
+			name: "plant handler skips the removal of a renamed plant's old name",
+			record: Record{EventName: EventRemove, OldImage: map[string]types.AttributeValue{
+				"name": &types.AttributeValueMemberS{Value: "snake plant"}, "id": &types.AttributeValueMemberS{Value: "renamed"},
+			}},
+		},
+		{
+			name: "plant handler sends the removal of a merged plant as purged",
+			record: Record{EventName: EventRemove, OldImage: map[string]types.AttributeValue{
+				"name": &types.AttributeValueMemberS{Value: "fern"}, "id": &types.AttributeValueMemberS{Value: "merged"},
+			}},
+			want: []string{pkg.EventPlantPurged},
+		},
+		{
+			name: "plant handler sends the removal of a plant from the trash as purged",
+			record: Record{EventName: EventRemove, OldImage: map[string]types.AttributeValue{
+				"name": &types.AttributeValueMemberS{Value: "snake plant"}, "id": &types.AttributeValueMemberS{Value: "renamed"}, "deleted_at": deleted,
+			}},
+			want: []string{pkg.EventPlantPurged},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			handler := PlantHandler(func(event pkg.Event, ctx context.Context) error {
+				got = append(got, event.Type)
+				return nil
+			}, plants)
+			err := handler(tt.record, context.TODO())
+			if err != nil {
+				t.Fatalf("PlantHandler() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlantHandler() sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
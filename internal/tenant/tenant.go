@@ -0,0 +1,53 @@
+// Package tenant carries the organization a request acts for, whose catalog and data are
+// kept apart from every other organization's
+package tenant
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Default is the tenant of requests which name none. Its keys are not scoped, so data
+// written before there were tenants belongs to it.
+const Default = ""
+
+// separator joins a tenant to the keys it scopes
+const separator = "#"
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,62}$`)
+
+type contextKey struct{}
+
+// WithID returns a context acting for the tenant
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// ID returns the tenant the context acts for, or the default tenant
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Valid reports whether id may name a tenant: up to 63 letters, digits, dashes and
+// underscores, starting with a letter or digit, as in an Auth0 organization id
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// Key scopes a key to the tenant. The default tenant's keys are left as they are.
+func Key(id string, key string) string {
+	if id == Default {
+		return key
+	}
+	return id + separator + key
+}
+
+// Unkey returns the key a tenant's scoped key was made from
+func Unkey(id string, key string) string {
+	if id == Default {
+		return key
+	}
+	return strings.TrimPrefix(key, id+separator)
+}
@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for urls which reach an address that is not on the public
+// internet, e.g. loopback, link-local (including cloud metadata) or private networks
+var ErrPrivateAddress = errors.New("webhook url resolves to a non-public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP.IsPrivate leaves out
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress reports whether the ip is routable on the public internet
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+// CheckURL resolves the host of a subscription's url and returns ErrPrivateAddress unless
+// every address it resolves to is public
+func CheckURL(raw string, ctx context.Context) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host %s: %w", parsed.Hostname(), err)
+	}
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// newClient builds the client deliveries are sent with. It refuses to connect to
+// non-public addresses, so a host which resolves differently after the subscription was
+// checked, or a redirect, cannot reach the internal network either.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicAddress(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
@@ -0,0 +1,233 @@
+// Package webhooks delivers catalog change events to subscribed urls
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/notify"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+)
+
+const (
+	// EventHeader carries the type of the event being delivered
+	EventHeader = "X-Plants-Event"
+	// MaxAttempts is how many times a delivery is tried before it is dead lettered
+	MaxAttempts = 8
+	// deliveryRetention is how long the delivery log is kept
+	deliveryRetention = 30 * 24 * time.Hour
+	// publishTimeout bounds the background sends started by Publish
+	publishTimeout = time.Minute
+)
+
+// Publisher records a delivery of each event for every subscription wanting it and sends
+// them. Deliveries which fail are retried by Run with exponential backoff.
+type Publisher struct {
+	db       db.DBInterface
+	client   *http.Client
+	lock     notify.Lock
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewPublisher builds a publisher whose Run looks for deliveries to retry every interval
+func NewPublisher(database db.DBInterface, lock notify.Lock, interval time.Duration) *Publisher {
+	return &Publisher{db: database, client: newClient(), lock: lock, interval: interval, now: time.Now}
+}
+
+// NewEvent builds an event of the given type about the plant
+func NewEvent(eventType string, plant pkg.Plant) pkg.Event {
+	return pkg.Event{ID: randomID(), Type: eventType, OccurredAt: time.Now().UTC(), Plant: plant}
+}
+
+// Publish records a delivery of the event for each subscription, then sends them in the
+// background. Once recorded a delivery is retried even if the process stops before sending it.
+func (p *Publisher) Publish(event pkg.Event, ctx context.Context) error {
+	subscriptions, err := p.db.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		subscription pkg.Subscription
+		delivery     pkg.WebhookDelivery
+	}
+	sends := []pending{}
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(event.Type) {
+			continue
+		}
+		delivery := p.newDelivery(subscription.ID, event)
+		// recorded as failed so the retry loop picks it up if the send below is lost, but
+		// not before the send has had its chance
+		retryAt := delivery.CreatedAt.Add(publishTimeout)
+		delivery.Status = pkg.DeliveryFailed
+		delivery.NextAttemptAt = &retryAt
+		err = p.db.PutWebhookDelivery(delivery, ctx)
+		if err != nil {
+			return err
+		}
+		sends = append(sends, pending{subscription, delivery})
+	}
+	if len(sends) == 0 {
+		return nil
+	}
+	// the sends outlive the request, but must record their outcomes for its tenant
+	tenantID := tenant.ID(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(tenant.WithID(context.Background(), tenantID), publishTimeout)
+		defer cancel()
+		for _, send := range sends {
+			_, err := p.Deliver(send.subscription, send.delivery, ctx)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Replay sends the event of an earlier delivery again as a new delivery, whatever the
+// outcome of the original
+func (p *Publisher) Replay(subscription pkg.Subscription, original pkg.WebhookDelivery, ctx context.Context) (*pkg.WebhookDelivery, error) {
+	delivery := p.newDelivery(subscription.ID, original.Event)
+	delivery.ReplayOf = original.ID
+	return p.Deliver(subscription, delivery, ctx)
+}
+
+// Retry makes another attempt at every failed delivery which is due one
+func (p *Publisher) Retry(ctx context.Context) error {
+	deliveries, err := p.db.ListRetryableWebhookDeliveries(p.now(), ctx)
+	if err != nil {
+		return err
+	}
+	subscriptions := map[string]*pkg.Subscription{}
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			subscription, err = p.db.GetSubscription(delivery.SubscriptionID, ctx)
+			if err != nil && err.Error() != db.ErrNotFound {
+				return err
+			}
+			subscriptions[delivery.SubscriptionID] = subscription
+		}
+		if subscription == nil {
+			// the subscription was deleted, so stop trying
+			delivery.Status = pkg.DeliveryDead
+			delivery.NextAttemptAt = nil
+			delivery.LastError = "subscription deleted"
+			err = p.db.PutWebhookDelivery(delivery, ctx)
+		} else {
+			_, err = p.Deliver(*subscription, delivery, ctx)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// Run retries failed deliveries of every tenant every interval while this replica holds
+// the lock, until the context is done
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		leader, err := p.lock.Acquire(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+		if leader {
+			err = db.ForEachTenant(p.db, ctx, p.Retry)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			err = p.lock.Release(context.Background())
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver makes an attempt at the delivery and records the outcome in the delivery log
+func (p *Publisher) Deliver(subscription pkg.Subscription, delivery pkg.WebhookDelivery, ctx context.Context) (*pkg.WebhookDelivery, error) {
+	now := p.now()
+	delivery.Attempts++
+	code, err := p.send(subscription, delivery, now, ctx)
+	delivery.ResponseCode = code
+	if err == nil {
+		at := now.UTC()
+		delivery.Status = pkg.DeliveryDelivered
+		delivery.DeliveredAt = &at
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	} else if delivery.Attempts >= MaxAttempts {
+		log.Printf("dead lettering webhook delivery %s to %s: %v", delivery.ID, subscription.URL, err)
+		delivery.Status = pkg.DeliveryDead
+		delivery.NextAttemptAt = nil
+		delivery.LastError = err.Error()
+	} else {
+		next := now.Add(notify.RetryDelay(delivery.Attempts)).UTC()
+		delivery.Status = pkg.DeliveryFailed
+		delivery.NextAttemptAt = &next
+		delivery.LastError = err.Error()
+	}
+	return &delivery, p.db.PutWebhookDelivery(delivery, ctx)
+}
+
+// send posts the event signed the same way as reminder webhooks
+func (p *Publisher) send(subscription pkg.Subscription, delivery pkg.WebhookDelivery, now time.Time, ctx context.Context) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, delivery.Event.Type)
+	request.Header.Set(notify.DeliveryHeader, delivery.ID)
+	request.Header.Set(notify.SignatureHeader, notify.Sign(subscription.Secret, now, body))
+	response, err := p.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (p *Publisher) newDelivery(subscriptionID string, event pkg.Event) pkg.WebhookDelivery {
+	now := p.now().UTC()
+	return pkg.WebhookDelivery{
+		SubscriptionID: subscriptionID,
+		ID:             fmt.Sprintf("%020d-%s", now.UnixNano(), randomID()[:8]),
+		Event:          event,
+		Status:         pkg.DeliveryPending,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(deliveryRetention).Unix(),
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
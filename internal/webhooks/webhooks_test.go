@@ -0,0 +1,230 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SevvyP/plants/internal/db"
+	"github.com/SevvyP/plants/internal/notify"
+	"github.com/SevvyP/plants/internal/tenant"
+	"github.com/SevvyP/plants/pkg"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPublisher_Publish(t *testing.T) {
+	received := make(chan *http.Request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer server.Close()
+	ctx := context.TODO()
+	mockDB := new(db.MockDB)
+	mockDB.On("ListSubscriptions", ctx).Return([]pkg.Subscription{
+		{ID: "search", URL: server.URL, Events: []string{pkg.EventPlantCreated}, Secret: "secret"},
+		{ID: "recommendations", URL: server.URL, Events: []string{pkg.EventPlantDeleted}, Secret: "secret"},
+	}, nil)
+	recorded := mockDB.On("PutWebhookDelivery", mock.MatchedBy(func(d pkg.WebhookDelivery) bool {
+		return d.SubscriptionID == "search" && d.Status == pkg.DeliveryFailed && d.Attempts == 0
+	}), ctx).Return(nil)
+	delivered := make(chan pkg.WebhookDelivery, 1)
+	mockDB.On("PutWebhookDelivery", mock.MatchedBy(func(d pkg.WebhookDelivery) bool {
+		return d.Status == pkg.DeliveryDelivered
+	}), mock.Anything).Run(func(args mock.Arguments) {
+		delivered <- args.Get(0).(pkg.WebhookDelivery)
+	}).Return(nil)
+
+	p := NewPublisher(mockDB, nil, time.Minute)
+	// the test server listens on loopback, which deliveries are otherwise refused
+	p.client = server.Client()
+	err := p.Publish(NewEvent(pkg.EventPlantCreated, pkg.Plant{Name: "fern"}), ctx)
+	if err != nil {
+		t.Fatalf("Publisher.Publish() error = %v", err)
+	}
+	mockDB.AssertCalled(t, "PutWebhookDelivery", recorded.Arguments...)
+	select {
+	case r := <-received:
+		if r.Header.Get(EventHeader) != pkg.EventPlantCreated || r.Header.Get(notify.SignatureHeader) == "" {
+			t.Errorf("Publisher.Publish() sent headers %v", r.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publisher.Publish() sent nothing")
+	}
+	select {
+	case d := <-delivered:
+		if d.Attempts != 1 || d.ResponseCode != 200 {
+			t.Errorf("Publisher.Publish() recorded %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publisher.Publish() did not record the delivery")
+	}
+}
+
+func TestPublisher_Publish_Tenant(t *testing.T) {
+	sent := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent <- struct{}{}
+	}))
+	defer server.Close()
+	ctx := tenant.WithID(context.TODO(), "acme")
+	// deliveries recorded by each tenant, by id
+	var mu sync.Mutex
+	recorded := map[string]map[string]pkg.WebhookDelivery{}
+	delivered := make(chan struct{}, 1)
+	mockDB := new(db.MockDB)
+	mockDB.On("ListSubscriptions", ctx).Return([]pkg.Subscription{
+		{ID: "search", URL: server.URL, Events: []string{pkg.EventPlantCreated}, Secret: "secret"},
+	}, nil)
+	mockDB.On("PutWebhookDelivery", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		delivery, id := args.Get(0).(pkg.WebhookDelivery), tenant.ID(args.Get(1).(context.Context))
+		mu.Lock()
+		defer mu.Unlock()
+		if recorded[id] == nil {
+			recorded[id] = map[string]pkg.WebhookDelivery{}
+		}
+		recorded[id][delivery.ID] = delivery
+		if delivery.Status == pkg.DeliveryDelivered {
+			delivered <- struct{}{}
+		}
+	}).Return(nil)
+
+	p := NewPublisher(mockDB, nil, time.Minute)
+	// the test server listens on loopback, which deliveries are otherwise refused
+	p.client = server.Client()
+	err := p.Publish(NewEvent(pkg.EventPlantCreated, pkg.Plant{Name: "fern"}), ctx)
+	if err != nil {
+		t.Fatalf("Publisher.Publish() error = %v", err)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publisher.Publish() did not record the delivery")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(recorded[tenant.Default]) != 0 || len(recorded["acme"]) != 1 {
+		t.Fatalf("Publisher.Publish() recorded deliveries by tenant %v, expected one for acme only", recorded)
+	}
+	// once the send has had its chance, the tenant's retry finds nothing left to send
+	p.now = func() time.Time { return time.Now().Add(2 * publishTimeout) }
+	retryable := []pkg.WebhookDelivery{}
+	for _, delivery := range recorded["acme"] {
+		if delivery.Status == pkg.DeliveryFailed && !delivery.NextAttemptAt.After(p.now()) {
+			retryable = append(retryable, delivery)
+		}
+	}
+	mockDB.On("ListRetryableWebhookDeliveries", mock.Anything, ctx).Return(retryable, nil)
+	err = p.Retry(ctx)
+	if err != nil {
+		t.Fatalf("Publisher.Retry() error = %v", err)
+	}
+	if len(sent) != 1 {
+		t.Errorf("Publisher sent %d requests, expected the event once", len(sent))
+	}
+}
+
+func TestPublisher_Deliver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		attempts int
+		status   string
+	}{
+		{name: "deliver schedules a retry after a failure", attempts: 2, status: pkg.DeliveryFailed},
+		{name: "deliver dead letters the last attempt", attempts: MaxAttempts - 1, status: pkg.DeliveryDead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			mockDB := new(db.MockDB)
+			mockDB.On("PutWebhookDelivery", mock.Anything, ctx).Return(nil)
+			p := NewPublisher(mockDB, nil, time.Minute)
+			// the test server listens on loopback, which deliveries are otherwise refused
+			p.client = server.Client()
+			p.now = func() time.Time { return now }
+			delivery, err := p.Deliver(pkg.Subscription{ID: "search", URL: server.URL}, pkg.WebhookDelivery{SubscriptionID: "search", ID: "1", Attempts: tt.attempts}, ctx)
+			if err != nil {
+				t.Fatalf("Publisher.Deliver() error = %v", err)
+			}
+			if delivery.Status != tt.status || delivery.ResponseCode != http.StatusBadGateway {
+				t.Errorf("Publisher.Deliver() = %+v, want status %s", delivery, tt.status)
+			}
+			if tt.status == pkg.DeliveryFailed && !delivery.NextAttemptAt.Equal(now.Add(notify.RetryDelay(tt.attempts+1))) {
+				t.Errorf("Publisher.Deliver() next attempt at %v", delivery.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestPublisher_Retry(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+	mockDB := new(db.MockDB)
+	mockDB.On("ListRetryableWebhookDeliveries", mock.Anything, ctx).Return([]pkg.WebhookDelivery{
+		{SubscriptionID: "gone", ID: "1", Status: pkg.DeliveryFailed, NextAttemptAt: &now},
+	}, nil)
+	mockDB.On("GetSubscription", "gone", ctx).Return((*pkg.Subscription)(nil), errors.New(db.ErrNotFound))
+	mockDB.On("PutWebhookDelivery", mock.MatchedBy(func(d pkg.WebhookDelivery) bool {
+		return d.Status == pkg.DeliveryDead && d.NextAttemptAt == nil
+	}), ctx).Return(nil)
+	p := NewPublisher(mockDB, nil, time.Minute)
+	err := p.Retry(ctx)
+	if err != nil {
+		t.Fatalf("Publisher.Retry() error = %v", err)
+	}
+	mockDB.AssertNumberOfCalls(t, "PutWebhookDelivery", 1)
+}
+
+func TestPublisher_Deliver_PrivateAddress(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+	ctx := context.TODO()
+	mockDB := new(db.MockDB)
+	mockDB.On("PutWebhookDelivery", mock.Anything, ctx).Return(nil)
+	p := NewPublisher(mockDB, nil, time.Minute)
+	delivery, err := p.Deliver(pkg.Subscription{ID: "search", URL: server.URL}, pkg.WebhookDelivery{SubscriptionID: "search", ID: "1"}, ctx)
+	if err != nil {
+		t.Fatalf("Publisher.Deliver() error = %v", err)
+	}
+	if reached || delivery.Status != pkg.DeliveryFailed || !strings.Contains(delivery.LastError, ErrPrivateAddress.Error()) {
+		t.Errorf("Publisher.Deliver() = %+v, want the loopback address refused", delivery)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "http://127.0.0.1/hook", wantErr: true},
+		{url: "http://localhost:8080/hook", wantErr: true},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{url: "http://10.0.0.1/hook", wantErr: true},
+		{url: "http://192.168.1.10/hook", wantErr: true},
+		{url: "http://[::1]/hook", wantErr: true},
+		{url: "http://[fd00::1]/hook", wantErr: true},
+		{url: "http://100.64.0.1/hook", wantErr: true},
+		{url: "http://0.0.0.0/hook", wantErr: true},
+		{url: "https://93.184.215.14/hook", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := CheckURL(tt.url, context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
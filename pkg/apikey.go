@@ -0,0 +1,32 @@
+package pkg
+
+import "time"
+
+// APIKey lets a caller which cannot sign in with Auth0 use the api with a fixed set of
+// scopes. Only a hash of the key is stored; the key itself is shown once when it is minted.
+type APIKey struct {
+	// Prefix is the start of the key, used to look it up
+	Prefix    string    `json:"prefix" dynamodbav:"prefix"`
+	Name      string    `json:"name" dynamodbav:"name"`
+	Hash      string    `json:"-" dynamodbav:"hash"`
+	Scopes    []string  `json:"scopes" dynamodbav:"scopes"`
+	CreatedBy string    `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+	// ExpiresAt is when the key stops working, or nil if it does not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" dynamodbav:"revoked_at,omitempty"`
+	// Tenant is the tenant the key acts for, or empty for the default tenant
+	Tenant string `json:"tenant,omitempty" dynamodbav:"tenant,omitempty"`
+	// ReplacedBy is the prefix of the key this one was rotated to
+	ReplacedBy string `json:"replaced_by,omitempty" dynamodbav:"replaced_by,omitempty"`
+	// Key is only set in the response which mints the key
+	Key string `json:"key,omitempty" dynamodbav:"-"`
+}
+
+// Active reports whether the key can be used at the given time
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil && !now.Before(*k.RevokedAt) {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import "time"
+
+const (
+	TaskWater     = "water"
+	TaskFertilize = "fertilize"
+	TaskRepot     = "repot"
+	TaskPrune     = "prune"
+	TaskRotate    = "rotate"
+)
+
+// TaskKinds lists every kind of care task in the order they are scheduled
+var TaskKinds = []string{TaskWater, TaskFertilize, TaskRepot, TaskPrune, TaskRotate}
+
+// CareProfile describes how often a plant needs each kind of care, in days. A zero
+// interval means the plant does not need that kind of care.
+type CareProfile struct {
+	WaterEveryDays     int `json:"water_every_days,omitempty" dynamodbav:"water_every_days,omitempty"`
+	FertilizeEveryDays int `json:"fertilize_every_days,omitempty" dynamodbav:"fertilize_every_days,omitempty"`
+	RepotEveryDays     int `json:"repot_every_days,omitempty" dynamodbav:"repot_every_days,omitempty"`
+	PruneEveryDays     int `json:"prune_every_days,omitempty" dynamodbav:"prune_every_days,omitempty"`
+	RotateEveryDays    int `json:"rotate_every_days,omitempty" dynamodbav:"rotate_every_days,omitempty"`
+}
+
+// Interval returns the base interval in days for a kind of task
+func (p CareProfile) Interval(kind string) int {
+	switch kind {
+	case TaskWater:
+		return p.WaterEveryDays
+	case TaskFertilize:
+		return p.FertilizeEveryDays
+	case TaskRepot:
+		return p.RepotEveryDays
+	case TaskPrune:
+		return p.PruneEveryDays
+	case TaskRotate:
+		return p.RotateEveryDays
+	}
+	return 0
+}
+
+// TaskState is what a user has done about a recurring task. Tasks recur from the
+// anchor, which is moved forward whenever the task is completed or skipped.
+type TaskState struct {
+	UserID       string     `json:"-" dynamodbav:"user_id"`
+	ID           string     `json:"id" dynamodbav:"id"`
+	Anchor       *time.Time `json:"anchor,omitempty" dynamodbav:"anchor,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" dynamodbav:"completed_at,omitempty"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty" dynamodbav:"snoozed_until,omitempty"`
+}
+
+// CareTask is the next occurrence of a recurring task for a plant in a user's collection
+type CareTask struct {
+	ID           string     `json:"id"`
+	EntryID      string     `json:"entry_id"`
+	PlantName    string     `json:"plant_name"`
+	Nickname     string     `json:"nickname,omitempty"`
+	Kind         string     `json:"kind"`
+	IntervalDays int        `json:"interval_days"`
+	DueAt        time.Time  `json:"due_at"`
+	DueDate      string     `json:"due_date"`
+	Overdue      bool       `json:"overdue"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+}
+
+var taskVerbs = map[string]string{
+	TaskWater:     "Water",
+	TaskFertilize: "Fertilize",
+	TaskRepot:     "Repot",
+	TaskPrune:     "Prune",
+	TaskRotate:    "Rotate",
+}
+
+// Summary describes the task in a few words, e.g. "Water Monty"
+func (t CareTask) Summary() string {
+	name := t.PlantName
+	if t.Nickname != "" {
+		name = t.Nickname
+	}
+	verb, ok := taskVerbs[t.Kind]
+	if !ok {
+		verb = t.Kind
+	}
+	return verb + " " + name
+}
@@ -0,0 +1,10 @@
+package pkg
+
+import "time"
+
+// CatalogVersion counts the changes made to the catalog, so a client holding a list of
+// plants can check whether it is still current without listing them again
+type CatalogVersion struct {
+	Version   int64     `json:"version" dynamodbav:"version"`
+	ChangedAt time.Time `json:"changed_at" dynamodbav:"changed_at"`
+}
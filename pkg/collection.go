@@ -0,0 +1,20 @@
+package pkg
+
+import "time"
+
+// CollectionEntry is a catalog plant owned by a user. Entries are only ever read and
+// written through the owner's subject, so UserID is never sent to clients.
+type CollectionEntry struct {
+	UserID    string `json:"-" dynamodbav:"user_id"`
+	ID        string `json:"id" dynamodbav:"id"`
+	PlantName string `json:"plant_name" dynamodbav:"plant_name"`
+	Nickname  string `json:"nickname,omitempty" dynamodbav:"nickname,omitempty"`
+	Location  string `json:"location,omitempty" dynamodbav:"location,omitempty"`
+	// AcquiredOn is the date the plant was acquired, formatted as YYYY-MM-DD
+	AcquiredOn string `json:"acquired_on,omitempty" dynamodbav:"acquired_on,omitempty"`
+	// PotDiameterCm is the diameter of the plant's pot in centimetres
+	PotDiameterCm int       `json:"pot_diameter_cm,omitempty" dynamodbav:"pot_diameter_cm,omitempty"`
+	Notes         string    `json:"notes,omitempty" dynamodbav:"notes,omitempty"`
+	CreatedAt     time.Time `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
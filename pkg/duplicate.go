@@ -0,0 +1,311 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultDuplicateThreshold is the score from which two plants are taken to be duplicates
+const DefaultDuplicateThreshold = 0.6
+
+// How much each similarity counts towards the score of a pair of plants. Plants without a
+// taxonomy are scored on their names and descriptions alone.
+const (
+	nameWeight        = 0.5
+	taxonomyWeight    = 0.3
+	descriptionWeight = 0.2
+)
+
+// DuplicatePair scores how likely two plants are to be the same plant, from 0 to 1
+type DuplicatePair struct {
+	A     string  `json:"a"`
+	B     string  `json:"b"`
+	Score float64 `json:"score"`
+	Name  float64 `json:"name"`
+	// Taxonomy is nil unless both plants have a genus
+	Taxonomy    *float64 `json:"taxonomy,omitempty"`
+	Description float64  `json:"description"`
+}
+
+// DuplicateCluster is a group of plants each of which is likely a duplicate of another
+type DuplicateCluster struct {
+	Plants []string `json:"plants"`
+	// Score is that of the cluster's most likely pair
+	Score float64         `json:"score"`
+	Pairs []DuplicatePair `json:"pairs"`
+}
+
+// NormalizeName reduces a plant's name to folded words of letters and digits, so that names
+// differing only in case, accents, spacing or punctuation are equal
+func NormalizeName(name string) string {
+	return strings.Join(words(name), " ")
+}
+
+// ScorePlants scores how likely two plants are to be the same plant
+func ScorePlants(a Plant, b Plant) DuplicatePair {
+	pair := DuplicatePair{A: a.Name, B: b.Name, Name: nameSimilarity(a, b), Description: wordSimilarity(a.Description, b.Description)}
+	if taxonomy, ok := taxonomySimilarity(a.Taxonomy, b.Taxonomy); ok {
+		pair.Taxonomy = &taxonomy
+		pair.Score = nameWeight*pair.Name + taxonomyWeight*taxonomy + descriptionWeight*pair.Description
+		return pair
+	}
+	pair.Score = (nameWeight*pair.Name + descriptionWeight*pair.Description) / (nameWeight + descriptionWeight)
+	return pair
+}
+
+// FindDuplicates scores every pair of plants and groups those scoring at least threshold
+// into clusters, most likely first
+func FindDuplicates(plants []Plant, threshold float64) []DuplicateCluster {
+	// parent links each plant to another in its cluster, the root naming the cluster
+	parent := make([]int, len(plants))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	pairs := map[int][]DuplicatePair{}
+	for i := range plants {
+		for j := i + 1; j < len(plants); j++ {
+			pair := ScorePlants(plants[i], plants[j])
+			if pair.Score < threshold {
+				continue
+			}
+			pairs[i] = append(pairs[i], pair)
+			parent[root(j)] = root(i)
+		}
+	}
+	clusters := map[int]*DuplicateCluster{}
+	for i, plant := range plants {
+		r := root(i)
+		if _, ok := clusters[r]; !ok {
+			clusters[r] = &DuplicateCluster{Plants: []string{}, Pairs: []DuplicatePair{}}
+		}
+		clusters[r].Plants = append(clusters[r].Plants, plant.Name)
+		for _, pair := range pairs[i] {
+			clusters[r].Pairs = append(clusters[r].Pairs, pair)
+			if pair.Score > clusters[r].Score {
+				clusters[r].Score = pair.Score
+			}
+		}
+	}
+	found := []DuplicateCluster{}
+	for _, cluster := range clusters {
+		if len(cluster.Plants) < 2 {
+			continue
+		}
+		sort.Strings(cluster.Plants)
+		sort.Slice(cluster.Pairs, func(i, j int) bool { return cluster.Pairs[i].Score > cluster.Pairs[j].Score })
+		found = append(found, *cluster)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Score != found[j].Score {
+			return found[i].Score > found[j].Score
+		}
+		return found[i].Plants[0] < found[j].Plants[0]
+	})
+	return found
+}
+
+// CombinePlants merges the plant from into the plant into, which wins wherever both have a
+// value. Fields and translations into is missing are taken from from, its images are added
+// and its name and synonyms become synonyms of into.
+func CombinePlants(into Plant, from Plant) Plant {
+	combined := into
+	if combined.Description == "" {
+		combined.Description = from.Description
+	}
+	if from.Care != nil {
+		care := CareProfile{}
+		if into.Care != nil {
+			care = *into.Care
+		}
+		for _, interval := range careFields {
+			if *interval(&care) == 0 {
+				*interval(&care) = *interval(from.Care)
+			}
+		}
+		combined.Care = &care
+	}
+	if from.Taxonomy != nil {
+		taxonomy := Taxonomy{}
+		if into.Taxonomy != nil {
+			taxonomy = *into.Taxonomy
+		}
+		if taxonomy.Family == "" {
+			taxonomy.Family = from.Taxonomy.Family
+		}
+		if taxonomy.Genus == "" {
+			taxonomy.Genus = from.Taxonomy.Genus
+		}
+		if taxonomy.Species == "" {
+			taxonomy.Species = from.Taxonomy.Species
+		}
+		combined.Taxonomy = &taxonomy
+	}
+	if len(from.Translations) > 0 {
+		combined.Translations = map[string]Translation{}
+		for locale, translation := range from.Translations {
+			combined.Translations[locale] = translation
+		}
+		for locale, translation := range into.Translations {
+			if translation.CommonName == "" {
+				translation.CommonName = from.Translations[locale].CommonName
+			}
+			if translation.Description == "" {
+				translation.Description = from.Translations[locale].Description
+			}
+			combined.Translations[locale] = translation
+		}
+	}
+	combined.Images = append(append([]Image{}, into.Images...), from.Images...)
+	combined.Synonyms = []string{}
+	seen := map[string]bool{NormalizeName(into.Name): true}
+	for _, synonym := range append(append(append([]string{}, into.Synonyms...), from.Name), from.Synonyms...) {
+		if seen[NormalizeName(synonym)] {
+			continue
+		}
+		seen[NormalizeName(synonym)] = true
+		combined.Synonyms = append(combined.Synonyms, synonym)
+	}
+	return combined
+}
+
+// nameSimilarity is the greatest similarity between any name or synonym of a and any of b
+func nameSimilarity(a Plant, b Plant) float64 {
+	best := 0.0
+	for _, x := range append([]string{a.Name}, a.Synonyms...) {
+		for _, y := range append([]string{b.Name}, b.Synonyms...) {
+			if similarity := namesSimilarity(NormalizeName(x), NormalizeName(y)); similarity > best {
+				best = similarity
+			}
+		}
+	}
+	return best
+}
+
+// namesSimilarity compares two normalized names by their edit distance, and by how many
+// words of the shorter name the longer one shares, so that "pothos" is close to
+// "golden pothos"
+func namesSimilarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	x, y := []rune(a), []rune(b)
+	longest := len(x)
+	if len(y) > longest {
+		longest = len(y)
+	}
+	edit := 1 - float64(levenshtein(x, y))/float64(longest)
+	aWords, bWords := strings.Fields(a), strings.Fields(b)
+	shared := len(intersect(aWords, bWords))
+	fewest := len(aWords)
+	if len(bWords) < fewest {
+		fewest = len(bWords)
+	}
+	// containing every word of the other name is a strong sign, but not as strong as equal
+	contained := 0.9 * float64(shared) / float64(fewest)
+	if contained > edit {
+		return contained
+	}
+	return edit
+}
+
+// taxonomySimilarity compares two taxonomies by their most specific shared rank. It is not
+// known unless both have a genus.
+func taxonomySimilarity(a *Taxonomy, b *Taxonomy) (float64, bool) {
+	if a == nil || b == nil || a.Genus == "" || b.Genus == "" {
+		return 0, false
+	}
+	switch {
+	case !strings.EqualFold(a.Genus, b.Genus):
+		if a.Family != "" && strings.EqualFold(a.Family, b.Family) {
+			return 0.3, true
+		}
+		return 0, true
+	case a.Species == "" || b.Species == "":
+		return 0.8, true
+	case strings.EqualFold(a.Species, b.Species):
+		return 1, true
+	default:
+		return 0.4, true
+	}
+}
+
+// wordSimilarity is the share of the distinct words of two texts which they have in common,
+// ignoring short words
+func wordSimilarity(a string, b string) float64 {
+	x, y := significantWords(a), significantWords(b)
+	union := len(x) + len(y) - len(intersect(x, y))
+	if union == 0 {
+		return 0
+	}
+	return float64(len(intersect(x, y))) / float64(union)
+}
+
+// significantWords returns the distinct words of a text longer than three letters
+func significantWords(text string) []string {
+	found := []string{}
+	seen := map[string]bool{}
+	for _, word := range words(text) {
+		if len([]rune(word)) <= 3 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		found = append(found, word)
+	}
+	return found
+}
+
+// words splits text into words of letters and digits, folded as by FoldName
+func words(text string) []string {
+	return strings.FieldsFunc(FoldName(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// intersect returns the distinct words in both a and b
+func intersect(a []string, b []string) []string {
+	in := map[string]bool{}
+	for _, word := range a {
+		in[word] = true
+	}
+	both := []string{}
+	for _, word := range b {
+		if in[word] {
+			both = append(both, word)
+			delete(in, word)
+		}
+	}
+	return both
+}
+
+// levenshtein counts the single rune insertions, deletions and substitutions which turn a
+// into b
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
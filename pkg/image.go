@@ -0,0 +1,33 @@
+package pkg
+
+import "time"
+
+// Image is a picture attached to a plant. Every image has the uploaded original and
+// any thumbnails which are smaller than it.
+type Image struct {
+	ID          string           `json:"id" dynamodbav:"id"`
+	AltText     string           `json:"alt_text" dynamodbav:"alt_text"`
+	License     string           `json:"license,omitempty" dynamodbav:"license,omitempty"`
+	Attribution string           `json:"attribution,omitempty" dynamodbav:"attribution,omitempty"`
+	UploadedAt  time.Time        `json:"uploaded_at" dynamodbav:"uploaded_at"`
+	Renditions  []ImageRendition `json:"renditions" dynamodbav:"renditions"`
+}
+
+// ImageRendition is one stored size of an image
+type ImageRendition struct {
+	Name        string `json:"name" dynamodbav:"name"`
+	Key         string `json:"key" dynamodbav:"key"`
+	ContentType string `json:"content_type" dynamodbav:"content_type"`
+	Width       int    `json:"width" dynamodbav:"width"`
+	Height      int    `json:"height" dynamodbav:"height"`
+}
+
+// Rendition returns the named rendition of the image
+func (i Image) Rendition(name string) (ImageRendition, bool) {
+	for _, rendition := range i.Renditions {
+		if rendition.Name == name {
+			return rendition, true
+		}
+	}
+	return ImageRendition{}, false
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import "time"
+
+const (
+	JournalTreatment   = "treatment"
+	JournalObservation = "observation"
+)
+
+// JournalEntry records something an owner did to or noticed about a plant in their
+// collection. Entries whose kind is a care task kind count as completing that task.
+type JournalEntry struct {
+	UserID string `json:"-" dynamodbav:"user_id"`
+	// CollectionEntryID is the plant in the user's collection the entry is about
+	CollectionEntryID string `json:"collection_entry_id" dynamodbav:"collection_entry_id"`
+	// ID sorts by OccurredAt, so the journal can be read by time range
+	ID         string    `json:"id" dynamodbav:"id"`
+	Kind       string    `json:"kind" dynamodbav:"kind"`
+	OccurredAt time.Time `json:"occurred_at" dynamodbav:"occurred_at"`
+	Quantity   float64   `json:"quantity,omitempty" dynamodbav:"quantity,omitempty"`
+	Unit       string    `json:"unit,omitempty" dynamodbav:"unit,omitempty"`
+	Notes      string    `json:"notes,omitempty" dynamodbav:"notes,omitempty"`
+	Photos     []Image   `json:"photos,omitempty" dynamodbav:"photos,omitempty"`
+}
+
+// JournalKinds lists the kinds of entry a journal accepts
+var JournalKinds = []string{TaskWater, TaskFertilize, TaskRepot, TaskPrune, TaskRotate, JournalTreatment, JournalObservation}
+
+// IsTask reports whether the entry completes a care task
+func (e JournalEntry) IsTask() bool {
+	for _, kind := range TaskKinds {
+		if e.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import "time"
+
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	// DeliveryDead marks a delivery which failed too many times to be retried
+	DeliveryDead = "dead"
+)
+
+// NotificationPreferences decide when and how a user is reminded of their care tasks
+type NotificationPreferences struct {
+	UserID string `json:"-" dynamodbav:"user_id"`
+	// Timezone is the IANA name of the user's timezone, which quiet hours and digests follow
+	Timezone   string                `json:"timezone" dynamodbav:"timezone"`
+	Hemisphere string                `json:"hemisphere,omitempty" dynamodbav:"hemisphere,omitempty"`
+	Channels   []NotificationChannel `json:"channels" dynamodbav:"channels"`
+	QuietHours *QuietHours           `json:"quiet_hours,omitempty" dynamodbav:"quiet_hours,omitempty"`
+	// Digest sends one reminder a day at DigestHour listing every due task, rather than one per task
+	Digest     bool `json:"digest" dynamodbav:"digest"`
+	DigestHour int  `json:"digest_hour" dynamodbav:"digest_hour"`
+}
+
+// NotificationChannel is somewhere reminders are delivered
+type NotificationChannel struct {
+	Kind string `json:"kind" dynamodbav:"kind"`
+	// URL receives webhook reminders
+	URL string `json:"url,omitempty" dynamodbav:"url,omitempty"`
+	// Secret signs webhook reminders. It is generated if left empty.
+	Secret string `json:"secret,omitempty" dynamodbav:"secret,omitempty"`
+	// Email receives email reminders
+	Email string `json:"email,omitempty" dynamodbav:"email,omitempty"`
+}
+
+// QuietHours is a daily span of local time, "HH:MM" to "HH:MM", in which no reminders are
+// sent. The span wraps past midnight when End is before Start.
+type QuietHours struct {
+	Start string `json:"start" dynamodbav:"start"`
+	End   string `json:"end" dynamodbav:"end"`
+}
+
+// Delivery records a reminder sent, or being retried, on one channel
+type Delivery struct {
+	UserID string `json:"-" dynamodbav:"user_id"`
+	// ID names what is being delivered on which channel, so each reminder is sent once
+	ID            string     `json:"id" dynamodbav:"id"`
+	Channel       int        `json:"channel" dynamodbav:"channel"`
+	TaskIDs       []string   `json:"task_ids" dynamodbav:"task_ids"`
+	Status        string     `json:"status" dynamodbav:"status"`
+	Attempts      int        `json:"attempts" dynamodbav:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" dynamodbav:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty" dynamodbav:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" dynamodbav:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty" dynamodbav:"delivered_at,omitempty"`
+	// ExpiresAt is when the table's TTL removes the record, in unix seconds
+	ExpiresAt int64 `json:"-" dynamodbav:"expires_at,omitempty"`
+}
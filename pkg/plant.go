@@ -1,6 +1,55 @@
 package pkg
 
+import "time"
+
 type Plant struct {
-	Name        string `json:"name" dynamodbav:"name"`
-	Description string `json:"description" dynamodbav:"description"`
+	Name        string       `json:"name" dynamodbav:"name"`
+	Description string       `json:"description" dynamodbav:"description"`
+	Images      []Image      `json:"images,omitempty" dynamodbav:"images,omitempty"`
+	Care        *CareProfile `json:"care,omitempty" dynamodbav:"care,omitempty"`
+	// Taxonomy places the plant in its family, genus and species, as far as they are known
+	Taxonomy *Taxonomy `json:"taxonomy,omitempty" dynamodbav:"taxonomy,omitempty"`
+	// Synonyms are other names the plant is known by, including those of plants merged into it
+	Synonyms []string `json:"synonyms,omitempty" dynamodbav:"synonyms,omitempty"`
+	// ID is the plant's immutable identifier, which it keeps when it is renamed
+	ID string `json:"id,omitempty" dynamodbav:"id,omitempty"`
+	// Slug is the plant's canonical url name, derived from its name by Slugify
+	Slug string `json:"slug,omitempty" dynamodbav:"slug,omitempty"`
+	// Translations hold the plant's common name and description in other locales, keyed by
+	// BCP 47 language tag
+	Translations map[string]Translation `json:"translations,omitempty" dynamodbav:"translations,omitempty"`
+	// Owner is the subject which created the plant
+	Owner string `json:"owner,omitempty" dynamodbav:"owner,omitempty"`
+	// UpdatedAt is when the plant was last written. It is left out of diffs, since every
+	// revision changes it.
+	UpdatedAt *time.Time `json:"updated_at,omitempty" dynamodbav:"updated_at,omitempty" diff:"-"`
+	// DeletedAt is set while the plant is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" dynamodbav:"deleted_at,omitempty"`
+	// ExpiresAt is the unix time at which a trashed plant is purged by the table's TTL
+	ExpiresAt int64 `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"`
+	// ArchivedAt is set while the plant is withdrawn from the published catalog
+	ArchivedAt *time.Time `json:"archived_at,omitempty" dynamodbav:"archived_at,omitempty"`
+}
+
+// Taxonomy is the botanical classification of a plant
+type Taxonomy struct {
+	Family  string `json:"family,omitempty" dynamodbav:"family,omitempty"`
+	Genus   string `json:"genus,omitempty" dynamodbav:"genus,omitempty"`
+	Species string `json:"species,omitempty" dynamodbav:"species,omitempty"`
+}
+
+// Status is whether the plant is published in the catalog, archived or in the trash
+func (p Plant) Status() string {
+	if p.DeletedAt != nil {
+		return PlantStatusTrashed
+	}
+	if p.ArchivedAt != nil {
+		return PlantStatusArchived
+	}
+	return PlantStatusActive
+}
+
+// Resource describes the plant for checking permissions on it
+func (p Plant) Resource() *Resource {
+	return &Resource{Kind: "plant", Name: p.Name, Owner: p.Owner, Status: p.Status()}
 }
@@ -0,0 +1,52 @@
+package pkg
+
+import "time"
+
+// Proposal kinds
+const (
+	ProposalCreate = "create"
+	ProposalUpdate = "update"
+)
+
+// Proposal statuses. A draft is only seen by its author until it is submitted for review,
+// after which a reviewer approves it, publishing the plant, or rejects it.
+const (
+	ProposalDraft    = "draft"
+	ProposalInReview = "in_review"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+)
+
+// Proposal is a change to the catalog held until a reviewer approves it
+type Proposal struct {
+	ID        string `json:"id" dynamodbav:"id"`
+	PlantName string `json:"plant_name" dynamodbav:"plant_name"`
+	Kind      string `json:"kind" dynamodbav:"kind"`
+	Status    string `json:"status" dynamodbav:"status"`
+	// Plant is the plant as it would be published
+	Plant Plant `json:"plant" dynamodbav:"plant"`
+	// Base is the published plant an update was proposed against, so a reviewer can see
+	// what it changes and whether the plant has changed since
+	Base *Plant `json:"base,omitempty" dynamodbav:"base,omitempty"`
+	// Changes are the fields the proposal changes, filled in when it is read
+	Changes     []FieldChange `json:"changes,omitempty" dynamodbav:"-"`
+	Author      string        `json:"author" dynamodbav:"author"`
+	CreatedAt   time.Time     `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at" dynamodbav:"updated_at"`
+	SubmittedAt *time.Time    `json:"submitted_at,omitempty" dynamodbav:"submitted_at,omitempty"`
+	Reviewer    string        `json:"reviewer,omitempty" dynamodbav:"reviewer,omitempty"`
+	ReviewedAt  *time.Time    `json:"reviewed_at,omitempty" dynamodbav:"reviewed_at,omitempty"`
+	Comments    []Comment     `json:"comments,omitempty" dynamodbav:"comments,omitempty"`
+}
+
+// Comment is left on a proposal by its author or a reviewer
+type Comment struct {
+	Author    string    `json:"author" dynamodbav:"author"`
+	Text      string    `json:"text" dynamodbav:"text"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Resource describes the proposal for checking permissions on it
+func (p Proposal) Resource() *Resource {
+	return &Resource{Kind: "proposal", Name: p.ID, Owner: p.Author, Status: p.Status}
+}
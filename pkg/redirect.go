@@ -0,0 +1,12 @@
+package pkg
+
+import "time"
+
+// Redirect is left at the name of a plant which has been merged into another, so that
+// links to the old name keep working
+type Redirect struct {
+	Name      string    `json:"name" dynamodbav:"name"`
+	To        string    `json:"to" dynamodbav:"to"`
+	CreatedBy string    `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRevert  = "revert"
+	RevisionRestore = "restore"
+	RevisionPurge   = "purge"
+	RevisionArchive = "archive"
+	// RevisionUnarchive publishes an archived plant again
+	RevisionUnarchive = "unarchive"
+	// RevisionMerge is recorded on both plants when one is merged into another
+	RevisionMerge = "merge"
+	// RevisionRename is recorded when a plant is given a new name, keeping its id
+	RevisionRename = "rename"
+)
+
+// Revision is an immutable record of the state of a plant after a write.
+type Revision struct {
+	PlantName string    `json:"plant_name" dynamodbav:"plant_name"`
+	ID        string    `json:"id" dynamodbav:"id"`
+	Action    string    `json:"action" dynamodbav:"action"`
+	Author    string    `json:"author" dynamodbav:"author"`
+	Timestamp time.Time `json:"timestamp" dynamodbav:"timestamp"`
+	Summary   string    `json:"summary" dynamodbav:"summary"`
+	Snapshot  Plant     `json:"snapshot" dynamodbav:"snapshot"`
+}
+
+// FieldChange describes a single field that differs between two plants.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// DiffPlants returns the fields that differ between from and to, named by their json tags.
+func DiffPlants(from Plant, to Plant) []FieldChange {
+	changes := []FieldChange{}
+	fromValue := reflect.ValueOf(from)
+	toValue := reflect.ValueOf(to)
+	for i := 0; i < fromValue.NumField(); i++ {
+		if fromValue.Type().Field(i).Tag.Get("diff") == "-" {
+			continue
+		}
+		a := fromValue.Field(i).Interface()
+		b := toValue.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: fieldName(fromValue.Type().Field(i)), From: a, To: b})
+	}
+	return changes
+}
+
+// SummarizeChanges builds a short human readable summary from a list of changes.
+func SummarizeChanges(changes []FieldChange) string {
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	fields := make([]string, len(changes))
+	for i := range changes {
+		fields[i] = changes[i].Field
+	}
+	return "changed " + strings.Join(fields, ", ")
+}
+
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import "time"
+
+// Permissions which roles grant, named like scopes. A token with a scope of the same name
+// has the permission too.
+const (
+	PermissionReadPlants    = "read:plants"
+	PermissionCreatePlants  = "create:plants"
+	PermissionUpdatePlants  = "update:plants"
+	PermissionDeletePlants  = "delete:plants"
+	PermissionRevertPlants  = "revert:plants"
+	PermissionRestorePlants = "restore:plants"
+	PermissionPurgePlants   = "purge:plants"
+	PermissionManageRoles   = "manage:roles"
+	// PermissionPublishPlants lets changes be published without review when moderation is on
+	PermissionPublishPlants = "publish:plants"
+	// PermissionReviewPlants lets proposed changes be approved or rejected and plants archived
+	PermissionReviewPlants = "review:plants"
+	// PermissionMergePlants lets duplicate plants be found and merged
+	PermissionMergePlants = "merge:plants"
+	// PermissionAll is granted by a role which may do anything
+	PermissionAll = "*"
+)
+
+// Plant statuses, which grants may be limited to
+const (
+	PlantStatusActive   = "active"
+	PlantStatusArchived = "archived"
+	PlantStatusTrashed  = "trashed"
+)
+
+// Grant gives a role a permission, optionally only on resources the caller owns or which
+// are in one of the given statuses
+type Grant struct {
+	Permission string   `json:"permission" dynamodbav:"permission"`
+	OwnOnly    bool     `json:"own_only,omitempty" dynamodbav:"own_only,omitempty"`
+	Statuses   []string `json:"statuses,omitempty" dynamodbav:"statuses,omitempty"`
+}
+
+// Role is a named set of grants
+type Role struct {
+	Name        string    `json:"name" dynamodbav:"name"`
+	Description string    `json:"description,omitempty" dynamodbav:"description,omitempty"`
+	Grants      []Grant   `json:"grants" dynamodbav:"grants"`
+	UpdatedBy   string    `json:"updated_by,omitempty" dynamodbav:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" dynamodbav:"updated_at,omitempty"`
+	// BuiltIn is set on roles defined by the api which have not been replaced
+	BuiltIn bool `json:"built_in,omitempty" dynamodbav:"-"`
+}
+
+// RoleAssignment gives a subject a role
+type RoleAssignment struct {
+	Subject    string    `json:"subject" dynamodbav:"subject"`
+	Role       string    `json:"role" dynamodbav:"role"`
+	AssignedBy string    `json:"assigned_by" dynamodbav:"assigned_by"`
+	AssignedAt time.Time `json:"assigned_at" dynamodbav:"assigned_at"`
+}
+
+// Resource is what a permission is checked against
+type Resource struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// Decision is the outcome of checking a permission, with the reasons it was reached
+type Decision struct {
+	Allowed    bool      `json:"allowed"`
+	Subject    string    `json:"subject"`
+	Permission string    `json:"permission"`
+	Resource   *Resource `json:"resource,omitempty"`
+	Roles      []string  `json:"roles"`
+	Reasons    []string  `json:"reasons"`
+}
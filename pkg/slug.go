@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// PlantRef identifies a plant by its immutable id, its current name and its canonical slug
+type PlantRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// NewPlantID returns a new random plant id
+func NewPlantID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Slugify returns the canonical url slug of a plant's name: its words of letters and
+// digits, folded as by FoldName and joined by hyphens. Names differing only in case,
+// accents, spacing, punctuation or Unicode compatibility forms share a slug, so
+// "Snake Plant", "snake  plant " and "Snake-plant" are all "snake-plant".
+func Slugify(name string) string {
+	return strings.Join(words(name), "-")
+}
+
+// FoldName normalizes a name for comparison. Text is decomposed so that accents can be
+// dropped, recomposed with NFKC so compatibility forms such as full width letters and
+// ligatures become their plain equivalents, and case folded.
+func FoldName(name string) string {
+	decomposed := norm.NFKD.String(name)
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, decomposed)
+	return cases.Fold().String(norm.NFKC.String(stripped))
+}
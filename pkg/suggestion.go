@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Suggestion statuses. A suggestion is open until an editor decides each of its changes.
+const (
+	SuggestionOpen              = "open"
+	SuggestionAccepted          = "accepted"
+	SuggestionPartiallyAccepted = "partially_accepted"
+	SuggestionRejected          = "rejected"
+)
+
+// How a suggested change merges with the plant as it is now
+const (
+	// MergeClean changes have not been changed on the plant since the suggestion's base
+	MergeClean = "clean"
+	// MergeConflict changes have been changed on the plant to something else since
+	MergeConflict = "conflict"
+	// MergeApplied changes have already been made to the plant
+	MergeApplied = "applied"
+)
+
+// careFields are the care intervals a suggestion may change, named by their json paths
+var careFields = map[string]func(*CareProfile) *int{
+	"care.water_every_days":     func(c *CareProfile) *int { return &c.WaterEveryDays },
+	"care.fertilize_every_days": func(c *CareProfile) *int { return &c.FertilizeEveryDays },
+	"care.repot_every_days":     func(c *CareProfile) *int { return &c.RepotEveryDays },
+	"care.prune_every_days":     func(c *CareProfile) *int { return &c.PruneEveryDays },
+	"care.rotate_every_days":    func(c *CareProfile) *int { return &c.RotateEveryDays },
+}
+
+// SuggestableFields lists the fields of a plant suggestions may change
+var SuggestableFields = []string{"description", "care.water_every_days", "care.fertilize_every_days", "care.repot_every_days", "care.prune_every_days", "care.rotate_every_days"}
+
+// Suggestion proposes changes to some fields of a plant, made against one of its revisions
+type Suggestion struct {
+	PlantName string `json:"plant_name" dynamodbav:"plant_name"`
+	ID        string `json:"id" dynamodbav:"id"`
+	// BaseRevision is the id of the revision the changes were made against
+	BaseRevision  string            `json:"base_revision" dynamodbav:"base_revision"`
+	Changes       []SuggestedChange `json:"changes" dynamodbav:"changes"`
+	Comment       string            `json:"comment,omitempty" dynamodbav:"comment,omitempty"`
+	Status        string            `json:"status" dynamodbav:"status"`
+	Author        string            `json:"author" dynamodbav:"author"`
+	CreatedAt     time.Time         `json:"created_at" dynamodbav:"created_at"`
+	Reviewer      string            `json:"reviewer,omitempty" dynamodbav:"reviewer,omitempty"`
+	ReviewedAt    *time.Time        `json:"reviewed_at,omitempty" dynamodbav:"reviewed_at,omitempty"`
+	ReviewComment string            `json:"review_comment,omitempty" dynamodbav:"review_comment,omitempty"`
+	Merge         *SuggestionMerge  `json:"merge,omitempty" dynamodbav:"-"`
+}
+
+// SuggestedChange changes one field of a plant
+type SuggestedChange struct {
+	Field string `json:"field" dynamodbav:"field"`
+	// From is the field's value in the base revision
+	From interface{} `json:"from" dynamodbav:"from"`
+	To   interface{} `json:"to" dynamodbav:"to"`
+	// Accepted is set once an editor has decided the change
+	Accepted *bool `json:"accepted,omitempty" dynamodbav:"accepted,omitempty"`
+}
+
+// SuggestionMerge describes how an open suggestion merges with the plant as it is now
+type SuggestionMerge struct {
+	// Stale is set when the plant has been revised since the suggestion's base revision
+	Stale           bool         `json:"stale"`
+	CurrentRevision string       `json:"current_revision"`
+	Fields          []FieldMerge `json:"fields"`
+}
+
+// FieldMerge is the three way merge of one suggested change
+type FieldMerge struct {
+	Field     string      `json:"field"`
+	Base      interface{} `json:"base"`
+	Current   interface{} `json:"current"`
+	Suggested interface{} `json:"suggested"`
+	Status    string      `json:"status"`
+}
+
+// Conflicts reports whether any change conflicts with the plant as it is now
+func (m SuggestionMerge) Conflicts() bool {
+	for _, field := range m.Fields {
+		if field.Status == MergeConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeSuggestion merges each suggested change with the current plant. A change merges
+// cleanly if the field still has its base value, and conflicts if it has since been changed
+// to anything other than the suggested value.
+func MergeSuggestion(current Plant, changes []SuggestedChange) ([]FieldMerge, error) {
+	fields := []FieldMerge{}
+	for _, change := range changes {
+		value, err := PlantField(current, change.Field)
+		if err != nil {
+			return nil, err
+		}
+		from, err := FieldValue(change.Field, change.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := FieldValue(change.Field, change.To)
+		if err != nil {
+			return nil, err
+		}
+		merge := FieldMerge{Field: change.Field, Base: from, Current: value, Suggested: to, Status: MergeConflict}
+		switch value {
+		case to:
+			merge.Status = MergeApplied
+		case from:
+			merge.Status = MergeClean
+		}
+		fields = append(fields, merge)
+	}
+	return fields, nil
+}
+
+// PlantField returns the value of a suggestable field of the plant: a string for the
+// description and a number of days for care intervals
+func PlantField(plant Plant, field string) (interface{}, error) {
+	if field == "description" {
+		return plant.Description, nil
+	}
+	interval, ok := careFields[field]
+	if !ok {
+		return nil, fmt.Errorf("%s cannot be suggested", field)
+	}
+	care := CareProfile{}
+	if plant.Care != nil {
+		care = *plant.Care
+	}
+	return *interval(&care), nil
+}
+
+// SetPlantField sets a suggestable field of the plant
+func SetPlantField(plant *Plant, field string, value interface{}) error {
+	value, err := FieldValue(field, value)
+	if err != nil {
+		return err
+	}
+	if field == "description" {
+		plant.Description = value.(string)
+		return nil
+	}
+	care := CareProfile{}
+	if plant.Care != nil {
+		care = *plant.Care
+	}
+	*careFields[field](&care) = value.(int)
+	plant.Care = &care
+	if care == (CareProfile{}) {
+		plant.Care = nil
+	}
+	return nil
+}
+
+// FieldValue converts a value of a suggestable field, as decoded from json or DynamoDB, to
+// the field's type so values can be compared
+func FieldValue(field string, value interface{}) (interface{}, error) {
+	if field == "description" {
+		text, ok := value.(string)
+		if !ok || text == "" {
+			return nil, fmt.Errorf("description must be a non empty string")
+		}
+		return text, nil
+	}
+	if _, ok := careFields[field]; !ok {
+		return nil, fmt.Errorf("%s cannot be suggested", field)
+	}
+	var days float64
+	switch number := value.(type) {
+	case int:
+		days = float64(number)
+	case int64:
+		days = float64(number)
+	case float64:
+		days = number
+	default:
+		return nil, fmt.Errorf("%s must be a number of days", field)
+	}
+	if days < 0 || days != math.Trunc(days) {
+		return nil, fmt.Errorf("%s must be a whole number of days", field)
+	}
+	return int(days), nil
+}
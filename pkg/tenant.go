@@ -0,0 +1,45 @@
+package pkg
+
+import "time"
+
+// Features which may be turned off for a tenant
+const (
+	FeatureImages        = "images"
+	FeatureCollections   = "collections"
+	FeatureJournal       = "journal"
+	FeatureTasks         = "tasks"
+	FeatureCalendar      = "calendar"
+	FeatureNotifications = "notifications"
+	FeatureWebhooks      = "webhooks"
+	FeatureEvents        = "events"
+)
+
+// Features lists every feature which may be turned off
+var Features = []string{
+	FeatureImages, FeatureCollections, FeatureJournal, FeatureTasks, FeatureCalendar, FeatureNotifications, FeatureWebhooks, FeatureEvents,
+}
+
+// Tenant is an organization with its own catalog, kept apart from every other tenant's
+type Tenant struct {
+	ID   string `json:"id" dynamodbav:"id"`
+	Name string `json:"name" dynamodbav:"name"`
+	// MaxPlants is the most plants the catalog may hold, or 0 for no limit
+	MaxPlants int `json:"max_plants,omitempty" dynamodbav:"max_plants,omitempty"`
+	// RateLimit is how many requests the whole tenant may make, as requests/period, e.g.
+	// "1000/1m", on top of each caller's own limit
+	RateLimit string `json:"rate_limit,omitempty" dynamodbav:"rate_limit,omitempty"`
+	// DisabledFeatures are features the tenant may not use
+	DisabledFeatures []string  `json:"disabled_features,omitempty" dynamodbav:"disabled_features,omitempty"`
+	CreatedBy        string    `json:"created_by,omitempty" dynamodbav:"created_by,omitempty"`
+	CreatedAt        time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Enabled reports whether the tenant may use the feature
+func (t Tenant) Enabled(feature string) bool {
+	for _, disabled := range t.DisabledFeatures {
+		if disabled == feature {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import "sort"
+
+// DefaultLocale is the locale a plant's own name and description are written in, unless
+// the server is configured with another
+const DefaultLocale = "en"
+
+// The fields of a plant which are translated, named by their json tags
+const (
+	TranslationCommonName  = "common_name"
+	TranslationDescription = "description"
+)
+
+// Translation is a plant's common name and description in another locale. Either may be
+// left out, in which case it falls back to the next locale the reader accepts.
+type Translation struct {
+	CommonName  string `json:"common_name,omitempty" dynamodbav:"common_name,omitempty"`
+	Description string `json:"description,omitempty" dynamodbav:"description,omitempty"`
+}
+
+// LocalizedPlant is a plant as read in one locale. The plant's own fields are left as they
+// are written, so that it can be read and written back without losing its content.
+type LocalizedPlant struct {
+	Plant
+	// CommonName is the plant's name in the reader's language, which is its name unless it
+	// has been translated. The plant is still addressed by its name.
+	CommonName string `json:"common_name"`
+	// LocalizedDescription is the plant's description in the reader's language, which is its
+	// description unless it has been translated
+	LocalizedDescription string `json:"localized_description"`
+	// Locale is the locale of the localized description
+	Locale string `json:"locale"`
+}
+
+// MissingTranslation names the fields of a plant not yet translated into a locale
+type MissingTranslation struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// TranslationReport lists the plants whose content is not fully translated into a locale
+type TranslationReport struct {
+	Locale string `json:"locale"`
+	// Plants is how many plants the catalog has, and Translated how many of them are fully
+	// translated
+	Plants     int                  `json:"plants"`
+	Translated int                  `json:"translated"`
+	Missing    []MissingTranslation `json:"missing"`
+}
+
+// Localize reads the plant in the first locale of the fallback chain which has each field,
+// ending with its own content in defaultLocale. Translations are left out, since readers
+// see only the locale they asked for.
+func (p Plant) Localize(chain []string, defaultLocale string) LocalizedPlant {
+	localized := LocalizedPlant{Plant: p, CommonName: p.Name, LocalizedDescription: p.Description, Locale: defaultLocale}
+	localized.Translations = nil
+	commonName, description := false, false
+	for _, locale := range chain {
+		if locale == defaultLocale {
+			break
+		}
+		translation, ok := p.Translations[locale]
+		if !ok {
+			continue
+		}
+		if !commonName && translation.CommonName != "" {
+			localized.CommonName, commonName = translation.CommonName, true
+		}
+		if !description && translation.Description != "" {
+			localized.LocalizedDescription, localized.Locale, description = translation.Description, locale, true
+		}
+	}
+	return localized
+}
+
+// ReportMissingTranslations lists the plants missing a translation of their common name or
+// description into the locale, by name
+func ReportMissingTranslations(plants []Plant, locale string) TranslationReport {
+	report := TranslationReport{Locale: locale, Plants: len(plants), Missing: []MissingTranslation{}}
+	for _, plant := range plants {
+		translation := plant.Translations[locale]
+		fields := []string{}
+		if translation.CommonName == "" {
+			fields = append(fields, TranslationCommonName)
+		}
+		if translation.Description == "" {
+			fields = append(fields, TranslationDescription)
+		}
+		if len(fields) == 0 {
+			report.Translated++
+			continue
+		}
+		report.Missing = append(report.Missing, MissingTranslation{Name: plant.Name, Fields: fields})
+	}
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i].Name < report.Missing[j].Name })
+	return report
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import "time"
+
+const (
+	EventPlantCreated = "plant.created"
+	EventPlantUpdated = "plant.updated"
+	EventPlantDeleted = "plant.deleted"
+	// EventPlantRestored is sent when a plant is restored from the trash
+	EventPlantRestored = "plant.restored"
+	// EventPlantPurged is sent when a plant is permanently deleted from the trash
+	EventPlantPurged = "plant.purged"
+	// EventPlantArchived is sent when a plant is withdrawn from the published catalog
+	EventPlantArchived = "plant.archived"
+	// EventPlantUnarchived is sent when an archived plant is published again
+	EventPlantUnarchived = "plant.unarchived"
+	// EventPlantRenamed is sent when a plant is given a new name, with the name it had before
+	EventPlantRenamed = "plant.renamed"
+)
+
+// EventTypes lists the catalog events webhooks can subscribe to
+var EventTypes = []string{EventPlantCreated, EventPlantUpdated, EventPlantDeleted, EventPlantRestored, EventPlantPurged, EventPlantArchived, EventPlantUnarchived, EventPlantRenamed}
+
+// Event is a change to the catalog, delivered to webhook subscriptions as the request body
+type Event struct {
+	// ID is the same for every delivery of the event, so receivers can drop duplicates
+	ID         string    `json:"id" dynamodbav:"id"`
+	Type       string    `json:"type" dynamodbav:"type"`
+	OccurredAt time.Time `json:"occurred_at" dynamodbav:"occurred_at"`
+	Plant      Plant     `json:"plant" dynamodbav:"plant"`
+	// PreviousName is the name a renamed plant had before
+	PreviousName string `json:"previous_name,omitempty" dynamodbav:"previous_name,omitempty"`
+	// Tenant is the tenant whose catalog changed, or empty for the default tenant
+	Tenant string `json:"tenant,omitempty" dynamodbav:"tenant,omitempty"`
+}
+
+// Subscription sends the events of the given types to a url
+type Subscription struct {
+	ID     string   `json:"id" dynamodbav:"id"`
+	URL    string   `json:"url" dynamodbav:"url"`
+	Events []string `json:"events" dynamodbav:"events"`
+	// Secret signs deliveries. It is generated when the subscription is created, and only
+	// returned then.
+	Secret    string    `json:"secret,omitempty" dynamodbav:"secret"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Subscribes reports whether the subscription wants events of the given type
+func (s Subscription) Subscribes(eventType string) bool {
+	for _, subscribed := range s.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery records the delivery of an event to a subscription. Statuses are the
+// same as for reminder deliveries.
+type WebhookDelivery struct {
+	SubscriptionID string `json:"subscription_id" dynamodbav:"subscription_id"`
+	// ID sorts deliveries in the order they were created
+	ID            string     `json:"id" dynamodbav:"id"`
+	Event         Event      `json:"event" dynamodbav:"event"`
+	Status        string     `json:"status" dynamodbav:"status"`
+	Attempts      int        `json:"attempts" dynamodbav:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" dynamodbav:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty" dynamodbav:"last_error,omitempty"`
+	ResponseCode  int        `json:"response_code,omitempty" dynamodbav:"response_code,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" dynamodbav:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty" dynamodbav:"delivered_at,omitempty"`
+	// ReplayOf is the id of the delivery this one replays
+	ReplayOf  string `json:"replay_of,omitempty" dynamodbav:"replay_of,omitempty"`
+	ExpiresAt int64  `json:"-" dynamodbav:"expires_at,omitempty"`
+}